@@ -0,0 +1,166 @@
+//  Copyright (C) Pat Kaehuaea - All Rights Reserved
+//  Unauthorized copying of this file, via any medium is strictly prohibited
+//  Proprietary and confidential
+//  Written by Pat Kaehuaea, March 2015
+//
+// Versioned JSON API for managing users under API_PREFIX. Unlike the
+// endpoints the timeserver calls, the API uses HTTP methods as intended
+// and answers errors with a JSON body. Every route requires the bearer
+// token set with --api-token.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	log "github.com/cihub/seelog"
+	"github.com/gorilla/mux"
+	"github.com/patkaehuaea/command/authserver/people"
+	"github.com/patkaehuaea/command/config"
+	"net/http"
+	"sort"
+)
+
+const (
+	API_PREFIX   = "/api/v1"
+	API_MAX_BODY = 4096
+)
+
+// Body of POST API_PREFIX/users. ID is generated when empty.
+type newUser struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// Registers the API routes on r, each wrapped by requireAPI.
+func routeAPI(r *mux.Router) {
+	api := r.PathPrefix(API_PREFIX).Subrouter()
+	api.HandleFunc("/users", requireAPI(handleAPIListUsers)).Methods("GET")
+	api.HandleFunc("/users", requireAPI(handleAPICreateUser)).Methods("POST")
+	api.HandleFunc("/users/{id}", requireAPI(handleAPIGetUser)).Methods("GET")
+	api.HandleFunc("/users/{id}", requireAPI(handleAPIDeleteUser)).Methods("DELETE")
+	api.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apiError(w, http.StatusNotFound, "no such endpoint")
+	})
+}
+
+// Wraps API handlers. Requests must carry "Authorization: Bearer <token>"
+// matching --api-token. Without a configured token the API is refused
+// outright.
+func requireAPI(fn http.HandlerFunc) http.HandlerFunc {
+	return requireBearer(*config.APIToken, config.API_TOKEN, "API", refuseAPI, fn)
+}
+
+// Answers refused API requests with status and a JSON error body.
+func refuseAPI(w http.ResponseWriter, status int) {
+	message := "missing or invalid bearer token"
+	if status == http.StatusForbidden {
+		message = "API disabled"
+	}
+	apiError(w, status, message)
+}
+
+// Writes v as the JSON response body with status.
+func apiJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Error(err)
+	}
+}
+
+// Answers with status and a JSON body carrying message.
+func apiError(w http.ResponseWriter, status int, message string) {
+	apiJSON(w, status, map[string]string{"error": message})
+}
+
+// Returns every user, oldest first.
+func handleAPIListUsers(w http.ResponseWriter, r *http.Request) {
+	log.Info("authserver: API list users handler called.")
+
+	list := users.Snapshot()
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].CreatedAt.Equal(list[j].CreatedAt) {
+			return list[i].CreatedAt.Before(list[j].CreatedAt)
+		}
+		return list[i].ID < list[j].ID
+	})
+	apiJSON(w, http.StatusOK, map[string]interface{}{"users": list, "count": len(list)})
+}
+
+func handleAPIGetUser(w http.ResponseWriter, r *http.Request) {
+	log.Info("authserver: API get user handler called.")
+
+	id := mux.Vars(r)["id"]
+	if !people.IsValidUUID(id) {
+		apiError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	person, err := users.Get(id)
+	if err != nil {
+		apiError(w, statusFor(err), err.Error())
+		return
+	}
+	apiJSON(w, http.StatusOK, person)
+}
+
+// Adds the user in the request body and answers 201 with the new user
+// and its location. An id is generated unless the body supplies one.
+func handleAPICreateUser(w http.ResponseWriter, r *http.Request) {
+	log.Info("authserver: API create user handler called.")
+
+	var body newUser
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, API_MAX_BODY))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&body); err != nil {
+		apiError(w, http.StatusBadRequest, "malformed body - "+err.Error())
+		return
+	}
+	if body.ID == "" {
+		if body.ID = people.UUID(); body.ID == "" {
+			apiError(w, http.StatusInternalServerError, "unable to generate id")
+			return
+		}
+	}
+	if !people.IsValidUUID(body.ID) {
+		apiError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	if !people.IsValidName(body.Name) {
+		apiError(w, http.StatusBadRequest, "invalid name")
+		return
+	}
+	if err := users.Add(body.ID, body.Name); err != nil {
+		log.Warn(err)
+		apiError(w, statusFor(err), err.Error())
+		return
+	}
+
+	person, err := users.Get(body.ID)
+	if err != nil {
+		// Removed between Add and Get, e.g. by the reaper.
+		apiError(w, statusFor(err), err.Error())
+		return
+	}
+	w.Header().Set("Location", API_PREFIX+"/users/"+person.ID)
+	apiJSON(w, http.StatusCreated, person)
+}
+
+// Removes the user and answers 204, or 404 if there is no such user.
+func handleAPIDeleteUser(w http.ResponseWriter, r *http.Request) {
+	log.Info("authserver: API delete user handler called.")
+
+	id := mux.Vars(r)["id"]
+	if !people.IsValidUUID(id) {
+		apiError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	if err := users.Remove(id); err != nil {
+		if !errors.Is(err, people.ErrUserNotFound) {
+			log.Warn(err)
+		}
+		apiError(w, statusFor(err), err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,209 @@
+//  Copyright (C) Pat Kaehuaea - All Rights Reserved
+//  Unauthorized copying of this file, via any medium is strictly prohibited
+//  Proprietary and confidential
+//  Written by Pat Kaehuaea, March 2015
+
+package main
+
+import (
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/patkaehuaea/command/authserver/people"
+	"github.com/patkaehuaea/command/config"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Sends method path with body through the API routes, authorized by
+// TEST_TOKEN, and returns the response.
+func callAPI(method string, path string, body string) *httptest.ResponseRecorder {
+	return callAPIWith(method, path, body, "Bearer "+TEST_TOKEN)
+}
+
+// As callAPI(), carrying authorization unless it is empty.
+func callAPIWith(method string, path string, body string, authorization string) *httptest.ResponseRecorder {
+	router := mux.NewRouter()
+	routeAPI(router)
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if authorization != "" {
+		r.Header.Set("Authorization", authorization)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	return w
+}
+
+// Returns the error message of an API error response, or "" if w does
+// not hold one.
+func apiErrorMessage(w *httptest.ResponseRecorder) string {
+	var body struct {
+		Error string `json:"error"`
+	}
+	if w.Header().Get("Content-Type") != "application/json" || json.Unmarshal(w.Body.Bytes(), &body) != nil {
+		return ""
+	}
+	return body.Error
+}
+
+func TestAPIAuth(t *testing.T) {
+	withUsers(t, people.NO_CAPACITY_LIMIT)
+	tests := []struct {
+		name          string
+		configured    string
+		authorization string
+		status        int
+	}{
+		{"no token configured", config.API_TOKEN, "Bearer " + TEST_TOKEN, http.StatusForbidden},
+		{"missing token", TEST_TOKEN, "", http.StatusUnauthorized},
+		{"wrong token", TEST_TOKEN, "Bearer guess", http.StatusUnauthorized},
+		{"no scheme", TEST_TOKEN, TEST_TOKEN, http.StatusUnauthorized},
+		{"right token", TEST_TOKEN, "Bearer " + TEST_TOKEN, http.StatusOK},
+	}
+	for _, tt := range tests {
+		override(t, config.APIToken, tt.configured)
+		w := callAPIWith("GET", API_PREFIX+"/users", "", tt.authorization)
+		if w.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, tt.status)
+		}
+		if message := apiErrorMessage(w); (message != "") != (tt.status != http.StatusOK) {
+			t.Errorf("%s: error body %q", tt.name, w.Body.String())
+		}
+		if challenge := w.Header().Get("WWW-Authenticate"); (challenge == BEARER_SCHEME) != (tt.status == http.StatusUnauthorized) {
+			t.Errorf("%s: WWW-Authenticate = %q", tt.name, challenge)
+		}
+	}
+}
+
+func TestAPICreateUser(t *testing.T) {
+	override(t, config.APIToken, TEST_TOKEN)
+	tests := []struct {
+		name     string
+		body     string
+		status   int
+		location string
+	}{
+		{"given id", `{"id":"` + SECOND_UUID + `","name":"Ada"}`, http.StatusCreated, API_PREFIX + "/users/" + SECOND_UUID},
+		{"generated id", `{"name":"Grace Hopper"}`, http.StatusCreated, API_PREFIX + "/users/"},
+		{"duplicate id", `{"id":"` + FIRST_UUID + `","name":"Ada"}`, http.StatusConflict, ""},
+		{"bad id", `{"id":"not-a-uuid","name":"Ada"}`, http.StatusBadRequest, ""},
+		{"bad name", `{"name":"R2-D2"}`, http.StatusBadRequest, ""},
+		{"no name", `{}`, http.StatusBadRequest, ""},
+		{"unknown field", `{"name":"Ada","admin":true}`, http.StatusBadRequest, ""},
+		{"malformed", `{"name":`, http.StatusBadRequest, ""},
+		{"too large", `{"name":"` + strings.Repeat("a", API_MAX_BODY) + `"}`, http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		u := withUsers(t, people.NO_CAPACITY_LIMIT)
+		u.Add(FIRST_UUID, "Bob")
+		w := callAPI("POST", API_PREFIX+"/users", tt.body)
+
+		if w.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d - %s", tt.name, w.Code, tt.status, w.Body.String())
+		}
+		if tt.status != http.StatusCreated {
+			if apiErrorMessage(w) == "" {
+				t.Errorf("%s: no JSON error body - %q", tt.name, w.Body.String())
+			}
+			if n := u.Len(); n != 1 {
+				t.Errorf("%s: %d users after refused create, want 1", tt.name, n)
+			}
+			continue
+		}
+
+		var person people.Person
+		if err := json.Unmarshal(w.Body.Bytes(), &person); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		location := w.Header().Get("Location")
+		if !strings.HasPrefix(location, tt.location) || location != API_PREFIX+"/users/"+person.ID {
+			t.Errorf("%s: Location = %q, want %s for user %s", tt.name, location, tt.location, person.ID)
+		}
+		if !u.Exists(person.ID) || !people.IsValidUUID(person.ID) {
+			t.Errorf("%s: created user %q not in store", tt.name, person.ID)
+		}
+	}
+}
+
+func TestAPIGetUser(t *testing.T) {
+	override(t, config.APIToken, TEST_TOKEN)
+	u := withUsers(t, people.NO_CAPACITY_LIMIT)
+	u.Add(FIRST_UUID, "Ada")
+	tests := []struct {
+		id     string
+		status int
+	}{
+		{FIRST_UUID, http.StatusOK},
+		{SECOND_UUID, http.StatusNotFound},
+		{"not-a-uuid", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		w := callAPI("GET", API_PREFIX+"/users/"+tt.id, "")
+		if w.Code != tt.status {
+			t.Errorf("GET %s: status = %d, want %d", tt.id, w.Code, tt.status)
+		}
+		var person people.Person
+		json.Unmarshal(w.Body.Bytes(), &person)
+		if tt.status == http.StatusOK && (person.ID != FIRST_UUID || person.Name != "Ada") {
+			t.Errorf("GET %s: user = %+v, want Ada", tt.id, person)
+		}
+		if tt.status != http.StatusOK && apiErrorMessage(w) == "" {
+			t.Errorf("GET %s: no JSON error body - %q", tt.id, w.Body.String())
+		}
+	}
+}
+
+func TestAPIListUsers(t *testing.T) {
+	override(t, config.APIToken, TEST_TOKEN)
+	u := withUsers(t, people.NO_CAPACITY_LIMIT)
+	u.Add(FIRST_UUID, "Ada")
+	u.Add(SECOND_UUID, "Bob")
+
+	w := callAPI("GET", API_PREFIX+"/users", "")
+	var body struct {
+		Users []people.Person `json:"users"`
+		Count int             `json:"count"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("status %d - %v", w.Code, err)
+	}
+	if body.Count != 2 || len(body.Users) != 2 || body.Users[0].Name != "Ada" {
+		t.Errorf("listed %d users %+v, want Ada then Bob", body.Count, body.Users)
+	}
+}
+
+func TestAPIDeleteUser(t *testing.T) {
+	override(t, config.APIToken, TEST_TOKEN)
+	tests := []struct {
+		id     string
+		status int
+	}{
+		{FIRST_UUID, http.StatusNoContent},
+		{SECOND_UUID, http.StatusNotFound},
+		{"not-a-uuid", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		u := withUsers(t, people.NO_CAPACITY_LIMIT)
+		u.Add(FIRST_UUID, "Ada")
+		w := callAPI("DELETE", API_PREFIX+"/users/"+tt.id, "")
+		if w.Code != tt.status {
+			t.Errorf("DELETE %s: status = %d, want %d", tt.id, w.Code, tt.status)
+		}
+		if tt.status == http.StatusNoContent && (w.Body.Len() != 0 || u.Exists(FIRST_UUID)) {
+			t.Errorf("DELETE %s: body %q, user kept %v", tt.id, w.Body.String(), u.Exists(FIRST_UUID))
+		}
+		if tt.status != http.StatusNoContent && apiErrorMessage(w) == "" {
+			t.Errorf("DELETE %s: no JSON error body - %q", tt.id, w.Body.String())
+		}
+	}
+}
+
+func TestAPINotFound(t *testing.T) {
+	override(t, config.APIToken, TEST_TOKEN)
+	w := callAPI("GET", API_PREFIX+"/groups", "")
+	if w.Code != http.StatusNotFound || apiErrorMessage(w) == "" {
+		t.Errorf("unknown endpoint = %d %q, want 404 with a JSON error", w.Code, w.Body.String())
+	}
+}
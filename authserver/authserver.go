@@ -8,27 +8,68 @@
 // two endpoints /get and /set. The former allows a caller to fetch the name of
 // a user given a UUID, and the later allows setting a user in the data store
 // given a UUID and name. For purposes of this assignment both endpoints are
-// are implemented as HTTP GETs with data passed via query parameter.
+// are implemented as HTTP GETs with data passed via query parameter. A /stats
+// endpoint reports aggregate information about the data store as JSON, and
+// /theme/get and /theme/set read and write a user's display theme, and
+// /timezone/get and /timezone/set their preferred time zone. The
+// /stopwatch and /countdown endpoints keep each user's timers, see
+// timers.go. The store is backed up and restored as a JSON document with
+// /export and /import.
+// /healthz and /readyz are liveness and readiness checks.
+// /export, /import, /stats/names, /admin/user and /admin/clear are admin
+// endpoints requiring a bearer token set with --admin-token. A JSON API for
+// managing users is served under /api/v1, see api.go.
 
 package main
 
 import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
 	log "github.com/cihub/seelog"
 	"github.com/gorilla/mux"
+	"github.com/patkaehuaea/command/authserver/backup"
 	"github.com/patkaehuaea/command/authserver/people"
 	"github.com/patkaehuaea/command/config"
+	"github.com/patkaehuaea/command/debugserver"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
 )
 
 const (
 	VERSION_NUMBER   = "v0.0.1"
 	SEELOG_CONF_DIR  = "etc"
 	SEELOG_CONF_FILE = "seelog.xml"
+	BEARER_SCHEME    = "Bearer"
+	READYZ_FAILED    = "not writable"
 )
 
-var users *people.UserStore
+var (
+	users *people.UserStore
+	store people.Store
+)
+
+// Maps errors from the people package to response status codes.
+func statusFor(err error) int {
+	switch {
+	case errors.Is(err, people.ErrUserNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, people.ErrDuplicateID):
+		return http.StatusConflict
+	case errors.Is(err, people.ErrStoreFull), errors.Is(err, people.ErrStoreUnavailable):
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
 
 func handleGetUser(w http.ResponseWriter, r *http.Request) {
 	log.Info("authserver: Get user handler called.")
@@ -36,7 +77,7 @@ func handleGetUser(w http.ResponseWriter, r *http.Request) {
 	if uuid := r.FormValue("cookie"); people.IsValidUUID(uuid) {
 		log.Debug("authserver: Found valid uuid: " + uuid)
 		w.WriteHeader(http.StatusOK)
-		io.WriteString(w, users.Name(uuid))
+		io.WriteString(w, users.Visit(uuid))
 	} else {
 		log.Debug("authserver: UUID not valid, or not found in users.")
 		w.WriteHeader(http.StatusBadRequest)
@@ -48,9 +89,25 @@ func handleSetUser(w http.ResponseWriter, r *http.Request) {
 
 	uuid := r.FormValue("cookie")
 	name := r.FormValue("name")
+	identity := r.FormValue("identity")
 
-	if people.IsValidUUID(uuid) && people.IsValidName(name) {
-		users.Add(uuid, name)
+	if people.IsValidUUID(uuid) && people.IsValidName(name) && (identity == "" || people.IsValidIdentity(identity)) {
+		// Under a single session policy a new login for a name
+		// invalidates any session already held by that name.
+		if *config.SingleSession {
+			for _, id := range users.FindByName(name) {
+				log.Debug("authserver: Removing prior session for " + name + ".")
+				users.Remove(id)
+			}
+		}
+		if err := users.Add(uuid, name); err != nil {
+			log.Warn(err)
+			w.WriteHeader(statusFor(err))
+			return
+		}
+		if identity != "" {
+			users.SetIdentity(uuid, identity)
+		}
 		w.WriteHeader(http.StatusOK)
 	} else {
 		log.Debug("authserver: Invalid uuid and/or name.")
@@ -58,14 +115,296 @@ func handleSetUser(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// Removes the user with the uuid, as on logout. Removing a user that
+// is not present succeeds so a repeated logout is harmless.
+func handleDeleteUser(w http.ResponseWriter, r *http.Request) {
+	log.Info("authserver: Delete user handler called.")
+
+	uuid := r.FormValue("cookie")
+	if !people.IsValidUUID(uuid) {
+		log.Debug("authserver: UUID not valid.")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if err := users.Remove(uuid); err != nil && !errors.Is(err, people.ErrUserNotFound) {
+		log.Warn(err)
+		w.WriteHeader(statusFor(err))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func handleGetTheme(w http.ResponseWriter, r *http.Request) {
+	log.Info("authserver: Get theme handler called.")
+
+	if uuid := r.FormValue("cookie"); people.IsValidUUID(uuid) {
+		w.WriteHeader(http.StatusOK)
+		io.WriteString(w, users.Theme(uuid))
+	} else {
+		log.Debug("authserver: UUID not valid.")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func handleSetTheme(w http.ResponseWriter, r *http.Request) {
+	log.Info("authserver: Set theme handler called.")
+
+	uuid := r.FormValue("cookie")
+	theme := r.FormValue("theme")
+
+	if !people.IsValidUUID(uuid) || !people.IsValidTheme(theme) {
+		log.Debug("authserver: Invalid uuid and/or theme.")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if !users.SetTheme(uuid, theme) {
+		log.Debug("authserver: Theme set for unknown uuid " + uuid)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func handleGetTimezone(w http.ResponseWriter, r *http.Request) {
+	log.Info("authserver: Get timezone handler called.")
+
+	if uuid := r.FormValue("cookie"); people.IsValidUUID(uuid) {
+		w.WriteHeader(http.StatusOK)
+		io.WriteString(w, users.Timezone(uuid))
+	} else {
+		log.Debug("authserver: UUID not valid.")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// Sets the preferred time zone of the user with the uuid. An empty
+// timezone clears the preference.
+func handleSetTimezone(w http.ResponseWriter, r *http.Request) {
+	log.Info("authserver: Set timezone handler called.")
+
+	uuid := r.FormValue("cookie")
+	tz := r.FormValue("timezone")
+
+	if !people.IsValidUUID(uuid) || (tz != "" && !people.IsValidTimezone(tz)) {
+		log.Debug("authserver: Invalid uuid and/or timezone.")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if !users.SetTimezone(uuid, tz) {
+		log.Debug("authserver: Timezone set for unknown uuid " + uuid)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func handleStats(w http.ResponseWriter, r *http.Request) {
+	log.Info("authserver: Stats handler called.")
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(users.Stats()); err != nil {
+		log.Error(err)
+	}
+}
+
+// Length of logged in names and the number of users with that length.
+type nameBucket struct {
+	Length int `json:"length"`
+	Count  int `json:"count"`
+}
+
+// Reports the distribution of name lengths, in characters, across a
+// snapshot of the data store. Buckets are sorted by length.
+func handleNameStats(w http.ResponseWriter, r *http.Request) {
+	log.Info("authserver: Name stats handler called.")
+
+	counts := make(map[int]int)
+	for _, person := range users.Snapshot() {
+		counts[utf8.RuneCountInString(person.Name)]++
+	}
+	buckets := make([]nameBucket, 0, len(counts))
+	for length, count := range counts {
+		buckets = append(buckets, nameBucket{Length: length, Count: count})
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].Length < buckets[j].Length })
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(buckets); err != nil {
+		log.Error(err)
+	}
+}
+
+// Returns the Person with the id query parameter as JSON, or 404 if
+// there is no such user.
+func handleAdminUser(w http.ResponseWriter, r *http.Request) {
+	log.Info("authserver: Admin user handler called.")
+
+	id := r.FormValue("id")
+	if !people.IsValidUUID(id) {
+		log.Debug("authserver: UUID not valid.")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	person, err := users.Get(id)
+	if err != nil {
+		w.WriteHeader(statusFor(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(person); err != nil {
+		log.Error(err)
+	}
+}
+
+// Wraps handlers that expose or replace user data. Requests must carry
+// "Authorization: Bearer <token>" matching --admin-token. Without a
+// configured token admin endpoints are refused outright.
+func requireAdmin(fn http.HandlerFunc) http.HandlerFunc {
+	return requireBearer(*config.AdminToken, config.ADMIN_TOKEN, "admin", refuseAdmin, fn)
+}
+
+// Answers refused admin requests with status alone.
+func refuseAdmin(w http.ResponseWriter, status int) {
+	w.WriteHeader(status)
+}
+
+// Wraps fn so requests must carry "Authorization: Bearer <token>".
+// A token equal to unset means none was configured, and every request
+// is refused with 403. Kind names the endpoints in log messages and
+// refuse answers refused requests with their status.
+func requireBearer(token string, unset string, kind string, refuse func(w http.ResponseWriter, status int), fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if token == unset {
+			log.Debug("authserver: " + kind + " endpoint called without a token configured.")
+			refuse(w, http.StatusForbidden)
+			return
+		}
+		if !matchesBearer(r, token) {
+			log.Warn("authserver: Rejected " + kind + " request from " + r.RemoteAddr)
+			w.Header().Set("WWW-Authenticate", BEARER_SCHEME)
+			refuse(w, http.StatusUnauthorized)
+			return
+		}
+		fn(w, r)
+	}
+}
+
+// Reports whether r carries token in an "Authorization: Bearer" header.
+func matchesBearer(r *http.Request, token string) bool {
+	given, ok := bearerToken(r)
+	return ok && subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+}
+
+// Returns the token of an "Authorization: Bearer <token>" header. ok is
+// false when the header is missing or names another scheme, which is
+// matched regardless of case.
+func bearerToken(r *http.Request) (token string, ok bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, BEARER_SCHEME) {
+		return "", false
+	}
+	return token, true
+}
+
+// Removes every user, as from the timeserver's admin page, and reports
+// how many were removed as JSON.
+func handleClear(w http.ResponseWriter, r *http.Request) {
+	log.Info("authserver: Clear handler called.")
+
+	removed := users.Clear()
+	log.Warnf("authserver: Cleared %d users.", removed)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]int{"removed": removed}); err != nil {
+		log.Error(err)
+	}
+}
+
+func handleExport(w http.ResponseWriter, r *http.Request) {
+	log.Info("authserver: Export handler called.")
+
+	data, err := users.Export()
+	if err != nil {
+		log.Error(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// Replaces the data store with the JSON document in the request body.
+// Malformed or invalid documents are rejected and leave the store as is.
+func handleImport(w http.ResponseWriter, r *http.Request) {
+	log.Info("authserver: Import handler called.")
+
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		log.Error(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if err = users.Import(data); err != nil {
+		log.Warn(err)
+		if errors.Is(err, people.ErrStoreFull) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		} else {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		io.WriteString(w, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// Liveness check. Answers as long as the process is serving requests.
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		io.WriteString(w, "ok\n")
+	}
+}
+
+// Readiness check. The server is ready when the dumpfile's directory is
+// writable, so checkpoints and the dump on shutdown will succeed. Failures
+// answer 503 in a JSON body naming the failed check. The reason, which
+// names the dumpfile's path, is only given to requests carrying
+// --admin-token.
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	body := map[string]interface{}{"status": "ok"}
+	status := http.StatusOK
+	if err := backup.Writable(*config.DumpFile); err != nil {
+		log.Warn("authserver: Not ready, dumpfile not writable - " + err.Error())
+		reason := READYZ_FAILED
+		if *config.AdminToken != config.ADMIN_TOKEN && matchesBearer(r, *config.AdminToken) {
+			reason = err.Error()
+		}
+		body = map[string]interface{}{"status": "unavailable", "errors": map[string]string{"store": reason}}
+		status = http.StatusServiceUnavailable
+	}
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Error(err)
+	}
+}
+
 func handleNotFound(w http.ResponseWriter, r *http.Request) {
 	log.Info("authserver: Not found handler called.")
 	w.WriteHeader(http.StatusNotFound)
 }
 
 func init() {
-
 	log.ReplaceLogger(config.Logger)
+}
+
+// Opens the user store from the dumpfile and starts persisting and
+// reaping it. Called from main() rather than init() so tests can supply
+// their own store.
+func openStore() {
 
 	// DumpFile needs to be specified, but dumpfile need
 	// not be present at startup.
@@ -78,29 +417,120 @@ func init() {
 	// transparent to the authserver. Future project to move
 	// into its own pacakge's init() function and have authserver
 	// reference a public member.
-	users = people.NewUsers()
-	if err := users.Load(*config.DumpFile); err != nil {
+	backup.FileMode = config.FileMode
+	codec, ok := backup.CODECS[*config.Storage]
+	if !ok {
+		log.Critical("database: Storage must be json or gob.")
+		os.Exit(1)
+	}
+	backup.Encoding = codec
+	store = backup.File(*config.DumpFile)
+	users = people.NewUsers(*config.MaxUsers)
+	users.OnAdd = func(p people.Person) { log.Debug("authserver: Session " + p.ID + " added.") }
+	users.OnRemove = func(p people.Person) { log.Debug("authserver: Session " + p.ID + " removed.") }
+	// A corrupt dumpfile must not keep the server down. Load() leaves the
+	// store empty on error, and the next dump replaces the bad file.
+	if err := users.Load(store); os.IsNotExist(err) {
 		log.Info("database: Backup not found at initialization.")
+	} else if err != nil {
+		log.Warn("database: Ignoring unreadable backup, starting empty - " + err.Error())
 	}
-	go users.Persist(*config.DumpFile, *config.CheckpointInt)
+	go users.Persist(store, *config.CheckpointInt)
+	if *config.ReapInterval <= 0 {
+		log.Critical("database: Reap interval must be positive.")
+		os.Exit(1)
+	}
+	go users.Reap(*config.UserTTL, *config.ReapInterval, *config.ReapChunkSize)
 }
 
 func main() {
 
 	/*
 	   Paramters surfaced via config pacakge used in this program:
+	   *config.AdminToken
+	   *config.APIToken
 	   *config.AuthPort
+	   config.FileMode
+	   *config.MaxUsers
+	   *config.ReapChunkSize
+	   *config.ReapInterval
+	   *config.ShutdownTO
+	   *config.SingleSession
+	   *config.Storage
+	   *config.UserTTL
 	   config.Logger
 	   database.Users
 	*/
 
+	openStore()
+
 	r := mux.NewRouter()
 	r.HandleFunc("/get", handleGetUser).Methods("GET")
 	// Should be POST, but assignment spec requires GET.
 	r.HandleFunc("/set", handleSetUser).Methods("GET")
+	// GET for consistency with /set.
+	r.HandleFunc("/delete", handleDeleteUser).Methods("GET")
+	r.HandleFunc("/theme/get", handleGetTheme).Methods("GET")
+	// GET for consistency with /set.
+	r.HandleFunc("/theme/set", handleSetTheme).Methods("GET")
+	r.HandleFunc("/timezone/get", handleGetTimezone).Methods("GET")
+	r.HandleFunc("/timezone/set", handleSetTimezone).Methods("GET")
+	r.HandleFunc("/stats", handleStats).Methods("GET")
+	r.HandleFunc("/stats/names", requireAdmin(handleNameStats)).Methods("GET")
+	r.HandleFunc("/admin/user", requireAdmin(handleAdminUser)).Methods("GET")
+	r.HandleFunc("/admin/clear", requireAdmin(handleClear)).Methods("POST")
+	r.HandleFunc("/export", requireAdmin(handleExport)).Methods("GET")
+	r.HandleFunc("/import", requireAdmin(handleImport)).Methods("POST")
+	r.HandleFunc("/healthz", handleHealthz).Methods("GET", "HEAD")
+	r.HandleFunc("/readyz", handleReadyz).Methods("GET", "HEAD")
+	routeTimers(r)
+	routeAPI(r)
 	r.NotFoundHandler = http.HandlerFunc(handleNotFound)
-	http.Handle("/", r)
-	if err := (http.ListenAndServe(*config.AuthPort, nil)); err != nil {
+	// Not http.DefaultServeMux, where net/http/pprof and expvar register
+	// themselves.
+	server := &http.Server{Addr: *config.AuthPort, Handler: r}
+	if *config.DebugAddr != config.DEBUG_ADDR {
+		debugserver.Publish("registered_users", func() interface{} { return users.Len() })
+		debugServer, err := debugserver.Serve(*config.DebugAddr)
+		if err != nil {
+			log.Critical(err)
+			os.Exit(1)
+		}
+		server.RegisterOnShutdown(func() { debugServer.Close() })
+	}
+	done := make(chan error, 1)
+	go shutdownOnSignal(server, *config.ShutdownTO, done)
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
 		log.Critical(err)
+		os.Exit(1)
+	}
+	code := 0
+	if err := <-done; err != nil {
+		code = 1
+	}
+	log.Flush()
+	os.Exit(code)
+}
+
+// Waits for SIGINT or SIGTERM then stops server accepting connections,
+// lets in-flight requests finish for up to timeout and dumps the store so
+// changes since the last checkpoint survive a restart. Sends the dump's
+// error, if any, on done.
+func shutdownOnSignal(server *http.Server, timeout time.Duration, done chan<- error) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	s := <-sig
+	log.Info("authserver: Received " + s.String() + ", shutting down.")
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Warn("authserver: Shutdown timed out, closing remaining connections - " + err.Error())
+		server.Close()
+	}
+	err := users.Dump(store)
+	if err == nil {
+		log.Info("authserver: Shutdown complete.")
 	}
+	done <- err
 }
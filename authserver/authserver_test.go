@@ -0,0 +1,328 @@
+//  Copyright (C) Pat Kaehuaea - All Rights Reserved
+//  Unauthorized copying of this file, via any medium is strictly prohibited
+//  Proprietary and confidential
+//  Written by Pat Kaehuaea, March 2015
+//
+// Tests for the authserver's handlers, called directly against a store
+// created for each test in place of the one main() opens.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "github.com/cihub/seelog"
+	"github.com/patkaehuaea/command/authserver/people"
+	"github.com/patkaehuaea/command/config"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	FIRST_UUID  = "0f8b6a3e-6f4e-4c1a-9d2b-3e5f7a9c1b2d"
+	SECOND_UUID = "7c1d2e3f-4a5b-4c6d-8e9f-0a1b2c3d4e5f"
+	THIRD_UUID  = "5a6b7c8d-9e0f-4a1b-8c2d-3e4f5a6b7c8d"
+	TEST_TOKEN  = "s3cret"
+)
+
+// Replaces users with an empty store holding at most max users for the
+// duration of t.
+func withUsers(t *testing.T, max int) *people.UserStore {
+	saved := users
+	users = people.NewUsers(max)
+	t.Cleanup(func() { users = saved })
+	return users
+}
+
+// Sets *setting to value for the duration of t.
+func override[T any](t *testing.T, setting *T, value T) {
+	saved := *setting
+	*setting = value
+	t.Cleanup(func() { *setting = saved })
+}
+
+// Calls h with a GET of path carrying params and returns the response.
+func call(h http.HandlerFunc, path string, params url.Values) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h(w, httptest.NewRequest("GET", path+"?"+params.Encode(), nil))
+	return w
+}
+
+func TestSingleSession(t *testing.T) {
+	tests := []struct {
+		single bool
+		first  bool
+	}{
+		{false, true},
+		{true, false},
+	}
+	for _, tt := range tests {
+		override(t, config.SingleSession, tt.single)
+		u := withUsers(t, people.NO_CAPACITY_LIMIT)
+		for _, uuid := range []string{FIRST_UUID, SECOND_UUID} {
+			if w := call(handleSetUser, "/set", url.Values{"cookie": {uuid}, "name": {"Ada"}}); w.Code != http.StatusOK {
+				t.Fatalf("--single-session %v: /set %s = %d", tt.single, uuid, w.Code)
+			}
+		}
+		if u.Exists(FIRST_UUID) != tt.first {
+			t.Errorf("--single-session %v: first session kept %v, want %v", tt.single, u.Exists(FIRST_UUID), tt.first)
+		}
+		if !u.Exists(SECOND_UUID) {
+			t.Errorf("--single-session %v: second session missing", tt.single)
+		}
+	}
+}
+
+func TestSetUserAtCapacity(t *testing.T) {
+	withUsers(t, 1)
+	tests := []struct {
+		uuid   string
+		status int
+	}{
+		{FIRST_UUID, http.StatusOK},
+		{FIRST_UUID, http.StatusConflict},
+		{SECOND_UUID, http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		if w := call(handleSetUser, "/set", url.Values{"cookie": {tt.uuid}, "name": {"Ada"}}); w.Code != tt.status {
+			t.Errorf("/set %s = %d, want %d", tt.uuid, w.Code, tt.status)
+		}
+	}
+}
+
+// Calls h with a GET of path carrying token, if not empty, as a bearer
+// token.
+func callWithToken(h http.HandlerFunc, path string, token string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", path, nil)
+	if token != "" {
+		r.Header.Set("Authorization", BEARER_SCHEME+" "+token)
+	}
+	w := httptest.NewRecorder()
+	h(w, r)
+	return w
+}
+
+func TestNameStats(t *testing.T) {
+	u := withUsers(t, people.NO_CAPACITY_LIMIT)
+	u.Add(FIRST_UUID, "Ada")
+	u.Add(SECOND_UUID, "Bob")
+	u.Add(THIRD_UUID, "Grace Hopper")
+
+	override(t, config.AdminToken, TEST_TOKEN)
+	w := callWithToken(requireAdmin(handleNameStats), "/stats/names", TEST_TOKEN)
+	var got []nameBucket
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatalf("status %d - %v", w.Code, err)
+	}
+	want := []nameBucket{{Length: 3, Count: 2}, {Length: 12, Count: 1}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("histogram = %v, want %v", got, want)
+	}
+}
+
+func TestRequireAdmin(t *testing.T) {
+	tests := []struct {
+		name          string
+		configured    string
+		authorization string
+		status        int
+	}{
+		{"no token configured", config.ADMIN_TOKEN, "Bearer " + TEST_TOKEN, http.StatusForbidden},
+		{"missing token", TEST_TOKEN, "", http.StatusUnauthorized},
+		{"wrong token", TEST_TOKEN, "Bearer guess", http.StatusUnauthorized},
+		{"no scheme", TEST_TOKEN, TEST_TOKEN, http.StatusUnauthorized},
+		{"basic scheme", TEST_TOKEN, "Basic " + TEST_TOKEN, http.StatusUnauthorized},
+		{"scheme only", TEST_TOKEN, "Bearer", http.StatusUnauthorized},
+		{"right token", TEST_TOKEN, "Bearer " + TEST_TOKEN, http.StatusOK},
+		{"lower case scheme", TEST_TOKEN, "bearer " + TEST_TOKEN, http.StatusOK},
+	}
+	withUsers(t, people.NO_CAPACITY_LIMIT)
+	for _, tt := range tests {
+		override(t, config.AdminToken, tt.configured)
+		r := httptest.NewRequest("GET", "/stats/names", nil)
+		if tt.authorization != "" {
+			r.Header.Set("Authorization", tt.authorization)
+		}
+		w := httptest.NewRecorder()
+		requireAdmin(handleNameStats)(w, r)
+		if w.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, tt.status)
+		}
+	}
+}
+
+func TestHealthz(t *testing.T) {
+	tests := []struct {
+		method string
+		body   string
+	}{
+		{"GET", "ok\n"},
+		{"HEAD", ""},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		handleHealthz(w, httptest.NewRequest(tt.method, "/healthz", nil))
+		if w.Code != http.StatusOK || w.Body.String() != tt.body {
+			t.Errorf("%s: %d %q, want %d %q", tt.method, w.Code, w.Body.String(), http.StatusOK, tt.body)
+		}
+		if cc := w.Header().Get("Cache-Control"); cc != "no-store" {
+			t.Errorf("%s: Cache-Control = %q, want no-store", tt.method, cc)
+		}
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	override(t, config.AdminToken, TEST_TOKEN)
+	dir := t.TempDir()
+	missing := filepath.Join(dir, "missing", "users.json")
+	tests := []struct {
+		name          string
+		dumpFile      string
+		authorization string
+		status        int
+		reason        string
+	}{
+		{"writable", filepath.Join(dir, "users.json"), "", http.StatusOK, ""},
+		{"not writable", missing, "", http.StatusServiceUnavailable, READYZ_FAILED},
+		{"not writable, wrong token", missing, "Bearer wrong", http.StatusServiceUnavailable, READYZ_FAILED},
+		{"not writable, admin", missing, "Bearer " + TEST_TOKEN, http.StatusServiceUnavailable, filepath.Join(dir, "missing")},
+	}
+	for _, tt := range tests {
+		override(t, config.DumpFile, tt.dumpFile)
+		r := httptest.NewRequest("GET", "/readyz", nil)
+		if tt.authorization != "" {
+			r.Header.Set("Authorization", tt.authorization)
+		}
+		w := httptest.NewRecorder()
+		handleReadyz(w, r)
+		if w.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, tt.status)
+		}
+		var body struct {
+			Status string
+			Errors map[string]string
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Errorf("%s: body %q - %v", tt.name, w.Body.String(), err)
+			continue
+		}
+		if tt.status == http.StatusOK {
+			if body.Status != "ok" || len(body.Errors) != 0 {
+				t.Errorf("%s: body = %q, want status ok", tt.name, w.Body.String())
+			}
+			continue
+		}
+		if body.Status != "unavailable" || !strings.Contains(body.Errors["store"], tt.reason) {
+			t.Errorf("%s: body = %q, want store error containing %q", tt.name, w.Body.String(), tt.reason)
+		}
+		if tt.reason == READYZ_FAILED && strings.Contains(w.Body.String(), dir) {
+			t.Errorf("%s: body %q names the dumpfile's path", tt.name, w.Body.String())
+		}
+	}
+}
+
+func TestOpenStore(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+		count    int
+		warning  bool
+	}{
+		{"missing", "", 0, false},
+		{"valid", `{"` + FIRST_UUID + `": {"name": "Ada"}}`, 1, false},
+		{"corrupt", `{"` + FIRST_UUID + `": {"name": `, 0, true},
+	}
+	for _, tt := range tests {
+		dumpFile := filepath.Join(t.TempDir(), "users.json")
+		if tt.contents != "" {
+			if err := os.WriteFile(dumpFile, []byte(tt.contents), 0600); err != nil {
+				t.Fatal(err)
+			}
+		}
+		override(t, config.DumpFile, dumpFile)
+		override(t, config.CheckpointInt, time.Hour)
+		override(t, config.ReapInterval, time.Hour)
+		withUsers(t, people.NO_CAPACITY_LIMIT)
+		saved := store
+		t.Cleanup(func() { store = saved })
+
+		var logged bytes.Buffer
+		logger, err := log.LoggerFromWriterWithMinLevel(&logged, log.WarnLvl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		log.ReplaceLogger(logger)
+		openStore()
+		log.Flush()
+		log.ReplaceLogger(config.Logger)
+
+		if got := users.Stats().Count; got != tt.count {
+			t.Errorf("%s: %d users loaded, want %d", tt.name, got, tt.count)
+		}
+		if warned := strings.Contains(logged.String(), "starting empty"); warned != tt.warning {
+			t.Errorf("%s: logged %q, want a warning %v", tt.name, logged.String(), tt.warning)
+		}
+		if err := users.Add(SECOND_UUID, "Grace"); err != nil {
+			t.Errorf("%s: store unusable after opening - %v", tt.name, err)
+		}
+	}
+}
+
+func TestAdminUser(t *testing.T) {
+	u := withUsers(t, people.NO_CAPACITY_LIMIT)
+	u.Add(FIRST_UUID, "Ada")
+	tests := []struct {
+		id     string
+		status int
+		name   string
+	}{
+		{FIRST_UUID, http.StatusOK, "Ada"},
+		{SECOND_UUID, http.StatusNotFound, ""},
+		{"not-a-uuid", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		w := call(handleAdminUser, "/admin/user", url.Values{"id": {tt.id}})
+		if w.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.id, w.Code, tt.status)
+			continue
+		}
+		if tt.status != http.StatusOK {
+			continue
+		}
+		var got people.Person
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Fatalf("%s: %v", tt.id, err)
+		}
+		if got.ID != tt.id || got.Name != tt.name || got.CreatedAt.IsZero() {
+			t.Errorf("%s: returned %+v", tt.id, got)
+		}
+	}
+}
+
+func TestStatusFor(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+	}{
+		{people.ErrUserNotFound, http.StatusNotFound},
+		{people.ErrDuplicateID, http.StatusConflict},
+		{people.ErrStoreFull, http.StatusServiceUnavailable},
+		{people.ErrStoreUnavailable, http.StatusServiceUnavailable},
+		{fmt.Errorf("removing user - %w", people.ErrUserNotFound), http.StatusNotFound},
+		{errors.New("unexpected"), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		if got := statusFor(tt.err); got != tt.status {
+			t.Errorf("statusFor(%v) = %d, want %d", tt.err, got, tt.status)
+		}
+	}
+}
@@ -3,28 +3,118 @@
 //  Proprietary and confidential
 //  Written by Pat Kaehuaea, February 2015
 //
-// Package intended to server as the interface between the in memory user's
-// data store and the file system. Implements functions to Read(), and Write()
-// a JSON encoded document to the file system along with Exists() and verify()
-// helper methods. Common parameters include a filepath/filename and a user
-// map[string]string. Read() and Write() methods are guarded by a method
-// which checks for presence of the dumpFile before contuing.
+// Package intended to server as the interface between the in memory user's data
+// store and the file system. Implements functions to Read(), and Write() a JSON
+// encoded document to the file system along with Exists() and verify() helper
+// methods, and Writable() for readiness checks. Common parameters include a
+// filepath/filename and a value that can be encoded as JSON, typically the
+// user's map. Read() and Write() methods are guarded by a method which checks
+// for presence of the dumpFile before contuing. Writes are atomic: a temporary
+// file is written and renamed over the dumpFile only once verified. A dumpFile
+// whose name ends in .gz is transparently gzip compressed on Write() and
+// decompressed on Read(). Documents are JSON encoded unless Encoding is set to
+// another Codec, such as Gob. File wraps a dumpFile path for callers that
+// accept any store with Read() and Write() methods.
 package backup
 
 import (
+	"bytes"
+	"compress/gzip"
+	"encoding/gob"
 	"encoding/json"
 	"errors"
 	log "github.com/cihub/seelog"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"reflect"
 )
 
 const (
-	BACKUP_FILE_EXTENSION = ".bak"
-	DEFAULT_MODE          = 0600
+	DEFAULT_MODE        = 0600
+	GZIP_FILE_EXTENSION = ".gz"
+	TEMP_FILE_EXTENSION = ".tmp"
 )
 
+// Encodes values written to and decodes values read from a dumpFile.
+type Codec struct {
+	Marshal   func(v interface{}) ([]byte, error)
+	Unmarshal func(data []byte, v interface{}) error
+}
+
+var (
+	JSON = Codec{Marshal: json.Marshal, Unmarshal: json.Unmarshal}
+	Gob  = Codec{Marshal: gobMarshal, Unmarshal: gobUnmarshal}
+)
+
+// Codecs by the name accepted by the --storage flag.
+var CODECS = map[string]Codec{
+	"json": JSON,
+	"gob":  Gob,
+}
+
+// Codec used by Read() and Write(). Changing it makes existing dumpFiles
+// written with another codec unreadable.
+var Encoding = JSON
+
+// A dumpFile path. Read() and Write() call the package functions of
+// the same name.
+type File string
+
+func (f File) Read(target interface{}) error {
+	return Read(string(f), target)
+}
+
+func (f File) Write(value interface{}) error {
+	return Write(string(f), value)
+}
+
+func gobMarshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func gobUnmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// Permissions for newly created dumpFiles. Existing dumpFiles keep
+// their permissions when rewritten.
+var FileMode os.FileMode = DEFAULT_MODE
+
+// Returns true if dumpFile should be gzip compressed.
+func compressed(dumpFile string) bool {
+	return filepath.Ext(dumpFile) == GZIP_FILE_EXTENSION
+}
+
+// Returns data gzip compressed.
+func compress(data []byte) (out []byte, err error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err = zw.Write(data); err != nil {
+		return
+	}
+	if err = zw.Close(); err != nil {
+		return
+	}
+	out = buf.Bytes()
+	return
+}
+
+// Returns gzip compressed data decompressed.
+func decompress(data []byte) (out []byte, err error) {
+	var zr *gzip.Reader
+	if zr, err = gzip.NewReader(bytes.NewReader(data)); err != nil {
+		return
+	}
+	defer zr.Close()
+	out, err = ioutil.ReadAll(zr)
+	return
+}
+
 // Calls os.Stat() on dumpfile and passes file mode to
 // caller if exists. Same expectation as Stat() method
 // where err != nil indicates file not present.
@@ -38,12 +128,11 @@ func Exists(dumpFile string) (mode os.FileMode, err error) {
 	return
 }
 
-// If dumpFile exists, read the JSON encoded documents into
-// users. Undetermined behaviour if map is not string to string.
-// Will not unmarshall into users unless file is read successfully.
-func Read(dumpFile string, target map[string]string) (err error) {
-
-	var contents []byte
+// If dumpFile exists, read the JSON encoded documents into target,
+// which must be a pointer. Will not unmarshall into target unless
+// file is read successfully. Leftover temporary files from an
+// interrupted Write() have distinct names and are never read.
+func Read(dumpFile string, target interface{}) (err error) {
 
 	if _, err = Exists(dumpFile); err != nil {
 		log.Trace("backup: Backup does not exist.")
@@ -51,22 +140,48 @@ func Read(dumpFile string, target map[string]string) (err error) {
 	}
 
 	log.Trace("backup: Reading backup dumpFile.")
-	if contents, err = ioutil.ReadFile(dumpFile); err != nil {
+	err = readFile(dumpFile, compressed(dumpFile), target)
+	return
+}
+
+// Reads path into target, decompressing first if gz is true. Split from
+// Read() so temporary files can be verified using the dumpFile's format.
+func readFile(path string, gz bool, target interface{}) (err error) {
+
+	var contents []byte
+
+	if contents, err = ioutil.ReadFile(path); err != nil {
 		return
 	}
 
-	log.Trace("backup: Deserializing into target map.")
-	err = json.Unmarshal(contents, &target)
+	if gz {
+		log.Trace("backup: Decompressing dumpFile.")
+		if contents, err = decompress(contents); err != nil {
+			return
+		}
+	}
+
+	log.Trace("backup: Deserializing into target.")
+	err = Encoding.Unmarshal(contents, target)
 	return
 }
 
-// Credit for advice on reflect package and DeepEqual: http://goo.gl/VqeDyZ
-func verify(dumpFile string, original map[string]string) (err error) {
-	compare := make(map[string]string)
-	if err = Read(dumpFile, compare); err != nil {
+// Reads path into a new value of original's type and compares the two
+// after re-encoding. Values such as time.Time do not survive a JSON round
+// trip under reflect.DeepEqual, so the encoded forms are compared instead.
+func verify(path string, gz bool, original interface{}) (err error) {
+	var want, got []byte
+	compare := reflect.New(reflect.TypeOf(original)).Interface()
+	if err = readFile(path, gz, compare); err != nil {
+		return
+	}
+	if want, err = json.Marshal(original); err != nil {
+		return
+	}
+	if got, err = json.Marshal(compare); err != nil {
 		return
 	}
-	if equal := reflect.DeepEqual(original, compare); !equal {
+	if !bytes.Equal(want, got) {
 		err = errors.New("backup: Backup data not equal to original.")
 		return
 	}
@@ -74,47 +189,94 @@ func verify(dumpFile string, original map[string]string) (err error) {
 	return
 }
 
-// Expects map passed as parameter to be copy of main data store. Function
-// writes JSON encoded document to disk given user parameter. Will rename
-// existing dumpFile, but will not delete until new dumpFile can be parsed
-// and verified to contain data that is identical to users.
-func Write(dumpFile string, userCopy map[string]string) (err error) {
+// Checks that Write() could create its temporary file next to dumpFile by
+// creating and removing one. Nothing is written to dumpFile itself.
+func Writable(dumpFile string) (err error) {
+	var tmp *os.File
+	dir, base := filepath.Split(dumpFile)
+	if tmp, err = ioutil.TempFile(dir, base+".*"+TEMP_FILE_EXTENSION); err != nil {
+		return
+	}
+	tmp.Close()
+	return os.Remove(tmp.Name())
+}
+
+// Expects value passed as parameter to be copy of main data store. Function
+// writes JSON encoded document to a temporary file in the same directory as
+// dumpFile, fsyncs, and verifies it before renaming over dumpFile. Rename is
+// atomic so a crash at any point leaves either the original or the new
+// dumpFile in place, never a truncated one.
+func Write(dumpFile string, userCopy interface{}) (err error) {
 
 	var mode os.FileMode
 	var data []byte
-	backup := dumpFile + BACKUP_FILE_EXTENSION
+	var tmp *os.File
+	gz := compressed(dumpFile)
+
+	// Preserve permissions of an existing dumpFile.
+	if mode, err = Exists(dumpFile); err != nil {
+		mode = FileMode
+	}
 
-	if mode, err = Exists(dumpFile); err == nil {
-		log.Trace("backup: Renaming original dumpFile.")
-		if err = os.Rename(dumpFile, backup); err != nil {
+	log.Trace("backup: Serializing duplicate user's map.")
+	if data, err = Encoding.Marshal(userCopy); err != nil {
+		return
+	}
+
+	if gz {
+		log.Trace("backup: Compressing dumpFile.")
+		if data, err = compress(data); err != nil {
 			return
 		}
-	} else {
-		// ioutil.WriteFile needs default mode or dumpFile will
-		// be created with no permission bits set.
-		mode = DEFAULT_MODE
 	}
 
-	log.Trace("backup: Serializing duplicate user's map.")
-	if data, err = json.Marshal(&userCopy); err != nil {
+	log.Trace("backup: Writing temporary dumpFile to disk.")
+	dir, base := filepath.Split(dumpFile)
+	if tmp, err = ioutil.TempFile(dir, base+".*"+TEMP_FILE_EXTENSION); err != nil {
 		return
 	}
+	tmpName := tmp.Name()
 
-	log.Trace("backup: Writing dumpFile to disk.")
-	if err = ioutil.WriteFile(dumpFile, data, mode); err != nil {
+	// Remove the temporary file on any failure below so
+	// repeated failures don't litter the directory.
+	defer func() {
+		if err != nil {
+			os.Remove(tmpName)
+		}
+	}()
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return
+	}
+	if err = tmp.Close(); err != nil {
+		return
+	}
+	if err = os.Chmod(tmpName, mode); err != nil {
 		return
 	}
 
-	log.Trace("backup: Verifying dumpFile.")
-	if err = verify(dumpFile, userCopy); err != nil {
+	log.Trace("backup: Verifying temporary dumpFile.")
+	if err = verify(tmpName, gz, userCopy); err != nil {
 		return
 	}
 
-	// A .bak dumpFile will not exist if the original dumpFile was not present
-	// when Write() was called. This block must use a separate error
-	// variable or function will incorrectly report an error.
-	if _, bakErr := Exists(backup); bakErr == nil {
-		err = os.Remove(backup)
+	log.Trace("backup: Renaming temporary dumpFile into place.")
+	if err = os.Rename(tmpName, dumpFile); err != nil {
+		return
+	}
+
+	// Sync the directory so the rename itself survives a crash. Not all
+	// platforms support syncing a directory, so failure is only logged.
+	if d, dirErr := os.Open(filepath.Dir(dumpFile)); dirErr == nil {
+		if syncErr := d.Sync(); syncErr != nil {
+			log.Trace("backup: Unable to sync directory - " + syncErr.Error())
+		}
+		d.Close()
 	}
 
 	return
@@ -0,0 +1,95 @@
+//  Copyright (C) Pat Kaehuaea - All Rights Reserved
+//  Unauthorized copying of this file, via any medium is strictly prohibited
+//  Proprietary and confidential
+//  Written by Pat Kaehuaea, March 2015
+
+package backup
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+var GZIP_MAGIC = []byte{0x1f, 0x8b}
+
+var TEST_USERS = map[string]string{
+	"0f8b6a3e-6f4e-4c1a-9d2b-3e5f7a9c1b2d": "Ada",
+	"7c1d2e3f-4a5b-4c6d-8e9f-0a1b2c3d4e5f": "Grace",
+}
+
+func TestRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		gz   bool
+	}{
+		{"users.json", false},
+		{"users.json.gz", true},
+	}
+	for _, tt := range tests {
+		dumpFile := filepath.Join(t.TempDir(), tt.name)
+		if err := Write(dumpFile, TEST_USERS); err != nil {
+			t.Fatalf("%s: Write() - %v", tt.name, err)
+		}
+
+		raw, err := ioutil.ReadFile(dumpFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if gz := bytes.HasPrefix(raw, GZIP_MAGIC); gz != tt.gz {
+			t.Errorf("%s: gzip compressed %v, want %v", tt.name, gz, tt.gz)
+		}
+
+		var got map[string]string
+		if err := Read(dumpFile, &got); err != nil {
+			t.Fatalf("%s: Read() - %v", tt.name, err)
+		}
+		if !reflect.DeepEqual(got, TEST_USERS) {
+			t.Errorf("%s: read %v, want %v", tt.name, got, TEST_USERS)
+		}
+	}
+}
+
+func TestReadCorruptGzip(t *testing.T) {
+	dumpFile := filepath.Join(t.TempDir(), "users.json.gz")
+	if err := ioutil.WriteFile(dumpFile, []byte(`{"not":"compressed"}`), DEFAULT_MODE); err != nil {
+		t.Fatal(err)
+	}
+	target := map[string]string{"kept": "as is"}
+	if err := Read(dumpFile, &target); err == nil {
+		t.Error("Read() of an uncompressed .gz dumpFile succeeded")
+	}
+	if target["kept"] != "as is" || len(target) != 1 {
+		t.Errorf("target changed by failed Read() - %v", target)
+	}
+}
+
+// A crash mid Write() leaves a truncated temporary file beside the
+// dumpFile. Neither it nor a failed Write() may disturb the original.
+func TestInterruptedWrite(t *testing.T) {
+	dumpFile := filepath.Join(t.TempDir(), "users.json")
+	if err := Write(dumpFile, TEST_USERS); err != nil {
+		t.Fatal(err)
+	}
+	leftover := dumpFile + ".4242" + TEMP_FILE_EXTENSION
+	if err := ioutil.WriteFile(leftover, []byte(`{"0f8b6a3e-`), DEFAULT_MODE); err != nil {
+		t.Fatal(err)
+	}
+	if err := Write(dumpFile, map[string]interface{}{"unencodable": func() {}}); err == nil {
+		t.Error("Write() of an unencodable value succeeded")
+	}
+
+	var got map[string]string
+	if err := Read(dumpFile, &got); err != nil {
+		t.Fatalf("Read() - %v", err)
+	}
+	if !reflect.DeepEqual(got, TEST_USERS) {
+		t.Errorf("read %v, want original %v", got, TEST_USERS)
+	}
+	tmps, _ := filepath.Glob(dumpFile + ".*" + TEMP_FILE_EXTENSION)
+	if len(tmps) != 1 || tmps[0] != leftover {
+		t.Errorf("temporary files %v, want only the leftover %s", tmps, leftover)
+	}
+}
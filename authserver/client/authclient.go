@@ -4,13 +4,24 @@
 //  Written by Pat Kaehuaea, February 2015
 //
 // Package exposes AuthClient as interface to authserver. Exposes methods
-// to construct a new AuthClient as well as Get() and Set() users. Both
-// functions able to use request helper function because authserver implements
-// endpoints as GET rather than GET and POST.
+// to construct a new AuthClient as well as Get(), Set(), and Delete()
+// users, Theme() and SetTheme() their display theme, Timezone() and
+// SetTimezone() their preferred time zone, Stopwatch() and
+// UpdateStopwatch() their stopwatch, Countdown() and SetCountdown() their
+// countdown, count Users(), and check the authserver is Ready(). All
+// functions able to use request helper function because authserver
+// implements endpoints as GET rather than GET and POST. People() and
+// Clear() call admin endpoints with a token through adminRequest instead.
+// Every call takes a context and is abandoned once the context is done,
+// so a request cancelled by its caller does not wait on authserver.
 package client
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	log "github.com/cihub/seelog"
+	"github.com/patkaehuaea/command/authserver/people"
 	"io/ioutil"
 	"net/http"
 	"net/url"
@@ -18,9 +29,17 @@ import (
 )
 
 const (
-	AUTH_SCHEME = "http"
+	AUTH_SCHEME   = "http"
+	BEARER_PREFIX = "Bearer "
 )
 
+// Returned when authserver responds 503, which it does when
+// its user store is at capacity.
+var ErrCapacity = errors.New("auth: Authserver at capacity.")
+
+// Returned by Ready() when authserver answers its readiness check with 503.
+var ErrNotReady = errors.New("auth: Authserver not ready.")
+
 // Host and port stored as strings, with
 // port expected in form ':8080'.
 type AuthClient struct {
@@ -43,10 +62,10 @@ func NewAuthClient(host string, port string, timeoutMS time.Duration) (ac *AuthC
 // on UUID or name before submission. Returns name if found
 // by authserver and empty otherwise. Error associated with
 // HTTP request are returned to caller.
-func (ac *AuthClient) Get(uuid string) (name string, err error) {
+func (ac *AuthClient) Get(ctx context.Context, uuid string) (name string, err error) {
 	log.Trace("auth: Get called.")
 	params := map[string]string{"cookie": uuid}
-	name, err = ac.request("get", params)
+	name, err = ac.request(ctx, "get", params)
 	log.Trace("auth: Get complete.")
 	return
 }
@@ -55,18 +74,243 @@ func (ac *AuthClient) Get(uuid string) (name string, err error) {
 // and map of cookie to uuid, and name to name. Performs no error
 // checking on UUID or name. Error associated with
 // HTTP request is returned to caller.
-func (ac *AuthClient) Set(uuid string, name string) (err error) {
+func (ac *AuthClient) Set(ctx context.Context, uuid string, name string) (err error) {
 	log.Trace("auth: Set called.")
 	params := map[string]string{"cookie": uuid, "name": name}
-	_, err = ac.request("set", params)
+	_, err = ac.request(ctx, "set", params)
 	log.Trace("auth: Set complete.")
 	return
 }
 
+// As Set(), also recording identity, the login provider account the
+// user signed in with, such as github:583231.
+func (ac *AuthClient) SetIdentified(ctx context.Context, uuid string, name string, identity string) (err error) {
+	log.Trace("auth: SetIdentified called.")
+	params := map[string]string{"cookie": uuid, "name": name, "identity": identity}
+	_, err = ac.request(ctx, "set", params)
+	log.Trace("auth: SetIdentified complete.")
+	return
+}
+
+// Calls private request method with "delete" as parameter and map
+// of cookie to uuid. Succeeds whether or not the user was present.
+// Error associated with HTTP request is returned to caller.
+func (ac *AuthClient) Delete(ctx context.Context, uuid string) (err error) {
+	log.Trace("auth: Delete called.")
+	params := map[string]string{"cookie": uuid}
+	_, err = ac.request(ctx, "delete", params)
+	log.Trace("auth: Delete complete.")
+	return
+}
+
+// Calls private request method with "theme/get" as parameter and
+// map of cookie to uuid. Returns the user's display theme, empty if
+// none was chosen or the user is not found.
+func (ac *AuthClient) Theme(ctx context.Context, uuid string) (theme string, err error) {
+	log.Trace("auth: Theme called.")
+	params := map[string]string{"cookie": uuid}
+	theme, err = ac.request(ctx, "theme/get", params)
+	log.Trace("auth: Theme complete.")
+	return
+}
+
+// Calls private request method with "theme/set" as parameter and
+// map of cookie to uuid, and theme to theme. Error associated with
+// HTTP request, including an unknown user, is returned to caller.
+func (ac *AuthClient) SetTheme(ctx context.Context, uuid string, theme string) (err error) {
+	log.Trace("auth: SetTheme called.")
+	params := map[string]string{"cookie": uuid, "theme": theme}
+	_, err = ac.request(ctx, "theme/set", params)
+	log.Trace("auth: SetTheme complete.")
+	return
+}
+
+// Calls private request method with "timezone/get" as parameter and
+// map of cookie to uuid. Returns the user's preferred time zone, empty
+// if none was chosen or the user is not found.
+func (ac *AuthClient) Timezone(ctx context.Context, uuid string) (tz string, err error) {
+	log.Trace("auth: Timezone called.")
+	params := map[string]string{"cookie": uuid}
+	tz, err = ac.request(ctx, "timezone/get", params)
+	log.Trace("auth: Timezone complete.")
+	return
+}
+
+// Calls private request method with "timezone/set" as parameter and
+// map of cookie to uuid, and timezone to tz. An empty tz clears the
+// preference. Error associated with HTTP request, including an unknown
+// user, is returned to caller.
+func (ac *AuthClient) SetTimezone(ctx context.Context, uuid string, tz string) (err error) {
+	log.Trace("auth: SetTimezone called.")
+	params := map[string]string{"cookie": uuid, "timezone": tz}
+	_, err = ac.request(ctx, "timezone/set", params)
+	log.Trace("auth: SetTimezone complete.")
+	return
+}
+
+// Calls private request method with "stopwatch/get" as parameter and
+// map of cookie to uuid. Returns the user's stopwatch, stopped and zeroed
+// if the user has none or is not found.
+func (ac *AuthClient) Stopwatch(ctx context.Context, uuid string) (sw people.Stopwatch, err error) {
+	log.Trace("auth: Stopwatch called.")
+	var contents string
+	params := map[string]string{"cookie": uuid}
+	if contents, err = ac.request(ctx, "stopwatch/get", params); err != nil {
+		return
+	}
+	err = json.Unmarshal([]byte(contents), &sw)
+	log.Trace("auth: Stopwatch complete.")
+	return
+}
+
+// Calls private request method with "stopwatch/set" as parameter and
+// map of cookie to uuid, action to action, and at to at. Returns the
+// stopwatch after the action. Error associated with HTTP request,
+// including an unknown user, is returned to caller.
+func (ac *AuthClient) UpdateStopwatch(ctx context.Context, uuid string, action string, at time.Time) (sw people.Stopwatch, err error) {
+	log.Trace("auth: UpdateStopwatch called.")
+	var contents string
+	params := map[string]string{"cookie": uuid, "action": action, "at": at.Format(time.RFC3339Nano)}
+	if contents, err = ac.request(ctx, "stopwatch/set", params); err != nil {
+		return
+	}
+	err = json.Unmarshal([]byte(contents), &sw)
+	log.Trace("auth: UpdateStopwatch complete.")
+	return
+}
+
+// Calls private request method with "countdown/get" as parameter and
+// map of cookie to uuid. Returns the user's countdown, the zero
+// countdown if the user has none or is not found.
+func (ac *AuthClient) Countdown(ctx context.Context, uuid string) (c people.Countdown, err error) {
+	log.Trace("auth: Countdown called.")
+	var contents string
+	params := map[string]string{"cookie": uuid}
+	if contents, err = ac.request(ctx, "countdown/get", params); err != nil {
+		return
+	}
+	err = json.Unmarshal([]byte(contents), &c)
+	log.Trace("auth: Countdown complete.")
+	return
+}
+
+// Calls private request method with "countdown/set" as parameter and
+// map of cookie to uuid, and ends and duration to those of c. A
+// Countdown that is not Set() clears the user's countdown. Error
+// associated with HTTP request, including an unknown user, is returned
+// to caller.
+func (ac *AuthClient) SetCountdown(ctx context.Context, uuid string, c people.Countdown) (err error) {
+	log.Trace("auth: SetCountdown called.")
+	params := map[string]string{"cookie": uuid}
+	if c.Set() {
+		params["ends"] = c.Ends.Format(time.RFC3339Nano)
+		params["duration"] = c.Duration.String()
+	}
+	_, err = ac.request(ctx, "countdown/set", params)
+	log.Trace("auth: SetCountdown complete.")
+	return
+}
+
+// Calls private request method with "readyz" as parameter. Returns nil
+// when authserver is reachable and ready to serve, otherwise the reason
+// it is not.
+func (ac *AuthClient) Ready(ctx context.Context) (err error) {
+	log.Trace("auth: Ready called.")
+	if _, err = ac.request(ctx, "readyz", nil); err == ErrCapacity {
+		err = ErrNotReady
+	}
+	log.Trace("auth: Ready complete.")
+	return
+}
+
+// Calls private request method with "stats" as parameter and returns
+// the number of users held by authserver. Error associated with HTTP
+// request, or a malformed response, is returned to caller.
+func (ac *AuthClient) Users(ctx context.Context) (count int, err error) {
+	log.Trace("auth: Users called.")
+	var contents string
+	if contents, err = ac.request(ctx, "stats", nil); err != nil {
+		return
+	}
+	var stats struct {
+		Count int `json:"count"`
+	}
+	if err = json.Unmarshal([]byte(contents), &stats); err != nil {
+		return
+	}
+	count = stats.Count
+	log.Trace("auth: Users complete.")
+	return
+}
+
+// Returns every user held by authserver, read from its admin export
+// endpoint with token. Order is undefined.
+func (ac *AuthClient) People(ctx context.Context, token string) (list []people.Person, err error) {
+	log.Trace("auth: People called.")
+	var body []byte
+	if body, err = ac.adminRequest(ctx, http.MethodGet, "export", token); err != nil {
+		return
+	}
+	var all map[string]people.Person
+	if err = json.Unmarshal(body, &all); err != nil {
+		return
+	}
+	for id, person := range all {
+		person.ID = id
+		list = append(list, person)
+	}
+	log.Trace("auth: People complete.")
+	return
+}
+
+// Removes every user held by authserver through its admin clear endpoint
+// with token. Returns the number removed.
+func (ac *AuthClient) Clear(ctx context.Context, token string) (removed int, err error) {
+	log.Trace("auth: Clear called.")
+	var body []byte
+	if body, err = ac.adminRequest(ctx, http.MethodPost, "admin/clear", token); err != nil {
+		return
+	}
+	var result struct {
+		Removed int `json:"removed"`
+	}
+	if err = json.Unmarshal(body, &result); err != nil {
+		return
+	}
+	removed = result.Removed
+	log.Trace("auth: Clear complete.")
+	return
+}
+
+// Sends a bodiless request with method to an admin endpoint at path,
+// authorized by token. Returns the response body, or error if the request
+// failed or status was not 200 OK.
+func (ac *AuthClient) adminRequest(ctx context.Context, method string, path string, token string) (body []byte, err error) {
+	uri := url.URL{Scheme: AUTH_SCHEME, Host: ac.host + ac.port, Path: path}
+	var req *http.Request
+	if req, err = http.NewRequestWithContext(ctx, method, uri.String(), nil); err != nil {
+		return
+	}
+	req.Header.Set("Authorization", BEARER_PREFIX+token)
+
+	log.Debug("auth: Requesting admin URI - " + uri.String())
+	var resp *http.Response
+	if resp, err = ac.client.Do(req); err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		err = errors.New("auth: Unexpected admin response status - " + resp.Status)
+		return
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
 // Takes the request path as an argument along with a map of parameters. Map is encoded
 // into URL then submitted via HTTP GET request to authserver. Returns the content of the
-// response as a string and error if request failed.
-func (ac *AuthClient) request(path string, params map[string]string) (contents string, err error) {
+// response as a string and error if request failed or status was not 200 OK.
+func (ac *AuthClient) request(ctx context.Context, path string, params map[string]string) (contents string, err error) {
 	log.Trace("auth: Request called.")
 
 	var resp *http.Response
@@ -79,8 +323,13 @@ func (ac *AuthClient) request(path string, params map[string]string) (contents s
 	}
 	uri.RawQuery = values.Encode()
 
+	var req *http.Request
+	if req, err = http.NewRequestWithContext(ctx, http.MethodGet, uri.String(), nil); err != nil {
+		return
+	}
+
 	log.Debug("auth: Requesting URI - " + uri.String())
-	if resp, err = ac.client.Get(uri.String()); err != nil {
+	if resp, err = ac.client.Do(req); err != nil {
 		return
 	}
 
@@ -89,6 +338,17 @@ func (ac *AuthClient) request(path string, params map[string]string) (contents s
 	// is non-nil. Calling here, after error checking
 	// ensures response is valid.
 	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusServiceUnavailable:
+		err = ErrCapacity
+		return
+	default:
+		err = errors.New("auth: Unexpected response status - " + resp.Status)
+		return
+	}
+
 	if body, err = ioutil.ReadAll(resp.Body); err != nil {
 		return
 	}
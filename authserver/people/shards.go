@@ -0,0 +1,108 @@
+//  Copyright (C) Pat Kaehuaea - All Rights Reserved
+//  Unauthorized copying of this file, via any medium is strictly prohibited
+//  Proprietary and confidential
+//  Written by Pat Kaehuaea, March 2015
+//
+// Users are split across SHARD_COUNT maps by a hash of their id, each
+// with its own lock, so lookups and visits for different users on the
+// /time path rarely wait on one another. Operations on one user lock only
+// that user's shard. Operations on the whole store, such as Import() or
+// Dump(), lock every shard in index order, which keeps them consistent and
+// free of deadlock with each other.
+
+package people
+
+import (
+	"sync"
+)
+
+// Number of shards. A power of two so the hash needs only a mask.
+const SHARD_COUNT = 32
+
+// FNV-1a parameters used to hash ids to shards.
+const (
+	FNV_OFFSET = 2166136261
+	FNV_PRIME  = 16777619
+)
+
+// Part of the store holding the users whose ids hash to it. users is nil
+// in a store not created by NewUsers().
+type shard struct {
+	sync.RWMutex
+	users map[string]Person
+	// Pads shards to a 64 byte cache line so locking one does not slow
+	// its neighbour.
+	_ [32]byte
+}
+
+// Returns the index of the shard holding the user with id.
+func shardIndex(id string) int {
+	var h uint32 = FNV_OFFSET
+	for i := 0; i < len(id); i++ {
+		h ^= uint32(id[i])
+		h *= FNV_PRIME
+	}
+	return int(h & (SHARD_COUNT - 1))
+}
+
+// Returns the shard holding the user with id.
+func (u *UserStore) shardFor(id string) *shard {
+	return &u.shards[shardIndex(id)]
+}
+
+// Acquires the write lock of every shard in index order.
+func (u *UserStore) lockAll() {
+	for i := range u.shards {
+		u.shards[i].Lock()
+	}
+}
+
+func (u *UserStore) unlockAll() {
+	for i := range u.shards {
+		u.shards[i].Unlock()
+	}
+}
+
+// Acquires the read lock of every shard in index order, so the store can
+// be read as a whole while lookups continue.
+func (u *UserStore) rlockAll() {
+	for i := range u.shards {
+		u.shards[i].RLock()
+	}
+}
+
+func (u *UserStore) runlockAll() {
+	for i := range u.shards {
+		u.shards[i].RUnlock()
+	}
+}
+
+// Performs read lock on the shard of user with id and returns a copy of
+// the Person. ok is false if not found.
+func (u *UserStore) lookup(id string) (person Person, ok bool) {
+	s := u.shardFor(id)
+	s.RLock()
+	person, ok = s.users[id]
+	s.RUnlock()
+	return
+}
+
+// Acquires RW lock on the shard of user with id and replaces the Person
+// with the one change returns, marking the store dirty. Returns the
+// changed Person, or false, changing nothing, if the user is not found.
+func (u *UserStore) update(id string, change func(Person) Person) (person Person, ok bool) {
+	s := u.shardFor(id)
+	s.Lock()
+	if person, ok = s.users[id]; ok {
+		person = change(person)
+		s.users[id] = person
+		u.dirty.Store(true)
+	}
+	s.Unlock()
+	return
+}
+
+// Returns the number of users in the store without taking any lock.
+func (u *UserStore) Len() int {
+	return int(u.count.Load())
+}
@@ -0,0 +1,194 @@
+//  Copyright (C) Pat Kaehuaea - All Rights Reserved
+//  Unauthorized copying of this file, via any medium is strictly prohibited
+//  Proprietary and confidential
+//  Written by Pat Kaehuaea, March 2015
+//
+// Run with -race. The concurrent tests only prove the sharded locking
+// sound under the race detector.
+
+package people
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// Len() must track every Add() and Remove() that changed the store and
+// none that failed.
+func TestLen(t *testing.T) {
+	u := NewUsers(2)
+	tests := []struct {
+		name string
+		op   func() error
+		err  error
+		len  int
+	}{
+		{"add", func() error { return u.Add(testID(1), "Ada") }, nil, 1},
+		{"add duplicate", func() error { return u.Add(testID(1), "Grace") }, ErrDuplicateID, 1},
+		{"add second", func() error { return u.Add(testID(2), "Grace") }, nil, 2},
+		{"add when full", func() error { return u.Add(testID(3), "Linus") }, ErrStoreFull, 2},
+		{"add duplicate when full", func() error { return u.Add(testID(2), "Linus") }, ErrDuplicateID, 2},
+		{"remove", func() error { return u.Remove(testID(1)) }, nil, 1},
+		{"remove missing", func() error { return u.Remove(testID(1)) }, ErrUserNotFound, 1},
+		{"add after remove", func() error { return u.Add(testID(3), "Linus") }, nil, 2},
+	}
+	for _, tt := range tests {
+		if err := tt.op(); !errors.Is(err, tt.err) {
+			t.Errorf("%s: error %v, want %v", tt.name, err, tt.err)
+		}
+		if u.Len() != tt.len || len(u.Snapshot()) != tt.len {
+			t.Errorf("%s: Len() = %d holding %d users, want %d", tt.name, u.Len(), len(u.Snapshot()), tt.len)
+		}
+	}
+}
+
+// Exactly one of many concurrent adds of an id succeeds, and concurrent
+// adds of distinct ids never overfill the store.
+func TestConcurrentAdd(t *testing.T) {
+	const (
+		workers  = 64
+		capacity = 20
+	)
+	tests := []struct {
+		name       string
+		max        int
+		id         func(i int) string
+		added      int64
+		duplicates int64
+		full       int64
+	}{
+		{"same id", NO_CAPACITY_LIMIT, func(int) string { return testID(1) }, 1, workers - 1, 0},
+		{"distinct ids", capacity, testID, capacity, 0, workers - capacity},
+	}
+	for _, tt := range tests {
+		u := NewUsers(tt.max)
+		var added, duplicates, full atomic.Int64
+		var wg sync.WaitGroup
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				switch err := u.Add(tt.id(i), "Ada"); {
+				case err == nil:
+					added.Add(1)
+				case errors.Is(err, ErrDuplicateID):
+					duplicates.Add(1)
+				case errors.Is(err, ErrStoreFull):
+					full.Add(1)
+				}
+			}(i)
+		}
+		wg.Wait()
+		if added.Load() != tt.added || duplicates.Load() != tt.duplicates || full.Load() != tt.full {
+			t.Errorf("%s: %d added, %d duplicates, %d full, want %d, %d, %d", tt.name,
+				added.Load(), duplicates.Load(), full.Load(), tt.added, tt.duplicates, tt.full)
+		}
+		if u.Len() != int(tt.added) || len(u.Snapshot()) != int(tt.added) {
+			t.Errorf("%s: Len() = %d holding %d users, want %d", tt.name, u.Len(), len(u.Snapshot()), tt.added)
+		}
+	}
+}
+
+// Workers churn through their own ids and a set shared by all, so
+// operations on one id and on ids across shards interleave.
+func TestConcurrentChurn(t *testing.T) {
+	const (
+		workers = 16
+		rounds  = 200
+		shared  = 8
+	)
+	u := NewUsers(NO_CAPACITY_LIMIT)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				own := testID(shared + w*rounds + i)
+				common := testID(i % shared)
+				for _, id := range []string{own, common} {
+					u.Add(id, "Ada")
+					u.Get(id)
+					u.Visit(id)
+					u.Name(id)
+				}
+				u.Remove(common)
+				if i%2 == 1 {
+					u.Remove(own)
+				}
+			}
+		}(w)
+	}
+	// Whole store readers run alongside.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			u.Stats()
+			u.Snapshot()
+		}
+	}()
+	wg.Wait()
+	<-done
+
+	want := workers * rounds / 2
+	for i := 0; i < shared; i++ {
+		if u.Exists(testID(i)) {
+			want++
+		}
+	}
+	if u.Len() != want || len(u.Snapshot()) != want {
+		t.Errorf("Len() = %d holding %d users, want %d", u.Len(), len(u.Snapshot()), want)
+	}
+}
+
+// Store guarded by a single lock, the design the shards replaced.
+type mutexStore struct {
+	sync.RWMutex
+	users map[string]Person
+}
+
+func (m *mutexStore) Get(id string) (person Person, err error) {
+	m.RLock()
+	person, ok := m.users[id]
+	m.RUnlock()
+	if !ok {
+		err = ErrUserNotFound
+	}
+	return
+}
+
+// Compares parallel lookups, as on the /time path, in the sharded store
+// and behind a single lock.
+func BenchmarkGet(b *testing.B) {
+	const size = 10000
+	sharded := NewUsers(NO_CAPACITY_LIMIT)
+	single := &mutexStore{users: make(map[string]Person, size)}
+	ids := make([]string, size)
+	for i := range ids {
+		ids[i] = testID(i)
+		sharded.Add(ids[i], "Ada")
+		single.users[ids[i]] = Person{ID: ids[i], Name: "Ada"}
+	}
+	stores := []struct {
+		name string
+		get  func(id string) (Person, error)
+	}{
+		{"sharded", sharded.Get},
+		{"single mutex", single.Get},
+	}
+	for _, store := range stores {
+		b.Run(store.name, func(b *testing.B) {
+			var next atomic.Int64
+			b.RunParallel(func(pb *testing.PB) {
+				i := int(next.Add(1)) * 7919
+				for pb.Next() {
+					store.get(ids[i%size])
+					i++
+				}
+			})
+		})
+	}
+}
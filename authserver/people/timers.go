@@ -0,0 +1,156 @@
+//  Copyright (C) Pat Kaehuaea - All Rights Reserved
+//  Unauthorized copying of this file, via any medium is strictly prohibited
+//  Proprietary and confidential
+//  Written by Pat Kaehuaea, March 2015
+//
+// Personal stopwatch and countdown kept on each Person so they follow the
+// session. Times are supplied by the caller, the timeserver, so readings
+// agree with the clock it reports rather than the authserver's.
+
+package people
+
+import (
+	"time"
+)
+
+const (
+	// Laps kept on a stopwatch. Later laps are ignored.
+	MAX_LAPS = 100
+	// Longest countdown that may be started.
+	MAX_COUNTDOWN = 24 * time.Hour
+)
+
+// Actions accepted by UpdateStopwatch().
+var STOPWATCH_ACTIONS = map[string]bool{
+	"start": true,
+	"stop":  true,
+	"lap":   true,
+	"reset": true,
+}
+
+// Stopwatch of a user. Elapsed is the time run before the current run,
+// which began at Started and is zero while stopped. Laps are the
+// stopwatch's readings when each lap was taken.
+type Stopwatch struct {
+	Started time.Time       `json:"started,omitzero"`
+	Elapsed time.Duration   `json:"elapsed"`
+	Laps    []time.Duration `json:"laps,omitempty"`
+}
+
+// Countdown of a user, ending at Ends after running for Duration. The
+// zero value is no countdown.
+type Countdown struct {
+	Ends     time.Time     `json:"ends"`
+	Duration time.Duration `json:"duration"`
+}
+
+// Returns true if the stopwatch is running.
+func (s Stopwatch) Running() bool {
+	return !s.Started.IsZero()
+}
+
+// Returns the time shown by the stopwatch at now.
+func (s Stopwatch) Reading(now time.Time) time.Duration {
+	if !s.Running() {
+		return s.Elapsed
+	}
+	return s.Elapsed + now.Sub(s.Started)
+}
+
+// Returns the stopwatch after applying action at time at. Starting a
+// running stopwatch, stopping a stopped one, or taking a lap while
+// stopped changes nothing.
+func (s Stopwatch) Apply(action string, at time.Time) Stopwatch {
+	switch action {
+	case "start":
+		if !s.Running() {
+			s.Started = at
+		}
+	case "stop":
+		if s.Running() {
+			s.Elapsed = s.Reading(at)
+			s.Started = time.Time{}
+		}
+	case "lap":
+		if s.Running() && len(s.Laps) < MAX_LAPS {
+			s.Laps = append(append([]time.Duration(nil), s.Laps...), s.Reading(at))
+		}
+	case "reset":
+		s = Stopwatch{}
+	}
+	return s
+}
+
+// Returns true if the countdown is set.
+func (c Countdown) Set() bool {
+	return !c.Ends.IsZero()
+}
+
+// Returns the time left on the countdown at now, zero once it has ended.
+func (c Countdown) Remaining(now time.Time) time.Duration {
+	if left := c.Ends.Sub(now); left > 0 {
+		return left
+	}
+	return 0
+}
+
+// Returns true if action is one of people.STOPWATCH_ACTIONS.
+func IsValidStopwatchAction(action string) bool {
+	return STOPWATCH_ACTIONS[action]
+}
+
+// Returns true if a countdown may run for d.
+func IsValidCountdown(d time.Duration) bool {
+	return d > 0 && d <= MAX_COUNTDOWN
+}
+
+// Acquires RW lock and applies action to the stopwatch of user with id
+// at time at. Returns the resulting stopwatch, or false, recording
+// nothing, if the user is not found.
+func (u *UserStore) UpdateStopwatch(id string, action string, at time.Time) (sw Stopwatch, ok bool) {
+	person, ok := u.update(id, func(person Person) Person {
+		var current Stopwatch
+		if person.Stopwatch != nil {
+			current = *person.Stopwatch
+		}
+		current = current.Apply(action, at)
+		person.Stopwatch = &current
+		return person
+	})
+	if ok {
+		sw = *person.Stopwatch
+	}
+	return
+}
+
+// Performs read lock on Users and returns the stopwatch of user with id.
+// Returns a stopped, zeroed stopwatch if not found.
+func (u *UserStore) Stopwatch(id string) (sw Stopwatch) {
+	if person, _ := u.lookup(id); person.Stopwatch != nil {
+		sw = *person.Stopwatch
+	}
+	return
+}
+
+// Acquires RW lock and sets the countdown of user with id. A Countdown
+// that is not Set() clears it. Returns false, recording nothing, if the
+// user is not found.
+func (u *UserStore) SetCountdown(id string, c Countdown) (ok bool) {
+	_, ok = u.update(id, func(person Person) Person {
+		person.Countdown = nil
+		if c.Set() {
+			person.Countdown = &c
+		}
+		return person
+	})
+	return
+}
+
+// Performs read lock on Users and returns the countdown of user with id.
+// Returns the zero Countdown if not found or none is set.
+func (u *UserStore) Countdown(id string) (c Countdown) {
+	if person, _ := u.lookup(id); person.Countdown != nil {
+		c = *person.Countdown
+	}
+	return
+}
@@ -0,0 +1,189 @@
+//  Copyright (C) Pat Kaehuaea - All Rights Reserved
+//  Unauthorized copying of this file, via any medium is strictly prohibited
+//  Proprietary and confidential
+//  Written by Pat Kaehuaea, March 2015
+
+package people
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Instant the stopwatch tests start from.
+var T0 = time.Date(2015, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+// Returns T0 plus seconds.
+func at(seconds int) time.Time {
+	return T0.Add(time.Duration(seconds) * time.Second)
+}
+
+func TestStopwatchApply(t *testing.T) {
+	type step struct {
+		action string
+		second int
+	}
+	tests := []struct {
+		name  string
+		steps []step
+		want  Stopwatch
+	}{
+		{"start", []step{{"start", 0}}, Stopwatch{Started: at(0)}},
+		{"start running", []step{{"start", 0}, {"start", 5}}, Stopwatch{Started: at(0)}},
+		{"stop", []step{{"start", 0}, {"stop", 5}}, Stopwatch{Elapsed: 5 * time.Second}},
+		{"stop stopped", []step{{"stop", 5}}, Stopwatch{}},
+		{"resume", []step{{"start", 0}, {"stop", 5}, {"start", 10}}, Stopwatch{Started: at(10), Elapsed: 5 * time.Second}},
+		{"resume and stop", []step{{"start", 0}, {"stop", 5}, {"start", 10}, {"stop", 12}}, Stopwatch{Elapsed: 7 * time.Second}},
+		{"laps", []step{{"start", 0}, {"lap", 3}, {"lap", 7}}, Stopwatch{Started: at(0), Laps: []time.Duration{3 * time.Second, 7 * time.Second}}},
+		{"lap after resume", []step{{"start", 0}, {"stop", 5}, {"start", 10}, {"lap", 11}}, Stopwatch{Started: at(10), Elapsed: 5 * time.Second, Laps: []time.Duration{6 * time.Second}}},
+		{"lap stopped", []step{{"start", 0}, {"stop", 5}, {"lap", 6}}, Stopwatch{Elapsed: 5 * time.Second}},
+		{"reset running", []step{{"start", 0}, {"lap", 3}, {"reset", 5}}, Stopwatch{}},
+		{"reset stopped", []step{{"start", 0}, {"stop", 5}, {"reset", 6}}, Stopwatch{}},
+		{"unknown action", []step{{"start", 0}, {"pause", 5}}, Stopwatch{Started: at(0)}},
+	}
+	for _, tt := range tests {
+		var sw Stopwatch
+		for _, s := range tt.steps {
+			sw = sw.Apply(s.action, at(s.second))
+		}
+		if !reflect.DeepEqual(sw, tt.want) {
+			t.Errorf("%s: stopwatch = %+v, want %+v", tt.name, sw, tt.want)
+		}
+	}
+}
+
+func TestStopwatchReading(t *testing.T) {
+	tests := []struct {
+		name    string
+		sw      Stopwatch
+		now     time.Time
+		running bool
+		want    time.Duration
+	}{
+		{"zero", Stopwatch{}, at(10), false, 0},
+		{"stopped", Stopwatch{Elapsed: 5 * time.Second}, at(10), false, 5 * time.Second},
+		{"running", Stopwatch{Started: at(2)}, at(10), true, 8 * time.Second},
+		{"resumed", Stopwatch{Started: at(8), Elapsed: 5 * time.Second}, at(10), true, 7 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := tt.sw.Running(); got != tt.running {
+			t.Errorf("%s: Running() = %v, want %v", tt.name, got, tt.running)
+		}
+		if got := tt.sw.Reading(tt.now); got != tt.want {
+			t.Errorf("%s: Reading() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestStopwatchMaxLaps(t *testing.T) {
+	sw := Stopwatch{}.Apply("start", T0)
+	for i := 1; i <= MAX_LAPS+5; i++ {
+		sw = sw.Apply("lap", at(i))
+	}
+	if len(sw.Laps) != MAX_LAPS {
+		t.Fatalf("%d laps kept, want %d", len(sw.Laps), MAX_LAPS)
+	}
+	if last := sw.Laps[MAX_LAPS-1]; last != time.Duration(MAX_LAPS)*time.Second {
+		t.Errorf("last lap = %v, want the %dth", last, MAX_LAPS)
+	}
+}
+
+func TestStopwatchApplyCopiesLaps(t *testing.T) {
+	before := Stopwatch{}.Apply("start", T0).Apply("lap", at(1))
+	before.Laps = append(make([]time.Duration, 0, 4), before.Laps...)
+	after := before.Apply("lap", at(2))
+	after.Laps[0] = time.Hour
+	if len(before.Laps) != 1 || before.Laps[0] != time.Second {
+		t.Errorf("laps of the original stopwatch changed to %v", before.Laps)
+	}
+}
+
+func TestCountdownRemaining(t *testing.T) {
+	c := Countdown{Ends: at(60), Duration: time.Minute}
+	tests := []struct {
+		now  time.Time
+		want time.Duration
+	}{
+		{at(0), time.Minute},
+		{at(59), time.Second},
+		{at(60), 0},
+		{at(3600), 0},
+	}
+	for _, tt := range tests {
+		if got := c.Remaining(tt.now); got != tt.want {
+			t.Errorf("Remaining(%v) = %v, want %v", tt.now, got, tt.want)
+		}
+	}
+	if (Countdown{}).Set() || !c.Set() {
+		t.Error("Set() wrong for the zero or a started countdown")
+	}
+}
+
+func TestIsValidCountdown(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want bool
+	}{
+		{-time.Second, false},
+		{0, false},
+		{time.Nanosecond, true},
+		{10 * time.Minute, true},
+		{MAX_COUNTDOWN, true},
+		{MAX_COUNTDOWN + time.Nanosecond, false},
+	}
+	for _, tt := range tests {
+		if got := IsValidCountdown(tt.d); got != tt.want {
+			t.Errorf("IsValidCountdown(%v) = %v, want %v", tt.d, got, tt.want)
+		}
+	}
+}
+
+func TestIsValidStopwatchAction(t *testing.T) {
+	tests := []struct {
+		action string
+		want   bool
+	}{
+		{"start", true},
+		{"stop", true},
+		{"lap", true},
+		{"reset", true},
+		{"", false},
+		{"Start", false},
+		{"pause", false},
+	}
+	for _, tt := range tests {
+		if got := IsValidStopwatchAction(tt.action); got != tt.want {
+			t.Errorf("IsValidStopwatchAction(%q) = %v, want %v", tt.action, got, tt.want)
+		}
+	}
+}
+
+func TestTimersInStore(t *testing.T) {
+	u := NewUsers(NO_CAPACITY_LIMIT)
+	u.Add(testID(1), "Ada")
+
+	if _, ok := u.UpdateStopwatch(testID(2), "start", T0); ok {
+		t.Error("UpdateStopwatch() succeeded for an unknown user")
+	}
+	if sw, ok := u.UpdateStopwatch(testID(1), "start", T0); !ok || !sw.Running() {
+		t.Errorf("UpdateStopwatch() = %+v, %v, want a running stopwatch", sw, ok)
+	}
+	if sw := u.Stopwatch(testID(1)); sw.Started != T0 {
+		t.Errorf("Stopwatch() = %+v, want started at %v", sw, T0)
+	}
+
+	c := Countdown{Ends: at(60), Duration: time.Minute}
+	if u.SetCountdown(testID(2), c) {
+		t.Error("SetCountdown() succeeded for an unknown user")
+	}
+	if !u.SetCountdown(testID(1), c) || u.Countdown(testID(1)) != c {
+		t.Errorf("Countdown() = %+v, want %+v", u.Countdown(testID(1)), c)
+	}
+	if !u.SetCountdown(testID(1), Countdown{}) || u.Countdown(testID(1)).Set() {
+		t.Error("countdown not cleared by the zero Countdown")
+	}
+	if sw := u.Stopwatch(testID(2)); sw.Running() || sw.Elapsed != 0 {
+		t.Errorf("Stopwatch() of unknown user = %+v, want zero", sw)
+	}
+}
@@ -4,102 +4,509 @@
 //  Written by Pat Kaehuaea, January 2015
 //
 // Package encapsulates a UserStore and acts as an in memory database. The
-// data store is implemented as a map[string]string wrapped by the UserStore
-// type. Helper methods are provided to Add(), Delete() and return Name()
-// data. Data is able to persist beyond program termination by utilizing
-// the backup package. The implementation of the "backup" is abstracted
-// from the data store by the referenced pacakge. Facilities to Dump(),
-// Load(), and Persist() the user data are provided.
+// data store is implemented as maps of id to Person, sharded by id so
+// concurrent lookups rarely contend, wrapped by the UserStore type. Helper
+// methods are provided to Add(), Remove() and return Name() data along
+// with aggregate Stats(). Data is able to persist beyond program
+// termination by utilizing the backup package. The implementation of the
+// "backup" is abstracted from the data store by the referenced pacakge.
+// Facilities to Dump(), Load(), and Persist() the user data to any Store
+// are provided, along with Export() and Import() for backups taken outside
+// the dumpFile.
 package people
 
 import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
 	log "github.com/cihub/seelog"
-	"github.com/patkaehuaea/command/authserver/backup"
-	"os/exec"
+	"io"
 	"regexp"
-	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 )
 
-// First name, or first and last name in English characters with intervening space.
-// Minimum two characters and max length 71 characters including space.
+// First name, or first and last name with intervening space, in letters of
+// any script. Apostrophes and hyphens may join letters within a name, as
+// in O'Brien or Jean-Luc. Minimum two characters and max length 71
+// characters including space and separators.
 const (
-	NAME_REGEX = "^[a-zA-Z]{2,35} {0,1}[a-zA-Z]{0,35}$"
-	UUID_REGEX = "[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}"
+	NAME_CHARS      = `\p{L}\p{M}`
+	NAME_SEPARATORS = "'’-"
+	NAME_MIN_LENGTH = 2
+	NAME_MAX_LENGTH = 71
+	NAME_WORD       = `\p{L}[` + NAME_CHARS + "]*(?:[" + NAME_SEPARATORS + `]\p{L}[` + NAME_CHARS + "]*)*"
+	NAME_REGEX      = "^" + NAME_WORD + "(?: " + NAME_WORD + ")?$"
+	UUID_REGEX      = "^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$"
+	TZ_MAX_LENGTH   = 64
+	// Identities are a provider name and the provider's id for the
+	// user, such as github:583231.
+	IDENTITY_REGEX = `^[a-z][a-z0-9]*:[\x21-\x7e]{1,255}$`
 )
 
+var validName = regexp.MustCompile(NAME_REGEX)
+
+var validIdentity = regexp.MustCompile(IDENTITY_REGEX)
+
+var validUUID = regexp.MustCompile(UUID_REGEX)
+
+// Constraints applied by IsValidName(), published so front-ends can mirror
+// them. Lengths count characters including the space between names.
+type Rules struct {
+	MinLength  int    `json:"min_length"`
+	MaxLength  int    `json:"max_length"`
+	Characters string `json:"allowed_characters"`
+	Separators string `json:"separators"`
+	Spaces     int    `json:"max_spaces"`
+	Unicode    bool   `json:"unicode"`
+	Pattern    string `json:"pattern"`
+}
+
+// Record kept for each user in the data store. Visits counts lookups of the
+// user by the timeserver and LastSeen is the time of the latest lookup.
+// Theme is the user's display theme, empty until one is chosen, and
+// Timezone the IANA name of the zone the user's times are shown in,
+// empty for the server's. Identity names the login provider account the
+// user signed in with, empty for those who typed a name. Stopwatch and
+// Countdown are nil until used, see timers.go.
+type Person struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	CreatedAt time.Time  `json:"created_at"`
+	LastSeen  time.Time  `json:"last_seen"`
+	Visits    int        `json:"visits"`
+	Theme     string     `json:"theme"`
+	Timezone  string     `json:"timezone,omitempty"`
+	Identity  string     `json:"identity,omitempty"`
+	Stopwatch *Stopwatch `json:"stopwatch,omitempty"`
+	Countdown *Countdown `json:"countdown,omitempty"`
+}
+
+// Dumpfiles written before Person was introduced map a uuid to a bare
+// name. Accept either form so existing dumpfiles continue to load.
+func (p *Person) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		p.Name = name
+		return nil
+	}
+	type person Person
+	return json.Unmarshal(data, (*person)(p))
+}
+
+// The dirty flag is set by any change to users, under the changed shard's
+// lock, and cleared when a copy is taken for Dump() with every shard
+// locked. count is the number of users, kept so capacity checks and Len()
+// need not lock every shard. dumpLock serializes calls to Dump() so a
+// periodic checkpoint and a final dump never write the dumpFile
+// concurrently. See shards.go for how users are split across shards.
+//
+// OnAdd and OnRemove are optional callbacks fired after a Person is added,
+// or removed by Remove() or Expire(). They run after the lock is released
+// so they may call back into the store, but run on the caller's go
+// routine. Set them before the store is shared. Load() and Import() do not
+// fire them.
 type UserStore struct {
-	sync.RWMutex
-	users map[string]string
+	dirty    atomic.Bool
+	count    atomic.Int64
+	dumpLock sync.Mutex
+	max      int
+	shards   [SHARD_COUNT]shard
+	OnAdd    func(Person)
+	OnRemove func(Person)
 }
 
-// Adds a *Person to users map. Acquires RW lock before accessing resource.
-func (u *UserStore) Add(id string, name string) {
-	u.Lock()
-	u.users[id] = name
-	u.Unlock()
+const NO_CAPACITY_LIMIT = 0
+
+// Display themes a user may choose. "system" follows the browser's
+// light or dark preference.
+var THEMES = map[string]bool{
+	"system": true,
+	"light":  true,
+	"dark":   true,
+}
+
+// Source of randomness for UUID(). Replaceable with a fixed reader so
+// generated ids are reproducible, for example in end-to-end tests.
+var Rand io.Reader = rand.Reader
+
+// Errors returned by store operations. Compare with errors.Is().
+var (
+	// Returned by Add() when the store holds max users.
+	ErrStoreFull = errors.New("people: User store at capacity.")
+	// Returned by Get() and Remove() when no user has the id.
+	ErrUserNotFound = errors.New("people: User not found.")
+	// Returned by Add() when a user already has the id.
+	ErrDuplicateID = errors.New("people: User id already exists.")
+	// Returned by operations on a store not created by NewUsers().
+	ErrStoreUnavailable = errors.New("people: User store unavailable.")
+)
+
+// Aggregate information about the data store computed in a single pass.
+type UserStats struct {
+	Count           int       `json:"count"`
+	OldestCreatedAt time.Time `json:"oldest_created_at"`
+	NewestCreatedAt time.Time `json:"newest_created_at"`
+	Visits          int       `json:"visits"`
+}
+
+// Adds a Person with id and name to users map. Acquires RW lock on the
+// id's shard before accessing resource. Uniqueness of id is checked under
+// that lock, and a place is reserved against max atomically, so concurrent
+// calls can never exceed max or overwrite each other. Returns
+// ErrDuplicateID if id is taken and ErrStoreFull if the store holds max
+// users.
+func (u *UserStore) Add(id string, name string) (err error) {
+	now := time.Now()
+	person := Person{ID: id, Name: name, CreatedAt: now, LastSeen: now}
+	s := u.shardFor(id)
+	s.Lock()
+	if _, exists := s.users[id]; s.users == nil {
+		err = ErrStoreUnavailable
+	} else if exists {
+		err = ErrDuplicateID
+	} else if !u.reserve() {
+		err = ErrStoreFull
+	} else {
+		s.users[id] = person
+		u.dirty.Store(true)
+	}
+	s.Unlock()
+
+	if err == nil && u.OnAdd != nil {
+		u.OnAdd(person)
+	}
+	return
+}
+
+// Counts one more user unless the store already holds max. Returns false
+// if the store is full.
+func (u *UserStore) reserve() bool {
+	for {
+		n := u.count.Load()
+		if u.max != NO_CAPACITY_LIMIT && n >= int64(u.max) {
+			return false
+		}
+		if u.count.CompareAndSwap(n, n+1) {
+			return true
+		}
+	}
+}
+
+// Persistent storage for the users map, such as a backup.File.
+// Write() must replace the stored map atomically.
+type Store interface {
+	Read(target interface{}) error
+	Write(value interface{}) error
 }
 
 // Copies concurrent user store to non-concurrent user store
-// and calls backup.Write() to dump.
-func (u *UserStore) Dump(dumpFile string) (err error) {
-	copy := make(map[string]string)
-	u.Lock()
-	for uuid, name := range u.users {
-		copy[uuid] = name
+// and calls store.Write() to dump. Skips the write if nothing
+// has changed since the last successful dump.
+func (u *UserStore) Dump(store Store) (err error) {
+	u.dumpLock.Lock()
+	defer u.dumpLock.Unlock()
+
+	copy := make(map[string]Person, u.Len())
+	// Read locks suffice as every change holds its shard's write lock.
+	u.rlockAll()
+	if !u.dirty.Load() {
+		u.runlockAll()
+		log.Trace("database: No changes since last dump.")
+		return
 	}
-	u.Unlock()
+	for i := range u.shards {
+		for uuid, person := range u.shards[i].users {
+			copy[uuid] = person
+		}
+	}
+	u.dirty.Store(false)
+	u.runlockAll()
 
-	if err = backup.Write(dumpFile, copy); err != nil {
+	if err = store.Write(copy); err != nil {
+		// Changes made while writing have already set dirty, but the
+		// changes captured in copy also need retrying.
+		u.dirty.Store(true)
 		log.Error(err)
 	}
 	return
 }
 
-// Deletes *Person from users map whose ID is p.ID. Acquires RW lock before accessing resource.
-func (u *UserStore) Delete(id string, name string) {
-	u.Lock()
-	delete(u.users, id)
-	u.Unlock()
+// Removes Person with id from users map. Returns ErrUserNotFound,
+// changing nothing, if id is not present. Acquires RW lock on the id's
+// shard before accessing resource.
+func (u *UserStore) Remove(id string) (err error) {
+	s := u.shardFor(id)
+	s.Lock()
+	person, ok := s.users[id]
+	if s.users == nil {
+		err = ErrStoreUnavailable
+	} else if !ok {
+		err = ErrUserNotFound
+	} else {
+		delete(s.users, id)
+		u.count.Add(-1)
+		u.dirty.Store(true)
+	}
+	s.Unlock()
+
+	if err == nil && u.OnRemove != nil {
+		u.OnRemove(person)
+	}
+	return
+}
+
+// Removes every Person from users map and returns how many there were.
+// OnRemove is not fired.
+func (u *UserStore) Clear() (removed int) {
+	u.lockAll()
+	for i := range u.shards {
+		if s := &u.shards[i]; len(s.users) > 0 {
+			removed += len(s.users)
+			s.users = make(map[string]Person)
+		}
+	}
+	if removed > 0 {
+		u.count.Add(-int64(removed))
+		u.dirty.Store(true)
+	}
+	u.unlockAll()
+	return
+}
+
+// Performs read lock on each shard in turn and returns ids of all
+// users whose name is name. Order is undefined.
+func (u *UserStore) FindByName(name string) (ids []string) {
+	for i := range u.shards {
+		s := &u.shards[i]
+		s.RLock()
+		for id, person := range s.users {
+			if person.Name == name {
+				ids = append(ids, id)
+			}
+		}
+		s.RUnlock()
+	}
+	return
 }
 
 // Performs read lock on Users. Returns true
 // if user with id exists in map. Returns false
 // otherise.
 func (u *UserStore) Exists(id string) bool {
-	u.RLock()
-	_, ok := u.users[id]
-	u.RUnlock()
+	_, ok := u.lookup(id)
 	return ok
 }
 
-// Uses people.NAME_REGEX to determine if name passed as
-// parameter is valid.
+// Uses people.NAME_REGEX and the NAME_*_LENGTH bounds to determine if
+// name passed as parameter is valid. Length is counted in characters,
+// not bytes, so names in non-Latin scripts get the same bound.
 func IsValidName(name string) bool {
-	match, err := regexp.MatchString(NAME_REGEX, name)
-	if err != nil {
-		log.Error(err)
+	length := utf8.RuneCountInString(name)
+	if length < NAME_MIN_LENGTH || length > NAME_MAX_LENGTH {
+		return false
+	}
+	return validName.MatchString(name)
+}
+
+// Returns the rules IsValidName() enforces.
+func NameRules() Rules {
+	return Rules{
+		MinLength:  NAME_MIN_LENGTH,
+		MaxLength:  NAME_MAX_LENGTH,
+		Characters: NAME_CHARS,
+		Separators: NAME_SEPARATORS,
+		Spaces:     1,
+		Unicode:    true,
+		Pattern:    NAME_REGEX,
+	}
+}
+
+// Returns true if theme is one of people.THEMES.
+func IsValidTheme(theme string) bool {
+	return THEMES[theme]
+}
+
+// Returns true if identity matches people.IDENTITY_REGEX.
+func IsValidIdentity(identity string) bool {
+	return validIdentity.MatchString(identity)
+}
+
+// Returns true if tz is an IANA time zone name such as America/Los_Angeles
+// or UTC. "Local" is refused as it names the server's zone, which an
+// empty preference already means.
+func IsValidTimezone(tz string) bool {
+	if tz == "" || tz == "Local" || len(tz) > TZ_MAX_LENGTH {
+		return false
 	}
-	return match
+	_, err := time.LoadLocation(tz)
+	return err == nil
 }
 
 // Uses people.UUID_REGEX to determine if UUID passed
-// as parameter is valid.
+// as parameter is valid. The whole value must be a UUID.
 func IsValidUUID(value string) bool {
-	match, err := regexp.MatchString(UUID_REGEX, value)
-	if err != nil {
-		log.Error(err)
+	return validUUID.MatchString(value)
+}
+
+// Calls store.Read() to load into concurrent users map. Expects
+// call on empty map. Entries from legacy dumpfiles carry no
+// timestamps and are treated as created at load time.
+func (u *UserStore) Load(store Store) (err error) {
+	loaded := make(map[string]Person)
+	if err = store.Read(&loaded); err != nil {
+		return
+	}
+
+	backfill(loaded)
+	u.lockAll()
+	for id, person := range loaded {
+		s := u.shardFor(id)
+		if _, exists := s.users[id]; !exists {
+			u.count.Add(1)
+		}
+		s.users[id] = person
+	}
+	u.unlockAll()
+	return
+}
+
+// Sets ID from the map key and treats entries without timestamps
+// as created now.
+func backfill(loaded map[string]Person) {
+	now := time.Now()
+	for id, person := range loaded {
+		person.ID = id
+		if person.CreatedAt.IsZero() {
+			person.CreatedAt = now
+			person.LastSeen = now
+		}
+		loaded[id] = person
+	}
+}
+
+// Returns users serialized as a JSON document in the same format
+// as the dumpFile, independent of any file path.
+func (u *UserStore) Export() (data []byte, err error) {
+	all := make(map[string]Person, u.Len())
+	u.rlockAll()
+	for i := range u.shards {
+		for id, person := range u.shards[i].users {
+			all[id] = person
+		}
 	}
-	return match
+	u.runlockAll()
+	return json.Marshal(all)
 }
 
-// Calls backup.Read() to load dumpFile into concurrent users map.
-// Expects call on empty map.
-func (u *UserStore) Load(dumpFile string) (err error) {
-	u.Lock()
-	err = backup.Read(dumpFile, u.users)
-	u.Unlock()
+// Replaces users with the JSON document data, as produced by Export()
+// or read from a dumpFile. The whole document is validated before the
+// store is touched: every key must be a valid uuid matching the entry's
+// id, if present, every name must be valid, and the number of users must
+// fit the store's capacity. Store is unchanged on error.
+func (u *UserStore) Import(data []byte) (err error) {
+	var imported map[string]Person
+	if err = json.Unmarshal(data, &imported); err != nil {
+		return errors.New("people: Malformed import - " + err.Error())
+	}
+	if imported == nil {
+		return errors.New("people: Import must be a JSON object.")
+	}
+	for id, person := range imported {
+		if !IsValidUUID(id) {
+			return errors.New("people: Invalid uuid in import - " + id)
+		}
+		if person.ID != "" && person.ID != id {
+			return errors.New("people: Mismatched id in import - " + id)
+		}
+		if !IsValidName(person.Name) {
+			return errors.New("people: Invalid name in import for " + id)
+		}
+	}
+	if u.max != NO_CAPACITY_LIMIT && len(imported) > u.max {
+		return ErrStoreFull
+	}
+
+	backfill(imported)
+	var split [SHARD_COUNT]map[string]Person
+	for i := range split {
+		split[i] = make(map[string]Person)
+	}
+	for id, person := range imported {
+		split[shardIndex(id)][id] = person
+	}
+	u.lockAll()
+	for i := range u.shards {
+		u.shards[i].users = split[i]
+	}
+	u.count.Store(int64(len(imported)))
+	u.dirty.Store(true)
+	u.unlockAll()
+	return
+}
+
+// Copies the users of other into u, for example to combine the dumpFiles
+// of two instances. When both stores hold an id the Person with the later
+// LastSeen is kept. other is copied under its own lock before u is locked
+// so the stores are never locked at the same time and concurrent merges in
+// opposite directions can't deadlock. Returns the number of users added or
+// replaced. Returns ErrStoreFull, changing nothing, if the merged store
+// would exceed max. OnAdd is not fired.
+func (u *UserStore) MergeFrom(other *UserStore) (merged int, err error) {
+	if u == other {
+		return
+	}
+	incoming := other.Snapshot()
+
+	u.lockAll()
+	defer u.unlockAll()
+	if u.shards[0].users == nil {
+		err = ErrStoreUnavailable
+		return
+	}
+
+	added := 0
+	for _, person := range incoming {
+		if _, exists := u.shardFor(person.ID).users[person.ID]; !exists {
+			added++
+		}
+	}
+	if u.max != NO_CAPACITY_LIMIT && u.Len()+added > u.max {
+		err = ErrStoreFull
+		return
+	}
+
+	for _, person := range incoming {
+		s := u.shardFor(person.ID)
+		current, exists := s.users[person.ID]
+		if exists && !person.LastSeen.After(current.LastSeen) {
+			continue
+		}
+		s.users[person.ID] = person
+		merged++
+	}
+	u.count.Add(int64(added))
+	if merged > 0 {
+		u.dirty.Store(true)
+	}
+	return
+}
+
+// Performs read lock on Users and returns a copy of the Person with
+// id. Returns ErrUserNotFound if not found.
+func (u *UserStore) Get(id string) (person Person, err error) {
+	s := u.shardFor(id)
+	s.RLock()
+	person, ok := s.users[id]
+	if s.users == nil {
+		err = ErrStoreUnavailable
+	} else if !ok {
+		err = ErrUserNotFound
+	}
+	s.RUnlock()
 	return
 }
 
@@ -107,42 +514,198 @@ func (u *UserStore) Load(dumpFile string) (err error) {
 // name of user with id. If not found, returns
 // empty string.
 func (u *UserStore) Name(id string) (name string) {
-	u.RLock()
-	name = u.users[id]
-	u.RUnlock()
-	return
+	person, _ := u.lookup(id)
+	return person.Name
 }
 
 // Returns pointer to object of Users type. Map containing
-// state is initialized and ready for use.
-func NewUsers() *UserStore {
-	return &UserStore{users: make(map[string]string)}
+// state is initialized and ready for use. Add() refuses new
+// users once max are stored unless max is NO_CAPACITY_LIMIT.
+func NewUsers(max int) *UserStore {
+	u := &UserStore{max: max}
+	for i := range u.shards {
+		u.shards[i].users = make(map[string]Person)
+	}
+	return u
 }
 
-// Loops through Dump(), and sleep whose duration determined
-// by wait parameter. Can be called from main thread of execution
-// or as go routine.
-func (u *UserStore) Persist(dumpFile string, wait time.Duration) {
-	for {
+// Removes users not seen for longer than ttl, one shard at a time.
+// Candidates are collected under the shard's read lock, which does not
+// block lookups, then removed in batches of chunk ids with the write lock
+// released between batches so handlers are not starved while a large
+// table is reaped. Each candidate is rechecked under the write lock in
+// case it was seen in the meantime. Returns number of users removed.
+func (u *UserStore) Expire(ttl time.Duration, chunk int) (removed int) {
+	if chunk < 1 {
+		chunk = 1
+	}
+	cutoff := time.Now().Add(-ttl)
+
+	for i := range u.shards {
+		s := &u.shards[i]
+		var stale []string
+		s.RLock()
+		for id, person := range s.users {
+			if person.LastSeen.Before(cutoff) {
+				stale = append(stale, id)
+			}
+		}
+		s.RUnlock()
+
+		for start := 0; start < len(stale); start += chunk {
+			end := start + chunk
+			if end > len(stale) {
+				end = len(stale)
+			}
+			var expired []Person
+			s.Lock()
+			for _, id := range stale[start:end] {
+				if person, ok := s.users[id]; ok && person.LastSeen.Before(cutoff) {
+					delete(s.users, id)
+					u.count.Add(-1)
+					u.dirty.Store(true)
+					expired = append(expired, person)
+				}
+			}
+			s.Unlock()
+
+			removed += len(expired)
+			if u.OnRemove != nil {
+				for _, person := range expired {
+					u.OnRemove(person)
+				}
+			}
+		}
+	}
+	return
+}
+
+// Calls Expire() every interval. Intended to be run as go routine.
+func (u *UserStore) Reap(ttl time.Duration, interval time.Duration, chunk int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if removed := u.Expire(ttl, chunk); removed > 0 {
+			log.Infof("database: Reaped %d users not seen in %s.", removed, ttl)
+		}
+	}
+}
+
+// Calls Dump() every wait interval as determined by a ticker. Can be
+// called from main thread of execution or as go routine. Dumps are
+// skipped when the store is unchanged.
+func (u *UserStore) Persist(store Store, wait time.Duration) {
+	ticker := time.NewTicker(wait)
+	defer ticker.Stop()
+	for range ticker.C {
 		log.Trace("database: Beginning persist dump.")
-		if err := u.Dump(dumpFile); err != nil {
+		if err := u.Dump(store); err != nil {
 			log.Error(err)
 		}
-		log.Trace("database: Sleeping for " + wait.String())
-		time.Sleep(wait)
 	}
 }
 
-// For simplicity, was implimented as call to OS executable, but
-// should be replaced with uuid package.
+// Computes UserStats with every shard read locked so the aggregates
+// are consistent with each other.
+func (u *UserStore) Stats() (stats UserStats) {
+	u.rlockAll()
+	for i := range u.shards {
+		for _, person := range u.shards[i].users {
+			if stats.Count == 0 || person.CreatedAt.Before(stats.OldestCreatedAt) {
+				stats.OldestCreatedAt = person.CreatedAt
+			}
+			if person.CreatedAt.After(stats.NewestCreatedAt) {
+				stats.NewestCreatedAt = person.CreatedAt
+			}
+			stats.Count++
+			stats.Visits += person.Visits
+		}
+	}
+	u.runlockAll()
+	return
+}
+
+// Acquires RW lock and sets the display theme of user with id.
+// Returns false, recording nothing, if the user is not found.
+func (u *UserStore) SetTheme(id string, theme string) (ok bool) {
+	_, ok = u.update(id, func(person Person) Person {
+		person.Theme = theme
+		return person
+	})
+	return
+}
+
+// Performs read lock on Users and returns display theme of user
+// with id. Returns empty string if not found or not yet chosen.
+func (u *UserStore) Theme(id string) string {
+	person, _ := u.lookup(id)
+	return person.Theme
+}
+
+// Acquires RW lock and sets the preferred time zone of user with id.
+// An empty tz clears the preference. Returns false, recording nothing,
+// if the user is not found.
+func (u *UserStore) SetTimezone(id string, tz string) (ok bool) {
+	_, ok = u.update(id, func(person Person) Person {
+		person.Timezone = tz
+		return person
+	})
+	return
+}
+
+// Acquires RW lock and records the login provider identity of user with
+// id. Returns false, recording nothing, if the user is not found.
+func (u *UserStore) SetIdentity(id string, identity string) (ok bool) {
+	_, ok = u.update(id, func(person Person) Person {
+		person.Identity = identity
+		return person
+	})
+	return
+}
+
+// Performs read lock on Users and returns preferred time zone of user
+// with id. Returns empty string if not found or not yet chosen.
+func (u *UserStore) Timezone(id string) string {
+	person, _ := u.lookup(id)
+	return person.Timezone
+}
+
+// Returns a copy of every Person in the store taken with every shard
+// read locked. Callers may inspect the copy without holding any lock.
+func (u *UserStore) Snapshot() (people []Person) {
+	people = make([]Person, 0, u.Len())
+	u.rlockAll()
+	for i := range u.shards {
+		for _, person := range u.shards[i].users {
+			people = append(people, person)
+		}
+	}
+	u.runlockAll()
+	return
+}
+
+// Acquires RW lock and records a visit by user with id, updating
+// LastSeen and Visits. Returns name of user or empty string if
+// not found, in which case nothing is recorded.
+func (u *UserStore) Visit(id string) string {
+	now := time.Now()
+	person, _ := u.update(id, func(person Person) Person {
+		person.LastSeen = now
+		person.Visits++
+		return person
+	})
+	return person.Name
+}
+
+// Returns a random (version 4) UUID read from Rand, or empty string if
+// Rand fails.
 func UUID() string {
-	out, err := exec.Command("/usr/bin/uuidgen").Output()
-	if err != nil {
+	var b [16]byte
+	if _, err := io.ReadFull(Rand, b[:]); err != nil {
 		log.Error(err)
 		return ""
 	}
-	// Command returns newline at end and must be stripped before use
-	// otherwise SetCookie will fail.
-	uuid := strings.TrimSuffix(string(out), "\n")
-	return uuid
+	b[6] = (b[6] & 0x0f) | 0x40 // Version 4.
+	b[8] = (b[8] & 0x3f) | 0x80 // RFC 4122 variant.
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
 }
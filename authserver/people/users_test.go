@@ -0,0 +1,608 @@
+//  Copyright (C) Pat Kaehuaea - All Rights Reserved
+//  Unauthorized copying of this file, via any medium is strictly prohibited
+//  Proprietary and confidential
+//  Written by Pat Kaehuaea, March 2015
+
+package people
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Returns a valid uuid unique to i.
+func testID(i int) string {
+	return fmt.Sprintf("%08x-0000-4000-8000-000000000000", i)
+}
+
+// Returns a store holding list, imported so timestamps and visits are
+// kept as given.
+func storeOf(t *testing.T, list ...Person) *UserStore {
+	t.Helper()
+	all := make(map[string]Person, len(list))
+	for _, person := range list {
+		all[person.ID] = person
+	}
+	data, err := json.Marshal(all)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := NewUsers(NO_CAPACITY_LIMIT)
+	if err := u.Import(data); err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func TestStats(t *testing.T) {
+	day := time.Date(2015, time.March, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		list []Person
+		want UserStats
+	}{
+		{"empty", nil, UserStats{}},
+		{
+			"one",
+			[]Person{{ID: testID(1), Name: "Ada", CreatedAt: day, LastSeen: day, Visits: 3}},
+			UserStats{Count: 1, OldestCreatedAt: day, NewestCreatedAt: day, Visits: 3},
+		},
+		{
+			"several",
+			[]Person{
+				{ID: testID(1), Name: "Ada", CreatedAt: day.Add(time.Hour), LastSeen: day, Visits: 1},
+				{ID: testID(2), Name: "Grace", CreatedAt: day, LastSeen: day, Visits: 2},
+				{ID: testID(3), Name: "Linus", CreatedAt: day.Add(48 * time.Hour), LastSeen: day},
+			},
+			UserStats{Count: 3, OldestCreatedAt: day, NewestCreatedAt: day.Add(48 * time.Hour), Visits: 3},
+		},
+	}
+	for _, tt := range tests {
+		got := storeOf(t, tt.list...).Stats()
+		if got.Count != tt.want.Count || got.Visits != tt.want.Visits ||
+			!got.OldestCreatedAt.Equal(tt.want.OldestCreatedAt) || !got.NewestCreatedAt.Equal(tt.want.NewestCreatedAt) {
+			t.Errorf("%s: Stats() = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// Store recording each Write() in memory.
+type memoryStore struct {
+	sync.Mutex
+	writes int
+	last   map[string]Person
+}
+
+func (m *memoryStore) Read(target interface{}) error {
+	m.Lock()
+	defer m.Unlock()
+	*target.(*map[string]Person) = m.last
+	return nil
+}
+
+func (m *memoryStore) Write(value interface{}) error {
+	m.Lock()
+	defer m.Unlock()
+	m.writes++
+	m.last = value.(map[string]Person)
+	return nil
+}
+
+func (m *memoryStore) count() int {
+	m.Lock()
+	defer m.Unlock()
+	return m.writes
+}
+
+// Waits up to a second for store to have been written n times.
+func waitForWrites(t *testing.T, store *memoryStore, n int) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); store.count() < n; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d writes after a second, want %d", store.count(), n)
+		}
+	}
+}
+
+func TestPersist(t *testing.T) {
+	const interval = 5 * time.Millisecond
+	u := NewUsers(NO_CAPACITY_LIMIT)
+	store := &memoryStore{}
+	go u.Persist(store, interval)
+
+	u.Add(testID(1), "Ada")
+	waitForWrites(t, store, 1)
+
+	// Unchanged stores are not rewritten.
+	time.Sleep(10 * interval)
+	if n := store.count(); n != 1 {
+		t.Errorf("%d writes of an unchanged store, want 1", n)
+	}
+
+	u.Add(testID(2), "Grace")
+	waitForWrites(t, store, 2)
+	store.Lock()
+	defer store.Unlock()
+	if len(store.last) != 2 {
+		t.Errorf("last write held %d users, want 2", len(store.last))
+	}
+}
+
+func TestAddCapacity(t *testing.T) {
+	tests := []struct {
+		max  int
+		adds int
+		want int
+	}{
+		{NO_CAPACITY_LIMIT, 5, 5},
+		{1, 3, 1},
+		{3, 3, 3},
+		{3, 5, 3},
+	}
+	for _, tt := range tests {
+		u := NewUsers(tt.max)
+		for i := 0; i < tt.adds; i++ {
+			err := u.Add(testID(i), "Ada")
+			if full := i >= tt.want; full != errors.Is(err, ErrStoreFull) {
+				t.Errorf("max %d: add %d returned %v", tt.max, i, err)
+			}
+		}
+		if u.Len() != tt.want {
+			t.Errorf("max %d: Len() = %d after %d adds, want %d", tt.max, u.Len(), tt.adds, tt.want)
+		}
+	}
+}
+
+// Returns a store of n users, every other one last seen a day ago.
+func agedStore(n int) *UserStore {
+	u := NewUsers(NO_CAPACITY_LIMIT)
+	stale := time.Now().Add(-24 * time.Hour)
+	for i := 0; i < n; i++ {
+		u.Add(testID(i), "Ada")
+		if i%2 == 1 {
+			u.update(testID(i), func(person Person) Person {
+				person.LastSeen = stale
+				return person
+			})
+		}
+	}
+	return u
+}
+
+// Lookups of live users must keep completing promptly while a large
+// store is reaped in chunks.
+func TestExpireConcurrentLookups(t *testing.T) {
+	const (
+		size  = 100000
+		chunk = 100
+		bound = 100 * time.Millisecond
+	)
+	u := agedStore(size)
+
+	done := make(chan int)
+	go func() { done <- u.Expire(time.Hour, chunk) }()
+
+	var slowest time.Duration
+	lookups := 0
+	for reaping := true; reaping; lookups++ {
+		select {
+		case removed := <-done:
+			if removed != size/2 {
+				t.Errorf("Expire() removed %d, want %d", removed, size/2)
+			}
+			reaping = false
+		default:
+		}
+		id := testID(2 * (lookups % (size / 2)))
+		start := time.Now()
+		if _, err := u.Get(id); err != nil {
+			t.Fatalf("Get(%s) of a live user - %v", id, err)
+		}
+		if u.Visit(id) == "" {
+			t.Fatalf("Visit(%s) of a live user found no one", id)
+		}
+		if took := time.Since(start); took > slowest {
+			slowest = took
+		}
+	}
+	if slowest > bound {
+		t.Errorf("slowest of %d lookups during Expire() took %s, want under %s", lookups, slowest, bound)
+	}
+	if u.Len() != size/2 {
+		t.Errorf("Len() = %d after Expire(), want %d", u.Len(), size/2)
+	}
+}
+
+func BenchmarkExpire(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		u := agedStore(10000)
+		b.StartTimer()
+		u.Expire(time.Hour, 100)
+	}
+}
+
+func TestIsValidUUID(t *testing.T) {
+	tests := []struct {
+		value string
+		want  bool
+	}{
+		{testID(1), true},
+		{"0F8B6A3E-6F4E-4C1A-9D2B-3E5F7A9C1B2D", true},
+		{"", false},
+		{"junk-" + testID(1) + "-junk", false},
+		{testID(1) + "\n", false},
+		{" " + testID(1), false},
+		{"0f8b6a3e6f4e4c1a9d2b3e5f7a9c1b2d", false},
+		{"0f8b6a3e-6f4e-4c1a-9d2b-3e5f7a9c1b2g", false},
+	}
+	for _, tt := range tests {
+		if got := IsValidUUID(tt.value); got != tt.want {
+			t.Errorf("IsValidUUID(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	day := time.Date(2015, time.March, 1, 0, 0, 0, 0, time.UTC)
+	source := storeOf(t,
+		Person{ID: testID(1), Name: "Ada", CreatedAt: day, LastSeen: day, Visits: 2, Theme: "dark"},
+		Person{ID: testID(2), Name: "Grace Hopper", CreatedAt: day, LastSeen: day, Timezone: "UTC"},
+	)
+	data, err := source.Export()
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := NewUsers(NO_CAPACITY_LIMIT)
+	if err := restored.Import(data); err != nil {
+		t.Fatal(err)
+	}
+	want, got := byID(source.Snapshot()), byID(restored.Snapshot())
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("imported %v, want %v", got, want)
+	}
+}
+
+// Returns list keyed by id, as Snapshot() order is undefined.
+func byID(list []Person) map[string]Person {
+	all := make(map[string]Person, len(list))
+	for _, person := range list {
+		all[person.ID] = person
+	}
+	return all
+}
+
+func TestImportRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		max  int
+		data string
+	}{
+		{"malformed", NO_CAPACITY_LIMIT, `{"` + testID(1)},
+		{"not an object", NO_CAPACITY_LIMIT, `["Ada"]`},
+		{"null", NO_CAPACITY_LIMIT, `null`},
+		{"invalid uuid", NO_CAPACITY_LIMIT, `{"not-a-uuid":"Ada"}`},
+		{"uuid inside junk", NO_CAPACITY_LIMIT, `{"junk-` + testID(1) + `-junk":"Ada"}`},
+		{"mismatched id", NO_CAPACITY_LIMIT, `{"` + testID(1) + `":{"id":"` + testID(2) + `","name":"Ada"}}`},
+		{"invalid name", NO_CAPACITY_LIMIT, `{"` + testID(1) + `":"Ada99"}`},
+		{"over capacity", 1, `{"` + testID(1) + `":"Ada","` + testID(2) + `":"Grace"}`},
+	}
+	for _, tt := range tests {
+		u := NewUsers(tt.max)
+		u.Add(testID(9), "Linus")
+		if err := u.Import([]byte(tt.data)); err == nil {
+			t.Errorf("%s: Import() succeeded", tt.name)
+		}
+		if u.Len() != 1 || !u.Exists(testID(9)) {
+			t.Errorf("%s: store changed by rejected import", tt.name)
+		}
+	}
+}
+
+func TestCallbacks(t *testing.T) {
+	u := NewUsers(NO_CAPACITY_LIMIT)
+	var added, removed []Person
+	// Calling back into the store proves the lock is released first.
+	u.OnAdd = func(p Person) {
+		added = append(added, p)
+		u.Exists(p.ID)
+	}
+	u.OnRemove = func(p Person) {
+		removed = append(removed, p)
+		u.Exists(p.ID)
+	}
+
+	u.Add(testID(1), "Ada")
+	u.Add(testID(2), "Grace")
+	u.Add(testID(1), "Duplicate")
+	u.Remove(testID(1))
+	u.Remove(testID(3))
+	u.update(testID(2), func(p Person) Person {
+		p.LastSeen = time.Now().Add(-time.Hour)
+		return p
+	})
+	u.Expire(time.Minute, 1)
+
+	describe := func(list []Person) (out []string) {
+		for _, p := range list {
+			out = append(out, p.ID+" "+p.Name)
+		}
+		return
+	}
+	wantAdded := []string{testID(1) + " Ada", testID(2) + " Grace"}
+	wantRemoved := []string{testID(1) + " Ada", testID(2) + " Grace"}
+	if got := describe(added); !reflect.DeepEqual(got, wantAdded) {
+		t.Errorf("OnAdd saw %v, want %v", got, wantAdded)
+	}
+	if got := describe(removed); !reflect.DeepEqual(got, wantRemoved) {
+		t.Errorf("OnRemove saw %v, want %v", got, wantRemoved)
+	}
+
+	// Bulk operations do not fire the callbacks.
+	u.Import([]byte(`{"` + testID(4) + `":"Linus"}`))
+	u.Clear()
+	if len(added) != 2 || len(removed) != 2 {
+		t.Errorf("callbacks fired by Import() or Clear(), %d adds %d removes", len(added), len(removed))
+	}
+}
+
+func TestUUID(t *testing.T) {
+	defer func(saved io.Reader) { Rand = saved }(Rand)
+	ascending := make([]byte, 16)
+	for i := range ascending {
+		ascending[i] = byte(i)
+	}
+	tests := []struct {
+		name   string
+		random []byte
+		want   string
+	}{
+		{"zeros", make([]byte, 16), "00000000-0000-4000-8000-000000000000"},
+		{"ascending", ascending, "00010203-0405-4607-8809-0a0b0c0d0e0f"},
+		{"ones", bytes.Repeat([]byte{0xff}, 16), "ffffffff-ffff-4fff-bfff-ffffffffffff"},
+		{"short read", make([]byte, 15), ""},
+		{"empty", nil, ""},
+	}
+	for _, tt := range tests {
+		Rand = bytes.NewReader(tt.random)
+		got := UUID()
+		if got != tt.want {
+			t.Errorf("%s: UUID() = %q, want %q", tt.name, got, tt.want)
+		}
+		if got != "" && !IsValidUUID(got) {
+			t.Errorf("%s: UUID() = %q is not a valid uuid", tt.name, got)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	u := NewUsers(1)
+	u.Add(testID(1), "Ada")
+	unavailable := &UserStore{}
+	get := func(u *UserStore, id string) error {
+		_, err := u.Get(id)
+		return err
+	}
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"add duplicate", u.Add(testID(1), "Grace"), ErrDuplicateID},
+		{"add when full", u.Add(testID(2), "Grace"), ErrStoreFull},
+		{"get missing", get(u, testID(2)), ErrUserNotFound},
+		{"get existing", get(u, testID(1)), nil},
+		{"remove missing", u.Remove(testID(2)), ErrUserNotFound},
+		{"add unavailable", unavailable.Add(testID(1), "Ada"), ErrStoreUnavailable},
+		{"get unavailable", get(unavailable, testID(1)), ErrStoreUnavailable},
+		{"remove unavailable", unavailable.Remove(testID(1)), ErrStoreUnavailable},
+		{"remove existing", u.Remove(testID(1)), nil},
+	}
+	for _, tt := range tests {
+		if !errors.Is(tt.err, tt.want) {
+			t.Errorf("%s: error %v, want %v", tt.name, tt.err, tt.want)
+		}
+	}
+}
+
+func TestMergeFrom(t *testing.T) {
+	day := time.Date(2015, time.March, 1, 0, 0, 0, 0, time.UTC)
+	at := func(id int, name string, seen time.Time) Person {
+		return Person{ID: testID(id), Name: name, CreatedAt: day, LastSeen: seen}
+	}
+	tests := []struct {
+		name   string
+		into   []Person
+		from   []Person
+		merged int
+		want   map[string]string
+	}{
+		{
+			"disjoint",
+			[]Person{at(1, "Ada", day)},
+			[]Person{at(2, "Grace", day)},
+			1,
+			map[string]string{testID(1): "Ada", testID(2): "Grace"},
+		},
+		{
+			"incoming seen later",
+			[]Person{at(1, "Ada", day)},
+			[]Person{at(1, "Grace", day.Add(time.Hour))},
+			1,
+			map[string]string{testID(1): "Grace"},
+		},
+		{
+			"incoming seen earlier",
+			[]Person{at(1, "Ada", day.Add(time.Hour))},
+			[]Person{at(1, "Grace", day)},
+			0,
+			map[string]string{testID(1): "Ada"},
+		},
+		{
+			"seen at the same time",
+			[]Person{at(1, "Ada", day)},
+			[]Person{at(1, "Grace", day)},
+			0,
+			map[string]string{testID(1): "Ada"},
+		},
+		{
+			"mixed",
+			[]Person{at(1, "Ada", day), at(2, "Grace", day.Add(time.Hour))},
+			[]Person{at(1, "Linus", day.Add(time.Hour)), at(2, "Ken", day), at(3, "Rob", day)},
+			2,
+			map[string]string{testID(1): "Linus", testID(2): "Grace", testID(3): "Rob"},
+		},
+	}
+	for _, tt := range tests {
+		u := storeOf(t, tt.into...)
+		merged, err := u.MergeFrom(storeOf(t, tt.from...))
+		if err != nil || merged != tt.merged {
+			t.Errorf("%s: MergeFrom() = %d, %v, want %d", tt.name, merged, err, tt.merged)
+		}
+		got := make(map[string]string)
+		for id, person := range byID(u.Snapshot()) {
+			got[id] = person.Name
+		}
+		if !reflect.DeepEqual(got, tt.want) || u.Len() != len(tt.want) {
+			t.Errorf("%s: merged store %v with Len() %d, want %v", tt.name, got, u.Len(), tt.want)
+		}
+	}
+}
+
+func TestMergeFromAtCapacity(t *testing.T) {
+	u := NewUsers(2)
+	u.Add(testID(1), "Ada")
+	other := NewUsers(NO_CAPACITY_LIMIT)
+	other.Add(testID(1), "Ada")
+	other.Add(testID(2), "Grace")
+	other.Add(testID(3), "Linus")
+	if merged, err := u.MergeFrom(other); !errors.Is(err, ErrStoreFull) || merged != 0 {
+		t.Errorf("MergeFrom() over capacity = %d, %v, want %v", merged, err, ErrStoreFull)
+	}
+	if u.Len() != 1 || u.Exists(testID(2)) {
+		t.Errorf("store changed by refused merge, Len() = %d", u.Len())
+	}
+	if merged, err := u.MergeFrom(u); err != nil || merged != 0 {
+		t.Errorf("MergeFrom() of itself = %d, %v", merged, err)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	u := NewUsers(NO_CAPACITY_LIMIT)
+	u.Add(testID(1), "Ada")
+	u.Add(testID(2), "Grace")
+	tests := []struct {
+		name string
+		id   string
+		err  error
+		len  int
+	}{
+		{"present", testID(1), nil, 1},
+		{"removed again", testID(1), ErrUserNotFound, 1},
+		{"absent", testID(3), ErrUserNotFound, 1},
+		{"invalid id", "not-a-uuid", ErrUserNotFound, 1},
+		{"last", testID(2), nil, 0},
+	}
+	for _, tt := range tests {
+		if err := u.Remove(tt.id); !errors.Is(err, tt.err) {
+			t.Errorf("%s: Remove(%s) = %v, want %v", tt.name, tt.id, err, tt.err)
+		}
+		if name := u.Name(tt.id); name != "" || u.Exists(tt.id) {
+			t.Errorf("%s: %s still resolves to %q after Remove()", tt.name, tt.id, name)
+		}
+		if u.Len() != tt.len {
+			t.Errorf("%s: Len() = %d, want %d", tt.name, u.Len(), tt.len)
+		}
+	}
+}
+
+func TestIsValidName(t *testing.T) {
+	tests := []struct {
+		name  string
+		valid bool
+	}{
+		{"Ada", true},
+		{"Grace Hopper", true},
+		{"José", true},
+		{"Zoë Saldaña", true},
+		{"O'Brien", true},
+		{"O’Brien", true},
+		{"Jean-Luc Picard", true},
+		{"李雷", true},
+		{"Лев Толстой", true},
+		{"محمد", true},
+		{"Nguyễn Trãi", true},
+		{"A", false},
+		{"", false},
+		{"Ada99", false},
+		{"R2-D2", false},
+		{"-Ada", false},
+		{"Ada-", false},
+		{"O''Brien", false},
+		{"Ada  Lovelace", false},
+		{" Ada", false},
+		{"Ada Byron King", false},
+		{"Ada\tLovelace", false},
+		{"Ada\x00", false},
+		{"Ada\u200b", false},
+		{"<script>", false},
+		{strings.Repeat("a", NAME_MAX_LENGTH), true},
+		{strings.Repeat("a", NAME_MAX_LENGTH+1), false},
+	}
+	for _, tt := range tests {
+		if got := IsValidName(tt.name); got != tt.valid {
+			t.Errorf("IsValidName(%q) = %v, want %v", tt.name, got, tt.valid)
+		}
+	}
+}
+
+func TestReap(t *testing.T) {
+	const interval = 5 * time.Millisecond
+	tests := []struct {
+		name    string
+		stale   bool
+		visited bool
+		kept    bool
+	}{
+		{"fresh", false, false, true},
+		{"stale", true, false, false},
+		{"stale then visited", true, true, true},
+	}
+	u := NewUsers(NO_CAPACITY_LIMIT)
+	for i, tt := range tests {
+		u.Add(testID(i), "Ada")
+		if tt.stale {
+			u.update(testID(i), func(person Person) Person {
+				person.LastSeen = time.Now().Add(-2 * time.Hour)
+				return person
+			})
+		}
+		if tt.visited {
+			u.Visit(testID(i))
+		}
+	}
+	go u.Reap(time.Hour, interval, 1)
+
+	// Lookups and logins carry on while the janitor sweeps.
+	deadline := time.Now().Add(time.Second)
+	for i := len(tests); u.Exists(testID(1)); i++ {
+		if time.Now().After(deadline) {
+			t.Fatal("stale user still present a second after reaping began")
+		}
+		u.Add(testID(i), "Grace")
+		u.Name(testID(0))
+	}
+	for i, tt := range tests {
+		if u.Exists(testID(i)) != tt.kept {
+			t.Errorf("%s: kept %v, want %v", tt.name, !tt.kept, tt.kept)
+		}
+	}
+}
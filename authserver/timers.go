@@ -0,0 +1,118 @@
+//  Copyright (C) Pat Kaehuaea - All Rights Reserved
+//  Unauthorized copying of this file, via any medium is strictly prohibited
+//  Proprietary and confidential
+//  Written by Pat Kaehuaea, March 2015
+//
+// Endpoints reading and changing a user's stopwatch and countdown. Like
+// the other endpoints the timeserver calls, they are GETs taking the uuid
+// as the cookie parameter. Times are passed in RFC 3339 with nanoseconds
+// so the timeserver's clock is the one timers run on.
+
+package main
+
+import (
+	"encoding/json"
+	log "github.com/cihub/seelog"
+	"github.com/gorilla/mux"
+	"github.com/patkaehuaea/command/authserver/people"
+	"net/http"
+	"time"
+)
+
+// Registers the stopwatch and countdown routes on r.
+func routeTimers(r *mux.Router) {
+	r.HandleFunc("/stopwatch/get", handleGetStopwatch).Methods("GET")
+	// GET for consistency with /set.
+	r.HandleFunc("/stopwatch/set", handleSetStopwatch).Methods("GET")
+	r.HandleFunc("/countdown/get", handleGetCountdown).Methods("GET")
+	r.HandleFunc("/countdown/set", handleSetCountdown).Methods("GET")
+}
+
+// Writes v as the JSON body of a 200 response.
+func writeTimer(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Error(err)
+	}
+}
+
+// Returns the stopwatch of the user with the uuid, stopped and zeroed if
+// the user has none or is not found.
+func handleGetStopwatch(w http.ResponseWriter, r *http.Request) {
+	log.Info("authserver: Get stopwatch handler called.")
+
+	uuid := r.FormValue("cookie")
+	if !people.IsValidUUID(uuid) {
+		log.Debug("authserver: UUID not valid.")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	writeTimer(w, users.Stopwatch(uuid))
+}
+
+// Applies the action parameter to the stopwatch of the user with the
+// uuid at the time in the at parameter, and returns the stopwatch.
+func handleSetStopwatch(w http.ResponseWriter, r *http.Request) {
+	log.Info("authserver: Set stopwatch handler called.")
+
+	uuid := r.FormValue("cookie")
+	action := r.FormValue("action")
+	at, err := time.Parse(time.RFC3339Nano, r.FormValue("at"))
+
+	if !people.IsValidUUID(uuid) || !people.IsValidStopwatchAction(action) || err != nil {
+		log.Debug("authserver: Invalid uuid, action and/or time.")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	sw, ok := users.UpdateStopwatch(uuid, action, at)
+	if !ok {
+		log.Debug("authserver: Stopwatch set for unknown uuid " + uuid)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	writeTimer(w, sw)
+}
+
+// Returns the countdown of the user with the uuid, the zero countdown if
+// the user has none or is not found.
+func handleGetCountdown(w http.ResponseWriter, r *http.Request) {
+	log.Info("authserver: Get countdown handler called.")
+
+	uuid := r.FormValue("cookie")
+	if !people.IsValidUUID(uuid) {
+		log.Debug("authserver: UUID not valid.")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	writeTimer(w, users.Countdown(uuid))
+}
+
+// Sets the countdown of the user with the uuid to end at the ends
+// parameter after running for duration. An empty ends clears it.
+func handleSetCountdown(w http.ResponseWriter, r *http.Request) {
+	log.Info("authserver: Set countdown handler called.")
+
+	uuid := r.FormValue("cookie")
+	ends := r.FormValue("ends")
+
+	var c people.Countdown
+	valid := people.IsValidUUID(uuid)
+	if valid && ends != "" {
+		var endsErr, durationErr error
+		c.Ends, endsErr = time.Parse(time.RFC3339Nano, ends)
+		c.Duration, durationErr = time.ParseDuration(r.FormValue("duration"))
+		valid = endsErr == nil && durationErr == nil && people.IsValidCountdown(c.Duration)
+	}
+
+	if !valid {
+		log.Debug("authserver: Invalid uuid and/or countdown.")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if !users.SetCountdown(uuid, c) {
+		log.Debug("authserver: Countdown set for unknown uuid " + uuid)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,152 @@
+//  Copyright (C) Pat Kaehuaea - All Rights Reserved
+//  Unauthorized copying of this file, via any medium is strictly prohibited
+//  Proprietary and confidential
+//  Written by Pat Kaehuaea, March 2015
+
+package main
+
+import (
+	"encoding/json"
+	"github.com/patkaehuaea/command/authserver/people"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// Instant the timer tests set their timers at.
+var TIMER_AT = time.Date(2015, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+func TestSetStopwatch(t *testing.T) {
+	tests := []struct {
+		name   string
+		uuid   string
+		action string
+		at     string
+		status int
+	}{
+		{"start", FIRST_UUID, "start", TIMER_AT.Format(time.RFC3339Nano), http.StatusOK},
+		{"unknown user", SECOND_UUID, "start", TIMER_AT.Format(time.RFC3339Nano), http.StatusNotFound},
+		{"bad uuid", "not-a-uuid", "start", TIMER_AT.Format(time.RFC3339Nano), http.StatusBadRequest},
+		{"bad action", FIRST_UUID, "pause", TIMER_AT.Format(time.RFC3339Nano), http.StatusBadRequest},
+		{"bad time", FIRST_UUID, "start", "noon", http.StatusBadRequest},
+		{"no time", FIRST_UUID, "start", "", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		u := withUsers(t, people.NO_CAPACITY_LIMIT)
+		u.Add(FIRST_UUID, "Ada")
+		w := call(handleSetStopwatch, "/stopwatch/set", url.Values{"cookie": {tt.uuid}, "action": {tt.action}, "at": {tt.at}})
+		if w.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, tt.status)
+		}
+		if tt.status != http.StatusOK {
+			if u.Stopwatch(FIRST_UUID).Running() {
+				t.Errorf("%s: stopwatch started by a refused request", tt.name)
+			}
+			continue
+		}
+		var sw people.Stopwatch
+		if err := json.Unmarshal(w.Body.Bytes(), &sw); err != nil || !sw.Started.Equal(TIMER_AT) {
+			t.Errorf("%s: answered %q, want the stopwatch started at %v", tt.name, w.Body.String(), TIMER_AT)
+		}
+		if !u.Stopwatch(FIRST_UUID).Started.Equal(TIMER_AT) {
+			t.Errorf("%s: stopwatch not stored", tt.name)
+		}
+	}
+}
+
+func TestGetStopwatch(t *testing.T) {
+	u := withUsers(t, people.NO_CAPACITY_LIMIT)
+	u.Add(FIRST_UUID, "Ada")
+	u.UpdateStopwatch(FIRST_UUID, "start", TIMER_AT)
+	tests := []struct {
+		uuid    string
+		status  int
+		running bool
+	}{
+		{FIRST_UUID, http.StatusOK, true},
+		{SECOND_UUID, http.StatusOK, false},
+		{"not-a-uuid", http.StatusBadRequest, false},
+	}
+	for _, tt := range tests {
+		w := call(handleGetStopwatch, "/stopwatch/get", url.Values{"cookie": {tt.uuid}})
+		if w.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.uuid, w.Code, tt.status)
+			continue
+		}
+		var sw people.Stopwatch
+		if tt.status == http.StatusOK && (json.Unmarshal(w.Body.Bytes(), &sw) != nil || sw.Running() != tt.running) {
+			t.Errorf("%s: answered %q, want running %v", tt.uuid, w.Body.String(), tt.running)
+		}
+	}
+}
+
+func TestSetCountdown(t *testing.T) {
+	ends := TIMER_AT.Add(10 * time.Minute).Format(time.RFC3339Nano)
+	tests := []struct {
+		name     string
+		uuid     string
+		ends     string
+		duration string
+		status   int
+		set      bool
+	}{
+		{"set", FIRST_UUID, ends, "10m", http.StatusOK, true},
+		{"clear", FIRST_UUID, "", "", http.StatusOK, false},
+		{"unknown user", SECOND_UUID, ends, "10m", http.StatusNotFound, true},
+		{"bad uuid", "not-a-uuid", ends, "10m", http.StatusBadRequest, true},
+		{"bad end", FIRST_UUID, "soon", "10m", http.StatusBadRequest, true},
+		{"bad duration", FIRST_UUID, ends, "ten minutes", http.StatusBadRequest, true},
+		{"no duration", FIRST_UUID, ends, "", http.StatusBadRequest, true},
+		{"too long", FIRST_UUID, ends, (people.MAX_COUNTDOWN + time.Second).String(), http.StatusBadRequest, true},
+		{"zero", FIRST_UUID, ends, "0s", http.StatusBadRequest, true},
+	}
+	for _, tt := range tests {
+		u := withUsers(t, people.NO_CAPACITY_LIMIT)
+		u.Add(FIRST_UUID, "Ada")
+		u.SetCountdown(FIRST_UUID, people.Countdown{Ends: TIMER_AT, Duration: time.Minute})
+		params := url.Values{"cookie": {tt.uuid}}
+		if tt.ends != "" {
+			params.Set("ends", tt.ends)
+			params.Set("duration", tt.duration)
+		}
+		w := call(handleSetCountdown, "/countdown/set", params)
+		if w.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, tt.status)
+		}
+		c := u.Countdown(FIRST_UUID)
+		if c.Set() != tt.set {
+			t.Errorf("%s: countdown set %v, want %v", tt.name, c.Set(), tt.set)
+		}
+		if tt.status == http.StatusOK && tt.set && c.Duration != 10*time.Minute {
+			t.Errorf("%s: countdown = %+v, want 10m", tt.name, c)
+		}
+	}
+}
+
+func TestGetCountdown(t *testing.T) {
+	u := withUsers(t, people.NO_CAPACITY_LIMIT)
+	u.Add(FIRST_UUID, "Ada")
+	want := people.Countdown{Ends: TIMER_AT, Duration: time.Minute}
+	u.SetCountdown(FIRST_UUID, want)
+	tests := []struct {
+		uuid   string
+		status int
+		want   people.Countdown
+	}{
+		{FIRST_UUID, http.StatusOK, want},
+		{SECOND_UUID, http.StatusOK, people.Countdown{}},
+		{"not-a-uuid", http.StatusBadRequest, people.Countdown{}},
+	}
+	for _, tt := range tests {
+		w := call(handleGetCountdown, "/countdown/get", url.Values{"cookie": {tt.uuid}})
+		if w.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.uuid, w.Code, tt.status)
+			continue
+		}
+		var c people.Countdown
+		if tt.status == http.StatusOK && (json.Unmarshal(w.Body.Bytes(), &c) != nil || !c.Ends.Equal(tt.want.Ends) || c.Duration != tt.want.Duration) {
+			t.Errorf("%s: answered %q, want %+v", tt.uuid, w.Body.String(), tt.want)
+		}
+	}
+}
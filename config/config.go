@@ -9,71 +9,416 @@
 package config
 
 import (
+	"errors"
 	"flag"
 	log "github.com/cihub/seelog"
+	"io/ioutil"
+	"net"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
 	"time"
 )
 
 const (
+	ADMIN_TOKEN      = ""
+	API_TOKEN        = ""
 	AUTH_HOST        = "localhost"
 	AUTH_PORT        = ":9080"
 	AUTH_TIMEOUT_MS  = 1000 * time.Millisecond
 	AVG_RESP_MS      = 1000 * time.Millisecond
+	BLOCK_PATHS      = "/wp-login.php,/wp-admin/,/xmlrpc.php,/.env,/.git/,/phpmyadmin/"
+	COMPRESS_MIN     = 1024
 	CHECKPOINT_INT   = 60 * time.Second
+	CONFIG_FILE      = ""
+	COOKIE_SAME_SITE = "lax"
+	COOKIE_KEY_FILE  = ""
+	DEBUG_ADDR       = ""
+	DEFAULT_THEME    = "system"
 	DEV_MS           = 100 * time.Millisecond
 	DUMP_FILE        = ""
+	FILE_MODE        = "0600"
+	GITHUB_CLIENT_ID = ""
+	GITHUB_SECRET    = ""
+	GOOGLE_CLIENT_ID = ""
+	GOOGLE_SECRET    = ""
+	HTTP_REDIRECT    = ""
+	LATENCY_BUCKETS  = "0.005,0.01,0.025,0.05,0.1,0.25,0.5,1,2.5,5,10"
+	LEFT_DELIM       = "{{"
+	LOGIN_BURST      = 5
+	LOGIN_RATE       = 0.2
+	LOG_FILE         = ""
+	LOG_FORMAT       = "text"
+	LOG_LEVEL        = ""
+	LOG_MAX_ROLLS    = 7
+	LOG_MAX_SIZE     = 100 << 20
+	LOG_NAMES        = "off"
+	LOG_ROTATE       = LOG_ROTATE_SIZE
+	LOGOUT_DELAY     = 10
 	MAX_IN_FLIGHT    = 0
+	MAX_RENDERS      = 0
+	MAX_USERS        = 0
+	NTP_CACHE_TTL    = 30 * time.Second
+	NTP_SERVER       = "pool.ntp.org"
+	NTP_TIMEOUT      = 2 * time.Second
+	OIDC_CLIENT_ID   = ""
+	OIDC_ISSUER      = ""
+	OIDC_SECRET      = ""
+	OIDC_TITLE       = "Single sign-on"
+	POST_LOGIN_PATH  = ""
+	PUBLIC_URL       = ""
+	QR_SIZE          = 256
+	REAP_CHUNK_SIZE  = 1000
+	REAP_INTERVAL    = 1 * time.Minute
+	RENDER_WAIT      = 100 * time.Millisecond
+	REQUEST_TIMEOUT  = 30 * time.Second
+	RIGHT_DELIM      = "}}"
+	SESSION_TTL      = 24 * time.Hour
+	SHUTDOWN_TIMEOUT = 5 * time.Second
+	STATIC_DIR       = ""
+	STATIC_MAX_AGE   = 1 * time.Hour
+	STREAM_INTERVAL  = 1 * time.Second
+	TARPIT           = 0 * time.Second
+	TIME_HOST        = ""
+	TIME_OFFSET      = 0 * time.Second
 	TIME_PORT        = ":8080"
+	TIME_PRECISION   = ""
+	TIME_RATE        = 0.0
+	TIME_SOURCE      = ""
+	TIME_BURST       = 10
+	TLS_CERT         = ""
+	TLS_KEY          = ""
+	TLS_MIN_VERSION  = "1.2"
+	STORAGE          = "json"
 	SEELOG_CONF_DIR  = "etc"
 	SEELOG_CONF_FILE = "seelog.xml"
-	TMPL_DIR         = "templates"
+	TMPL_DIR         = ""
+	TRUSTED_REFRESH  = 10 * time.Minute
+	TRUSTED_PROXIES  = ""
+	TRUSTED_SOURCE   = ""
+	UPSTREAM         = ""
+	UPSTREAM_TIMEOUT = 1 * time.Second
+	UPSTREAM_TTL     = 5 * time.Second
+	USER_TTL         = 24 * time.Hour
 )
 
 var (
+	AdminToken    *string
+	APIToken      *string
 	AuthHost      *string
 	AuthPort      *string
 	AuthTimeoutMS *time.Duration
+	AutoMaxProcs  *bool
 	AvgRespMS     *time.Duration
+	BlockPaths    *string
 	DeviationMS   *time.Duration
+	DevTemplates  *bool
 	DumpFile      *string
+	FileMode      os.FileMode
+	GitHubID      *string
+	GitHubSecret  *string
+	GoogleID      *string
+	GoogleSecret  *string
+	InlineLogin   *bool
+	LatencyBkts   *string
+	LeftDelim     *string
 	CheckpointInt *time.Duration
+	CompressMin   *int
+	NoCompression *bool
+	CookieCheck   *bool
+	CookieSecrets StringList
+	CookieKeyFile *string
+	CookieSecure  *bool
+	CookieSite    *string
+	DebugAddr     *string
+	DebugEndpts   *bool
+	DefaultTheme  *string
+	HTTPRedirect  *string
+	LoginBurst    *int
+	LoginRate     *float64
+	LogNames      *string
+	LogoutDelay   *int
 	MaxInFlight   *int
+	MaxRenders    *int
+	MaxUsers      *int
+	NoKeepAlives  *bool
+	NTPCacheTTL   *time.Duration
+	NTPServer     *string
+	NTPTimeout    *time.Duration
+	OIDCID        *string
+	OIDCIssuer    *string
+	OIDCSecret    *string
+	OIDCTitle     *string
+	PostLoginPath *string
+	PublicURL     *string
+	QRSize        *int
+	ReapChunkSize *int
+	ReapInterval  *time.Duration
+	RenderWait    *time.Duration
+	ReqTimeout    *time.Duration
+	RightDelim    *string
+	SessionTTL    *time.Duration
+	ShutdownTO    *time.Duration
+	SingleSession *bool
+	StaticDir     *string
+	StaticMaxAge  *time.Duration
+	Storage       *string
+	StreamIntvl   *time.Duration
+	Tarpit        *time.Duration
+	TimeNoName    *bool
+	TimeHost      *string
 	TimePort      *string
+	TimeOffset    *time.Duration
+	TimePrecision *string
+	TimeRate      *float64
+	TimeSource    *string
+	TimeBurst     *int
+	TLSCert       *string
+	TLSKey        *string
+	TLSMinVersion *string
 	TmplDir       *string
+	TrustedProxy  *string
+	TrustedRefr   *time.Duration
+	TrustedSource *string
+	Upstream      *string
+	UpstreamTO    *time.Duration
+	UpstreamTTL   *time.Duration
+	UserTTL       *time.Duration
 	Verbose       *bool
+	WarmTemplates *bool
 	Logger        log.LoggerInterface
 )
 
+// Flag value collecting every occurrence of a repeatable flag in order.
+type StringList []string
+
+func (l *StringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *StringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
 func init() {
 	// Parameters for timeserver:
 	AuthHost = flag.String("authhost", AUTH_HOST, "Hostname of downstream authentication server.")
 	AuthTimeoutMS = flag.Duration("authtimeout-ms", AUTH_TIMEOUT_MS, "Milliseconds to wait before terminating downstream auth request.")
+	AutoMaxProcs = flag.Bool("auto-maxprocs", false, "Size GOMAXPROCS to the cgroup CPU quota instead of the host CPU count.")
+	BlockPaths = flag.String("block-paths", BLOCK_PATHS, "Comma separated scanner paths answered with a bare 404. Entries ending in / block the whole subtree.")
+	DefaultTheme = flag.String("default-theme", DEFAULT_THEME, "Display theme for visitors who have not chosen one: system, light, or dark.")
+	NoCompression = flag.Bool("no-compression", false, "Never compress responses. For debugging.")
+	CompressMin = flag.Int("compress-min-size", COMPRESS_MIN, "Smallest response body in bytes compressed with brotli or gzip.")
+	NoKeepAlives = flag.Bool("disable-keepalives", false, "Close each connection after one request instead of reusing it.")
+	DebugEndpts = flag.Bool("debug-endpoints", false, "Expose debugging endpoints under /debug/.")
+	CookieCheck = flag.Bool("cookie-check", false, "After login, detect clients that do not send the session cookie back and ask them to enable cookies.")
+	flag.Var(&CookieSecrets, "cookie-secret", "Key for signing session cookies. Repeat to rotate: first signs, all verify.")
+	CookieKeyFile = flag.String("cookie-secret-file", COOKIE_KEY_FILE, "File holding the session cookie signing key, generated on first run if absent. Ignored when --cookie-secret is set.")
+	CookieSecure = flag.Bool("secure-cookies", false, "Mark session cookies Secure so browsers only send them over HTTPS.")
+	CookieSite = flag.String("cookie-samesite", COOKIE_SAME_SITE, "SameSite attribute of session cookies: lax, strict, or none. none implies --secure-cookies.")
 	AvgRespMS = flag.Duration("avg-response-ms", AVG_RESP_MS, "Average time to delay response to upstream time request.")
+	DevTemplates = flag.Bool("dev-templates", false, "Reparse --templates on every request. For development only.")
+	flag.BoolVar(DevTemplates, "reload-templates", false, "Same as --dev-templates.")
 	DeviationMS = flag.Duration("deviation-ms", DEV_MS, "Average standard deviation in response delay to upstream time request.")
+	InlineLogin = flag.Bool("inline-login", false, "Show the login form on / to anonymous visitors instead of redirecting to /login.")
+	LatencyBkts = flag.String("latency-buckets", LATENCY_BUCKETS, "Comma separated upper bounds in seconds of request latency histogram buckets.")
+	LeftDelim = flag.String("left-delim", LEFT_DELIM, "Left action delimiter used when parsing templates.")
+	RightDelim = flag.String("right-delim", RIGHT_DELIM, "Right action delimiter used when parsing templates.")
+	LoginRate = flag.Float64("login-rate", LOGIN_RATE, "Average login attempts per second allowed per client address. Zero for no limit.")
+	LoginBurst = flag.Int("login-burst", LOGIN_BURST, "Login attempts a client address may make in a burst under --login-rate.")
+	LogNames = flag.String("log-names", LOG_NAMES, "Log names submitted at login at Debug level: off, plain, or redacted to log only length and hash.")
+	LogoutDelay = flag.Int("logout-delay", LOGOUT_DELAY, "Seconds before the logged out page redirects to login. Zero disables redirect.")
 	MaxInFlight = flag.Int("max-inflight", MAX_IN_FLIGHT, "Maximum number of in-flight time requests the timeserver can handle.")
+	MaxRenders = flag.Int("max-renders", MAX_RENDERS, "Maximum number of templates rendered concurrently. Zero for no limit.")
+	RenderWait = flag.Duration("render-wait", RENDER_WAIT, "Time a render waits for a free slot under --max-renders before answering 503.")
+	ReqTimeout = flag.Duration("request-timeout", REQUEST_TIMEOUT, "Longest a request may take before it is cancelled and answered 504. Streams and websockets are exempt once started. Zero for no limit.")
+	NTPCacheTTL = flag.Duration("ntp-cache-ttl", NTP_CACHE_TTL, "Duration to reuse the last NTP measurement.")
+	NTPServer = flag.String("ntp-server", NTP_SERVER, "NTP server used to measure clock accuracy, as host or host:port.")
+	NTPTimeout = flag.Duration("ntp-timeout", NTP_TIMEOUT, "Milliseconds to wait for a response from the NTP server.")
+	StreamIntvl = flag.Duration("stream-interval", STREAM_INTERVAL, "Time between updates pushed to /time/stream and /time/ws clients.")
+	SessionTTL = flag.Duration("session-ttl", SESSION_TTL, "Lifetime of session cookies, renewed on each visit by a logged in user. Keep authserver's --user-ttl at least as long.")
+	Tarpit = flag.Duration("tarpit", TARPIT, "Delay before answering requests for blocked paths.")
+	GitHubID = flag.String("github-client-id", GITHUB_CLIENT_ID, "OAuth app client id enabling login with GitHub. Needs --github-client-secret.")
+	GitHubSecret = flag.String("github-client-secret", GITHUB_SECRET, "OAuth app client secret for --github-client-id.")
+	GoogleID = flag.String("google-client-id", GOOGLE_CLIENT_ID, "OAuth client id enabling login with Google. Needs --google-client-secret.")
+	GoogleSecret = flag.String("google-client-secret", GOOGLE_SECRET, "OAuth client secret for --google-client-id.")
+	OIDCIssuer = flag.String("oidc-issuer", OIDC_ISSUER, "Issuer URL of an OpenID Connect provider enabling login with it, discovered at startup. Needs --oidc-client-id and --oidc-client-secret.")
+	OIDCID = flag.String("oidc-client-id", OIDC_CLIENT_ID, "Client id registered with --oidc-issuer.")
+	OIDCSecret = flag.String("oidc-client-secret", OIDC_SECRET, "Client secret registered with --oidc-issuer.")
+	OIDCTitle = flag.String("oidc-title", OIDC_TITLE, "Name of the --oidc-issuer provider shown on the login page.")
+	PublicURL = flag.String("public-url", PUBLIC_URL, "Base URL the timeserver is reached at, such as https://time.example.com, used in login provider callbacks. Unset derives it from each request.")
+	PostLoginPath = flag.String("post-login-path", POST_LOGIN_PATH, "Time page logged in users are sent to from /: /time, /time/iso, or /time/rfc1123. Unset shows the greetings page.")
+	QRSize = flag.Int("qr-size", QR_SIZE, "Width and height in pixels of the /time/qr PNG.")
+	TimeNoName = flag.Bool("time-no-name", false, "Never personalize the time page with the name of the logged in user.")
+	TimeOffset = flag.Duration("time-offset", TIME_OFFSET, "Fixed offset added to the time reported by every time endpoint, on top of the time source. For testing clients against a skewed clock.")
+	TimePrecision = flag.String("time-precision", TIME_PRECISION, "Fractional seconds shown by all time endpoints: seconds, millis, or nanos. Unset keeps each endpoint's default.")
+	TimeRate = flag.Float64("time-rate", TIME_RATE, "Average time page requests per second allowed per session, or per address without one. Zero for no limit.")
+	TimeSource = flag.String("time-source", TIME_SOURCE, "Clock the time endpoints report: local, ntp for the local clock corrected by the --ntp-server offset, or upstream. Unset is upstream with --upstream, otherwise local.")
+	TimeBurst = flag.Int("time-burst", TIME_BURST, "Time page requests a session may make in a burst under --time-rate.")
+	TimeHost = flag.String("host", TIME_HOST, "Interface address time server binds to, such as 127.0.0.1 or ::1. Unset binds all interfaces.")
 	TimePort = flag.String("port", TIME_PORT, "Time server binds to this port.")
-	TmplDir = flag.String("templates", TMPL_DIR, "Directory relative to executable where templates are stored.")
+	TLSCert = flag.String("tls-cert", TLS_CERT, "PEM certificate file. With --tls-key serves HTTPS instead of HTTP.")
+	TLSKey = flag.String("tls-key", TLS_KEY, "PEM private key file for --tls-cert.")
+	HTTPRedirect = flag.String("http-redirect-port", HTTP_REDIRECT, "With --tls-cert, also listen on this port for plain HTTP and redirect it to HTTPS on --port. Unset disables.")
+	TLSMinVersion = flag.String("tls-min-version", TLS_MIN_VERSION, "Minimum TLS version accepted by time server: 1.0, 1.1, 1.2, or 1.3.")
+	StaticDir = flag.String("static-dir", STATIC_DIR, "Directory of assets served under /static/ instead of those built into the binary. Relative to the working directory.")
+	StaticMaxAge = flag.Duration("static-max-age", STATIC_MAX_AGE, "How long browsers may cache /static/ assets before revalidating them.")
+	TmplDir = flag.String("templates", TMPL_DIR, "Directory of templates used instead of those built into the binary. Relative to the working directory.")
+	TrustedProxy = flag.String("trusted-proxies", TRUSTED_PROXIES, "Comma separated CIDRs or addresses of reverse proxies whose X-Forwarded-For and X-Forwarded-Proto headers are honored.")
+	TrustedSource = flag.String("trusted-source", TRUSTED_SOURCE, "Serve time from a clock synchronized against ntp or upstream and advanced monotonically, ignoring host clock jumps.")
+	TrustedRefr = flag.Duration("trusted-refresh", TRUSTED_REFRESH, "Interval between synchronizations of the --trusted-source clock.")
+	Upstream = flag.String("upstream", UPSTREAM, "Base URL of upstream timeserver to relay time from instead of the local clock.")
+	UpstreamTO = flag.Duration("upstream-timeout", UPSTREAM_TIMEOUT, "Milliseconds to wait for the upstream timeserver.")
+	UpstreamTTL = flag.Duration("upstream-ttl", UPSTREAM_TTL, "Duration to reuse the last upstream time before fetching again.")
+	WarmTemplates = flag.Bool("warm-templates", true, "Render every template with sample data at startup and on reload, refusing templates that fail.")
 	Verbose = flag.Bool("V", false, "Prints version number of program.")
 
 	// Parameters for authserver:
+	APIToken = flag.String("api-token", API_TOKEN, "Bearer token required by the /api/v1 user management API. The API is disabled when empty.")
 	DumpFile = flag.String("dumpfile", DUMP_FILE, "Name of file storing state as JSON document.")
+	Storage = flag.String("storage", STORAGE, "Encoding of the dumpfile: json or gob. Files written in one encoding are not readable in the other.")
 	CheckpointInt = flag.Duration("checkpoint-interval", CHECKPOINT_INT, "Dump state to file every checkpoint-interval seconds.")
+	MaxUsers = flag.Int("max-users", MAX_USERS, "Maximum number of users held by auth server. Zero for no limit.")
+	ReapChunkSize = flag.Int("reap-chunk-size", REAP_CHUNK_SIZE, "Users removed per lock acquisition when expiring stale users.")
+	ReapInterval = flag.Duration("reap-interval", REAP_INTERVAL, "Interval between sweeps removing users not seen within --user-ttl.")
+	UserTTL = flag.Duration("user-ttl", USER_TTL, "Remove users not seen for this duration.")
+	SingleSession = flag.Bool("single-session", false, "Log out existing sessions for a name when the same name logs in again.")
 
 	// Shared parameters:
+	AdminToken = flag.String("admin-token", ADMIN_TOKEN, "Token required by authserver's admin endpoints and timeserver's /admin page, which uses it to call them. Both are disabled when empty.")
 	AuthPort = flag.String("authport", AUTH_PORT, "Auth server binds to this port.")
+	DebugAddr = flag.String("debug-addr", DEBUG_ADDR, "Address, such as localhost:6060, of a separate listener serving pprof profiles and expvar variables under /debug/. Keep it on loopback. Disabled when empty.")
+	ShutdownTO = flag.Duration("shutdown-timeout", SHUTDOWN_TIMEOUT, "Time allowed for in-flight requests to finish on SIGINT or SIGTERM before connections are closed.")
 
 	// Local parameters:
+	configFile := flag.String(CONFIG_FLAG, CONFIG_FILE, "JSON file of settings keyed by flag name. Flags given on the command line override it.")
+	fileMode := flag.String("file-mode", FILE_MODE, "Octal permissions for created log and dump files.")
 	logConf := flag.String("log", SEELOG_CONF_FILE, "Name of log configuration file in etc directory relative to executable.")
+	logFormat := flag.String("log-format", LOG_FORMAT, "Format of log messages: text, as set in the log configuration file, logfmt, or json.")
+	logLevel := flag.String("log-level", LOG_LEVEL, "Least severe level logged by every output: trace, debug, info, warn, error, or critical. Unset keeps the levels of the log configuration file.")
+	logPath := flag.String("log-file", LOG_FILE, "Log file replacing the file outputs of the log configuration file, rotated per --log-rotate. Unset keeps the configured outputs.")
+	logRotate := flag.String("log-rotate", LOG_ROTATE, "When --log-file is rotated: size, once it reaches --log-max-size, or daily.")
+	logMaxSize := flag.Int64("log-max-size", LOG_MAX_SIZE, "Bytes --log-file may reach before it is rotated under --log-rotate size.")
+	logMaxRolls := flag.Int("log-max-rolls", LOG_MAX_ROLLS, "Rotated --log-file files kept before the oldest is deleted.")
+
+	// Test binaries parse their own -test flags once every init has run,
+	// so settings keep their defaults under go test.
+	if !testing.Testing() {
+		flag.Parse()
+	}
 
-	flag.Parse()
+	if *configFile != CONFIG_FILE {
+		if err := loadFile(*configFile); err != nil {
+			log.Critical(err)
+			os.Exit(1)
+		}
+	}
+
+	// Must precede logger creation so log files are created with the
+	// restricted permissions.
+	if err := setFileMode(*fileMode); err != nil {
+		log.Critical(err)
+		os.Exit(1)
+	}
+
+	if *logLevel != LOG_LEVEL && !IsValidLevel(*logLevel) {
+		log.Critical("config: Log level must be trace, debug, info, warn, error, or critical.")
+		os.Exit(1)
+	}
+
+	if *logRotate != LOG_ROTATE_SIZE && *logRotate != LOG_ROTATE_DAILY {
+		log.Critical("config: Log rotation must be size or daily.")
+		os.Exit(1)
+	}
+	if *logMaxSize <= 0 || *logMaxRolls <= 0 {
+		log.Critical("config: Log max size and max rolls must be positive.")
+		os.Exit(1)
+	}
+	file := logFile{path: *logPath, rotate: *logRotate, maxSize: *logMaxSize, maxRolls: *logMaxRolls}
 
 	// Will fail to default log configuration as defined by seelog package
 	// if unable to open file. Assumes *LogConf is in SEELOG_CONF_DIR relative to cwd.
-	cwd, _ := os.Getwd()
-	var err error
-	if Logger, err = log.LoggerFromConfigAsFile(filepath.Join(cwd, SEELOG_CONF_DIR, *logConf)); err != nil {
+	// Log configuration and templates are found relative to the working
+	// directory, so an unknown one would only surface later as confusing
+	// missing file errors.
+	cwd, err := os.Getwd()
+	if err != nil {
+		log.Critical("config: Unable to determine working directory - " + err.Error())
+		log.Flush()
+		os.Exit(1)
+	}
+	if Logger, err = newLogger(filepath.Join(cwd, SEELOG_CONF_DIR, *logConf), *logFormat, *logLevel, file); err != nil {
 		log.Warn(err)
 	}
 }
+
+// Creates logger from the seelog configuration at path. The text format
+// uses the configuration's own formats, logfmt and json rewrite its outputs
+// to use the matching custom formatter. A level other than LOG_LEVEL
+// replaces the levels of every output, and a file path other than
+// LOG_FILE replaces its file outputs with a rotated one.
+func newLogger(path string, format string, level string, file logFile) (log.LoggerInterface, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	conf := string(data)
+	if level != LOG_LEVEL {
+		conf = withLevel(conf, level)
+	}
+	if file.path != LOG_FILE {
+		conf = withFile(conf, file)
+	}
+
+	// Formatters are registered here rather than in init() as they must
+	// exist before the configuration referencing them is parsed.
+	switch format {
+	case LOG_FORMAT:
+	case LOGFMT_FORMAT_ID:
+		if err = log.RegisterCustomFormatter(LOGFMT_FORMATTER, newLogfmtFormatter); err != nil {
+			return nil, err
+		}
+		conf = withFormat(conf, LOGFMT_FORMAT_ID, LOGFMT_FORMATTER)
+	case JSON_FORMAT_ID:
+		if err = log.RegisterCustomFormatter(JSON_FORMATTER, newJSONFormatter); err != nil {
+			return nil, err
+		}
+		conf = withFormat(conf, JSON_FORMAT_ID, JSON_FORMATTER)
+	default:
+		return nil, errors.New("config: Unsupported log format - " + format)
+	}
+	return log.LoggerFromConfigAsBytes([]byte(conf))
+}
+
+// Returns the address to listen on for host and port. port may be given as
+// ":8080" or "8080". An empty host returns port unchanged, so a port that
+// already names an interface keeps working; otherwise host replaces any
+// host in port. IPv6 hosts are bracketed.
+func ListenAddr(host string, port string) string {
+	if host == "" {
+		return port
+	}
+	if _, p, err := net.SplitHostPort(port); err == nil {
+		port = p
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// Parses mode as octal permission bits and stores the result in FileMode.
+// Group and other bits absent from mode are also masked out of the process
+// umask so files created by third party code, like seelog's log files, are
+// no more permissive. Owner bits are left alone so created directories
+// remain traversable.
+func setFileMode(mode string) (err error) {
+	var bits uint64
+	if bits, err = strconv.ParseUint(mode, 8, 32); err != nil || bits > 0777 {
+		return errors.New("config: Invalid octal file mode - " + mode)
+	}
+	FileMode = os.FileMode(bits)
+	syscall.Umask(int(^bits & 0077))
+	return
+}
@@ -0,0 +1,32 @@
+//  Copyright (C) Pat Kaehuaea - All Rights Reserved
+//  Unauthorized copying of this file, via any medium is strictly prohibited
+//  Proprietary and confidential
+//  Written by Pat Kaehuaea, March 2015
+
+package config
+
+import (
+	"testing"
+)
+
+func TestListenAddr(t *testing.T) {
+	tests := []struct {
+		host string
+		port string
+		want string
+	}{
+		{"", ":8080", ":8080"},
+		{"", "127.0.0.1:8080", "127.0.0.1:8080"},
+		{"127.0.0.1", ":8080", "127.0.0.1:8080"},
+		{"127.0.0.1", "8080", "127.0.0.1:8080"},
+		{"10.0.0.1", "127.0.0.1:8080", "10.0.0.1:8080"},
+		{"::1", ":8080", "[::1]:8080"},
+		{"fe80::1%eth0", "8080", "[fe80::1%eth0]:8080"},
+		{"localhost", ":443", "localhost:443"},
+	}
+	for _, tt := range tests {
+		if got := ListenAddr(tt.host, tt.port); got != tt.want {
+			t.Errorf("ListenAddr(%q, %q) = %q, want %q", tt.host, tt.port, got, tt.want)
+		}
+	}
+}
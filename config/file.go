@@ -0,0 +1,83 @@
+//  Copyright (C) Pat Kaehuaea - All Rights Reserved
+//  Unauthorized copying of this file, via any medium is strictly prohibited
+//  Proprietary and confidential
+//  Written by Pat Kaehuaea, March 2015
+//
+// Settings read from the --config file. The file is a JSON object keyed by
+// flag name, so every flag can be set from it and a value is parsed exactly
+// as the flag would parse it on the command line.
+
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io/ioutil"
+)
+
+const CONFIG_FLAG = "config"
+
+// Sets every flag named in the JSON object at path that was not given on
+// the command line. Strings, numbers, and booleans are accepted, as are
+// arrays for repeatable flags such as cookie-secret. Durations are
+// strings like "5s". Unknown names and values the flag rejects are errors.
+func loadFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return errors.New("config: Unable to read config file " + path + " - " + err.Error())
+	}
+
+	var settings map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err = dec.Decode(&settings); err != nil {
+		return errors.New("config: Unable to parse config file " + path + " - " + err.Error())
+	}
+
+	given := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { given[f.Name] = true })
+
+	for name, value := range settings {
+		if name == CONFIG_FLAG {
+			return errors.New("config: Config file " + path + " cannot set " + CONFIG_FLAG + ".")
+		}
+		if flag.Lookup(name) == nil {
+			return errors.New("config: Unknown setting in config file " + path + " - " + name)
+		}
+		if given[name] {
+			continue
+		}
+		values, ok := value.([]interface{})
+		if !ok {
+			values = []interface{}{value}
+		}
+		for _, v := range values {
+			s, err := settingString(v)
+			if err == nil {
+				err = flag.Set(name, s)
+			}
+			if err != nil {
+				return errors.New("config: Invalid value for " + name + " in config file " + path + " - " + err.Error())
+			}
+		}
+	}
+	return nil
+}
+
+// Returns v as the string the flag would receive on the command line.
+func settingString(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		if v {
+			return "true", nil
+		}
+		return "false", nil
+	}
+	return "", errors.New("expected a string, number, or boolean")
+}
@@ -0,0 +1,126 @@
+//  Copyright (C) Pat Kaehuaea - All Rights Reserved
+//  Unauthorized copying of this file, via any medium is strictly prohibited
+//  Proprietary and confidential
+//  Written by Pat Kaehuaea, March 2015
+
+package config
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Flags loadFile sets in place of the real ones.
+type testFlags struct {
+	name    *string
+	port    *int
+	verbose *bool
+	wait    *time.Duration
+	secrets StringList
+}
+
+// Replaces the command line with one defining testFlags, given args, for
+// the duration of t. The --config flag is defined so the file cannot set
+// it.
+func withFlags(t *testing.T, args ...string) *testFlags {
+	saved := flag.CommandLine
+	t.Cleanup(func() { flag.CommandLine = saved })
+	flag.CommandLine = flag.NewFlagSet("test", flag.ContinueOnError)
+	f := &testFlags{
+		name:    flag.String("name", "anonymous", ""),
+		port:    flag.Int("port", 8080, ""),
+		verbose: flag.Bool("verbose", false, ""),
+		wait:    flag.Duration("wait", time.Second, ""),
+	}
+	flag.Var(&f.secrets, "secret", "")
+	flag.String(CONFIG_FLAG, CONFIG_FILE, "")
+	if err := flag.CommandLine.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// Writes contents to a config file and returns its path.
+func writeConfig(t *testing.T, contents string) string {
+	path := filepath.Join(t.TempDir(), "timeserver.json")
+	if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadFile(t *testing.T) {
+	f := withFlags(t)
+	path := writeConfig(t, `{"name": "Ada", "port": 9090, "verbose": true, "wait": "5s", "secret": ["first", "second"]}`)
+	if err := loadFile(path); err != nil {
+		t.Fatalf("loadFile() error %v", err)
+	}
+	if *f.name != "Ada" || *f.port != 9090 || !*f.verbose || *f.wait != 5*time.Second {
+		t.Errorf("settings = %q %d %v %v, want Ada 9090 true 5s", *f.name, *f.port, *f.verbose, *f.wait)
+	}
+	if want := (StringList{"first", "second"}); !reflect.DeepEqual(f.secrets, want) {
+		t.Errorf("secret = %q, want %q", f.secrets, want)
+	}
+}
+
+func TestLoadFileCommandLineWins(t *testing.T) {
+	f := withFlags(t, "--port", "7070", "--secret", "given")
+	path := writeConfig(t, `{"name": "Ada", "port": 9090, "secret": ["first", "second"]}`)
+	if err := loadFile(path); err != nil {
+		t.Fatalf("loadFile() error %v", err)
+	}
+	if *f.name != "Ada" {
+		t.Errorf("name = %q, want Ada from the file", *f.name)
+	}
+	if *f.port != 7070 {
+		t.Errorf("port = %d, want 7070 from the command line", *f.port)
+	}
+	if want := (StringList{"given"}); !reflect.DeepEqual(f.secrets, want) {
+		t.Errorf("secret = %q, want %q from the command line only", f.secrets, want)
+	}
+}
+
+func TestLoadFileErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+		want     string
+	}{
+		{"not JSON", `name = "Ada"`, "Unable to parse"},
+		{"not an object", `["Ada"]`, "Unable to parse"},
+		{"unknown key", `{"nmae": "Ada"}`, "Unknown setting in config file %s - nmae"},
+		{"config key", `{"config": "other.json"}`, "cannot set config"},
+		{"object value", `{"name": {"first": "Ada"}}`, "Invalid value for name"},
+		{"null value", `{"name": null}`, "Invalid value for name"},
+		{"nested array", `{"secret": [["first"]]}`, "Invalid value for secret"},
+		{"rejected by flag", `{"port": "eighty"}`, "Invalid value for port"},
+		{"duration as number", `{"wait": 5}`, "Invalid value for wait"},
+	}
+	for _, tt := range tests {
+		withFlags(t)
+		path := writeConfig(t, tt.contents)
+		err := loadFile(path)
+		if err == nil {
+			t.Errorf("%s: loadFile() succeeded, want an error", tt.name)
+			continue
+		}
+		want := strings.Replace(tt.want, "%s", path, 1)
+		if !strings.Contains(err.Error(), want) || !strings.Contains(err.Error(), path) {
+			t.Errorf("%s: error %q, want it to contain %q and the path", tt.name, err, want)
+		}
+	}
+}
+
+func TestLoadFileMissing(t *testing.T) {
+	withFlags(t)
+	path := filepath.Join(t.TempDir(), "missing.json")
+	err := loadFile(path)
+	if err == nil || !strings.Contains(err.Error(), "Unable to read config file "+path) {
+		t.Errorf("loadFile() error %v, want it to name %s", err, path)
+	}
+}
@@ -0,0 +1,49 @@
+//  Copyright (C) Pat Kaehuaea - All Rights Reserved
+//  Unauthorized copying of this file, via any medium is strictly prohibited
+//  Proprietary and confidential
+//  Written by Pat Kaehuaea, March 2015
+//
+// Seelog formatter writing each message as a single line JSON object
+// carrying the same fields as the logfmt format. Selected with
+// --log-format json for structured log pipelines.
+
+package config
+
+import (
+	"encoding/json"
+	log "github.com/cihub/seelog"
+	"time"
+)
+
+const (
+	JSON_FORMATTER = "JSON"
+	JSON_FORMAT_ID = "json"
+)
+
+type jsonEntry struct {
+	Time  string `json:"time"`
+	Level string `json:"level"`
+	File  string `json:"file"`
+	Func  string `json:"func"`
+	Line  int    `json:"line"`
+	Msg   string `json:"msg"`
+}
+
+func newJSONFormatter(param string) log.FormatterFunc {
+	return formatJSON
+}
+
+// Formats a single entry as a JSON object terminated by a newline.
+func formatJSON(message string, level log.LogLevel, context log.LogContextInterface) interface{} {
+	entry := jsonEntry{
+		Time:  context.CallTime().Format(time.RFC3339Nano),
+		Level: level.String(),
+		File:  context.FileName(),
+		Func:  context.Func(),
+		Line:  context.Line(),
+		Msg:   message,
+	}
+	// Encoding a struct of strings and an int cannot fail.
+	data, _ := json.Marshal(entry)
+	return string(data) + "\n"
+}
@@ -0,0 +1,143 @@
+//  Copyright (C) Pat Kaehuaea - All Rights Reserved
+//  Unauthorized copying of this file, via any medium is strictly prohibited
+//  Proprietary and confidential
+//  Written by Pat Kaehuaea, March 2015
+//
+// Rewrites applied to the seelog configuration file before the logger is
+// created, so --log-format, --log-level and --log-file can change every
+// output without editing the file.
+
+package config
+
+import (
+	"bytes"
+	"encoding/xml"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	LOG_ROTATE_SIZE  = "size"
+	LOG_ROTATE_DAILY = "daily"
+	// Suffix seelog appends to daily rolled files.
+	LOG_DATE_PATTERN = "2006-01-02"
+)
+
+// Log file and rotation selected with --log-file, --log-rotate,
+// --log-max-size and --log-max-rolls.
+type logFile struct {
+	path     string
+	rotate   string
+	maxSize  int64
+	maxRolls int
+}
+
+// Seelog levels from least to most severe.
+var LOG_LEVELS = []string{"trace", "debug", "info", "warn", "error", "critical"}
+
+// Matches the formatid attribute of seelog's <outputs> and output elements.
+var formatIDAttr = regexp.MustCompile(`formatid="[^"]*"`)
+
+// Matches the level constraints of the <seelog> and filter elements.
+var levelAttr = regexp.MustCompile(`\s+(levels|minlevel|maxlevel)="[^"]*"`)
+
+// Matches start and empty element tags, and the tag name within them.
+var (
+	startTag = regexp.MustCompile(`<[A-Za-z][^>]*>`)
+	tagName  = regexp.MustCompile(`^<[A-Za-z][^\s/>]*`)
+)
+
+// Matches seelog's file writers, which --log-file replaces.
+var fileWriter = regexp.MustCompile(`<(file|rollingfile)\b[^>]*/>`)
+
+// Returns true if level is one of LOG_LEVELS.
+func IsValidLevel(level string) bool {
+	for _, l := range LOG_LEVELS {
+		if l == level {
+			return true
+		}
+	}
+	return false
+}
+
+// Rewrites a seelog configuration so every output uses the format with id,
+// written by the custom formatter. The format is added to the existing
+// <formats> element or appended when the configuration has none.
+func withFormat(conf string, id string, formatter string) string {
+	format := `<format id="` + id + `" format="%` + formatter + `"/>`
+	conf = formatIDAttr.ReplaceAllString(conf, `formatid="`+id+`"`)
+	if !strings.Contains(conf, "formatid=") {
+		conf = strings.Replace(conf, "<outputs", `<outputs formatid="`+id+`"`, 1)
+	}
+	if strings.Contains(conf, "</formats>") {
+		return strings.Replace(conf, "</formats>", format+"</formats>", 1)
+	}
+	return strings.Replace(conf, "</seelog>", "<formats>"+format+"</formats></seelog>", 1)
+}
+
+// Rewrites a seelog configuration so every output writes level and more
+// severe messages, replacing the level constraints of the file. Each
+// constrained element loses all of its levels, minlevel and maxlevel
+// attributes and gains a single levels attribute, so one with both
+// minlevel and maxlevel does not end up repeating it. Expects level to
+// have been checked with IsValidLevel().
+func withLevel(conf string, level string) string {
+	var levels []string
+	for i, l := range LOG_LEVELS {
+		if l == level {
+			levels = LOG_LEVELS[i:]
+		}
+	}
+	attr := `levels="` + strings.Join(levels, ",") + `"`
+	constrained := false
+	conf = startTag.ReplaceAllStringFunc(conf, func(tag string) string {
+		if !levelAttr.MatchString(tag) {
+			return tag
+		}
+		constrained = true
+		tag = levelAttr.ReplaceAllString(tag, "")
+		name := tagName.FindString(tag)
+		return name + " " + attr + tag[len(name):]
+	})
+	if !constrained {
+		conf = strings.Replace(conf, "<seelog", "<seelog "+attr, 1)
+	}
+	return conf
+}
+
+// Rewrites a seelog configuration so every file writer becomes a rolling
+// writer of f.path, rotated at f.maxSize bytes or daily and keeping
+// f.maxRolls old files. A configuration without a file writer gains one
+// directly under <outputs>, so it logs at the levels of the whole file.
+func withFile(conf string, f logFile) string {
+	var writer string
+	switch f.rotate {
+	case LOG_ROTATE_DAILY:
+		writer = `<rollingfile type="date" filename="` + xmlEscape(f.path) + `" datepattern="` + LOG_DATE_PATTERN +
+			`" maxrolls="` + strconv.Itoa(f.maxRolls) + `"/>`
+	default:
+		writer = `<rollingfile type="size" filename="` + xmlEscape(f.path) + `" maxsize="` + strconv.FormatInt(f.maxSize, 10) +
+			`" maxrolls="` + strconv.Itoa(f.maxRolls) + `"/>`
+	}
+	if fileWriter.MatchString(conf) {
+		return fileWriter.ReplaceAllLiteralString(conf, writer)
+	}
+	start := strings.Index(conf, "<outputs")
+	if start < 0 {
+		return conf
+	}
+	end := strings.Index(conf[start:], ">")
+	if end < 0 {
+		return conf
+	}
+	at := start + end + 1
+	return conf[:at] + writer + conf[at:]
+}
+
+// Returns s escaped for use within an XML attribute.
+func xmlEscape(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
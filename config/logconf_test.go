@@ -0,0 +1,52 @@
+//  Copyright (C) Pat Kaehuaea - All Rights Reserved
+//  Unauthorized copying of this file, via any medium is strictly prohibited
+//  Proprietary and confidential
+//  Written by Pat Kaehuaea, March 2015
+
+package config
+
+import (
+	"testing"
+)
+
+func TestWithLevel(t *testing.T) {
+	const want = `levels="warn,error,critical"`
+	tests := []struct {
+		name string
+		conf string
+		want string
+	}{
+		{
+			"unconstrained",
+			`<seelog><outputs><console/></outputs></seelog>`,
+			`<seelog ` + want + `><outputs><console/></outputs></seelog>`,
+		},
+		{
+			"levels",
+			`<seelog levels="info,error"><outputs><console/></outputs></seelog>`,
+			`<seelog ` + want + `><outputs><console/></outputs></seelog>`,
+		},
+		{
+			"minlevel and maxlevel",
+			`<seelog type="sync" minlevel="debug" maxlevel="error"><outputs/></seelog>`,
+			`<seelog ` + want + ` type="sync"><outputs/></seelog>`,
+		},
+		{
+			"filters",
+			`<seelog minlevel="info"><outputs><filter maxlevel="error" minlevel="trace"><console/></filter>` +
+				`<filter levels="critical"><file path="x"/></filter></outputs></seelog>`,
+			`<seelog ` + want + `><outputs><filter ` + want + `><console/></filter>` +
+				`<filter ` + want + `><file path="x"/></filter></outputs></seelog>`,
+		},
+		{
+			"attribute lookalike text",
+			`<seelog><outputs><console/></outputs><!-- minlevel="info" --></seelog>`,
+			`<seelog ` + want + `><outputs><console/></outputs><!-- minlevel="info" --></seelog>`,
+		},
+	}
+	for _, tt := range tests {
+		if got := withLevel(tt.conf, "warn"); got != tt.want {
+			t.Errorf("%s: withLevel() = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+//  Copyright (C) Pat Kaehuaea - All Rights Reserved
+//  Unauthorized copying of this file, via any medium is strictly prohibited
+//  Proprietary and confidential
+//  Written by Pat Kaehuaea, March 2015
+//
+// Seelog formatter writing each message as logfmt: space separated
+// key=value pairs carrying the same fields as the common text format.
+// Selected with --log-format logfmt, which points every output of the
+// seelog configuration at the formatter.
+
+package config
+
+import (
+	log "github.com/cihub/seelog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	LOGFMT_FORMATTER = "Logfmt"
+	LOGFMT_FORMAT_ID = "logfmt"
+)
+
+func newLogfmtFormatter(param string) log.FormatterFunc {
+	return formatLogfmt
+}
+
+// Formats a single entry as time, level, file, func, line and msg pairs
+// terminated by a newline.
+func formatLogfmt(message string, level log.LogLevel, context log.LogContextInterface) interface{} {
+	pairs := []string{
+		"time=" + logfmtValue(context.CallTime().Format(time.RFC3339Nano)),
+		"level=" + logfmtValue(level.String()),
+		"file=" + logfmtValue(context.FileName()),
+		"func=" + logfmtValue(context.Func()),
+		"line=" + strconv.Itoa(context.Line()),
+		"msg=" + logfmtValue(message),
+	}
+	return strings.Join(pairs, " ") + "\n"
+}
+
+// Returns value quoted if it is empty or holds spaces, quotes, equals
+// signs or non printable characters, otherwise returns value as is.
+func logfmtValue(value string) string {
+	if value == "" {
+		return `""`
+	}
+	for _, r := range value {
+		if r <= ' ' || r == '=' || r == '"' || !strconv.IsPrint(r) {
+			return strconv.Quote(value)
+		}
+	}
+	return value
+}
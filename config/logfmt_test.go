@@ -0,0 +1,51 @@
+//  Copyright (C) Pat Kaehuaea - All Rights Reserved
+//  Unauthorized copying of this file, via any medium is strictly prohibited
+//  Proprietary and confidential
+//  Written by Pat Kaehuaea, March 2015
+
+package config
+
+import (
+	log "github.com/cihub/seelog"
+	"testing"
+	"time"
+)
+
+// Log context of a message logged from timeserver.go line 42 at
+// 2015-03-01 12:00 UTC.
+type testContext struct{}
+
+func (testContext) Func() string               { return "main.handleTime" }
+func (testContext) Line() int                  { return 42 }
+func (testContext) ShortPath() string          { return "timeserver.go" }
+func (testContext) FullPath() string           { return "/src/timeserver/timeserver.go" }
+func (testContext) FileName() string           { return "timeserver.go" }
+func (testContext) IsValid() bool              { return true }
+func (testContext) CustomContext() interface{} { return nil }
+func (testContext) CallTime() time.Time {
+	return time.Date(2015, time.March, 1, 12, 0, 0, 0, time.UTC)
+}
+
+const LOGFMT_PREFIX = "time=2015-03-01T12:00:00Z level=info file=timeserver.go func=main.handleTime line=42 "
+
+func TestFormatLogfmt(t *testing.T) {
+	tests := []struct {
+		message string
+		want    string
+	}{
+		{"ready", "msg=ready"},
+		{"", `msg=""`},
+		{"Templates reloaded.", `msg="Templates reloaded."`},
+		{"key=value", `msg="key=value"`},
+		{`say "hi"`, `msg="say \"hi\""`},
+		{"two\nlines", `msg="two\nlines"`},
+		{"tab\tseparated", `msg="tab\tseparated"`},
+		{"naïve", "msg=naïve"},
+	}
+	for _, tt := range tests {
+		got := formatLogfmt(tt.message, log.InfoLvl, testContext{})
+		if want := LOGFMT_PREFIX + tt.want + "\n"; got != want {
+			t.Errorf("formatLogfmt(%q) = %q, want %q", tt.message, got, want)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+//  Copyright (C) Pat Kaehuaea - All Rights Reserved
+//  Unauthorized copying of this file, via any medium is strictly prohibited
+//  Proprietary and confidential
+//  Written by Pat Kaehuaea, March 2015
+//
+// Package serves the runtime's debugging handlers, net/http/pprof profiles
+// and expvar variables, on a listener of their own, away from the public
+// port. Both packages register themselves on http.DefaultServeMux when
+// imported, so the servers must not serve that mux publicly. Servers add
+// their own variables with Publish().
+package debugserver
+
+import (
+	"expvar"
+	log "github.com/cihub/seelog"
+	"net"
+	"net/http"
+	"net/http/pprof"
+)
+
+// Returns handler serving /debug/pprof/ and /debug/vars.
+func Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	return mux
+}
+
+// Publishes the value returned by fn as expvar variable name, read each
+// time /debug/vars is requested.
+func Publish(name string, fn func() interface{}) {
+	expvar.Publish(name, expvar.Func(fn))
+}
+
+// Returns true if host names only this machine.
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Starts serving Handler() on addr, such as localhost:6060, in the
+// background. Listening starts before returning so a busy or invalid
+// addr is reported at startup. Warns if addr is reachable from other
+// machines, as profiles reveal the program's internals.
+func Serve(addr string) (*http.Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if host, _, err := net.SplitHostPort(addr); err != nil || !isLoopback(host) {
+		log.Warn("debugserver: Serving profiles on " + addr + ", which is reachable beyond this host.")
+	}
+	server := &http.Server{Handler: Handler()}
+	go func() {
+		if err := server.Serve(listener); err != http.ErrServerClosed {
+			log.Error(err)
+		}
+	}()
+	log.Info("debugserver: Serving pprof and expvar under /debug/ on " + listener.Addr().String())
+	return server, nil
+}
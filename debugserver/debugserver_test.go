@@ -0,0 +1,97 @@
+//  Copyright (C) Pat Kaehuaea - All Rights Reserved
+//  Unauthorized copying of this file, via any medium is strictly prohibited
+//  Proprietary and confidential
+//  Written by Pat Kaehuaea, March 2015
+
+package debugserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	Publish("test_answer", func() interface{} { return 42 })
+	h := Handler()
+	tests := []struct {
+		target string
+		status int
+	}{
+		{"/debug/pprof/", http.StatusOK},
+		{"/debug/pprof/cmdline", http.StatusOK},
+		{"/debug/pprof/symbol", http.StatusOK},
+		{"/debug/pprof/goroutine?debug=1", http.StatusOK},
+		{"/debug/vars", http.StatusOK},
+		{"/", http.StatusNotFound},
+		{"/debug", http.StatusNotFound},
+		{"/debug/", http.StatusNotFound},
+		{"/time", http.StatusNotFound},
+		{"/metrics", http.StatusNotFound},
+		{"/pprof/", http.StatusNotFound},
+		{"/vars", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", tt.target, nil))
+		if w.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.target, w.Code, tt.status)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/debug/vars", nil))
+	var vars map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &vars); err != nil {
+		t.Fatalf("/debug/vars: %v", err)
+	}
+	if vars["test_answer"] != 42.0 {
+		t.Errorf("/debug/vars: test_answer = %v, want 42", vars["test_answer"])
+	}
+}
+
+// Handlers registered on http.DefaultServeMux by other packages are not
+// served.
+func TestHandlerIgnoresDefaultServeMux(t *testing.T) {
+	http.HandleFunc("/debug/default", func(w http.ResponseWriter, r *http.Request) {})
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, httptest.NewRequest("GET", "/debug/default", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestIsLoopback(t *testing.T) {
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{"localhost", true},
+		{"127.0.0.1", true},
+		{"127.1.2.3", true},
+		{"::1", true},
+		{"", false},
+		{"0.0.0.0", false},
+		{"::", false},
+		{"192.0.2.1", false},
+		{"example.com", false},
+	}
+	for _, tt := range tests {
+		if got := isLoopback(tt.host); got != tt.want {
+			t.Errorf("isLoopback(%q) = %v, want %v", tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestServe(t *testing.T) {
+	server, err := Serve("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Close()
+
+	if _, err := Serve("127.0.0.1:notaport"); err == nil {
+		t.Error("Serve() of an invalid address succeeded")
+	}
+}
@@ -0,0 +1,105 @@
+//  Copyright (C) Pat Kaehuaea - All Rights Reserved
+//  Unauthorized copying of this file, via any medium is strictly prohibited
+//  Proprietary and confidential
+//  Written by Pat Kaehuaea, March 2015
+//
+// Live activity feed at /events. Each client is sent a tick with the
+// current time once per --stream-interval and a login or logout event as
+// users come and go, as server-sent events carrying JSON. Names of the
+// users are only sent to clients presenting --admin-token, as for /admin,
+// which shows the feed.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/cihub/seelog"
+	"github.com/patkaehuaea/command/config"
+	"github.com/patkaehuaea/command/timeserver/events"
+	"net/http"
+	"time"
+)
+
+// Login and logout events published to /events clients.
+var activity = events.NewBus()
+
+// Publishes an event of kind for the user called name, unless no one is
+// listening.
+func publishActivity(kind string, name string) {
+	if activity.Len() == 0 {
+		return
+	}
+	activity.Publish(events.Event{Type: kind, Time: now(), Name: name})
+}
+
+// Writes e as a server-sent event and flushes it to the client.
+func writeEvent(w http.ResponseWriter, e events.Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err = fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+		return err
+	}
+	w.(http.Flusher).Flush()
+	return nil
+}
+
+// Streams ticks and activity to the client until it disconnects or the
+// server shuts down. A client too slow to keep up misses events and is
+// sent a dropped event counting them before the next one it receives.
+func handleEvents(w http.ResponseWriter, r *http.Request) {
+	if _, ok := w.(http.Flusher); !ok {
+		log.Error("timeserver: Response writer does not support flushing.")
+		w.WriteHeader(http.StatusInternalServerError)
+		renderTemplate(w, r, "500", nil)
+		return
+	}
+	admin := isAdmin(r)
+
+	quit, leave, ok := streams.Join()
+	if !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		renderTemplate(w, r, "503", nil)
+		return
+	}
+	defer leave()
+	sub := activity.Subscribe(events.BUFFER)
+	defer activity.Unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	ticker := time.NewTicker(*config.StreamIntvl)
+	defer ticker.Stop()
+
+	e := events.Event{Type: events.TICK, Time: now()}
+	for {
+		if err := writeEvent(w, e); err != nil {
+			log.Debug(err)
+			return
+		}
+
+		select {
+		case <-r.Context().Done():
+			log.Debug("timeserver: Events client disconnected.")
+			return
+		case <-quit:
+			return
+		case <-ticker.C:
+			e = events.Event{Type: events.TICK, Time: now()}
+		case e = <-sub.C:
+			if dropped := sub.Dropped(); dropped > 0 {
+				if err := writeEvent(w, events.Event{Type: events.DROPPED, Time: now(), Dropped: dropped}); err != nil {
+					log.Debug(err)
+					return
+				}
+			}
+			if !admin {
+				e.Name = ""
+			}
+		}
+	}
+}
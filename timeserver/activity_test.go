@@ -0,0 +1,82 @@
+//  Copyright (C) Pat Kaehuaea - All Rights Reserved
+//  Unauthorized copying of this file, via any medium is strictly prohibited
+//  Proprietary and confidential
+//  Written by Pat Kaehuaea, March 2015
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/patkaehuaea/command/config"
+	"github.com/patkaehuaea/command/timeserver/events"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Returns the next event on the stream read by scanner.
+func nextEvent(t *testing.T, scanner *bufio.Scanner) (e events.Event) {
+	for scanner.Scan() {
+		if data, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+			if err := json.Unmarshal([]byte(data), &e); err != nil {
+				t.Fatal(err)
+			}
+			return
+		}
+	}
+	t.Fatalf("stream ended - %v", scanner.Err())
+	return
+}
+
+func TestEventsRedactNames(t *testing.T) {
+	const token = "s3cret"
+	withFixedNow(t)
+	override(t, config.AdminToken, token)
+	// No ticks but the first, so only published events follow it.
+	override(t, config.StreamIntvl, time.Hour)
+
+	tests := []struct {
+		name          string
+		authorization string
+		want          string
+	}{
+		{"anonymous", "", ""},
+		{"wrong token", "Bearer guess", ""},
+		{"admin", "Bearer " + token, "Ada"},
+	}
+	server := httptest.NewServer(http.HandlerFunc(handleEvents))
+	defer server.Close()
+	for _, tt := range tests {
+		req, _ := http.NewRequest("GET", server.URL, nil)
+		if tt.authorization != "" {
+			req.Header.Set("Authorization", tt.authorization)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+			t.Errorf("%s: Content-Type = %q, want text/event-stream", tt.name, ct)
+		}
+		scanner := bufio.NewScanner(resp.Body)
+		if e := nextEvent(t, scanner); e.Type != events.TICK || !e.Time.Equal(FIXED_NOW) {
+			t.Errorf("%s: first event %+v, want a tick at %v", tt.name, e, FIXED_NOW)
+		}
+
+		// Subscribed before the first tick was written.
+		publishActivity(events.LOGIN, "Ada")
+		publishActivity(events.LOGOUT, "Ada")
+		for _, kind := range []string{events.LOGIN, events.LOGOUT} {
+			if e := nextEvent(t, scanner); e.Type != kind || e.Name != tt.want {
+				t.Errorf("%s: event %+v, want %s of %q", tt.name, e, kind, tt.want)
+			}
+		}
+		resp.Body.Close()
+		for deadline := time.Now().Add(time.Second); activity.Len() > 0 && time.Now().Before(deadline); {
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
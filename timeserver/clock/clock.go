@@ -0,0 +1,164 @@
+//  Copyright (C) Pat Kaehuaea - All Rights Reserved
+//  Unauthorized copying of this file, via any medium is strictly prohibited
+//  Proprietary and confidential
+//  Written by Pat Kaehuaea, March 2015
+//
+// Package provides sources of the current time other than the local
+// clock. A Source reports the time according to some authority, such as
+// an upstream timeserver, and Cached turns a Source into a clock that is
+// cheap to read on every request. Trusted goes further and ignores the
+// host's wall clock, advancing the last reading by monotonic time.
+package clock
+
+import (
+	"encoding/json"
+	"errors"
+	log "github.com/cihub/seelog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const UPSTREAM_PATH = "/time.json"
+
+// Reports the current time according to some authority.
+type Source interface {
+	Now() (time.Time, error)
+}
+
+// Document served at UPSTREAM_PATH by every timeserver.
+type Document struct {
+	Time string `json:"time"`
+}
+
+// Source backed by the UPSTREAM_PATH endpoint of another timeserver.
+type Upstream struct {
+	url    string
+	client *http.Client
+}
+
+// Returns Upstream for timeserver at base, e.g. http://host:8080.
+// Requests are abandoned after timeout.
+func NewUpstream(base string, timeout time.Duration) *Upstream {
+	return &Upstream{url: strings.TrimSuffix(base, "/") + UPSTREAM_PATH, client: &http.Client{Timeout: timeout}}
+}
+
+// Fetches the upstream time. Half the round trip is added to the
+// reported time to account for the response's time in flight.
+func (u *Upstream) Now() (t time.Time, err error) {
+	var resp *http.Response
+	start := time.Now()
+	if resp, err = u.client.Get(u.url); err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		err = errors.New("clock: Unexpected upstream status - " + resp.Status)
+		return
+	}
+
+	var doc Document
+	if err = json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return
+	}
+	if t, err = time.Parse(time.RFC3339Nano, doc.Time); err != nil {
+		return
+	}
+	t = t.Add(time.Since(start) / 2)
+	return
+}
+
+// Clock reading src at most once per ttl. Between reads the offset from
+// src is applied to the local clock. When src fails the local clock is
+// used unmodified until the next read is due.
+type Cached struct {
+	sync.Mutex
+	src     Source
+	ttl     time.Duration
+	offset  time.Duration
+	fetched time.Time
+}
+
+func NewCached(src Source, ttl time.Duration) *Cached {
+	return &Cached{src: src, ttl: ttl}
+}
+
+// Returns current time according to src. Signature matches time.Now.
+func (c *Cached) Now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+
+	local := time.Now()
+	if c.fetched.IsZero() || local.Sub(c.fetched) >= c.ttl {
+		c.fetched = local
+		if t, err := c.src.Now(); err != nil {
+			log.Warn("clock: Falling back to local clock - " + err.Error())
+			c.offset = 0
+		} else {
+			c.offset = t.Sub(time.Now())
+		}
+	}
+	return time.Now().Add(c.offset)
+}
+
+// Clock synchronized against src and advanced by the monotonic clock in
+// between, so jumps of the host's wall clock never reach it. The time is
+// base plus the monotonic time elapsed since base was read from src.
+type Trusted struct {
+	sync.RWMutex
+	src  Source
+	base time.Time
+	mono time.Time
+}
+
+// Returns Trusted synchronized once against src. If src fails the local
+// clock is used as base until a later Sync() succeeds, and the error is
+// returned alongside the usable clock.
+func NewTrusted(src Source) (*Trusted, error) {
+	t := &Trusted{src: src, base: time.Now().Round(0), mono: time.Now()}
+	return t, t.Sync()
+}
+
+// Reads src and resets base. Clock is unchanged on error.
+func (t *Trusted) Sync() error {
+	now, err := t.src.Now()
+	if err != nil {
+		return err
+	}
+	mono := time.Now()
+	t.Lock()
+	t.base = now.Round(0)
+	t.mono = mono
+	t.Unlock()
+	return nil
+}
+
+// Calls Sync() every interval, logging failures. Intended to be run as
+// go routine.
+func (t *Trusted) Refresh(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := t.Sync(); err != nil {
+			log.Warn("clock: Keeping previous trusted time - " + err.Error())
+		}
+	}
+}
+
+// Returns clock reporting now shifted by offset, e.g. to test clients
+// against a server that is known to be ahead or behind.
+func WithOffset(now func() time.Time, offset time.Duration) func() time.Time {
+	return func() time.Time {
+		return now().Add(offset)
+	}
+}
+
+// Returns base advanced by monotonic time elapsed since it was read.
+// Signature matches time.Now.
+func (t *Trusted) Now() time.Time {
+	t.RLock()
+	defer t.RUnlock()
+	return t.base.Add(time.Since(t.mono))
+}
@@ -0,0 +1,162 @@
+//  Copyright (C) Pat Kaehuaea - All Rights Reserved
+//  Unauthorized copying of this file, via any medium is strictly prohibited
+//  Proprietary and confidential
+//  Written by Pat Kaehuaea, March 2015
+
+package clock
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Largest difference between readings tolerated for test round trips.
+const SLACK = time.Second
+
+// Returns a stand in upstream timeserver answering with the local time
+// shifted by ahead, or with status if it is not 200, and a count of the
+// requests it has served.
+func upstream(t *testing.T, ahead time.Duration, status int) (*httptest.Server, *atomic.Int64) {
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.URL.Path != UPSTREAM_PATH || status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+		json.NewEncoder(w).Encode(Document{Time: time.Now().Add(ahead).Format(time.RFC3339Nano)})
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func near(got time.Time, want time.Time) bool {
+	d := got.Sub(want)
+	return d > -SLACK && d < SLACK
+}
+
+func TestCachedUpstream(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		offset time.Duration
+	}{
+		{"relayed", http.StatusOK, time.Hour},
+		{"failing upstream", http.StatusInternalServerError, 0},
+	}
+	for _, tt := range tests {
+		server, requests := upstream(t, time.Hour, tt.status)
+		c := NewCached(NewUpstream(server.URL+"/", time.Second), time.Minute)
+		for i := 0; i < 3; i++ {
+			if got := c.Now(); !near(got, time.Now().Add(tt.offset)) {
+				t.Errorf("%s: read %d = %s, want %s from now", tt.name, i, got, tt.offset)
+			}
+		}
+		if n := requests.Load(); n != 1 {
+			t.Errorf("%s: %d upstream requests within the ttl, want 1", tt.name, n)
+		}
+	}
+}
+
+func TestUpstreamErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+	}{
+		{"server error", http.StatusInternalServerError},
+		{"not found", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		server, _ := upstream(t, 0, tt.status)
+		if _, err := NewUpstream(server.URL, time.Second).Now(); err == nil {
+			t.Errorf("%s: Now() succeeded", tt.name)
+		}
+	}
+}
+
+// Source reporting a fixed time, or err if set.
+type fixedSource struct {
+	at  time.Time
+	err error
+}
+
+func (f *fixedSource) Now() (time.Time, error) {
+	return f.at, f.err
+}
+
+func TestTrusted(t *testing.T) {
+	trusted := time.Date(2015, time.March, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		err  error
+		base time.Time
+	}{
+		{"synchronized", nil, trusted},
+		{"source failing", errors.New("unreachable"), time.Now()},
+	}
+	for _, tt := range tests {
+		start := time.Now()
+		c, err := NewTrusted(&fixedSource{trusted, tt.err})
+		if err != tt.err {
+			t.Errorf("%s: NewTrusted() error %v, want %v", tt.name, err, tt.err)
+		}
+		time.Sleep(10 * time.Millisecond)
+		got := c.Now()
+		elapsed := time.Since(start)
+		if offset := got.Sub(tt.base); offset < 10*time.Millisecond || offset > elapsed+SLACK {
+			t.Errorf("%s: Now() = %s, %s after base, want about %s", tt.name, got, offset, elapsed)
+		}
+	}
+}
+
+// A failed Sync() keeps the previous base, and the wall clock has no
+// say in either case.
+func TestTrustedSync(t *testing.T) {
+	src := &fixedSource{at: time.Date(2015, time.March, 1, 12, 0, 0, 0, time.UTC)}
+	c, err := NewTrusted(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		at   time.Time
+		err  error
+		want time.Time
+	}{
+		{"moved forward", src.at.Add(time.Hour), nil, src.at.Add(time.Hour)},
+		{"failing", src.at.Add(48 * time.Hour), errors.New("unreachable"), src.at.Add(time.Hour)},
+		{"moved back", src.at.Add(-time.Hour), nil, src.at.Add(-time.Hour)},
+	}
+	for _, tt := range tests {
+		src.at, src.err = tt.at, tt.err
+		if err := c.Sync(); err != tt.err {
+			t.Errorf("%s: Sync() error %v, want %v", tt.name, err, tt.err)
+		}
+		if got := c.Now(); !near(got, tt.want) {
+			t.Errorf("%s: Now() = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestWithOffset(t *testing.T) {
+	base := time.Date(2015, time.March, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		offset time.Duration
+		want   time.Time
+	}{
+		{0, base},
+		{90 * time.Second, base.Add(90 * time.Second)},
+		{-time.Hour, base.Add(-time.Hour)},
+	}
+	for _, tt := range tests {
+		now := WithOffset(func() time.Time { return base }, tt.offset)
+		if got := now(); !got.Equal(tt.want) {
+			t.Errorf("WithOffset(%s)() = %s, want %s", tt.offset, got, tt.want)
+		}
+	}
+}
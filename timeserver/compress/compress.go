@@ -0,0 +1,277 @@
+//  Copyright (C) Pat Kaehuaea - All Rights Reserved
+//  Unauthorized copying of this file, via any medium is strictly prohibited
+//  Proprietary and confidential
+//  Written by Pat Kaehuaea, March 2015
+//
+// Package provides middleware compressing responses with brotli or gzip,
+// whichever the Accept-Encoding header of the request prefers, brotli
+// winning ties. Only text formats such as HTML, JSON, CSS and JavaScript
+// are compressed, and only once the body reaches a minimum size, as small
+// bodies can grow when compressed. The first bytes of each body are held
+// back until that decision is made. Event streams, websocket upgrades,
+// partial content and bodies the handler encoded itself pass through
+// untouched.
+package compress
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"github.com/andybalholm/brotli"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	BROTLI = "br"
+	GZIP   = "gzip"
+	// Levels trading ratio for speed, as responses are compressed on
+	// every request.
+	BROTLI_LEVEL = 4
+	GZIP_LEVEL   = gzip.DefaultCompression
+)
+
+// Media types worth compressing. Images other than SVG and fonts are
+// compressed already.
+var compressible = map[string]bool{
+	"application/javascript": true,
+	"application/json":       true,
+	"application/xml":        true,
+	"image/svg+xml":          true,
+	"text/css":               true,
+	"text/html":              true,
+	"text/javascript":        true,
+	"text/plain":             true,
+	"text/xml":               true,
+}
+
+var (
+	gzipWriters = sync.Pool{New: func() interface{} {
+		w, _ := gzip.NewWriterLevel(nil, GZIP_LEVEL)
+		return w
+	}}
+	brotliWriters = sync.Pool{New: func() interface{} {
+		return brotli.NewWriterLevel(nil, BROTLI_LEVEL)
+	}}
+)
+
+// Returns the encoding r's Accept-Encoding prefers among those supported,
+// or empty string for none. A coding with q=0 is refused, and the wildcard
+// stands for any coding not listed.
+func Negotiate(r *http.Request) string {
+	q := make(map[string]float64)
+	for _, header := range r.Header["Accept-Encoding"] {
+		for _, part := range strings.Split(header, ",") {
+			fields := strings.Split(strings.TrimSpace(part), ";")
+			coding := strings.ToLower(strings.TrimSpace(fields[0]))
+			weight := 1.0
+			for _, param := range fields[1:] {
+				if v := strings.TrimSpace(param); strings.HasPrefix(v, "q=") {
+					if f, err := strconv.ParseFloat(v[2:], 64); err == nil {
+						weight = f
+					}
+				}
+			}
+			if coding != "" {
+				q[coding] = weight
+			}
+		}
+	}
+
+	best, bestQ := "", 0.0
+	for _, coding := range []string{BROTLI, GZIP} {
+		weight, listed := q[coding]
+		if !listed {
+			weight, listed = q["*"]
+		}
+		if listed && weight > bestQ {
+			best, bestQ = coding, weight
+		}
+	}
+	return best
+}
+
+// Returns middleware compressing eligible responses of minSize bytes or
+// more.
+func Handler(minSize int) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			encoding := Negotiate(r)
+			// Upgraded connections are hijacked and need the
+			// underlying writer.
+			if encoding == "" || r.Header.Get("Upgrade") != "" || r.Method == http.MethodHead {
+				h.ServeHTTP(w, r)
+				return
+			}
+			cw := &writer{ResponseWriter: w, encoding: encoding, minSize: minSize}
+			defer cw.close()
+			h.ServeHTTP(cw, r)
+		})
+	}
+}
+
+// Response writer holding back the start of the body until it can decide
+// whether to compress. Once decided, writes go to the encoder when
+// compressing and straight through otherwise.
+type writer struct {
+	http.ResponseWriter
+	encoding string
+	minSize  int
+	status   int
+	buf      bytes.Buffer
+	decided  bool
+	encoder  io.WriteCloser
+}
+
+// Records status, sent once the compression decision is made.
+func (cw *writer) WriteHeader(status int) {
+	if cw.status == 0 {
+		cw.status = status
+	}
+}
+
+func (cw *writer) Write(b []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	if !cw.decided {
+		// Sniffed as the server would on the first write, so the type
+		// is known before deciding.
+		if cw.buf.Len() == 0 && len(b) > 0 && cw.Header().Get("Content-Type") == "" {
+			cw.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		if cw.buf.Len()+len(b) < cw.minSize && cw.eligible() {
+			return cw.buf.Write(b)
+		}
+		cw.decide(true)
+		if err := cw.drain(); err != nil {
+			return 0, err
+		}
+	}
+	if cw.encoder != nil {
+		return cw.encoder.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// Returns true if the response may be compressed, judging by the
+// headers set so far.
+func (cw *writer) eligible() bool {
+	header := cw.Header()
+	if cw.status < http.StatusOK || cw.status == http.StatusNoContent || cw.status == http.StatusNotModified ||
+		cw.status == http.StatusPartialContent {
+		return false
+	}
+	if header.Get("Content-Encoding") != "" || header.Get("Content-Range") != "" {
+		return false
+	}
+	mt, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	return err == nil && compressible[mt]
+}
+
+// Sends the headers, compressing if big is true and the response is
+// eligible. Compressible responses vary by Accept-Encoding whether or
+// not this one is compressed.
+func (cw *writer) decide(big bool) {
+	cw.decided = true
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	header := cw.Header()
+	if cw.eligible() {
+		header.Add("Vary", "Accept-Encoding")
+		if big {
+			header.Set("Content-Encoding", cw.encoding)
+			header.Del("Content-Length")
+			// The compressed body is a different representation, but
+			// one equivalent to the original.
+			if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+				header.Set("ETag", "W/"+etag)
+			}
+			cw.encoder = cw.newEncoder()
+		}
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+}
+
+func (cw *writer) newEncoder() io.WriteCloser {
+	switch cw.encoding {
+	case BROTLI:
+		bw := brotliWriters.Get().(*brotli.Writer)
+		bw.Reset(cw.ResponseWriter)
+		return bw
+	default:
+		gw := gzipWriters.Get().(*gzip.Writer)
+		gw.Reset(cw.ResponseWriter)
+		return gw
+	}
+}
+
+// Writes out the held back start of the body.
+func (cw *writer) drain() (err error) {
+	if cw.buf.Len() == 0 {
+		return
+	}
+	if cw.encoder != nil {
+		_, err = cw.encoder.Write(cw.buf.Bytes())
+	} else {
+		_, err = cw.ResponseWriter.Write(cw.buf.Bytes())
+	}
+	cw.buf.Reset()
+	return
+}
+
+// Sends anything held back, compressed if the body reached minSize, and
+// returns the encoder to its pool.
+func (cw *writer) close() {
+	if !cw.decided {
+		cw.decide(cw.buf.Len() >= cw.minSize)
+		cw.drain()
+	}
+	if cw.encoder == nil {
+		return
+	}
+	cw.encoder.Close()
+	switch e := cw.encoder.(type) {
+	case *brotli.Writer:
+		brotliWriters.Put(e)
+	case *gzip.Writer:
+		gzipWriters.Put(e)
+	}
+	cw.encoder = nil
+}
+
+// Flushes held back and compressed bytes to the client. A body still
+// under minSize is sent as is.
+func (cw *writer) Flush() {
+	if !cw.decided {
+		cw.decide(false)
+		cw.drain()
+	}
+	switch e := cw.encoder.(type) {
+	case *brotli.Writer:
+		e.Flush()
+	case *gzip.Writer:
+		e.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijacks the underlying connection, allowed only before anything has
+// been written.
+func (cw *writer) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := cw.ResponseWriter.(http.Hijacker)
+	if !ok || cw.decided || cw.buf.Len() != 0 {
+		return nil, nil, errors.New("compress: Connection cannot be hijacked.")
+	}
+	cw.decided = true
+	return hj.Hijack()
+}
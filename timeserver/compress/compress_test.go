@@ -0,0 +1,204 @@
+//  Copyright (C) Pat Kaehuaea - All Rights Reserved
+//  Unauthorized copying of this file, via any medium is strictly prohibited
+//  Proprietary and confidential
+//  Written by Pat Kaehuaea, March 2015
+
+package compress
+
+import (
+	"compress/gzip"
+	"github.com/andybalholm/brotli"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Smallest body compressed by the handlers under test.
+const MIN_SIZE = 256
+
+// Body long enough to be compressed.
+var BIG = strings.Repeat("The time is now. ", 64)
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		accept []string
+		want   string
+	}{
+		{nil, ""},
+		{[]string{""}, ""},
+		{[]string{"identity"}, ""},
+		{[]string{"gzip"}, GZIP},
+		{[]string{"br"}, BROTLI},
+		{[]string{"gzip, deflate, br"}, BROTLI},
+		{[]string{"GZIP"}, GZIP},
+		{[]string{"br;q=0.5, gzip;q=0.8"}, GZIP},
+		{[]string{"br;q=0.8, gzip;q=0.8"}, BROTLI},
+		{[]string{"br;q=0, gzip"}, GZIP},
+		{[]string{"br;q=0, gzip;q=0"}, ""},
+		{[]string{"br; q=0.0"}, ""},
+		{[]string{"*"}, BROTLI},
+		{[]string{"*;q=0"}, ""},
+		{[]string{"br;q=0, *"}, GZIP},
+		{[]string{"gzip;q=0.2, *;q=0.5"}, BROTLI},
+		{[]string{"gzip;q=bogus"}, GZIP},
+		{[]string{"deflate", "gzip"}, GZIP},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		for _, value := range tt.accept {
+			r.Header.Add("Accept-Encoding", value)
+		}
+		if got := Negotiate(r); got != tt.want {
+			t.Errorf("Negotiate(%q) = %q, want %q", tt.accept, got, tt.want)
+		}
+	}
+}
+
+// Returns the body of w decoded according to its Content-Encoding.
+func decode(t *testing.T, w *httptest.ResponseRecorder) string {
+	var r io.Reader = w.Body
+	switch w.Header().Get("Content-Encoding") {
+	case GZIP:
+		gr, err := gzip.NewReader(w.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = gr
+	case BROTLI:
+		r = brotli.NewReader(w.Body)
+	}
+	body, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestHandler(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		accept   string
+		upgrade  bool
+		header   map[string]string
+		status   int
+		body     string
+		encoding string
+		vary     bool
+	}{
+		{"gzip", "GET", "gzip", false, map[string]string{"Content-Type": "text/html; charset=utf-8"}, 0, BIG, GZIP, true},
+		{"brotli", "GET", "gzip, br", false, map[string]string{"Content-Type": "application/json"}, 0, BIG, BROTLI, true},
+		{"sniffed type", "GET", "gzip", false, nil, 0, BIG, GZIP, true},
+		{"not accepted", "GET", "", false, map[string]string{"Content-Type": "text/html"}, 0, BIG, "", false},
+		{"refused", "GET", "gzip;q=0", false, map[string]string{"Content-Type": "text/html"}, 0, BIG, "", false},
+		{"under minimum", "GET", "gzip", false, map[string]string{"Content-Type": "text/html"}, 0, BIG[:MIN_SIZE-1], "", true},
+		{"at minimum", "GET", "gzip", false, map[string]string{"Content-Type": "text/html"}, 0, BIG[:MIN_SIZE], GZIP, true},
+		{"empty", "GET", "gzip", false, map[string]string{"Content-Type": "text/html"}, 0, "", "", true},
+		{"head", "HEAD", "gzip", false, map[string]string{"Content-Type": "text/html"}, 0, BIG, "", false},
+		{"upgrade", "GET", "gzip", true, map[string]string{"Content-Type": "text/html"}, 0, BIG, "", false},
+		{"partial content", "GET", "gzip", false, map[string]string{"Content-Type": "text/html"}, http.StatusPartialContent, BIG, "", false},
+		{"content range", "GET", "gzip", false, map[string]string{"Content-Type": "text/html", "Content-Range": "bytes 0-99/1000"}, 0, BIG, "", false},
+		{"already encoded", "GET", "gzip", false, map[string]string{"Content-Type": "text/html", "Content-Encoding": "br"}, 0, BIG, "br", false},
+		{"image", "GET", "gzip", false, map[string]string{"Content-Type": "image/png"}, 0, BIG, "", false},
+		{"event stream", "GET", "gzip", false, map[string]string{"Content-Type": "text/event-stream"}, 0, BIG, "", false},
+		{"not found", "GET", "gzip", false, map[string]string{"Content-Type": "text/html"}, http.StatusNotFound, BIG, GZIP, true},
+	}
+	for _, tt := range tests {
+		h := Handler(MIN_SIZE)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for k, v := range tt.header {
+				w.Header().Set(k, v)
+			}
+			// Only true of the body as written, so must go if compressed.
+			w.Header().Set("Content-Length", strconv.Itoa(len(tt.body)))
+			if tt.status != 0 {
+				w.WriteHeader(tt.status)
+			}
+			// Written in two parts so the held back start is joined
+			// to the rest.
+			io.WriteString(w, tt.body[:len(tt.body)/2])
+			io.WriteString(w, tt.body[len(tt.body)/2:])
+		}))
+		r := httptest.NewRequest(tt.method, "/", nil)
+		if tt.accept != "" {
+			r.Header.Set("Accept-Encoding", tt.accept)
+		}
+		if tt.upgrade {
+			r.Header.Set("Upgrade", "websocket")
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		want := tt.status
+		if want == 0 {
+			want = http.StatusOK
+		}
+		if w.Code != want {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, want)
+		}
+		if got := w.Header().Get("Content-Encoding"); got != tt.encoding {
+			t.Errorf("%s: Content-Encoding = %q, want %q", tt.name, got, tt.encoding)
+		}
+		if vary := w.Header().Get("Vary") == "Accept-Encoding"; vary != tt.vary {
+			t.Errorf("%s: Vary = %q, want Accept-Encoding %v", tt.name, w.Header().Get("Vary"), tt.vary)
+		}
+		compressed := w.Header().Get("Content-Encoding") != "" && tt.header["Content-Encoding"] == ""
+		if compressed && w.Header().Get("Content-Length") != "" {
+			t.Errorf("%s: Content-Length of the uncompressed body kept", tt.name)
+		}
+		if compressed && w.Body.Len() >= len(tt.body) {
+			t.Errorf("%s: %d byte body sent as %d bytes", tt.name, len(tt.body), w.Body.Len())
+		}
+		if compressed {
+			if got := decode(t, w); got != tt.body {
+				t.Errorf("%s: decoded body of %d bytes differs from the %d written", tt.name, len(got), len(tt.body))
+			}
+		} else if got := w.Body.String(); got != tt.body {
+			t.Errorf("%s: body of %d bytes altered to %d", tt.name, len(tt.body), len(got))
+		}
+	}
+}
+
+func TestETag(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		etag string
+		want string
+	}{
+		{"strong", BIG, `"abc"`, `W/"abc"`},
+		{"weak", BIG, `W/"abc"`, `W/"abc"`},
+		{"uncompressed", "small", `"abc"`, `"abc"`},
+	}
+	for _, tt := range tests {
+		h := Handler(MIN_SIZE)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("ETag", tt.etag)
+			io.WriteString(w, tt.body)
+		}))
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if got := w.Header().Get("ETag"); got != tt.want {
+			t.Errorf("%s: ETag = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFlush(t *testing.T) {
+	h := Handler(MIN_SIZE)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, "tick")
+		w.(http.Flusher).Flush()
+	}))
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if !w.Flushed || w.Body.String() != "tick" || w.Header().Get("Content-Encoding") != "" {
+		t.Errorf("flushed %v %q encoded %q, want small body flushed as is", w.Flushed, w.Body.String(), w.Header().Get("Content-Encoding"))
+	}
+}
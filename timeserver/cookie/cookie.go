@@ -5,42 +5,223 @@
 //
 // Package encapsulates cookie functionality needed by personal time server.
 // Provides methods for creating a new cookie with relevant fields as well
-// as returning the value from the uuid cookie.
+// as returning the value from the uuid cookie. When secrets are configured
+// cookie values are signed with HMAC-SHA256 so they cannot be forged.
 package cookie
 
 import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	log "github.com/cihub/seelog"
 	"github.com/patkaehuaea/command/authserver/people"
+	"github.com/patkaehuaea/command/timeserver/proxy"
+	"io/ioutil"
 	"net/http"
+	"os"
+	"strings"
+	"time"
 )
 
 const (
-	COOKIE_NAME  = "uuid"
-	COOKIE_PATH  = "/"
-	MAX_AGE      = 86400
-	DELETE_AGE   = -1
-	DELETE_VALUE = "deleted"
+	COOKIE_NAME   = "uuid"
+	COOKIE_PATH   = "/"
+	MAX_AGE       = 86400
+	DELETE_AGE    = -1
+	DELETE_VALUE  = "deleted"
+	SIGNATURE_SEP = "."
+	SECRET_BYTES  = 32
 )
 
+// SameSite modes accepted by SetAttributes().
+var SAME_SITE_MODES = map[string]http.SameSite{
+	"lax":    http.SameSiteLaxMode,
+	"strict": http.SameSiteStrictMode,
+	"none":   http.SameSiteNoneMode,
+}
+
+// Attributes applied to every cookie from NewCookie(), including the
+// deleting cookie, which browsers only match when attributes agree.
+var (
+	secure   bool
+	sameSite = http.SameSiteLaxMode
+)
+
+// Max-Age in seconds of session cookies, both issued at login and
+// renewed on later visits. MAX_AGE unless SetAge() is called.
+var age = MAX_AGE
+
+// Sets the Max-Age of session cookies from ttl, rounded down to whole
+// seconds.
+func SetAge(ttl time.Duration) {
+	age = int(ttl / time.Second)
+}
+
+// Returns the Max-Age of session cookies.
+func Age() int {
+	return age
+}
+
+// HMAC keys, newest first. Empty, leaving cookies unsigned and
+// unverified, unless SetSecrets() is called.
+var keys [][]byte
+
+// Sets keys used to sign and verify cookie values. New cookies are
+// signed with the first secret; cookies signed with any of them verify
+// so a key can be rotated without logging everyone out.
+func SetSecrets(secrets []string) {
+	keys = nil
+	for _, secret := range secrets {
+		keys = append(keys, []byte(secret))
+	}
+}
+
+// Sets the Secure and SameSite attributes of new cookies. Cookies are
+// always HttpOnly. Browsers reject SameSite=None without Secure.
+func SetAttributes(isSecure bool, mode http.SameSite) {
+	secure = isSecure
+	sameSite = mode
+}
+
+// Returns the secret stored in the file at path. If there is no file a
+// random SECRET_BYTES secret is generated and written there with mode,
+// so it survives restarts. Surrounding whitespace in the file is ignored.
+func LoadSecret(path string, mode os.FileMode) (secret string, err error) {
+	var data []byte
+	if data, err = ioutil.ReadFile(path); err == nil {
+		if secret = strings.TrimSpace(string(data)); secret == "" {
+			err = errors.New("cookie: Secret file " + path + " is empty.")
+		}
+		return
+	}
+	if !os.IsNotExist(err) {
+		return
+	}
+
+	b := make([]byte, SECRET_BYTES)
+	if _, err = rand.Read(b); err != nil {
+		return
+	}
+	secret = hex.EncodeToString(b)
+
+	// O_EXCL so two servers starting together don't overwrite each
+	// other's secret; the loser reads the winner's instead.
+	var f *os.File
+	if f, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode); err != nil {
+		if os.IsExist(err) {
+			return LoadSecret(path, mode)
+		}
+		return
+	}
+	if _, err = f.WriteString(secret + "\n"); err != nil {
+		f.Close()
+		return
+	}
+	err = f.Close()
+	log.Info("cookie: Generated new secret in " + path)
+	return
+}
+
+func signature(value string, key []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Returns value with its signature under the newest key appended.
+func sign(value string) string {
+	return value + SIGNATURE_SEP + signature(value, keys[0])
+}
+
+// Splits signed into value and signature and checks the signature
+// against every key. Comparison is constant time.
+func verify(signed string) (value string, ok bool) {
+	i := strings.LastIndex(signed, SIGNATURE_SEP)
+	if i < 0 {
+		return
+	}
+	value, sig := signed[:i], signed[i+len(SIGNATURE_SEP):]
+	for _, key := range keys {
+		if hmac.Equal([]byte(sig), []byte(signature(value, key))) {
+			return value, true
+		}
+	}
+	return "", false
+}
+
 // Returns address of new cookie with 'uuid' name, value set to value
 // path to '/' and age set accordingly. Should utilize MAX_AGE when
 // creating, and DELETE_AGE when intending to delete cookie with overwright.
+// Value is signed if secrets are set, except for DELETE_VALUE. Carries the
+// attributes from SetAttributes().
 func NewCookie(value string, age int) *http.Cookie {
-	c := http.Cookie{Name: COOKIE_NAME, Value: value, Path: COOKIE_PATH, MaxAge: age}
+	if len(keys) > 0 && value != DELETE_VALUE {
+		value = sign(value)
+	}
+	return NewNamedCookie(COOKIE_NAME, value, age)
+}
+
+// Returns address of new cookie called name with value, path set to '/'
+// and age set accordingly. The value is never signed. Carries the same
+// attributes from SetAttributes() as the uuid cookie.
+func NewNamedCookie(name string, value string, age int) *http.Cookie {
+	c := http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     COOKIE_PATH,
+		MaxAge:   age,
+		HttpOnly: true,
+		Secure:   secure,
+		SameSite: sameSite,
+	}
 	return &c
 }
 
+// Sets c on the response to r. c is marked Secure when r arrived over
+// HTTPS, directly or through a trusted proxy, even if SetAttributes() did
+// not ask for it.
+func Set(w http.ResponseWriter, r *http.Request, c *http.Cookie) {
+	if proxy.Scheme(r) == "https" {
+		c.Secure = true
+	}
+	http.SetCookie(w, c)
+}
+
+// Returns the value of the uuid cookie if present and a valid UUID. Any
+// error from parsing the request's cookies, including a malformed Cookie
+// header, is logged at debug level and returned with an empty uuid.
 func UUID(r *http.Request) (uuid string, err error) {
 	log.Trace("cookie: getting uuid from " + COOKIE_NAME + " cookie.")
 
 	var cookie *http.Cookie
 	if cookie, err = r.Cookie(COOKIE_NAME); err != nil {
+		log.Debug("cookie: unable to read " + COOKIE_NAME + " cookie - " + err.Error())
+		return
+	}
+
+	// Defensive check as r.Cookie() should never return a nil
+	// cookie without also returning an error.
+	if cookie == nil {
+		err = errors.New("cookie: " + COOKIE_NAME + " cookie not present")
+		log.Debug(err)
 		return
 	}
 
-	if people.IsValidUUID(cookie.Value) {
-		uuid = cookie.Value
+	value := cookie.Value
+	if len(keys) > 0 {
+		var ok bool
+		if value, ok = verify(value); !ok {
+			err = errors.New("cookie: signature not valid")
+			log.Debug(err)
+			return
+		}
+	}
+
+	if people.IsValidUUID(value) {
+		uuid = value
 		return
 	}
 
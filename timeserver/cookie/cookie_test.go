@@ -0,0 +1,85 @@
+//  Copyright (C) Pat Kaehuaea - All Rights Reserved
+//  Unauthorized copying of this file, via any medium is strictly prohibited
+//  Proprietary and confidential
+//  Written by Pat Kaehuaea, March 2015
+
+package cookie
+
+import (
+	"net/http"
+	"testing"
+)
+
+const TEST_UUID = "0f8b6a3e-6f4e-4c1a-9d2b-3e5f7a9c1b2d"
+
+func TestUUID(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   string
+		err    bool
+	}{
+		{"valid", COOKIE_NAME + "=" + TEST_UUID, TEST_UUID, false},
+		{"missing", "", "", true},
+		{"other cookie", "theme=dark", "", true},
+		{"garbage", "\x00;;==;" + COOKIE_NAME, "", true},
+		{"unquoted junk", COOKIE_NAME + "=\"" + TEST_UUID, "", true},
+		{"not a uuid", COOKIE_NAME + "=not-a-uuid", "", true},
+		{"uuid inside junk", COOKIE_NAME + "=junk-" + TEST_UUID + "-junk", "", true},
+	}
+	for _, tt := range tests {
+		r, _ := http.NewRequest("GET", "/time", nil)
+		if tt.header != "" {
+			r.Header.Set("Cookie", tt.header)
+		}
+		uuid, err := UUID(r)
+		if uuid != tt.want || (err != nil) != tt.err {
+			t.Errorf("%s: UUID() = %q, %v, want %q, error %v", tt.name, uuid, err, tt.want, tt.err)
+		}
+	}
+}
+
+// Returns a request carrying the uuid cookie signed with secret.
+func signedRequest(secret string) *http.Request {
+	SetSecrets([]string{secret})
+	r, _ := http.NewRequest("GET", "/time", nil)
+	r.AddCookie(NewCookie(TEST_UUID, MAX_AGE))
+	return r
+}
+
+func TestSecretRotation(t *testing.T) {
+	defer SetSecrets(nil)
+	tests := []struct {
+		name   string
+		signer string
+		valid  bool
+	}{
+		{"newest key", "new-secret", true},
+		{"previous key", "old-secret", true},
+		{"retired key", "ancient-secret", false},
+	}
+	for _, tt := range tests {
+		r := signedRequest(tt.signer)
+		SetSecrets([]string{"new-secret", "old-secret"})
+		uuid, err := UUID(r)
+		if (err == nil) != tt.valid || (tt.valid && uuid != TEST_UUID) {
+			t.Errorf("%s: UUID() = %q, %v, want valid %v", tt.name, uuid, err, tt.valid)
+		}
+	}
+
+	SetSecrets([]string{"new-secret", "old-secret"})
+	value := NewCookie(TEST_UUID, MAX_AGE).Value
+	if value != TEST_UUID+SIGNATURE_SEP+signature(TEST_UUID, []byte("new-secret")) {
+		t.Errorf("new cookie %q not signed with the newest key", value)
+	}
+}
+
+func TestUnsignedCookieRejected(t *testing.T) {
+	defer SetSecrets(nil)
+	SetSecrets([]string{"secret"})
+	r, _ := http.NewRequest("GET", "/time", nil)
+	r.Header.Set("Cookie", COOKIE_NAME+"="+TEST_UUID)
+	if uuid, err := UUID(r); err == nil {
+		t.Errorf("UUID() = %q for an unsigned cookie, want error", uuid)
+	}
+}
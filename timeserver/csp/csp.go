@@ -0,0 +1,75 @@
+//  Copyright (C) Pat Kaehuaea - All Rights Reserved
+//  Unauthorized copying of this file, via any medium is strictly prohibited
+//  Proprietary and confidential
+//  Written by Pat Kaehuaea, March 2015
+//
+// Package provides middleware that sets a Content-Security-Policy header
+// carrying a fresh nonce for every request. Inline scripts and styles only
+// run if they carry the request's nonce, so templates can use inline code
+// without falling back to 'unsafe-inline'.
+package csp
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	log "github.com/cihub/seelog"
+	"net/http"
+	"strings"
+)
+
+const (
+	HEADER_NAME = "Content-Security-Policy"
+	NONCE_BYTES = 16
+)
+
+type contextKey struct{}
+
+// Returns NONCE_BYTES of random data base64 encoded.
+func newNonce() (nonce string, err error) {
+	b := make([]byte, NONCE_BYTES)
+	if _, err = rand.Read(b); err != nil {
+		return
+	}
+	nonce = base64.StdEncoding.EncodeToString(b)
+	return
+}
+
+// Returns the policy allowing same origin resources and inline scripts
+// and styles carrying nonce. An empty nonce allows no inline code.
+func policy(nonce string) string {
+	source := "'self'"
+	if nonce != "" {
+		source += " 'nonce-" + nonce + "'"
+	}
+	return strings.Join([]string{
+		"default-src 'self'",
+		"script-src " + source,
+		"style-src " + source,
+		"object-src 'none'",
+		"base-uri 'self'",
+		"frame-ancestors 'none'",
+	}, "; ")
+}
+
+// Returns the nonce assigned by Handler, or empty string if r did not
+// pass through Handler.
+func Nonce(r *http.Request) string {
+	nonce, _ := r.Context().Value(contextKey{}).(string)
+	return nonce
+}
+
+// Wraps h so each request carries a nonce in its context and the response
+// sets a policy allowing only inline code with that nonce. If no nonce can
+// be generated the policy is sent without one, blocking all inline code.
+func Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nonce, err := newNonce()
+		if err != nil {
+			log.Error(err)
+		}
+
+		w.Header().Set(HEADER_NAME, policy(nonce))
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, nonce)))
+	})
+}
@@ -0,0 +1,54 @@
+//  Copyright (C) Pat Kaehuaea - All Rights Reserved
+//  Unauthorized copying of this file, via any medium is strictly prohibited
+//  Proprietary and confidential
+//  Written by Pat Kaehuaea, March 2015
+
+package csp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		var nonce string
+		h := Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			nonce = Nonce(r)
+		}))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+		if nonce == "" || seen[nonce] {
+			t.Errorf("request %d: nonce %q not fresh", i, nonce)
+		}
+		seen[nonce] = true
+		header := w.Header().Get(HEADER_NAME)
+		for _, directive := range []string{"script-src", "style-src"} {
+			if !strings.Contains(header, directive+" 'self' 'nonce-"+nonce+"'") {
+				t.Errorf("request %d: %s %q does not allow nonce for %s", i, HEADER_NAME, header, directive)
+			}
+		}
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	tests := []struct {
+		nonce string
+		want  string
+	}{
+		{"", "script-src 'self';"},
+		{"abc", "script-src 'self' 'nonce-abc';"},
+	}
+	for _, tt := range tests {
+		if got := policy(tt.nonce); !strings.Contains(got, tt.want) || strings.Contains(got, "unsafe-inline") {
+			t.Errorf("policy(%q) = %q, want %q", tt.nonce, got, tt.want)
+		}
+	}
+	if nonce := Nonce(httptest.NewRequest("GET", "/", nil)); nonce != "" {
+		t.Errorf("Nonce() = %q outside Handler, want empty", nonce)
+	}
+}
@@ -0,0 +1,111 @@
+//  Copyright (C) Pat Kaehuaea - All Rights Reserved
+//  Unauthorized copying of this file, via any medium is strictly prohibited
+//  Proprietary and confidential
+//  Written by Pat Kaehuaea, March 2015
+//
+// Package provides middleware protecting forms against cross-site request
+// forgery with a double submit token. Every visitor is given a random token
+// in a cookie, and requests other than GET, HEAD, OPTIONS and TRACE must
+// send the same token back in the csrf_token form field or X-CSRF-Token
+// header. Another site can make the browser send the cookie but cannot read
+// it to fill in the field.
+package csrf
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	log "github.com/cihub/seelog"
+	"github.com/patkaehuaea/command/timeserver/cookie"
+	"net/http"
+)
+
+const (
+	COOKIE_NAME = "csrf"
+	FIELD_NAME  = "csrf_token"
+	HEADER_NAME = "X-CSRF-Token"
+	TOKEN_BYTES = 32
+)
+
+type contextKey struct{}
+
+// Methods that must not change state and so are never checked.
+var safeMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodOptions: true,
+	http.MethodTrace:   true,
+}
+
+// Answers requests whose token is missing or wrong. Replaceable so the
+// caller can render its own error page; the default answers a bare 403.
+// Token() works within it, so the page can carry a fresh form.
+var Failure http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	http.Error(w, "Forbidden", http.StatusForbidden)
+})
+
+// Returns TOKEN_BYTES of random data base64 encoded.
+func newToken() (token string, err error) {
+	b := make([]byte, TOKEN_BYTES)
+	if _, err = rand.Read(b); err != nil {
+		return
+	}
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return
+}
+
+// Returns the token from r's csrf cookie, or empty string if the cookie
+// is absent or not a token this package issued.
+func fromCookie(r *http.Request) string {
+	c, err := r.Cookie(COOKIE_NAME)
+	if err != nil {
+		return ""
+	}
+	if b, err := base64.RawURLEncoding.DecodeString(c.Value); err != nil || len(b) != TOKEN_BYTES {
+		return ""
+	}
+	return c.Value
+}
+
+// Returns the token forms rendered for r must submit, or empty string if
+// r did not pass through Handler.
+func Token(r *http.Request) string {
+	token, _ := r.Context().Value(contextKey{}).(string)
+	return token
+}
+
+// Wraps h so each request carries the visitor's token in its context,
+// issuing a token cookie to visitors without one. Unsafe requests whose
+// submitted token does not match the cookie are passed to Failure instead
+// of h. A visitor issued a token by this request has not yet been able to
+// submit it, so their unsafe request fails.
+func Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := fromCookie(r)
+		issued := token == ""
+		if issued {
+			var err error
+			if token, err = newToken(); err != nil {
+				log.Error(err)
+			} else {
+				cookie.Set(w, r, cookie.NewNamedCookie(COOKIE_NAME, token, cookie.MAX_AGE))
+			}
+		}
+
+		r = r.WithContext(context.WithValue(r.Context(), contextKey{}, token))
+		if !safeMethods[r.Method] {
+			submitted := r.Header.Get(HEADER_NAME)
+			if submitted == "" {
+				submitted = r.PostFormValue(FIELD_NAME)
+			}
+			if issued || token == "" || subtle.ConstantTimeCompare([]byte(submitted), []byte(token)) != 1 {
+				log.Warn("csrf: Rejected " + r.Method + " " + r.URL.Path + " with missing or invalid token.")
+				Failure.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
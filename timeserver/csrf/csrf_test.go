@@ -0,0 +1,132 @@
+//  Copyright (C) Pat Kaehuaea - All Rights Reserved
+//  Unauthorized copying of this file, via any medium is strictly prohibited
+//  Proprietary and confidential
+//  Written by Pat Kaehuaea, March 2015
+
+package csrf
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// Token the tests present as a visitor's cookie.
+var TEST_TOKEN = base64.RawURLEncoding.EncodeToString(make([]byte, TOKEN_BYTES))
+
+// Serves r through Handler. Returns the response, the token the wrapped
+// handler saw, and whether it was reached at all.
+func serve(r *http.Request) (w *httptest.ResponseRecorder, seen string, reached bool) {
+	w = httptest.NewRecorder()
+	Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen, reached = Token(r), true
+	})).ServeHTTP(w, r)
+	return
+}
+
+// Returns the csrf cookie set by w, or nil.
+func issuedCookie(w *httptest.ResponseRecorder) *http.Cookie {
+	for _, c := range w.Result().Cookies() {
+		if c.Name == COOKIE_NAME {
+			return c
+		}
+	}
+	return nil
+}
+
+func TestSafeMethods(t *testing.T) {
+	for _, method := range []string{"GET", "HEAD", "OPTIONS", "TRACE"} {
+		w, seen, reached := serve(httptest.NewRequest(method, "/", nil))
+		if !reached {
+			t.Errorf("%s: fresh visitor refused with %d", method, w.Code)
+			continue
+		}
+		c := issuedCookie(w)
+		if c == nil || c.Value != seen || seen == "" {
+			t.Errorf("%s: issued cookie %v, handler saw token %q", method, c, seen)
+		}
+	}
+}
+
+func TestKnownVisitorKeepsToken(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: COOKIE_NAME, Value: TEST_TOKEN})
+	w, seen, _ := serve(r)
+	if seen != TEST_TOKEN {
+		t.Errorf("Token() = %q, want the cookie's %q", seen, TEST_TOKEN)
+	}
+	if issuedCookie(w) != nil {
+		t.Error("new token issued to a visitor holding one")
+	}
+}
+
+func TestUnsafeMethods(t *testing.T) {
+	other := base64.RawURLEncoding.EncodeToString([]byte(strings.Repeat("x", TOKEN_BYTES)))
+	tests := []struct {
+		name   string
+		method string
+		cookie string
+		header string
+		field  string
+		ok     bool
+	}{
+		{"fresh visitor", "POST", "", "", "", false},
+		{"fresh visitor guessing", "POST", "", TEST_TOKEN, "", false},
+		{"no token sent", "POST", TEST_TOKEN, "", "", false},
+		{"header token", "POST", TEST_TOKEN, TEST_TOKEN, "", true},
+		{"form token", "POST", TEST_TOKEN, "", TEST_TOKEN, true},
+		{"header preferred to field", "POST", TEST_TOKEN, other, TEST_TOKEN, false},
+		{"mismatched token", "POST", TEST_TOKEN, other, "", false},
+		{"mismatched cookie", "POST", other, TEST_TOKEN, "", false},
+		{"malformed cookie", "POST", "not base64!", "not base64!", "", false},
+		{"short cookie", "POST", "c2hvcnQ", "c2hvcnQ", "", false},
+		{"put", "PUT", TEST_TOKEN, TEST_TOKEN, "", true},
+		{"delete", "DELETE", TEST_TOKEN, "", "", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, "/", strings.NewReader(url.Values{FIELD_NAME: {tt.field}}.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if tt.cookie != "" {
+			r.AddCookie(&http.Cookie{Name: COOKIE_NAME, Value: tt.cookie})
+		}
+		if tt.header != "" {
+			r.Header.Set(HEADER_NAME, tt.header)
+		}
+		w, _, reached := serve(r)
+
+		if reached != tt.ok {
+			t.Errorf("%s: reached handler %v, want %v", tt.name, reached, tt.ok)
+		}
+		want := http.StatusOK
+		if !tt.ok {
+			want = http.StatusForbidden
+		}
+		if w.Code != want {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, want)
+		}
+	}
+}
+
+func TestFailureReplaced(t *testing.T) {
+	saved := Failure
+	defer func() { Failure = saved }()
+	var failed string
+	Failure = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		failed = Token(r)
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	w, _, reached := serve(httptest.NewRequest("POST", "/", nil))
+	if reached {
+		t.Error("refused request reached the handler")
+	}
+	if w.Code != http.StatusTeapot {
+		t.Errorf("status = %d, want the replaced Failure's %d", w.Code, http.StatusTeapot)
+	}
+	if c := issuedCookie(w); c == nil || failed != c.Value {
+		t.Errorf("Failure saw token %q, want the one issued by %v", failed, c)
+	}
+}
@@ -0,0 +1,102 @@
+//  Copyright (C) Pat Kaehuaea - All Rights Reserved
+//  Unauthorized copying of this file, via any medium is strictly prohibited
+//  Proprietary and confidential
+//  Written by Pat Kaehuaea, March 2015
+//
+// Package provides an in process event bus. Handlers Publish() events,
+// such as logins, and every Subscription receives a copy on its own
+// buffered channel. Publishing never blocks: a subscriber whose buffer is
+// full misses the event, which is counted so it can be told how many it
+// missed, and one slow client never holds up the handlers or the others.
+package events
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Events buffered per subscriber before later ones are dropped.
+const BUFFER = 16
+
+// Types of event.
+const (
+	TICK    = "tick"
+	LOGIN   = "login"
+	LOGOUT  = "logout"
+	DROPPED = "dropped"
+)
+
+// Something that happened, serialized as JSON for clients. Name is the
+// user who logged in or out. Dropped is the number of events a
+// subscriber missed, sent as a DROPPED event.
+type Event struct {
+	Type    string    `json:"type"`
+	Time    time.Time `json:"time"`
+	Name    string    `json:"name,omitempty"`
+	Dropped int64     `json:"dropped,omitempty"`
+}
+
+// Receives the events published while subscribed on C.
+type Subscription struct {
+	C       <-chan Event
+	c       chan Event
+	dropped atomic.Int64
+}
+
+// Returns and resets the number of events missed since the last call.
+func (s *Subscription) Dropped() int64 {
+	return s.dropped.Swap(0)
+}
+
+// Set of subscriptions. The zero value is not usable, use NewBus().
+type Bus struct {
+	sync.RWMutex
+	subs map[*Subscription]struct{}
+}
+
+// Returns new bus without subscribers.
+func NewBus() *Bus {
+	return &Bus{subs: make(map[*Subscription]struct{})}
+}
+
+// Returns a new subscription buffering up to buffer events. Callers must
+// Unsubscribe() once done.
+func (b *Bus) Subscribe(buffer int) *Subscription {
+	c := make(chan Event, buffer)
+	s := &Subscription{C: c, c: c}
+	b.Lock()
+	b.subs[s] = struct{}{}
+	b.Unlock()
+	return s
+}
+
+// Stops delivery to s. C is left open so a reader never mistakes it for
+// a closed bus.
+func (b *Bus) Unsubscribe(s *Subscription) {
+	b.Lock()
+	delete(b.subs, s)
+	b.Unlock()
+}
+
+// Returns the number of subscriptions, so publishers can skip work no one
+// would see.
+func (b *Bus) Len() int {
+	b.RLock()
+	defer b.RUnlock()
+	return len(b.subs)
+}
+
+// Delivers e to every subscription with room for it and counts it as
+// dropped for the rest.
+func (b *Bus) Publish(e Event) {
+	b.RLock()
+	for s := range b.subs {
+		select {
+		case s.c <- e:
+		default:
+			s.dropped.Add(1)
+		}
+	}
+	b.RUnlock()
+}
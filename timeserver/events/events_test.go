@@ -0,0 +1,102 @@
+//  Copyright (C) Pat Kaehuaea - All Rights Reserved
+//  Unauthorized copying of this file, via any medium is strictly prohibited
+//  Proprietary and confidential
+//  Written by Pat Kaehuaea, March 2015
+
+package events
+
+import (
+	"testing"
+	"time"
+)
+
+// Returns the events waiting on s without blocking.
+func drain(s *Subscription) (received []Event) {
+	for {
+		select {
+		case e := <-s.C:
+			received = append(received, e)
+		default:
+			return
+		}
+	}
+}
+
+func TestPublish(t *testing.T) {
+	bus := NewBus()
+	fast := bus.Subscribe(BUFFER)
+	slow := bus.Subscribe(2)
+	defer bus.Unsubscribe(fast)
+	defer bus.Unsubscribe(slow)
+	if bus.Len() != 2 {
+		t.Errorf("Len() = %d, want 2", bus.Len())
+	}
+
+	tests := []struct {
+		name     string
+		sub      *Subscription
+		received int
+		dropped  int64
+	}{
+		{"fast", fast, 5, 0},
+		{"slow", slow, 2, 3},
+	}
+	for i := 0; i < 5; i++ {
+		bus.Publish(Event{Type: LOGIN, Name: string(rune('A' + i))})
+	}
+	for _, tt := range tests {
+		received := drain(tt.sub)
+		if len(received) != tt.received {
+			t.Errorf("%s: received %d events, want %d", tt.name, len(received), tt.received)
+		}
+		if len(received) > 0 && received[0].Name != "A" {
+			t.Errorf("%s: first event %+v, want the first published", tt.name, received[0])
+		}
+		if dropped := tt.sub.Dropped(); dropped != tt.dropped {
+			t.Errorf("%s: Dropped() = %d, want %d", tt.name, dropped, tt.dropped)
+		}
+		if dropped := tt.sub.Dropped(); dropped != 0 {
+			t.Errorf("%s: Dropped() = %d after reading, want 0", tt.name, dropped)
+		}
+	}
+}
+
+func TestPublishNeverBlocks(t *testing.T) {
+	bus := NewBus()
+	full := bus.Subscribe(0)
+	defer bus.Unsubscribe(full)
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 100; i++ {
+			bus.Publish(Event{Type: TICK})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Publish() blocked on a subscriber without room")
+	}
+	if dropped := full.Dropped(); dropped != 100 {
+		t.Errorf("Dropped() = %d, want 100", dropped)
+	}
+}
+
+func TestUnsubscribe(t *testing.T) {
+	bus := NewBus()
+	s := bus.Subscribe(BUFFER)
+	bus.Unsubscribe(s)
+	bus.Publish(Event{Type: LOGOUT})
+	if bus.Len() != 0 {
+		t.Errorf("Len() = %d after Unsubscribe(), want 0", bus.Len())
+	}
+	if received := drain(s); len(received) != 0 || s.Dropped() != 0 {
+		t.Errorf("unsubscribed received %v", received)
+	}
+	select {
+	case _, ok := <-s.C:
+		t.Errorf("C readable after Unsubscribe(), open %v", ok)
+	default:
+	}
+}
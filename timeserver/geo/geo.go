@@ -0,0 +1,32 @@
+//  Copyright (C) Pat Kaehuaea - All Rights Reserved
+//  Unauthorized copying of this file, via any medium is strictly prohibited
+//  Proprietary and confidential
+//  Written by Pat Kaehuaea, March 2015
+//
+// Package defines how the time server infers a client's time zone from
+// its IP address for /time?auto=1. Lookups are delegated to a
+// TimezoneResolver so a GeoIP database can be plugged in; the default
+// resolver knows nothing about addresses and answers UTC.
+package geo
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// Returned by resolvers when no time zone is known for an address.
+var ErrUnknownLocation = errors.New("geo: No time zone known for address.")
+
+// Maps a client address to the time zone it is likely in. Implementations
+// must be safe for concurrent use.
+type TimezoneResolver interface {
+	Timezone(ip net.IP) (*time.Location, error)
+}
+
+// Resolver that answers UTC for every address.
+type UTC struct{}
+
+func (UTC) Timezone(ip net.IP) (*time.Location, error) {
+	return time.UTC, nil
+}
@@ -0,0 +1,93 @@
+//  Copyright (C) Pat Kaehuaea - All Rights Reserved
+//  Unauthorized copying of this file, via any medium is strictly prohibited
+//  Proprietary and confidential
+//  Written by Pat Kaehuaea, March 2015
+//
+// Package keeps track of long lived clients, such as time streams and
+// websockets, so they can be counted and told to disconnect together when
+// the server shuts down. Hijacked connections are invisible to
+// http.Server.Shutdown, so without a hub they would only end when the
+// process exits.
+package hub
+
+import (
+	"context"
+	"sync"
+)
+
+// Set of connected clients. The zero value is not usable, use New().
+type Hub struct {
+	sync.Mutex
+	clients map[chan struct{}]struct{}
+	closed  bool
+	active  sync.WaitGroup
+}
+
+// Returns new empty hub.
+func New() *Hub {
+	return &Hub{clients: make(map[chan struct{}]struct{})}
+}
+
+// Adds a client to the hub. The client should end once quit is closed and
+// must call leave when it ends, whatever the reason. ok is false, and the
+// client should not start, if the hub has already been closed.
+func (h *Hub) Join() (quit <-chan struct{}, leave func(), ok bool) {
+	c := make(chan struct{})
+	h.Lock()
+	defer h.Unlock()
+	if h.closed {
+		return nil, nil, false
+	}
+	h.clients[c] = struct{}{}
+	h.active.Add(1)
+
+	var once sync.Once
+	leave = func() {
+		once.Do(func() {
+			h.Lock()
+			delete(h.clients, c)
+			h.Unlock()
+			h.active.Done()
+		})
+	}
+	return c, leave, true
+}
+
+// Returns the number of clients currently connected.
+func (h *Hub) Len() int {
+	h.Lock()
+	defer h.Unlock()
+	return len(h.clients)
+}
+
+// Tells every connected client to quit and refuses later joins. Returns
+// the number of clients told. Safe to call more than once.
+func (h *Hub) Close() int {
+	h.Lock()
+	defer h.Unlock()
+	if h.closed {
+		return 0
+	}
+	h.closed = true
+	for c := range h.clients {
+		close(c)
+	}
+	return len(h.clients)
+}
+
+// Blocks until every client has left or ctx is done, returning ctx's
+// error in the latter case. Used after Close() so clients get the chance
+// to say goodbye before the process exits.
+func (h *Hub) Wait(ctx context.Context) error {
+	left := make(chan struct{})
+	go func() {
+		h.active.Wait()
+		close(left)
+	}()
+	select {
+	case <-left:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,88 @@
+//  Copyright (C) Pat Kaehuaea - All Rights Reserved
+//  Unauthorized copying of this file, via any medium is strictly prohibited
+//  Proprietary and confidential
+//  Written by Pat Kaehuaea, March 2015
+
+package hub
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// Wait comfortably longer than any client under test takes to leave.
+const PATIENCE = time.Second
+
+func TestJoinLeave(t *testing.T) {
+	h := New()
+	_, first, ok := h.Join()
+	if !ok {
+		t.Fatal("Join() refused by an open hub")
+	}
+	_, second, _ := h.Join()
+	if h.Len() != 2 {
+		t.Errorf("Len() = %d, want 2", h.Len())
+	}
+	first()
+	first()
+	if h.Len() != 1 {
+		t.Errorf("Len() = %d after one client left twice, want 1", h.Len())
+	}
+	second()
+
+	ctx, cancel := context.WithTimeout(context.Background(), PATIENCE)
+	defer cancel()
+	if err := h.Wait(ctx); err != nil {
+		t.Errorf("Wait() with every client gone = %v, want nil", err)
+	}
+}
+
+func TestCloseDelivers(t *testing.T) {
+	h := New()
+	const clients = 3
+	said := make(chan int, clients)
+	for i := 0; i < clients; i++ {
+		quit, leave, _ := h.Join()
+		go func(i int) {
+			defer leave()
+			<-quit
+			said <- i
+		}(i)
+	}
+
+	if told := h.Close(); told != clients {
+		t.Errorf("Close() told %d clients, want %d", told, clients)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), PATIENCE)
+	defer cancel()
+	if err := h.Wait(ctx); err != nil {
+		t.Fatalf("Wait() = %v, want every client gone", err)
+	}
+	if len(said) != clients {
+		t.Errorf("%d clients said goodbye before Wait() returned, want %d", len(said), clients)
+	}
+	if h.Len() != 0 {
+		t.Errorf("Len() = %d after Wait(), want 0", h.Len())
+	}
+
+	if told := h.Close(); told != 0 {
+		t.Errorf("second Close() told %d clients, want 0", told)
+	}
+	if _, _, ok := h.Join(); ok {
+		t.Error("Join() accepted by a closed hub")
+	}
+}
+
+func TestWaitGivesUp(t *testing.T) {
+	h := New()
+	_, leave, _ := h.Join()
+	defer leave()
+	h.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := h.Wait(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Wait() with a client that never leaves = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
@@ -0,0 +1,204 @@
+//  Copyright (C) Pat Kaehuaea - All Rights Reserved
+//  Unauthorized copying of this file, via any medium is strictly prohibited
+//  Proprietary and confidential
+//  Written by Pat Kaehuaea, March 2015
+//
+// Package chooses the language pages are rendered in and holds a message
+// catalog and time formatting conventions for each supported language.
+// Catalogs are the JSON files under locales, built into the binary, and
+// are keyed by the English text so a message missing from a catalog is
+// shown in English. The language is taken from the ?lang parameter, which
+// is remembered in a cookie, then from that cookie, then from the
+// Accept-Language header, and defaults to DEFAULT_LANG.
+package i18n
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"github.com/patkaehuaea/command/timeserver/cookie"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	COOKIE_NAME  = "lang"
+	DEFAULT_LANG = "en"
+	LANG_PARAM   = "lang"
+	LOCALE_DIR   = "locales"
+)
+
+//go:embed locales/*.json
+var catalogs embed.FS
+
+// Messages and conventions for one language. TimeLayout is a time.Format
+// layout for the time of day and so decides between 12 and 24 hour
+// clocks. DateLayout is text with {weekday}, {day}, {month} and {year}
+// placeholders, as Go layouts only know English names. Months start with
+// January and Days with Sunday, matching time.Month and time.Weekday.
+type Locale struct {
+	Tag        string            `json:"-"`
+	Name       string            `json:"name"`
+	TimeLayout string            `json:"time_layout"`
+	DateLayout string            `json:"date_layout"`
+	Months     []string          `json:"months"`
+	Days       []string          `json:"days"`
+	Messages   map[string]string `json:"messages"`
+}
+
+type contextKey struct{}
+
+// Supported languages by tag.
+var locales = make(map[string]*Locale)
+
+func init() {
+	files, err := catalogs.ReadDir(LOCALE_DIR)
+	if err != nil {
+		panic(err)
+	}
+	for _, f := range files {
+		data, err := catalogs.ReadFile(path.Join(LOCALE_DIR, f.Name()))
+		if err != nil {
+			panic(err)
+		}
+		l := &Locale{Tag: strings.TrimSuffix(f.Name(), path.Ext(f.Name()))}
+		if err = json.Unmarshal(data, l); err != nil {
+			panic("i18n: Malformed catalog " + f.Name() + " - " + err.Error())
+		}
+		if len(l.Months) != 12 || len(l.Days) != 7 {
+			panic("i18n: Catalog " + f.Name() + " needs 12 months and 7 days.")
+		}
+		locales[l.Tag] = l
+	}
+	if locales[DEFAULT_LANG] == nil {
+		panic("i18n: No catalog for default language " + DEFAULT_LANG + ".")
+	}
+}
+
+// Returns the locale for tag, matching case insensitively and falling
+// back from a regional tag such as es-MX to its language. ok is false if
+// the language is not supported.
+func Lookup(tag string) (l *Locale, ok bool) {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if l, ok = locales[tag]; ok {
+		return
+	}
+	if i := strings.IndexAny(tag, "-_"); i > 0 {
+		l, ok = locales[tag[:i]]
+	}
+	return
+}
+
+// Returns the locale for DEFAULT_LANG.
+func Default() *Locale {
+	return locales[DEFAULT_LANG]
+}
+
+// Returns the tags of all supported languages, sorted.
+func Tags() (tags []string) {
+	for tag := range locales {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	return
+}
+
+// Language range from an Accept-Language header and its weight.
+type weighted struct {
+	tag string
+	q   float64
+}
+
+// Returns the supported locale the Accept-Language header value prefers
+// most, or ok false if it names none of them. Ranges are tried in order of
+// q-value, ties keeping header order. The wildcard is ignored as it would
+// only ever mean the default.
+func Negotiate(header string) (l *Locale, ok bool) {
+	var ranges []weighted
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		w := weighted{tag: strings.TrimSpace(fields[0]), q: 1}
+		for _, param := range fields[1:] {
+			if v := strings.TrimSpace(param); strings.HasPrefix(v, "q=") {
+				if q, err := strconv.ParseFloat(v[2:], 64); err == nil {
+					w.q = q
+				}
+			}
+		}
+		if w.tag != "" && w.tag != "*" && w.q > 0 {
+			ranges = append(ranges, w)
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].q > ranges[j].q })
+	for _, w := range ranges {
+		if l, ok = Lookup(w.tag); ok {
+			return
+		}
+	}
+	return nil, false
+}
+
+// Returns the locale chosen for r by Handler, or the default locale if r
+// did not pass through Handler.
+func For(r *http.Request) *Locale {
+	if l, ok := r.Context().Value(contextKey{}).(*Locale); ok {
+		return l
+	}
+	return Default()
+}
+
+// Wraps h so each request carries its locale, retrieved with For(). A
+// supported ?lang is remembered in a cookie so it sticks as the visitor
+// follows links. Responses vary by Accept-Language.
+func Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Language")
+
+		l, ok := Lookup(r.URL.Query().Get(LANG_PARAM))
+		if ok {
+			cookie.Set(w, r, cookie.NewNamedCookie(COOKIE_NAME, l.Tag, cookie.MAX_AGE))
+		}
+		if !ok {
+			if c, err := r.Cookie(COOKIE_NAME); err == nil {
+				l, ok = Lookup(c.Value)
+			}
+		}
+		if !ok {
+			l, ok = Negotiate(strings.Join(r.Header["Accept-Language"], ","))
+		}
+		if !ok {
+			l = Default()
+		}
+
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, l)))
+	})
+}
+
+// Returns the translation of msg, or msg itself if the catalog has none.
+// A nil locale translates to the default language.
+func (l *Locale) T(msg string) string {
+	if l == nil {
+		l = Default()
+	}
+	if translated, ok := l.Messages[msg]; ok && translated != "" {
+		return translated
+	}
+	return msg
+}
+
+// Returns the date of t following the locale's DateLayout.
+func (l *Locale) Date(t time.Time) string {
+	if l == nil {
+		l = Default()
+	}
+	return strings.NewReplacer(
+		"{weekday}", l.Days[t.Weekday()],
+		"{day}", strconv.Itoa(t.Day()),
+		"{month}", l.Months[t.Month()-1],
+		"{year}", strconv.Itoa(t.Year()),
+	).Replace(l.DateLayout)
+}
@@ -0,0 +1,165 @@
+//  Copyright (C) Pat Kaehuaea - All Rights Reserved
+//  Unauthorized copying of this file, via any medium is strictly prohibited
+//  Proprietary and confidential
+//  Written by Pat Kaehuaea, March 2015
+
+package i18n
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLookup(t *testing.T) {
+	tests := []struct {
+		tag  string
+		want string
+		ok   bool
+	}{
+		{"en", "en", true},
+		{"DE", "de", true},
+		{" fr ", "fr", true},
+		{"es-MX", "es", true},
+		{"es_AR", "es", true},
+		{"fr-CA-x-private", "fr", true},
+		{"pt", "", false},
+		{"pt-BR", "", false},
+		{"-es", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		l, ok := Lookup(tt.tag)
+		if ok != tt.ok || (ok && l.Tag != tt.want) {
+			t.Errorf("Lookup(%q) = %v, %v, want %q, %v", tt.tag, l, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestTags(t *testing.T) {
+	if got, want := Tags(), []string{"de", "en", "es", "fr"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Tags() = %q, want %q", got, want)
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+		ok     bool
+	}{
+		{"", "", false},
+		{"de", "de", true},
+		{"de-AT, en;q=0.5", "de", true},
+		{"en;q=0.5, fr;q=0.9", "fr", true},
+		{"es;q=0.8, fr;q=0.8", "es", true},
+		{"pt-BR, pt;q=0.9, es;q=0.5", "es", true},
+		{"fr; q=0.7, de ;q=0.8", "de", true},
+		{"fr;q=0, de;q=0.1", "de", true},
+		{"fr;q=0", "", false},
+		{"fr;q=bogus, de;q=0.5", "fr", true},
+		{"*", "", false},
+		{"*, es;q=0.1", "es", true},
+		{"pt, ja", "", false},
+	}
+	for _, tt := range tests {
+		l, ok := Negotiate(tt.header)
+		if ok != tt.ok || (ok && l.Tag != tt.want) {
+			t.Errorf("Negotiate(%q) = %v, %v, want %q, %v", tt.header, l, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	tests := []struct {
+		name     string
+		target   string
+		cookie   string
+		accept   []string
+		want     string
+		remember bool
+	}{
+		{"default", "/", "", nil, DEFAULT_LANG, false},
+		{"accept", "/", "", []string{"fr-CH, fr;q=0.9"}, "fr", false},
+		{"repeated accept", "/", "", []string{"pt", "es;q=0.5"}, "es", false},
+		{"unsupported accept", "/", "", []string{"ja, pt;q=0.5"}, DEFAULT_LANG, false},
+		{"cookie over accept", "/", "de", []string{"fr"}, "de", false},
+		{"unsupported cookie", "/", "ja", []string{"fr"}, "fr", false},
+		{"param over cookie", "/?" + LANG_PARAM + "=es-MX", "de", []string{"fr"}, "es", true},
+		{"unsupported param", "/?" + LANG_PARAM + "=ja", "de", nil, "de", false},
+	}
+	for _, tt := range tests {
+		var got *Locale
+		h := Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = For(r)
+		}))
+		r := httptest.NewRequest("GET", tt.target, nil)
+		if tt.cookie != "" {
+			r.AddCookie(&http.Cookie{Name: COOKIE_NAME, Value: tt.cookie})
+		}
+		for _, value := range tt.accept {
+			r.Header.Add("Accept-Language", value)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		if got == nil || got.Tag != tt.want {
+			t.Errorf("%s: locale %v, want %q", tt.name, got, tt.want)
+		}
+		if w.Header().Get("Vary") != "Accept-Language" {
+			t.Errorf("%s: Vary = %q, want Accept-Language", tt.name, w.Header().Get("Vary"))
+		}
+		cookies := w.Result().Cookies()
+		if remembered := len(cookies) == 1 && cookies[0].Name == COOKIE_NAME && cookies[0].Value == tt.want; remembered != tt.remember {
+			t.Errorf("%s: set cookies %v, want %s remembered %v", tt.name, cookies, tt.want, tt.remember)
+		}
+	}
+}
+
+func TestForWithoutHandler(t *testing.T) {
+	if l := For(httptest.NewRequest("GET", "/", nil)); l != Default() {
+		t.Errorf("For() = %v, want the default locale", l)
+	}
+}
+
+func TestT(t *testing.T) {
+	de, _ := Lookup("de")
+	tests := []struct {
+		locale *Locale
+		msg    string
+		want   string
+	}{
+		{de, "Home", "Start"},
+		{de, "Not in any catalog", "Not in any catalog"},
+		{Default(), "Home", "Home"},
+		{nil, "Home", "Home"},
+	}
+	for _, tt := range tests {
+		if got := tt.locale.T(tt.msg); got != tt.want {
+			t.Errorf("%v.T(%q) = %q, want %q", tt.locale, tt.msg, got, tt.want)
+		}
+	}
+}
+
+func TestDate(t *testing.T) {
+	day := time.Date(2015, time.March, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		tag  string
+		want string
+	}{
+		{"en", "Sunday, March 1, 2015"},
+		{"de", "Sonntag, 1. März 2015"},
+	}
+	for _, tt := range tests {
+		l, _ := Lookup(tt.tag)
+		if got := l.Date(day); got != tt.want {
+			t.Errorf("%s: Date() = %q, want %q", tt.tag, got, tt.want)
+		}
+	}
+	var none *Locale
+	if got := none.Date(day); got != "Sunday, March 1, 2015" {
+		t.Errorf("nil locale: Date() = %q, want the default language's", got)
+	}
+}
@@ -0,0 +1,120 @@
+//  Copyright (C) Pat Kaehuaea - All Rights Reserved
+//  Unauthorized copying of this file, via any medium is strictly prohibited
+//  Proprietary and confidential
+//  Written by Pat Kaehuaea, March 2015
+//
+// Package sizes GOMAXPROCS to the CPU quota of the enclosing Linux cgroup.
+// The Go runtime sizes GOMAXPROCS to the number of CPUs on the host, which
+// inside a CPU limited container schedules more busy threads than the quota
+// allows and leads to throttling. Both cgroup v2 (cpu.max) and cgroup v1
+// (cpu.cfs_quota_us and cpu.cfs_period_us) are understood.
+package maxprocs
+
+import (
+	"errors"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+const (
+	CGROUP_ROOT   = "/sys/fs/cgroup"
+	CGROUP_V2_MAX = "cpu.max"
+	CGROUP_V1_DIR = "cpu"
+	CFS_QUOTA     = "cpu.cfs_quota_us"
+	CFS_PERIOD    = "cpu.cfs_period_us"
+	MIN_PROCS     = 1
+)
+
+// Returned when no CPU quota is configured for the process.
+var ErrNoQuota = errors.New("maxprocs: No cgroup CPU quota found.")
+
+// Returns the CPU quota of the enclosing cgroup as a number of CPUs,
+// trying cgroup v2 before cgroup v1.
+func Quota() (cpus float64, err error) {
+	if cpus, err = quotaV2(filepath.Join(CGROUP_ROOT, CGROUP_V2_MAX)); err == nil {
+		return
+	}
+	dir := filepath.Join(CGROUP_ROOT, CGROUP_V1_DIR)
+	cpus, err = quotaV1(filepath.Join(dir, CFS_QUOTA), filepath.Join(dir, CFS_PERIOD))
+	return
+}
+
+// Sets GOMAXPROCS to the cgroup CPU quota rounded down, never below
+// MIN_PROCS and never above the number of host CPUs. Returns the
+// resulting GOMAXPROCS. GOMAXPROCS is left unchanged on error.
+func Set() (procs int, err error) {
+	var cpus float64
+	if cpus, err = Quota(); err != nil {
+		return runtime.GOMAXPROCS(0), err
+	}
+	procs = int(math.Floor(cpus))
+	if procs < MIN_PROCS {
+		procs = MIN_PROCS
+	}
+	if procs > runtime.NumCPU() {
+		procs = runtime.NumCPU()
+	}
+	runtime.GOMAXPROCS(procs)
+	return
+}
+
+// Parses a cgroup v2 cpu.max file of the form "<quota> <period>", where
+// quota is "max" when unlimited.
+func quotaV2(path string) (cpus float64, err error) {
+	var fields []string
+	if fields, err = readFields(path); err != nil {
+		return
+	}
+	if len(fields) != 2 {
+		return 0, errors.New("maxprocs: Malformed " + path)
+	}
+	if fields[0] == "max" {
+		return 0, ErrNoQuota
+	}
+	return ratio(fields[0], fields[1])
+}
+
+// Parses cgroup v1 quota and period files. A quota of -1 means unlimited.
+func quotaV1(quotaPath, periodPath string) (cpus float64, err error) {
+	var quota, period []string
+	if quota, err = readFields(quotaPath); err != nil {
+		return
+	}
+	if period, err = readFields(periodPath); err != nil {
+		return
+	}
+	if len(quota) != 1 || len(period) != 1 {
+		return 0, errors.New("maxprocs: Malformed " + quotaPath)
+	}
+	if quota[0] == "-1" {
+		return 0, ErrNoQuota
+	}
+	return ratio(quota[0], period[0])
+}
+
+func ratio(quota, period string) (cpus float64, err error) {
+	var q, p float64
+	if q, err = strconv.ParseFloat(quota, 64); err != nil {
+		return
+	}
+	if p, err = strconv.ParseFloat(period, 64); err != nil {
+		return
+	}
+	if q <= 0 || p <= 0 {
+		return 0, ErrNoQuota
+	}
+	return q / p, nil
+}
+
+func readFields(path string) (fields []string, err error) {
+	var contents []byte
+	if contents, err = ioutil.ReadFile(path); err != nil {
+		return
+	}
+	fields = strings.Fields(string(contents))
+	return
+}
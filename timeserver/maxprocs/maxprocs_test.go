@@ -0,0 +1,85 @@
+//  Copyright (C) Pat Kaehuaea - All Rights Reserved
+//  Unauthorized copying of this file, via any medium is strictly prohibited
+//  Proprietary and confidential
+//  Written by Pat Kaehuaea, March 2015
+
+package maxprocs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Writes contents to name in dir and returns its path.
+func write(t *testing.T, dir string, name string, contents string) string {
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestQuotaV2(t *testing.T) {
+	tests := []struct {
+		contents string
+		want     float64
+		err      bool
+		noQuota  bool
+	}{
+		{"200000 100000\n", 2, false, false},
+		{"150000 100000", 1.5, false, false},
+		{"50000 100000\n", 0.5, false, false},
+		{"max 100000\n", 0, true, true},
+		{"0 100000\n", 0, true, true},
+		{"100000 0\n", 0, true, true},
+		{"100000\n", 0, true, false},
+		{"", 0, true, false},
+		{"lots 100000\n", 0, true, false},
+	}
+	dir := t.TempDir()
+	for _, tt := range tests {
+		cpus, err := quotaV2(write(t, dir, CGROUP_V2_MAX, tt.contents))
+		if (err != nil) != tt.err || (err == ErrNoQuota) != tt.noQuota {
+			t.Errorf("%q: error %v, want error %v, ErrNoQuota %v", tt.contents, err, tt.err, tt.noQuota)
+			continue
+		}
+		if cpus != tt.want {
+			t.Errorf("%q: %v CPUs, want %v", tt.contents, cpus, tt.want)
+		}
+	}
+	if _, err := quotaV2(filepath.Join(dir, "missing")); err == nil {
+		t.Error("missing cpu.max: no error")
+	}
+}
+
+func TestQuotaV1(t *testing.T) {
+	tests := []struct {
+		quota   string
+		period  string
+		want    float64
+		err     bool
+		noQuota bool
+	}{
+		{"400000\n", "100000\n", 4, false, false},
+		{"25000\n", "100000\n", 0.25, false, false},
+		{"-1\n", "100000\n", 0, true, true},
+		{"100000 100000\n", "100000\n", 0, true, false},
+		{"100000\n", "\n", 0, true, false},
+		{"many\n", "100000\n", 0, true, false},
+	}
+	dir := t.TempDir()
+	for _, tt := range tests {
+		cpus, err := quotaV1(write(t, dir, CFS_QUOTA, tt.quota), write(t, dir, CFS_PERIOD, tt.period))
+		if (err != nil) != tt.err || (err == ErrNoQuota) != tt.noQuota {
+			t.Errorf("%q / %q: error %v, want error %v, ErrNoQuota %v", tt.quota, tt.period, err, tt.err, tt.noQuota)
+			continue
+		}
+		if cpus != tt.want {
+			t.Errorf("%q / %q: %v CPUs, want %v", tt.quota, tt.period, cpus, tt.want)
+		}
+	}
+	if _, err := quotaV1(filepath.Join(dir, CFS_QUOTA), filepath.Join(dir, "missing")); err == nil {
+		t.Error("missing cpu.cfs_period_us: no error")
+	}
+}
@@ -0,0 +1,107 @@
+//  Copyright (C) Pat Kaehuaea - All Rights Reserved
+//  Unauthorized copying of this file, via any medium is strictly prohibited
+//  Proprietary and confidential
+//  Written by Pat Kaehuaea, March 2015
+
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Separates label values in series keys. Not valid in UTF-8 so it
+// cannot appear within a value.
+const keySeparator = "\xff"
+
+// Monotonically increasing count partitioned by zero or more labels.
+type Counter struct {
+	sync.Mutex
+	name   string
+	help   string
+	labels []string
+	series map[string]uint64
+}
+
+// Returns new counter called name, partitioned by labels.
+func NewCounter(name string, help string, labels ...string) *Counter {
+	return &Counter{name: name, help: help, labels: labels, series: make(map[string]uint64)}
+}
+
+// Adds one to the series for labelValues, given in the order the labels
+// were passed to NewCounter.
+func (c *Counter) Inc(labelValues ...string) {
+	key := strings.Join(labelValues, keySeparator)
+	c.Lock()
+	c.series[key]++
+	c.Unlock()
+}
+
+// Returns the count for each value of label, summed over the other
+// labels. Empty if label is not one of the counter's labels.
+func (c *Counter) Totals(label string) map[string]uint64 {
+	totals := make(map[string]uint64)
+	index := -1
+	for i, l := range c.labels {
+		if l == label {
+			index = i
+		}
+	}
+	if index < 0 {
+		return totals
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	for k, n := range c.series {
+		if values := strings.Split(k, keySeparator); index < len(values) {
+			totals[values[index]] += n
+		}
+	}
+	return totals
+}
+
+// Writes counter to w in the Prometheus text format. Series are ordered
+// by label values so output is stable between scrapes. A counter without
+// labels is written as zero before its first Inc().
+func (c *Counter) Write(w io.Writer) {
+	c.Lock()
+	defer c.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+
+	if len(c.labels) == 0 {
+		fmt.Fprintf(w, "%s %d\n", c.name, c.series[""])
+		return
+	}
+
+	keys := make([]string, 0, len(c.series))
+	for k := range c.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		values := strings.Split(k, keySeparator)
+		pairs := make([]string, len(c.labels))
+		for i, label := range c.labels {
+			var v string
+			if i < len(values) {
+				v = values[i]
+			}
+			pairs[i] = label + "=" + strconv.Quote(v)
+		}
+		fmt.Fprintf(w, "%s{%s} %d\n", c.name, strings.Join(pairs, ","), c.series[k])
+	}
+}
+
+// Writes a single unlabelled gauge to w in the Prometheus text format.
+// Gauges are sampled when written so they have no stored state.
+func WriteGauge(w io.Writer, name string, help string, value float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+	fmt.Fprintf(w, "%s %s\n", name, strconv.FormatFloat(value, 'g', -1, 64))
+}
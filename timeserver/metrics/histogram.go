@@ -0,0 +1,104 @@
+//  Copyright (C) Pat Kaehuaea - All Rights Reserved
+//  Unauthorized copying of this file, via any medium is strictly prohibited
+//  Proprietary and confidential
+//  Written by Pat Kaehuaea, March 2015
+//
+// Package implements the small subset of Prometheus instrumentation needed
+// by timeserver without pulling in the client library. Metrics are kept in
+// memory and written in the Prometheus text exposition format. Label values
+// are supplied by the caller, who is responsible for keeping them bounded.
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Observations for a single label value. counts[i] holds observations
+// falling in bucket i only; cumulative counts are computed on write.
+type series struct {
+	counts []uint64
+	sum    float64
+	total  uint64
+}
+
+// Distribution of observed values partitioned by a single label.
+type Histogram struct {
+	sync.Mutex
+	name    string
+	help    string
+	label   string
+	buckets []float64
+	series  map[string]*series
+}
+
+// Parses comma separated upper bounds. Bounds must be strictly
+// increasing and at least one must be given.
+func ParseBuckets(s string) (buckets []float64, err error) {
+	for _, field := range strings.Split(s, ",") {
+		var b float64
+		if b, err = strconv.ParseFloat(strings.TrimSpace(field), 64); err != nil {
+			return nil, errors.New("metrics: Invalid bucket bound - " + field)
+		}
+		if len(buckets) > 0 && b <= buckets[len(buckets)-1] {
+			return nil, errors.New("metrics: Bucket bounds must be strictly increasing.")
+		}
+		buckets = append(buckets, b)
+	}
+	return
+}
+
+// Returns new histogram called name, partitioned by label. Observations
+// larger than the last bucket are only counted in the implicit +Inf bucket.
+func NewHistogram(name string, help string, label string, buckets []float64) *Histogram {
+	return &Histogram{name: name, help: help, label: label, buckets: buckets, series: make(map[string]*series)}
+}
+
+// Records v under labelValue.
+func (h *Histogram) Observe(labelValue string, v float64) {
+	h.Lock()
+	s, ok := h.series[labelValue]
+	if !ok {
+		s = &series{counts: make([]uint64, len(h.buckets))}
+		h.series[labelValue] = s
+	}
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.sum += v
+	s.total++
+	h.Unlock()
+}
+
+// Writes histogram to w in the Prometheus text format. Series are
+// ordered by label value so output is stable between scrapes.
+func (h *Histogram) Write(w io.Writer) {
+	h.Lock()
+	defer h.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+
+	values := make([]string, 0, len(h.series))
+	for v := range h.series {
+		values = append(values, v)
+	}
+	sort.Strings(values)
+
+	for _, v := range values {
+		s := h.series[v]
+		label := h.label + "=" + strconv.Quote(v)
+		var cumulative uint64
+		for i, b := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket{%s,le=\"%s\"} %d\n", h.name, label, strconv.FormatFloat(b, 'g', -1, 64), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", h.name, label, s.total)
+		fmt.Fprintf(w, "%s_sum{%s} %s\n", h.name, label, strconv.FormatFloat(s.sum, 'g', -1, 64))
+		fmt.Fprintf(w, "%s_count{%s} %d\n", h.name, label, s.total)
+	}
+}
@@ -0,0 +1,96 @@
+//  Copyright (C) Pat Kaehuaea - All Rights Reserved
+//  Unauthorized copying of this file, via any medium is strictly prohibited
+//  Proprietary and confidential
+//  Written by Pat Kaehuaea, March 2015
+
+package middleware
+
+import (
+	"bufio"
+	"errors"
+	log "github.com/cihub/seelog"
+	"github.com/patkaehuaea/command/timeserver/requestid"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Records the status and body size written through a ResponseWriter.
+// Flush and Hijack are passed through so streaming and websocket
+// handlers keep working when wrapped.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+// Status written, or 200 if the handler wrote a body without calling
+// WriteHeader. Zero if nothing was written.
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		if w.status == 0 {
+			w.status = http.StatusOK
+		}
+		f.Flush()
+	}
+}
+
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("middleware: ResponseWriter does not support hijacking.")
+	}
+	w.status = http.StatusSwitchingProtocols
+	return h.Hijack()
+}
+
+// Lets http.ResponseController reach the wrapped writer.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Called once a request has been served with the status and body size
+// written and the time taken.
+type Observer func(r *http.Request, status int, bytes int, duration time.Duration)
+
+// Serves h and passes the outcome of each request to observe once the
+// handler returns.
+func Observe(h http.Handler, observe Observer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+		h.ServeHTTP(sw, r)
+		if sw.status == 0 {
+			// Nothing written, net/http answers 200 with no body.
+			sw.status = http.StatusOK
+		}
+		observe(r, sw.status, sw.bytes, time.Since(start))
+	})
+}
+
+// Logs one line per request at Info level once the handler returns, with
+// method, url, status, bytes written, duration, client address and request
+// id. Place after requestid.Handler so the id is known, and after
+// proxy.Handler so the client is not the proxy.
+func Logging(h http.Handler) http.Handler {
+	return Observe(h, func(r *http.Request, status int, bytes int, duration time.Duration) {
+		log.Infof("middleware: Served request - method=%s url=%q status=%d bytes=%d duration=%s remote=%s id=%s",
+			r.Method, r.URL.RequestURI(), status, bytes, duration, r.RemoteAddr, requestid.FromRequest(r))
+	})
+}
@@ -0,0 +1,85 @@
+//  Copyright (C) Pat Kaehuaea - All Rights Reserved
+//  Unauthorized copying of this file, via any medium is strictly prohibited
+//  Proprietary and confidential
+//  Written by Pat Kaehuaea, March 2015
+
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestObserve(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		status  int
+		bytes   int
+		delay   time.Duration
+	}{
+		{"nothing written", func(w http.ResponseWriter, r *http.Request) {}, http.StatusOK, 0, 0},
+		{"body only", func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, "hello")
+		}, http.StatusOK, 5, 0},
+		{"explicit status", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, "missing")
+		}, http.StatusNotFound, 7, 0},
+		{"status written twice", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.WriteHeader(http.StatusOK)
+		}, http.StatusServiceUnavailable, 0, 0},
+		{"flushed", func(w http.ResponseWriter, r *http.Request) {
+			w.(http.Flusher).Flush()
+		}, http.StatusOK, 0, 0},
+		{"slow", func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(20 * time.Millisecond)
+			w.WriteHeader(http.StatusNoContent)
+		}, http.StatusNoContent, 0, 20 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		calls := 0
+		var status, bytes int
+		var duration time.Duration
+		h := Observe(tt.handler, func(r *http.Request, s int, b int, d time.Duration) {
+			calls++
+			status, bytes, duration = s, b, d
+		})
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/time", nil))
+
+		if calls != 1 || status != tt.status || bytes != tt.bytes || duration < tt.delay {
+			t.Errorf("%s: observed %d times, status %d, %d bytes in %s, want %d, %d bytes in at least %s",
+				tt.name, calls, status, bytes, duration, tt.status, tt.bytes, tt.delay)
+		}
+		if w.Code != tt.status || w.Body.Len() != tt.bytes {
+			t.Errorf("%s: client got %d and %d bytes, want %d and %d", tt.name, w.Code, w.Body.Len(), tt.status, tt.bytes)
+		}
+	}
+}
+
+func TestObserveHijack(t *testing.T) {
+	observed := make(chan int, 1)
+	server := httptest.NewServer(Observe(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, _, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Errorf("Hijack() through wrapper - %v", err)
+			return
+		}
+		io.WriteString(conn, "HTTP/1.1 101 Switching Protocols\r\n\r\n")
+		conn.Close()
+	}), func(r *http.Request, s int, b int, d time.Duration) {
+		observed <- s
+	}))
+	defer server.Close()
+	if resp, err := http.Get(server.URL); err == nil {
+		resp.Body.Close()
+	}
+	if status := <-observed; status != http.StatusSwitchingProtocols {
+		t.Errorf("hijacked request observed with status %d, want %d", status, http.StatusSwitchingProtocols)
+	}
+}
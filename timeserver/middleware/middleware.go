@@ -0,0 +1,40 @@
+//  Copyright (C) Pat Kaehuaea - All Rights Reserved
+//  Unauthorized copying of this file, via any medium is strictly prohibited
+//  Proprietary and confidential
+//  Written by Pat Kaehuaea, March 2015
+//
+// Package makes the order of the time server's middleware explicit. A
+// Chain lists middleware outermost first and wraps a handler so a request
+// passes through them in that order, and the response in reverse.
+//
+// Recommended order, outermost first:
+//
+//	trusted proxy headers, so everything after sees the real client
+//	request ids, so everything after can log them
+//	logging, so every request is recorded, including rejected ones
+//	security headers, such as the Content-Security-Policy
+//	request filtering and rate limiting
+//	CSRF checks, after filtering so rejected probes are not issued tokens
+//	language selection, after the checks that may reject the request
+//	request timeout, so the timeout page is rendered like any other
+//	compression, nearest the handler so it sees the final body
+package middleware
+
+import (
+	"net/http"
+)
+
+// Wraps a handler, returning a handler that does its own work before
+// or after calling the one it wraps.
+type Middleware func(http.Handler) http.Handler
+
+// Middleware applied in order, the first outermost.
+type Chain []Middleware
+
+// Returns h wrapped by every middleware in c, c[0] outermost.
+func (c Chain) Then(h http.Handler) http.Handler {
+	for i := len(c) - 1; i >= 0; i-- {
+		h = c[i](h)
+	}
+	return h
+}
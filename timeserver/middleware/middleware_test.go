@@ -0,0 +1,53 @@
+//  Copyright (C) Pat Kaehuaea - All Rights Reserved
+//  Unauthorized copying of this file, via any medium is strictly prohibited
+//  Proprietary and confidential
+//  Written by Pat Kaehuaea, March 2015
+
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// Returns middleware appending name to calls on the way in and /name on
+// the way out.
+func recorder(name string, calls *[]string) Middleware {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			*calls = append(*calls, name)
+			h.ServeHTTP(w, r)
+			*calls = append(*calls, "/"+name)
+		})
+	}
+}
+
+func TestChainOrder(t *testing.T) {
+	tests := []struct {
+		names []string
+		want  []string
+	}{
+		{nil, []string{"handler"}},
+		{[]string{"proxy"}, []string{"proxy", "handler", "/proxy"}},
+		{
+			[]string{"proxy", "requestid", "logging"},
+			[]string{"proxy", "requestid", "logging", "handler", "/logging", "/requestid", "/proxy"},
+		},
+	}
+	for _, tt := range tests {
+		var calls []string
+		var chain Chain
+		for _, name := range tt.names {
+			chain = append(chain, recorder(name, &calls))
+		}
+		h := chain.Then(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls = append(calls, "handler")
+		}))
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		if !reflect.DeepEqual(calls, tt.want) {
+			t.Errorf("chain %v: calls %v, want %v", tt.names, calls, tt.want)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+//  Copyright (C) Pat Kaehuaea - All Rights Reserved
+//  Unauthorized copying of this file, via any medium is strictly prohibited
+//  Proprietary and confidential
+//  Written by Pat Kaehuaea, March 2015
+//
+// Package provides helpers for choosing a response representation from
+// the Accept header of a request. Parsing is deliberately simple: media
+// ranges are compared without regard to q-values, which is sufficient for
+// distinguishing browsers from API clients.
+package negotiate
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// Returns the media types listed in the Accept header of r, lower cased
+// and stripped of parameters. Malformed entries are skipped.
+func mediaTypes(r *http.Request) (types []string) {
+	for _, accept := range r.Header["Accept"] {
+		for _, part := range strings.Split(accept, ",") {
+			if mt, _, err := mime.ParseMediaType(strings.TrimSpace(part)); err == nil {
+				types = append(types, mt)
+			}
+		}
+	}
+	return
+}
+
+// Returns true if the client will accept an HTML response. A request
+// without an Accept header is treated as accepting anything, as are
+// the text/* and */* wildcards.
+func AcceptsHTML(r *http.Request) bool {
+	types := mediaTypes(r)
+	if len(types) == 0 {
+		return true
+	}
+	for _, mt := range types {
+		switch mt {
+		case "text/html", "application/xhtml+xml", "text/*", "*/*":
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if the client explicitly asks for JSON and does not
+// also list HTML, which browsers always do.
+func WantsJSON(r *http.Request) bool {
+	json := false
+	for _, mt := range mediaTypes(r) {
+		switch mt {
+		case "application/json":
+			json = true
+		case "text/html", "application/xhtml+xml":
+			return false
+		}
+	}
+	return json
+}
+
+// Returns true if the client explicitly asks for plain text and does not
+// also list HTML or JSON.
+func WantsText(r *http.Request) bool {
+	text := false
+	for _, mt := range mediaTypes(r) {
+		switch mt {
+		case "text/plain":
+			text = true
+		case "text/html", "application/xhtml+xml", "application/json":
+			return false
+		}
+	}
+	return text
+}
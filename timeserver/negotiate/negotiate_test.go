@@ -0,0 +1,46 @@
+//  Copyright (C) Pat Kaehuaea - All Rights Reserved
+//  Unauthorized copying of this file, via any medium is strictly prohibited
+//  Proprietary and confidential
+//  Written by Pat Kaehuaea, March 2015
+
+package negotiate
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		accept string
+		html   bool
+		json   bool
+		text   bool
+	}{
+		{"", true, false, false},
+		{"*/*", true, false, false},
+		{"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", true, false, false},
+		{"application/json", false, true, false},
+		{"Application/JSON; charset=utf-8", false, true, false},
+		{"application/json, text/html", true, false, false},
+		{"text/plain", false, false, true},
+		{"text/plain, application/json", false, true, false},
+		{"image/png", false, false, false},
+		{"not a media type;;", true, false, false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/time", nil)
+		if tt.accept != "" {
+			r.Header.Set("Accept", tt.accept)
+		}
+		if got := AcceptsHTML(r); got != tt.html {
+			t.Errorf("Accept %q: AcceptsHTML() = %v, want %v", tt.accept, got, tt.html)
+		}
+		if got := WantsJSON(r); got != tt.json {
+			t.Errorf("Accept %q: WantsJSON() = %v, want %v", tt.accept, got, tt.json)
+		}
+		if got := WantsText(r); got != tt.text {
+			t.Errorf("Accept %q: WantsText() = %v, want %v", tt.accept, got, tt.text)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+//  Copyright (C) Pat Kaehuaea - All Rights Reserved
+//  Unauthorized copying of this file, via any medium is strictly prohibited
+//  Proprietary and confidential
+//  Written by Pat Kaehuaea, March 2015
+//
+// Package implements a minimal SNTP (RFC 4330) client able to measure the
+// offset between the local clock and an NTP server. Client wraps Query()
+// with a short lived cache so callers can ask for the offset on every
+// request without hammering the server.
+package ntp
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	NTP_PORT    = "123"
+	PACKET_SIZE = 48
+	// Leap indicator 0, version 3, mode 3 (client).
+	CLIENT_HEADER = 0x1B
+	SERVER_MODE   = 4
+	// Seconds from the NTP epoch (1900) to the Unix epoch (1970).
+	EPOCH_OFFSET = 2208988800
+)
+
+// Result of a single exchange with an NTP server. Offset is positive when
+// the local clock is behind the server.
+type Response struct {
+	Time   time.Time
+	Offset time.Duration
+	RTT    time.Duration
+}
+
+// Converts 64 bit NTP timestamp in b to time.Time.
+func timestamp(b []byte) time.Time {
+	secs := int64(binary.BigEndian.Uint32(b[0:4])) - EPOCH_OFFSET
+	frac := int64(binary.BigEndian.Uint32(b[4:8]))
+	return time.Unix(secs, (frac*1e9)>>32)
+}
+
+// Sends a single SNTP request to server and computes clock offset and
+// round trip delay from the four timestamps involved. Whole exchange
+// must complete within timeout. server is queried on NTP_PORT unless it
+// names a port of its own, as in "localhost:1123".
+func Query(server string, timeout time.Duration) (resp Response, err error) {
+	addr := server
+	if _, _, splitErr := net.SplitHostPort(server); splitErr != nil {
+		addr = net.JoinHostPort(server, NTP_PORT)
+	}
+	var conn net.Conn
+	if conn, err = net.DialTimeout("udp", addr, timeout); err != nil {
+		return
+	}
+	defer conn.Close()
+
+	if err = conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return
+	}
+
+	packet := make([]byte, PACKET_SIZE)
+	packet[0] = CLIENT_HEADER
+
+	t1 := time.Now()
+	if _, err = conn.Write(packet); err != nil {
+		return
+	}
+	var n int
+	if n, err = conn.Read(packet); err != nil {
+		return
+	}
+	t4 := time.Now()
+
+	if n < PACKET_SIZE || packet[0]&0x07 != SERVER_MODE {
+		err = errors.New("ntp: Malformed response from " + server)
+		return
+	}
+	// Stratum zero is a kiss-o'-death packet telling the client to back off.
+	if packet[1] == 0 {
+		err = errors.New("ntp: Server " + server + " refused request.")
+		return
+	}
+
+	t2 := timestamp(packet[32:40])
+	t3 := timestamp(packet[40:48])
+	resp.Offset = (t2.Sub(t1) + t3.Sub(t4)) / 2
+	resp.RTT = t4.Sub(t1) - t3.Sub(t2)
+	resp.Time = t4.Add(resp.Offset)
+	return
+}
+
+// Caches the last successful Query() for ttl.
+type Client struct {
+	sync.Mutex
+	server  string
+	timeout time.Duration
+	ttl     time.Duration
+	last    Response
+	fetched time.Time
+}
+
+func NewClient(server string, timeout time.Duration, ttl time.Duration) *Client {
+	return &Client{server: server, timeout: timeout, ttl: ttl}
+}
+
+// Returns name of the server queried by c.
+func (c *Client) Server() string {
+	return c.server
+}
+
+// Returns cached response if younger than ttl, otherwise queries the
+// server. Failed queries are not cached. Lock is held for the duration
+// of the query so concurrent callers share a single request.
+func (c *Client) Query() (resp Response, err error) {
+	c.Lock()
+	defer c.Unlock()
+
+	if !c.fetched.IsZero() && time.Since(c.fetched) < c.ttl {
+		return c.last, nil
+	}
+	if resp, err = Query(c.server, c.timeout); err != nil {
+		return
+	}
+	c.last = resp
+	c.fetched = time.Now()
+	return
+}
+
+// Returns the current time according to the server by applying the
+// measured offset to the local clock. Satisfies clock.Source.
+func (c *Client) Now() (t time.Time, err error) {
+	var resp Response
+	if resp, err = c.Query(); err != nil {
+		return
+	}
+	t = time.Now().Add(resp.Offset)
+	return
+}
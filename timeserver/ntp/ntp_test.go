@@ -0,0 +1,135 @@
+//  Copyright (C) Pat Kaehuaea - All Rights Reserved
+//  Unauthorized copying of this file, via any medium is strictly prohibited
+//  Proprietary and confidential
+//  Written by Pat Kaehuaea, March 2015
+
+package ntp
+
+import (
+	"encoding/binary"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Largest error tolerated in offsets measured over loopback.
+const SLACK = 100 * time.Millisecond
+
+// Writes t to b as a 64 bit NTP timestamp.
+func putTimestamp(b []byte, t time.Time) {
+	binary.BigEndian.PutUint32(b[0:4], uint32(t.Unix()+EPOCH_OFFSET))
+	binary.BigEndian.PutUint32(b[4:8], uint32((int64(t.Nanosecond())<<32)/1e9))
+}
+
+// Starts a stand in NTP server answering with its clock ahead by ahead.
+// reply may rewrite each answer before it is sent; returning false drops
+// it. Returns the server's address and a count of requests received.
+func server(t *testing.T, ahead time.Duration, reply func(packet []byte) bool) (string, *atomic.Int64) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	var requests atomic.Int64
+	go func() {
+		buf := make([]byte, PACKET_SIZE)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			requests.Add(1)
+			if n < PACKET_SIZE {
+				continue
+			}
+			packet := make([]byte, PACKET_SIZE)
+			packet[0] = 0x1C // Version 3, server mode.
+			packet[1] = 2
+			putTimestamp(packet[32:40], time.Now().Add(ahead))
+			putTimestamp(packet[40:48], time.Now().Add(ahead))
+			if reply != nil && !reply(packet) {
+				continue
+			}
+			conn.WriteTo(packet, addr)
+		}
+	}()
+	return conn.LocalAddr().String(), &requests
+}
+
+func TestQuery(t *testing.T) {
+	tests := []struct {
+		name  string
+		ahead time.Duration
+		reply func([]byte) bool
+		err   bool
+	}{
+		{"ahead", time.Hour, nil, false},
+		{"behind", -90 * time.Second, nil, false},
+		{"in step", 0, nil, false},
+		{"kiss-o'-death", 0, func(p []byte) bool { p[1] = 0; return true }, true},
+		{"client mode", 0, func(p []byte) bool { p[0] = CLIENT_HEADER; return true }, true},
+		{"no answer", 0, func(p []byte) bool { return false }, true},
+	}
+	for _, tt := range tests {
+		addr, _ := server(t, tt.ahead, tt.reply)
+		resp, err := Query(addr, 200*time.Millisecond)
+		if (err != nil) != tt.err {
+			t.Errorf("%s: Query() error %v, want error %v", tt.name, err, tt.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if d := resp.Offset - tt.ahead; d < -SLACK || d > SLACK {
+			t.Errorf("%s: offset %s, want %s", tt.name, resp.Offset, tt.ahead)
+		}
+		if resp.RTT < 0 || resp.RTT > SLACK {
+			t.Errorf("%s: round trip %s over loopback", tt.name, resp.RTT)
+		}
+		if d := resp.Time.Sub(time.Now().Add(tt.ahead)); d < -SLACK || d > SLACK {
+			t.Errorf("%s: time %s, want about %s", tt.name, resp.Time, time.Now().Add(tt.ahead))
+		}
+	}
+}
+
+func TestClientCache(t *testing.T) {
+	tests := []struct {
+		name     string
+		ttl      time.Duration
+		requests int64
+	}{
+		{"cached", time.Minute, 1},
+		{"uncached", 0, 3},
+	}
+	for _, tt := range tests {
+		addr, requests := server(t, time.Hour, nil)
+		c := NewClient(addr, 200*time.Millisecond, tt.ttl)
+		for i := 0; i < 3; i++ {
+			now, err := c.Now()
+			if d := now.Sub(time.Now().Add(time.Hour)); err != nil || d < -SLACK || d > SLACK {
+				t.Errorf("%s: Now() = %s, %v, want an hour from now", tt.name, now, err)
+			}
+		}
+		if n := requests.Load(); n != tt.requests {
+			t.Errorf("%s: %d requests to the server, want %d", tt.name, n, tt.requests)
+		}
+	}
+}
+
+// Failed queries are not cached, so a later query reaches the server again.
+func TestClientFailure(t *testing.T) {
+	answer := atomic.Bool{}
+	addr, requests := server(t, 0, func([]byte) bool { return answer.Load() })
+	c := NewClient(addr, 50*time.Millisecond, time.Minute)
+	if _, err := c.Now(); err == nil {
+		t.Fatal("Now() succeeded without an answer")
+	}
+	answer.Store(true)
+	if _, err := c.Now(); err != nil {
+		t.Errorf("Now() after the server recovered - %v", err)
+	}
+	if n := requests.Load(); n != 2 {
+		t.Errorf("%d requests to the server, want 2", n)
+	}
+}
@@ -0,0 +1,247 @@
+//  Copyright (C) Pat Kaehuaea - All Rights Reserved
+//  Unauthorized copying of this file, via any medium is strictly prohibited
+//  Proprietary and confidential
+//  Written by Pat Kaehuaea, March 2015
+//
+// Package lets visitors log in with an external identity provider instead
+// of typing a name. A Provider sends the visitor to sign in with it and,
+// once they return with a code, exchanges the code for their Profile.
+// Providers are registered with Register() at startup and looked up by
+// ID() when visitors choose one. OAuth2 implements GitHub, Google and any
+// OpenID Connect provider found by discovery, all with PKCE.
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/endpoints"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// Bytes of a profile or discovery document read at most.
+	MAX_DOCUMENT = 1 << 20
+	// Path of the OpenID Connect discovery document under the issuer.
+	DISCOVERY_PATH  = "/.well-known/openid-configuration"
+	GITHUB_PROFILE  = "https://api.github.com/user"
+	GITHUB_SCOPES   = "read:user"
+	GOOGLE_USERINFO = "https://openidconnect.googleapis.com/v1/userinfo"
+	OIDC_SCOPES     = "openid profile email"
+)
+
+// Returned by Profile() when the provider's answer lacks a user id.
+var ErrNoSubject = errors.New("provider: Profile has no subject.")
+
+// User as told by a provider. Subject is the provider's stable id for
+// the user. Name and Username may be empty.
+type Profile struct {
+	Subject  string
+	Name     string
+	Username string
+	Email    string
+}
+
+// External service that signs users in.
+type Provider interface {
+	// Short lower case name used in URLs and identities, such as github.
+	ID() string
+	// Name shown on the login button, such as GitHub.
+	Title() string
+	// Returns the URL sending the visitor to sign in, after which the
+	// provider redirects them to redirectURL with a code and state.
+	// verifier is the PKCE code verifier kept until the callback.
+	AuthCodeURL(state string, verifier string, redirectURL string) string
+	// Exchanges the code from the callback for the signed in user.
+	Profile(ctx context.Context, code string, verifier string, redirectURL string) (Profile, error)
+}
+
+var registered = make(map[string]Provider)
+
+// Makes p available to Lookup() and All(). Not safe for use once
+// requests are being served.
+func Register(p Provider) {
+	registered[p.ID()] = p
+}
+
+// Returns the registered provider with id.
+func Lookup(id string) (p Provider, ok bool) {
+	p, ok = registered[id]
+	return
+}
+
+// Returns every registered provider ordered by title.
+func All() (all []Provider) {
+	for _, p := range registered {
+		all = append(all, p)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Title() < all[j].Title() })
+	return
+}
+
+// Returns a new PKCE code verifier.
+func NewVerifier() string {
+	return oauth2.GenerateVerifier()
+}
+
+// Provider using the OAuth2 authorization code flow, reading the profile
+// from a JSON endpoint with the access token.
+type OAuth2 struct {
+	id         string
+	title      string
+	config     oauth2.Config
+	profileURL string
+	parse      func(data []byte) (Profile, error)
+}
+
+func (o *OAuth2) ID() string {
+	return o.id
+}
+
+func (o *OAuth2) Title() string {
+	return o.title
+}
+
+func (o *OAuth2) AuthCodeURL(state string, verifier string, redirectURL string) string {
+	c := o.config
+	c.RedirectURL = redirectURL
+	return c.AuthCodeURL(state, oauth2.S256ChallengeOption(verifier))
+}
+
+func (o *OAuth2) Profile(ctx context.Context, code string, verifier string, redirectURL string) (profile Profile, err error) {
+	c := o.config
+	c.RedirectURL = redirectURL
+	token, err := c.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return profile, fmt.Errorf("provider: %s token exchange failed - %w", o.title, err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, o.profileURL, nil)
+	if err != nil {
+		return
+	}
+	req.Header.Set("Accept", "application/json")
+	data, err := getDocument(c.Client(ctx, token), req)
+	if err != nil {
+		return profile, fmt.Errorf("provider: %s profile unavailable - %w", o.title, err)
+	}
+	if profile, err = o.parse(data); err == nil && profile.Subject == "" {
+		err = ErrNoSubject
+	}
+	return
+}
+
+// Returns a provider logging in with a GitHub OAuth app.
+func NewGitHub(clientID string, clientSecret string) *OAuth2 {
+	return &OAuth2{
+		id:    "github",
+		title: "GitHub",
+		config: oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			Endpoint:     endpoints.GitHub,
+			Scopes:       strings.Fields(GITHUB_SCOPES),
+		},
+		profileURL: GITHUB_PROFILE,
+		parse:      parseGitHub,
+	}
+}
+
+// GitHub's user, whose id is a number.
+func parseGitHub(data []byte) (profile Profile, err error) {
+	var user struct {
+		ID    int64  `json:"id"`
+		Login string `json:"login"`
+		Name  string `json:"name"`
+		Email string `json:"email"`
+	}
+	if err = json.Unmarshal(data, &user); err != nil {
+		return
+	}
+	if user.ID != 0 {
+		profile.Subject = strconv.FormatInt(user.ID, 10)
+	}
+	profile.Name, profile.Username, profile.Email = user.Name, user.Login, user.Email
+	return
+}
+
+// Returns a provider logging in with Google, an OpenID Connect provider
+// whose endpoints are well known.
+func NewGoogle(clientID string, clientSecret string) *OAuth2 {
+	return newOIDC("google", "Google", endpoints.Google, GOOGLE_USERINFO, clientID, clientSecret)
+}
+
+// Returns a provider logging in with the OpenID Connect provider at
+// issuer, whose endpoints are read from its discovery document.
+func NewOIDC(ctx context.Context, title string, issuer string, clientID string, clientSecret string) (*OAuth2, error) {
+	uri := strings.TrimSuffix(issuer, "/") + DISCOVERY_PATH
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	data, err := getDocument(http.DefaultClient, req)
+	if err != nil {
+		return nil, fmt.Errorf("provider: Discovery at %s failed - %w", uri, err)
+	}
+	var doc struct {
+		AuthURL     string `json:"authorization_endpoint"`
+		TokenURL    string `json:"token_endpoint"`
+		UserinfoURL string `json:"userinfo_endpoint"`
+	}
+	if err = json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("provider: Malformed discovery document at %s - %w", uri, err)
+	}
+	if doc.AuthURL == "" || doc.TokenURL == "" || doc.UserinfoURL == "" {
+		return nil, errors.New("provider: Discovery document at " + uri + " lacks an authorization, token or userinfo endpoint.")
+	}
+	endpoint := oauth2.Endpoint{AuthURL: doc.AuthURL, TokenURL: doc.TokenURL}
+	return newOIDC("oidc", title, endpoint, doc.UserinfoURL, clientID, clientSecret), nil
+}
+
+func newOIDC(id string, title string, endpoint oauth2.Endpoint, userinfoURL string, clientID string, clientSecret string) *OAuth2 {
+	return &OAuth2{
+		id:    id,
+		title: title,
+		config: oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			Endpoint:     endpoint,
+			Scopes:       strings.Fields(OIDC_SCOPES),
+		},
+		profileURL: userinfoURL,
+		parse:      parseUserinfo,
+	}
+}
+
+// Standard claims of an OpenID Connect userinfo response.
+func parseUserinfo(data []byte) (profile Profile, err error) {
+	var claims struct {
+		Subject  string `json:"sub"`
+		Name     string `json:"name"`
+		Username string `json:"preferred_username"`
+		Email    string `json:"email"`
+	}
+	if err = json.Unmarshal(data, &claims); err != nil {
+		return
+	}
+	return Profile{claims.Subject, claims.Name, claims.Username, claims.Email}, nil
+}
+
+// Sends req with client and returns the body of a 200 response.
+func getDocument(client *http.Client, req *http.Request) (data []byte, err error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("unexpected response status " + resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, MAX_DOCUMENT))
+}
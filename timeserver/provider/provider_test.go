@@ -0,0 +1,200 @@
+//  Copyright (C) Pat Kaehuaea - All Rights Reserved
+//  Unauthorized copying of this file, via any medium is strictly prohibited
+//  Proprietary and confidential
+//  Written by Pat Kaehuaea, March 2015
+
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"golang.org/x/oauth2"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// Code the stand in provider exchanges for TEST_TOKEN.
+const (
+	TEST_CODE  = "good-code"
+	TEST_TOKEN = "test-token"
+)
+
+// Starts a stand in provider issuing TEST_TOKEN for TEST_CODE and
+// answering its profile endpoint with profile to that token.
+func stub(t *testing.T, profile string) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("code") != TEST_CODE || r.FormValue("code_verifier") == "" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, `{"error":"invalid_grant"}`)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"access_token": TEST_TOKEN, "token_type": "Bearer"})
+	})
+	mux.HandleFunc("/profile", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+TEST_TOKEN {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		io.WriteString(w, profile)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// Returns a provider using the stand in at server and parse.
+func stubProvider(server *httptest.Server, parse func(data []byte) (Profile, error)) *OAuth2 {
+	return &OAuth2{
+		id:    "stub",
+		title: "Stub",
+		config: oauth2.Config{
+			ClientID:     "client",
+			ClientSecret: "secret",
+			Endpoint:     oauth2.Endpoint{AuthURL: server.URL + "/authorize", TokenURL: server.URL + "/token"},
+		},
+		profileURL: server.URL + "/profile",
+		parse:      parse,
+	}
+}
+
+func TestProfile(t *testing.T) {
+	tests := []struct {
+		name    string
+		parse   func(data []byte) (Profile, error)
+		profile string
+		want    Profile
+		err     error
+	}{
+		{"github", parseGitHub, `{"id":42,"login":"ada","name":"Ada Lovelace","email":"ada@example.com"}`,
+			Profile{"42", "Ada Lovelace", "ada", "ada@example.com"}, nil},
+		{"github without id", parseGitHub, `{"login":"ada"}`, Profile{Username: "ada"}, ErrNoSubject},
+		{"github zero id", parseGitHub, `{"id":0,"login":"ada"}`, Profile{Username: "ada"}, ErrNoSubject},
+		{"userinfo", parseUserinfo, `{"sub":"abc","name":"Ada Lovelace","preferred_username":"ada","email":"ada@example.com"}`,
+			Profile{"abc", "Ada Lovelace", "ada", "ada@example.com"}, nil},
+		{"userinfo without sub", parseUserinfo, `{"name":"Ada Lovelace"}`, Profile{Name: "Ada Lovelace"}, ErrNoSubject},
+		{"userinfo empty sub", parseUserinfo, `{"sub":""}`, Profile{}, ErrNoSubject},
+	}
+	for _, tt := range tests {
+		p := stubProvider(stub(t, tt.profile), tt.parse)
+		got, err := p.Profile(context.Background(), TEST_CODE, NewVerifier(), "http://localhost/callback")
+		if err != tt.err {
+			t.Errorf("%s: Profile() error %v, want %v", tt.name, err, tt.err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: Profile() = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestProfileFailures(t *testing.T) {
+	tests := []struct {
+		name    string
+		code    string
+		profile string
+	}{
+		{"refused code", "bad-code", `{"sub":"abc"}`},
+		{"malformed profile", TEST_CODE, `{"sub":`},
+	}
+	for _, tt := range tests {
+		p := stubProvider(stub(t, tt.profile), parseUserinfo)
+		_, err := p.Profile(context.Background(), tt.code, NewVerifier(), "http://localhost/callback")
+		if err == nil || errors.Is(err, ErrNoSubject) {
+			t.Errorf("%s: Profile() error %v, want a failure", tt.name, err)
+		}
+	}
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	p := stubProvider(stub(t, ""), parseUserinfo)
+	uri, err := url.Parse(p.AuthCodeURL("the-state", NewVerifier(), "http://localhost/callback"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := uri.Query()
+	want := map[string]string{
+		"state":                 "the-state",
+		"redirect_uri":          "http://localhost/callback",
+		"client_id":             "client",
+		"code_challenge_method": "S256",
+	}
+	for key, value := range want {
+		if got := query.Get(key); got != value {
+			t.Errorf("%s = %q, want %q", key, got, value)
+		}
+	}
+	if query.Get("code_challenge") == "" {
+		t.Error("no PKCE code_challenge sent")
+	}
+}
+
+func TestNewOIDC(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		doc    string
+		ok     bool
+	}{
+		{"complete", http.StatusOK, `{"authorization_endpoint":"https://idp/auth","token_endpoint":"https://idp/token","userinfo_endpoint":"https://idp/userinfo"}`, true},
+		{"no authorization endpoint", http.StatusOK, `{"token_endpoint":"https://idp/token","userinfo_endpoint":"https://idp/userinfo"}`, false},
+		{"no token endpoint", http.StatusOK, `{"authorization_endpoint":"https://idp/auth","userinfo_endpoint":"https://idp/userinfo"}`, false},
+		{"no userinfo endpoint", http.StatusOK, `{"authorization_endpoint":"https://idp/auth","token_endpoint":"https://idp/token"}`, false},
+		{"malformed", http.StatusOK, `{"authorization_endpoint":`, false},
+		{"missing", http.StatusNotFound, ``, false},
+	}
+	for _, tt := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != DISCOVERY_PATH {
+				http.NotFound(w, r)
+				return
+			}
+			w.WriteHeader(tt.status)
+			io.WriteString(w, tt.doc)
+		}))
+		p, err := NewOIDC(context.Background(), "Example", server.URL+"/", "client", "secret")
+		server.Close()
+
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: NewOIDC() error %v, want success %v", tt.name, err, tt.ok)
+			continue
+		}
+		if !tt.ok {
+			continue
+		}
+		if p.ID() != "oidc" || p.Title() != "Example" {
+			t.Errorf("%s: provider %q titled %q, want oidc titled Example", tt.name, p.ID(), p.Title())
+		}
+		if p.profileURL != "https://idp/userinfo" || !strings.HasPrefix(p.AuthCodeURL("s", NewVerifier(), ""), "https://idp/auth?") {
+			t.Errorf("%s: endpoints not taken from the discovery document", tt.name)
+		}
+	}
+}
+
+func TestAll(t *testing.T) {
+	saved := registered
+	registered = make(map[string]Provider)
+	defer func() { registered = saved }()
+
+	Register(NewGoogle("id", "secret"))
+	Register(NewGitHub("id", "secret"))
+	if _, ok := Lookup("gitlab"); ok {
+		t.Error("Lookup() found an unregistered provider")
+	}
+	if p, ok := Lookup("github"); !ok || p.Title() != "GitHub" {
+		t.Errorf("Lookup(github) = %v, %v, want GitHub", p, ok)
+	}
+	var titles []string
+	for _, p := range All() {
+		titles = append(titles, p.Title())
+	}
+	if strings.Join(titles, ",") != "GitHub,Google" {
+		t.Errorf("All() = %v, want GitHub then Google", titles)
+	}
+}
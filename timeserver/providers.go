@@ -0,0 +1,203 @@
+//  Copyright (C) Pat Kaehuaea - All Rights Reserved
+//  Unauthorized copying of this file, via any medium is strictly prohibited
+//  Proprietary and confidential
+//  Written by Pat Kaehuaea, March 2015
+//
+// Login with the external providers configured by flag, offered on the
+// login page beside the name form. /login/{provider} sends the visitor
+// to the provider and /login/{provider}/callback, where the provider
+// sends them back, registers a user named after their profile and issues
+// the session cookie just as a typed name does.
+
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	log "github.com/cihub/seelog"
+	"github.com/gorilla/mux"
+	"github.com/patkaehuaea/command/authserver/client"
+	"github.com/patkaehuaea/command/authserver/people"
+	"github.com/patkaehuaea/command/config"
+	"github.com/patkaehuaea/command/timeserver/cookie"
+	"github.com/patkaehuaea/command/timeserver/provider"
+	"github.com/patkaehuaea/command/timeserver/proxy"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	LOGIN_STATE_COOKIE = "login_state"
+	// Time a visitor has to sign in with the provider.
+	LOGIN_STATE_AGE   = 600
+	DISCOVERY_TIMEOUT = 10 * time.Second
+	// Name given to users whose profile has none usable.
+	PROVIDER_FALLBACK = "Earthling"
+)
+
+// Carried in the login state cookie from /login/{provider} to the
+// callback. State must match the callback's state parameter.
+type loginState struct {
+	Provider string `json:"provider"`
+	State    string `json:"state"`
+	Verifier string `json:"verifier"`
+	Return   string `json:"return"`
+}
+
+// Registers the providers whose flags are set. Exits if a provider is
+// half configured or OpenID Connect discovery fails.
+func registerProviders() {
+	pairs := []struct{ flag, id, secret string }{
+		{"github", *config.GitHubID, *config.GitHubSecret},
+		{"google", *config.GoogleID, *config.GoogleSecret},
+		{"oidc", *config.OIDCID, *config.OIDCSecret},
+	}
+	for _, p := range pairs {
+		if (p.id == "") != (p.secret == "") {
+			log.Critical("timeserver: --" + p.flag + "-client-id and --" + p.flag + "-client-secret must be set together.")
+			os.Exit(1)
+		}
+	}
+	if (*config.OIDCIssuer == config.OIDC_ISSUER) != (*config.OIDCID == config.OIDC_CLIENT_ID) {
+		log.Critical("timeserver: --oidc-issuer and --oidc-client-id must be set together.")
+		os.Exit(1)
+	}
+
+	if *config.GitHubID != config.GITHUB_CLIENT_ID {
+		provider.Register(provider.NewGitHub(*config.GitHubID, *config.GitHubSecret))
+	}
+	if *config.GoogleID != config.GOOGLE_CLIENT_ID {
+		provider.Register(provider.NewGoogle(*config.GoogleID, *config.GoogleSecret))
+	}
+	if *config.OIDCIssuer != config.OIDC_ISSUER {
+		ctx, cancel := context.WithTimeout(context.Background(), DISCOVERY_TIMEOUT)
+		defer cancel()
+		p, err := provider.NewOIDC(ctx, *config.OIDCTitle, *config.OIDCIssuer, *config.OIDCID, *config.OIDCSecret)
+		if err != nil {
+			log.Critical(err)
+			os.Exit(1)
+		}
+		provider.Register(p)
+	}
+	for _, p := range provider.All() {
+		log.Info("timeserver: Login with " + p.Title() + " enabled.")
+	}
+}
+
+// Returns the URL the provider with id sends visitors back to, based on
+// --public-url or else the scheme and host of r.
+func callbackURL(r *http.Request, id string) string {
+	base := strings.TrimSuffix(*config.PublicURL, "/")
+	if base == "" {
+		base = proxy.Scheme(r) + "://" + r.Host
+	}
+	return base + "/login/" + id + "/callback"
+}
+
+// Returns a name people.IsValidName accepts for the user with profile:
+// their name, the first two words of it, or their username less anything
+// but letters, whichever is valid first.
+func providerName(profile provider.Profile) string {
+	letters := strings.Map(func(c rune) rune {
+		if unicode.IsLetter(c) {
+			return c
+		}
+		return -1
+	}, profile.Username)
+	words := strings.Fields(profile.Name)
+	if len(words) > 2 {
+		words = words[:2]
+	}
+	for _, name := range []string{profile.Name, strings.Join(words, " "), letters} {
+		if people.IsValidName(name) {
+			return name
+		}
+	}
+	return PROVIDER_FALLBACK
+}
+
+// Sends the visitor to sign in with the provider, remembering the state
+// and PKCE verifier for the callback in a short lived cookie.
+func handleProviderLogin(w http.ResponseWriter, r *http.Request) {
+	p, ok := provider.Lookup(mux.Vars(r)["provider"])
+	if !ok {
+		handleNotFound(w, r)
+		return
+	}
+	ls := loginState{
+		Provider: p.ID(),
+		State:    people.UUID(),
+		Verifier: provider.NewVerifier(),
+		Return:   safeRedirect(r.FormValue(RETURN_PARAM)),
+	}
+	data, err := json.Marshal(ls)
+	if ls.State == "" || err != nil {
+		log.Error("timeserver: Unable to start login with " + p.Title())
+		w.WriteHeader(http.StatusInternalServerError)
+		renderTemplate(w, r, "500", nil)
+		return
+	}
+	cookie.Set(w, r, cookie.NewNamedCookie(LOGIN_STATE_COOKIE, base64.RawURLEncoding.EncodeToString(data), LOGIN_STATE_AGE))
+	http.Redirect(w, r, p.AuthCodeURL(ls.State, ls.Verifier, callbackURL(r, p.ID())), http.StatusFound)
+}
+
+// Completes login with the provider. The state parameter must match the
+// login state cookie, which is cleared either way. Users are registered
+// with the name from their profile and the identity provider:subject.
+func handleProviderCallback(w http.ResponseWriter, r *http.Request) {
+	p, ok := provider.Lookup(mux.Vars(r)["provider"])
+	if !ok {
+		handleNotFound(w, r)
+		return
+	}
+	cookie.Set(w, r, cookie.NewNamedCookie(LOGIN_STATE_COOKIE, cookie.DELETE_VALUE, cookie.DELETE_AGE))
+
+	var ls loginState
+	if c, err := r.Cookie(LOGIN_STATE_COOKIE); err == nil {
+		if data, err := base64.RawURLEncoding.DecodeString(c.Value); err == nil {
+			json.Unmarshal(data, &ls)
+		}
+	}
+	state := r.FormValue("state")
+	if ls.Provider != p.ID() || ls.State == "" || subtle.ConstantTimeCompare([]byte(ls.State), []byte(state)) != 1 {
+		log.Warn("timeserver: Login with " + p.Title() + " from " + remoteHost(r) + " has unexpected state.")
+		w.WriteHeader(http.StatusBadRequest)
+		renderTemplate(w, r, "login", loginPage("That login has expired, please try again.", ls.Return))
+		return
+	}
+	if reason := r.FormValue("error"); reason != "" {
+		log.Info("timeserver: Login with " + p.Title() + " refused - " + reason)
+		w.WriteHeader(http.StatusUnauthorized)
+		renderTemplate(w, r, "login", loginPage("Login with "+p.Title()+" was cancelled.", ls.Return))
+		return
+	}
+
+	profile, err := p.Profile(r.Context(), r.FormValue("code"), ls.Verifier, callbackURL(r, p.ID()))
+	if err != nil {
+		log.Error(err)
+		w.WriteHeader(http.StatusBadGateway)
+		renderTemplate(w, r, "login", loginPage("Unable to log in with "+p.Title()+", try again later.", ls.Return))
+		return
+	}
+
+	uuid := people.UUID()
+	name := providerName(profile)
+	if err = authClient.SetIdentified(r.Context(), uuid, name, p.ID()+":"+profile.Subject); err == client.ErrCapacity {
+		log.Warn(err)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		renderTemplate(w, r, "login", loginPage("Server at capacity, try later.", ls.Return))
+		return
+	} else if err != nil {
+		log.Error(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		renderTemplate(w, r, "500", nil)
+		return
+	}
+	startSession(w, r, uuid, name, ls.Return)
+	log.Info("timeserver: " + name + " registered on site with " + p.Title() + ".")
+}
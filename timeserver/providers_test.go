@@ -0,0 +1,239 @@
+//  Copyright (C) Pat Kaehuaea - All Rights Reserved
+//  Unauthorized copying of this file, via any medium is strictly prohibited
+//  Proprietary and confidential
+//  Written by Pat Kaehuaea, March 2015
+//
+// Tests for login with external providers, against a stand in OpenID
+// Connect provider.
+
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/patkaehuaea/command/authserver/people"
+	"github.com/patkaehuaea/command/config"
+	"github.com/patkaehuaea/command/timeserver/provider"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// Code the stand in provider accepts, and the user it signs in.
+const (
+	PROVIDER_CODE     = "good-code"
+	PROVIDER_SUBJECT  = "248289761001"
+	PROVIDER_USERINFO = `{"sub":"` + PROVIDER_SUBJECT + `","name":"Ada Lovelace"}`
+)
+
+// Registers the oidc provider against a stand in for the duration of t.
+// The stand in serves discovery, exchanges PROVIDER_CODE for a token and
+// answers its userinfo endpoint with PROVIDER_USERINFO.
+func withProviderStub(t *testing.T) provider.Provider {
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	mux.HandleFunc(provider.DISCOVERY_PATH, func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"authorization_endpoint": server.URL + "/authorize",
+			"token_endpoint":         server.URL + "/token",
+			"userinfo_endpoint":      server.URL + "/userinfo",
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.FormValue("code") != PROVIDER_CODE {
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, `{"error":"invalid_grant"}`)
+			return
+		}
+		io.WriteString(w, `{"access_token":"token","token_type":"Bearer"}`)
+	})
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, PROVIDER_USERINFO)
+	})
+
+	p, err := provider.NewOIDC(context.Background(), "Example", server.URL, "client", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider.Register(p)
+	return p
+}
+
+// Returns a request for target routed to the provider with id.
+func providerRequest(target string, id string) *http.Request {
+	return mux.SetURLVars(httptest.NewRequest("GET", target, nil), map[string]string{"provider": id})
+}
+
+// Returns the login state cookie set by w.
+func stateCookie(w *httptest.ResponseRecorder) *http.Cookie {
+	for _, c := range w.Result().Cookies() {
+		if c.Name == LOGIN_STATE_COOKIE {
+			return c
+		}
+	}
+	return nil
+}
+
+// Returns a login state cookie carrying ls.
+func newStateCookie(ls loginState) *http.Cookie {
+	data, _ := json.Marshal(ls)
+	return &http.Cookie{Name: LOGIN_STATE_COOKIE, Value: base64.RawURLEncoding.EncodeToString(data)}
+}
+
+func TestProviderLogin(t *testing.T) {
+	p := withProviderStub(t)
+	w := httptest.NewRecorder()
+	handleProviderLogin(w, providerRequest("/login/oidc?"+RETURN_PARAM+"=/time", p.ID()))
+	if w.Code != http.StatusFound {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusFound)
+	}
+
+	uri, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uri.Path != "/authorize" {
+		t.Errorf("redirected to %q, want the provider's authorization endpoint", uri)
+	}
+	if got := uri.Query().Get("redirect_uri"); got != "http://example.com/login/oidc/callback" {
+		t.Errorf("redirect_uri = %q, want the callback on this site", got)
+	}
+
+	c := stateCookie(w)
+	if c == nil {
+		t.Fatal("no login state cookie set")
+	}
+	var ls loginState
+	data, _ := base64.RawURLEncoding.DecodeString(c.Value)
+	if err := json.Unmarshal(data, &ls); err != nil {
+		t.Fatal(err)
+	}
+	if ls.Provider != p.ID() || ls.State != uri.Query().Get("state") || ls.Return != "/time" || ls.Verifier == "" {
+		t.Errorf("login state = %+v, want oidc, the sent state, /time and a verifier", ls)
+	}
+	if c.MaxAge != LOGIN_STATE_AGE || !c.HttpOnly {
+		t.Errorf("login state cookie MaxAge %d HttpOnly %v, want %d and true", c.MaxAge, c.HttpOnly, LOGIN_STATE_AGE)
+	}
+}
+
+func TestProviderLoginUnknown(t *testing.T) {
+	w := httptest.NewRecorder()
+	handleProviderLogin(w, providerRequest("/login/myspace", "myspace"))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if stateCookie(w) != nil {
+		t.Error("login state cookie set for an unknown provider")
+	}
+}
+
+func TestProviderCallback(t *testing.T) {
+	override(t, config.CookieCheck, false)
+	p := withProviderStub(t)
+	valid := loginState{Provider: p.ID(), State: "the-state", Verifier: provider.NewVerifier(), Return: "/time"}
+	other := valid
+	other.Provider = "github"
+	offsite := valid
+	offsite.Return = "https://evil.example/"
+
+	tests := []struct {
+		name     string
+		query    string
+		state    *loginState
+		capacity int
+		status   int
+		location string
+	}{
+		{"no state cookie", "state=the-state&code=" + PROVIDER_CODE, nil, people.NO_CAPACITY_LIMIT, http.StatusBadRequest, ""},
+		{"state mismatch", "state=other&code=" + PROVIDER_CODE, &valid, people.NO_CAPACITY_LIMIT, http.StatusBadRequest, ""},
+		{"no state", "code=" + PROVIDER_CODE, &valid, people.NO_CAPACITY_LIMIT, http.StatusBadRequest, ""},
+		{"other provider's state", "state=the-state&code=" + PROVIDER_CODE, &other, people.NO_CAPACITY_LIMIT, http.StatusBadRequest, ""},
+		{"refused", "state=the-state&error=access_denied", &valid, people.NO_CAPACITY_LIMIT, http.StatusUnauthorized, ""},
+		{"failed exchange", "state=the-state&code=bad-code", &valid, people.NO_CAPACITY_LIMIT, http.StatusBadGateway, ""},
+		{"at capacity", "state=the-state&code=" + PROVIDER_CODE, &valid, 1, http.StatusServiceUnavailable, ""},
+		{"success", "state=the-state&code=" + PROVIDER_CODE, &valid, people.NO_CAPACITY_LIMIT, http.StatusFound, "/time"},
+		{"off site return", "state=the-state&code=" + PROVIDER_CODE, &offsite, people.NO_CAPACITY_LIMIT, http.StatusFound, "/"},
+	}
+	for _, tt := range tests {
+		users := withAuthStub(t, tt.capacity)
+		if tt.capacity != people.NO_CAPACITY_LIMIT {
+			users.Add(TEST_UUID, "Grace")
+		}
+		r := providerRequest("/login/oidc/callback?"+tt.query, p.ID())
+		if tt.state != nil {
+			r.AddCookie(newStateCookie(*tt.state))
+		}
+		w := httptest.NewRecorder()
+		handleProviderCallback(w, r)
+
+		if w.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, tt.status)
+		}
+		if got := w.Header().Get("Location"); got != tt.location {
+			t.Errorf("%s: redirected to %q, want %q", tt.name, got, tt.location)
+		}
+		if c := stateCookie(w); c == nil || c.MaxAge >= 0 {
+			t.Errorf("%s: login state cookie not cleared", tt.name)
+		}
+
+		var identified []people.Person
+		for _, person := range users.Snapshot() {
+			if person.Identity != "" {
+				identified = append(identified, person)
+			}
+		}
+		if tt.status != http.StatusFound {
+			if len(identified) != 0 {
+				t.Errorf("%s: %d users registered, want none", tt.name, len(identified))
+			}
+			continue
+		}
+		if len(identified) != 1 {
+			t.Errorf("%s: %d users registered, want 1", tt.name, len(identified))
+			continue
+		}
+		if want := p.ID() + ":" + PROVIDER_SUBJECT; identified[0].Identity != want || identified[0].Name != "Ada Lovelace" {
+			t.Errorf("%s: registered %q as %q, want Ada Lovelace as %q", tt.name, identified[0].Name, identified[0].Identity, want)
+		}
+		if c := sessionCookie(w); c == nil || c.Value != identified[0].ID {
+			t.Errorf("%s: session cookie %v, want the registered user's id", tt.name, c)
+		}
+	}
+}
+
+func TestProviderCallbackUnknown(t *testing.T) {
+	w := httptest.NewRecorder()
+	handleProviderCallback(w, providerRequest("/login/myspace/callback?state=s&code=c", "myspace"))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestProviderName(t *testing.T) {
+	tests := []struct {
+		profile provider.Profile
+		want    string
+	}{
+		{provider.Profile{Name: "Ada Lovelace", Username: "ada"}, "Ada Lovelace"},
+		{provider.Profile{Name: "Ada King Lovelace", Username: "ada"}, "Ada King"},
+		{provider.Profile{Name: "José Ñúñez"}, "José Ñúñez"},
+		{provider.Profile{Name: "A", Username: "ada_lovelace"}, "adalovelace"},
+		{provider.Profile{Name: "", Username: "ada1815"}, "ada"},
+		{provider.Profile{Name: "R2-D2", Username: "r2d2"}, "rd"},
+		{provider.Profile{Name: "12345", Username: "7"}, PROVIDER_FALLBACK},
+		{provider.Profile{}, PROVIDER_FALLBACK},
+		{provider.Profile{Name: strings.Repeat("a", people.NAME_MAX_LENGTH+1)}, PROVIDER_FALLBACK},
+	}
+	for _, tt := range tests {
+		if got := providerName(tt.profile); got != tt.want {
+			t.Errorf("providerName(%+v) = %q, want %q", tt.profile, got, tt.want)
+		}
+	}
+}
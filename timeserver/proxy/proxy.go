@@ -0,0 +1,137 @@
+//  Copyright (C) Pat Kaehuaea - All Rights Reserved
+//  Unauthorized copying of this file, via any medium is strictly prohibited
+//  Proprietary and confidential
+//  Written by Pat Kaehuaea, March 2015
+//
+// Package provides middleware for running behind reverse proxies such as
+// nginx or a load balancer. Requests from the proxies named by SetTrusted()
+// have RemoteAddr replaced with the client address from X-Forwarded-For,
+// and the scheme from X-Forwarded-Proto is reported by Scheme(), so logs,
+// rate limits, Secure cookies and absolute URLs reflect the client rather
+// than the proxy. The headers of any other request are ignored, as clients
+// can send them too.
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+)
+
+const (
+	FORWARDED_FOR   = "X-Forwarded-For"
+	FORWARDED_PROTO = "X-Forwarded-Proto"
+)
+
+type contextKey struct{}
+
+// Networks whose requests carry trustworthy forwarding headers.
+var trusted []*net.IPNet
+
+// Sets the proxies trusted by Handler from list, a comma separated list of
+// CIDRs such as 10.0.0.0/8 or bare addresses. An empty list trusts none.
+func SetTrusted(list string) error {
+	var networks []*net.IPNet
+	for _, entry := range strings.Split(list, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return errors.New("proxy: Invalid trusted proxy address " + entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return errors.New("proxy: Invalid trusted proxy network " + entry)
+		}
+		networks = append(networks, network)
+	}
+	trusted = networks
+	return nil
+}
+
+// Returns true if SetTrusted() named any proxies.
+func Enabled() bool {
+	return len(trusted) > 0
+}
+
+func isTrusted(ip net.IP) bool {
+	for _, network := range trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the client address of a request from peer carrying the
+// X-Forwarded-For hops. Proxies append the address they received from,
+// so the hops are read right to left and the first address that is not a
+// trusted proxy is the client. A malformed hop stops the walk at the last
+// address read, as anything to its left is unverifiable.
+func client(peer net.IP, hops []string) net.IP {
+	addr := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			break
+		}
+		addr = ip
+		if !isTrusted(ip) {
+			break
+		}
+	}
+	return addr
+}
+
+// Returns https if r arrived over TLS, directly or at a trusted proxy
+// that said so in X-Forwarded-Proto, and http otherwise.
+func Scheme(r *http.Request) string {
+	if r.TLS != nil {
+		return "https"
+	}
+	if scheme, ok := r.Context().Value(contextKey{}).(string); ok {
+		return scheme
+	}
+	return "http"
+}
+
+// Wraps h so requests from trusted proxies carry the client's address as
+// RemoteAddr, without a port, and the forwarded scheme for Scheme().
+// Place outermost so every later middleware sees the client.
+func Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		peer := net.ParseIP(host)
+		if peer == nil || !isTrusted(peer) {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		ctx := r.Context()
+		// The first value is the scheme the outermost proxy was reached
+		// by, which it should set rather than append to.
+		proto, _, _ := strings.Cut(r.Header.Get(FORWARDED_PROTO), ",")
+		if proto = strings.ToLower(strings.TrimSpace(proto)); proto == "https" || proto == "http" {
+			ctx = context.WithValue(ctx, contextKey{}, proto)
+		}
+		r = r.WithContext(ctx)
+		if header := r.Header.Values(FORWARDED_FOR); len(header) > 0 {
+			r.RemoteAddr = client(peer, strings.Split(strings.Join(header, ","), ",")).String()
+		}
+		h.ServeHTTP(w, r)
+	})
+}
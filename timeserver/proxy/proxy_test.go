@@ -0,0 +1,119 @@
+//  Copyright (C) Pat Kaehuaea - All Rights Reserved
+//  Unauthorized copying of this file, via any medium is strictly prohibited
+//  Proprietary and confidential
+//  Written by Pat Kaehuaea, March 2015
+
+package proxy
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Proxies trusted by the tests: a private network and one address.
+const TEST_TRUSTED = "10.0.0.0/8, 192.0.2.1"
+
+// Trusts list for the duration of t.
+func withTrusted(t *testing.T, list string) {
+	saved := trusted
+	if err := SetTrusted(list); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { trusted = saved })
+}
+
+// Serves r through Handler and returns the RemoteAddr and Scheme() the
+// wrapped handler saw.
+func serve(r *http.Request) (addr string, scheme string) {
+	Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		addr, scheme = r.RemoteAddr, Scheme(r)
+	})).ServeHTTP(httptest.NewRecorder(), r)
+	return
+}
+
+func TestSetTrusted(t *testing.T) {
+	tests := []struct {
+		list    string
+		ok      bool
+		enabled bool
+	}{
+		{"", true, false},
+		{" , ", true, false},
+		{"10.0.0.0/8", true, true},
+		{"192.0.2.1,2001:db8::1", true, true},
+		{"fd00::/8, 127.0.0.1", true, true},
+		{"proxy.internal", false, false},
+		{"10.0.0.0/33", false, false},
+	}
+	for _, tt := range tests {
+		trusted = nil
+		err := SetTrusted(tt.list)
+		if (err == nil) != tt.ok {
+			t.Errorf("SetTrusted(%q) error %v, want success %v", tt.list, err, tt.ok)
+		}
+		if Enabled() != tt.enabled {
+			t.Errorf("SetTrusted(%q): Enabled() = %v, want %v", tt.list, Enabled(), tt.enabled)
+		}
+	}
+	trusted = nil
+}
+
+func TestHandler(t *testing.T) {
+	withTrusted(t, TEST_TRUSTED)
+	tests := []struct {
+		name      string
+		peer      string
+		forwarded []string
+		proto     string
+		addr      string
+		scheme    string
+	}{
+		{"untrusted peer", "203.0.113.9:4000", []string{"198.51.100.7"}, "https", "203.0.113.9:4000", "http"},
+		{"untrusted peer without headers", "203.0.113.9:4000", nil, "", "203.0.113.9:4000", "http"},
+		{"trusted peer", "10.1.2.3:4000", []string{"198.51.100.7"}, "https", "198.51.100.7", "https"},
+		{"trusted peer without headers", "10.1.2.3:4000", nil, "", "10.1.2.3:4000", "http"},
+		{"bare address peer", "192.0.2.1", []string{"198.51.100.7"}, "", "198.51.100.7", "http"},
+		{"spoofed hop left of client", "10.1.2.3:4000", []string{"6.6.6.6, 198.51.100.7, 10.9.9.9"}, "", "198.51.100.7", "http"},
+		{"hops across headers", "10.1.2.3:4000", []string{"6.6.6.6, 198.51.100.7", "10.9.9.9"}, "", "198.51.100.7", "http"},
+		{"all hops trusted", "10.1.2.3:4000", []string{"10.7.7.7, 192.0.2.1"}, "", "10.7.7.7", "http"},
+		{"malformed hop", "10.1.2.3:4000", []string{"6.6.6.6, bogus, 10.9.9.9"}, "", "10.9.9.9", "http"},
+		{"malformed last hop", "10.1.2.3:4000", []string{"198.51.100.7, unknown"}, "", "10.1.2.3", "http"},
+		{"ipv6 client", "10.1.2.3:4000", []string{"2001:db8::7"}, "", "2001:db8::7", "http"},
+		{"comma separated proto", "10.1.2.3:4000", nil, "https, http", "10.1.2.3:4000", "https"},
+		{"upper case proto", "10.1.2.3:4000", nil, "HTTPS", "10.1.2.3:4000", "https"},
+		{"unknown proto", "10.1.2.3:4000", nil, "gopher", "10.1.2.3:4000", "http"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tt.peer
+		for _, value := range tt.forwarded {
+			r.Header.Add(FORWARDED_FOR, value)
+		}
+		if tt.proto != "" {
+			r.Header.Set(FORWARDED_PROTO, tt.proto)
+		}
+		addr, scheme := serve(r)
+		if addr != tt.addr {
+			t.Errorf("%s: RemoteAddr = %q, want %q", tt.name, addr, tt.addr)
+		}
+		if scheme != tt.scheme {
+			t.Errorf("%s: Scheme() = %q, want %q", tt.name, scheme, tt.scheme)
+		}
+	}
+}
+
+func TestSchemeTLS(t *testing.T) {
+	withTrusted(t, TEST_TRUSTED)
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "10.1.2.3:4000"
+	r.Header.Set(FORWARDED_PROTO, "http")
+	r.TLS = &tls.ConnectionState{}
+	if _, scheme := serve(r); scheme != "https" {
+		t.Errorf("Scheme() = %q over TLS, want https", scheme)
+	}
+	if scheme := Scheme(httptest.NewRequest("GET", "/", nil)); scheme != "http" {
+		t.Errorf("Scheme() = %q outside Handler, want http", scheme)
+	}
+}
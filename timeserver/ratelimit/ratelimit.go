@@ -0,0 +1,78 @@
+//  Copyright (C) Pat Kaehuaea - All Rights Reserved
+//  Unauthorized copying of this file, via any medium is strictly prohibited
+//  Proprietary and confidential
+//  Written by Pat Kaehuaea, March 2015
+//
+// Package implements a keyed token bucket rate limiter. Each key, such as
+// a session or client address, gets its own bucket holding up to burst
+// tokens and refilled at rate tokens per second. Buckets idle long enough
+// to have refilled completely are indistinguishable from new ones and are
+// discarded periodically so the table does not grow without bound.
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const SWEEP_INTERVAL = 1 * time.Minute
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type Limiter struct {
+	sync.Mutex
+	rate    float64
+	burst   float64
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+// Returns Limiter allowing rate requests per second per key on average
+// and bursts of up to burst requests. Burst is raised to 1 if lower.
+func NewLimiter(rate float64, burst int) *Limiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &Limiter{rate: rate, burst: float64(burst), buckets: make(map[string]*bucket), swept: time.Now()}
+}
+
+// Takes a token from the bucket for key. Returns true if one was
+// available, otherwise false and the time until one will be.
+func (l *Limiter) Allow(key string) (ok bool, retryAfter time.Duration) {
+	now := time.Now()
+	l.Lock()
+	defer l.Unlock()
+
+	if now.Sub(l.swept) >= SWEEP_INTERVAL {
+		l.sweep(now)
+	}
+
+	b, found := l.buckets[key]
+	if !found {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	retryAfter = time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	return false, retryAfter
+}
+
+// Removes buckets that would be full by now. Caller holds the lock.
+func (l *Limiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+	l.swept = now
+}
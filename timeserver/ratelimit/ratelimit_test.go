@@ -0,0 +1,71 @@
+//  Copyright (C) Pat Kaehuaea - All Rights Reserved
+//  Unauthorized copying of this file, via any medium is strictly prohibited
+//  Proprietary and confidential
+//  Written by Pat Kaehuaea, March 2015
+
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAllow(t *testing.T) {
+	tests := []struct {
+		name    string
+		rate    float64
+		burst   int
+		allowed int
+	}{
+		{"burst of one", 1, 1, 1},
+		{"burst of three", 1, 3, 3},
+		{"burst raised to one", 1, 0, 1},
+	}
+	for _, tt := range tests {
+		l := NewLimiter(tt.rate, tt.burst)
+		for i := 0; i < tt.allowed; i++ {
+			if ok, _ := l.Allow("a"); !ok {
+				t.Errorf("%s: request %d refused", tt.name, i)
+			}
+		}
+		ok, retry := l.Allow("a")
+		if ok || retry <= 0 || retry > time.Duration(float64(time.Second)/tt.rate) {
+			t.Errorf("%s: request over burst = %v, retry after %s", tt.name, ok, retry)
+		}
+		if ok, _ := l.Allow("b"); !ok {
+			t.Errorf("%s: other key refused", tt.name)
+		}
+	}
+}
+
+func TestRefill(t *testing.T) {
+	l := NewLimiter(100, 1)
+	l.Allow("a")
+	if ok, _ := l.Allow("a"); ok {
+		t.Fatal("second request within burst of one allowed")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if ok, retry := l.Allow("a"); !ok {
+		t.Errorf("request after refill refused, retry after %s", retry)
+	}
+}
+
+func TestSweep(t *testing.T) {
+	l := NewLimiter(1, 1)
+	l.Allow("idle")
+	l.Allow("busy")
+	l.Lock()
+	l.buckets["idle"].last = time.Now().Add(-time.Hour)
+	l.swept = time.Now().Add(-SWEEP_INTERVAL)
+	l.Unlock()
+
+	l.Allow("other")
+	l.Lock()
+	defer l.Unlock()
+	if _, found := l.buckets["idle"]; found {
+		t.Error("refilled bucket kept by sweep")
+	}
+	if _, found := l.buckets["busy"]; !found {
+		t.Error("drained bucket discarded by sweep")
+	}
+}
@@ -0,0 +1,77 @@
+//  Copyright (C) Pat Kaehuaea - All Rights Reserved
+//  Unauthorized copying of this file, via any medium is strictly prohibited
+//  Proprietary and confidential
+//  Written by Pat Kaehuaea, March 2015
+//
+// Package provides middleware that tags each request with an identifier
+// carried in the X-Request-ID header. An identifier assigned by an upstream
+// gateway is reused when it looks sane so traces can be followed across
+// systems; otherwise a new random identifier is generated.
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	log "github.com/cihub/seelog"
+	"net/http"
+	"regexp"
+)
+
+const (
+	HEADER_NAME = "X-Request-ID"
+	ID_BYTES    = 16
+	ID_REGEX    = "^[a-zA-Z0-9._-]{1,128}$"
+)
+
+type contextKey struct{}
+
+var validID = regexp.MustCompile(ID_REGEX)
+
+// Returns true if id is short enough and limited to characters that are
+// safe to echo in headers and logs.
+func IsValid(id string) bool {
+	return validID.MatchString(id)
+}
+
+// Returns ID_BYTES of random data hex encoded.
+func newID() (id string, err error) {
+	b := make([]byte, ID_BYTES)
+	if _, err = rand.Read(b); err != nil {
+		return
+	}
+	id = hex.EncodeToString(b)
+	return
+}
+
+// Returns the request ID assigned by Handler, or empty string if r
+// did not pass through Handler.
+func FromRequest(r *http.Request) string {
+	id, _ := r.Context().Value(contextKey{}).(string)
+	return id
+}
+
+// Wraps h so each request carries an ID in its context and the response
+// echoes it in the X-Request-ID header. Incoming IDs failing IsValid are
+// replaced rather than rejected.
+func Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(HEADER_NAME)
+		if !IsValid(id) {
+			if id != "" {
+				log.Debug("requestid: Discarding invalid incoming request ID.")
+			}
+			var err error
+			if id, err = newID(); err != nil {
+				log.Error(err)
+			}
+		}
+
+		if id != "" {
+			r.Header.Set(HEADER_NAME, id)
+			w.Header().Set(HEADER_NAME, id)
+			r = r.WithContext(context.WithValue(r.Context(), contextKey{}, id))
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,55 @@
+//  Copyright (C) Pat Kaehuaea - All Rights Reserved
+//  Unauthorized copying of this file, via any medium is strictly prohibited
+//  Proprietary and confidential
+//  Written by Pat Kaehuaea, March 2015
+
+package requestid
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	tests := []struct {
+		name     string
+		incoming string
+		adopted  bool
+	}{
+		{"missing", "", false},
+		{"valid", "gw-7f3a.12_x", true},
+		{"too long", strings.Repeat("a", 129), false},
+		{"unsafe characters", "id\r\nSet-Cookie: x=y", false},
+		{"space", "two words", false},
+	}
+	for _, tt := range tests {
+		var seen string
+		h := Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			seen = FromRequest(r)
+		}))
+		r := httptest.NewRequest("GET", "/", nil)
+		if tt.incoming != "" {
+			r.Header.Set(HEADER_NAME, tt.incoming)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		echoed := w.Header().Get(HEADER_NAME)
+		if !IsValid(seen) || echoed != seen {
+			t.Errorf("%s: handler saw %q, response echoed %q", tt.name, seen, echoed)
+		}
+		if adopted := seen == tt.incoming; adopted != tt.adopted {
+			t.Errorf("%s: incoming ID adopted %v, want %v", tt.name, adopted, tt.adopted)
+		}
+	}
+}
+
+func TestGeneratedIDsDiffer(t *testing.T) {
+	first, _ := newID()
+	second, _ := newID()
+	if first == second || len(first) != 2*ID_BYTES {
+		t.Errorf("newID() = %q then %q, want distinct %d character IDs", first, second, 2*ID_BYTES)
+	}
+}
@@ -0,0 +1,106 @@
+//  Copyright (C) Pat Kaehuaea - All Rights Reserved
+//  Unauthorized copying of this file, via any medium is strictly prohibited
+//  Proprietary and confidential
+//  Written by Pat Kaehuaea, March 2015
+//
+// Package serves the stylesheets, scripts and images referenced by the
+// templates. Builtin holds the assets under files, built into the binary,
+// and Handler serves any file system of assets with validators and caching
+// headers so browsers revalidate cheaply instead of downloading again.
+// Directories are never listed.
+package static
+
+import (
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//go:embed files
+var files embed.FS
+
+// Assets built into the binary.
+var Builtin fs.FS
+
+func init() {
+	var err error
+	if Builtin, err = fs.Sub(files, "files"); err != nil {
+		panic(err)
+	}
+}
+
+// Identifies a version of a file for caching its ETag.
+type version struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+// Serves files from fsys with the request path, less any prefix already
+// stripped, as the name. Responses carry an ETag, a hash of the content,
+// Last-Modified when the file system records modification times, which
+// embedded files do not, and Cache-Control with maxAge. Conditional and
+// range requests are answered by http.ServeContent. Directories, and
+// paths ending in a slash, are 404 so nothing is listed.
+func Handler(fsys fs.FS, maxAge time.Duration) http.Handler {
+	cacheControl := "public, max-age=" + strconv.Itoa(int(maxAge.Seconds()))
+	var etags sync.Map
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+		if name == "" || strings.HasSuffix(r.URL.Path, "/") || !fs.ValidPath(name) {
+			http.NotFound(w, r)
+			return
+		}
+		f, err := fsys.Open(name)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil || info.IsDir() {
+			http.NotFound(w, r)
+			return
+		}
+		content, ok := f.(io.ReadSeeker)
+		if !ok {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		key := version{name, info.Size(), info.ModTime()}
+		etag, cached := etags.Load(key)
+		if !cached {
+			sum := sha256.New()
+			if _, err = io.Copy(sum, content); err != nil {
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			etag = `"` + hex.EncodeToString(sum.Sum(nil)[:16]) + `"`
+			etags.Store(key, etag)
+			if _, err = content.Seek(0, io.SeekStart); err != nil {
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		w.Header().Set("ETag", etag.(string))
+		w.Header().Set("Cache-Control", cacheControl)
+		http.ServeContent(w, r, name, info.ModTime(), content)
+	})
+}
@@ -0,0 +1,190 @@
+//  Copyright (C) Pat Kaehuaea - All Rights Reserved
+//  Unauthorized copying of this file, via any medium is strictly prohibited
+//  Proprietary and confidential
+//  Written by Pat Kaehuaea, March 2015
+
+package static
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestCacheHeaders(t *testing.T) {
+	modTime := time.Date(2015, time.March, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name         string
+		fsys         fstest.MapFS
+		target       string
+		maxAge       time.Duration
+		cacheControl string
+		lastModified string
+	}{
+		{"modified", fstest.MapFS{"app.js": {Data: []byte("tick()"), ModTime: modTime}}, "/app.js", time.Hour, "public, max-age=3600", modTime.Format(http.TimeFormat)},
+		{"no modification time", fstest.MapFS{"app.js": {Data: []byte("tick()")}}, "/app.js", 0, "public, max-age=0", ""},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		Handler(tt.fsys, tt.maxAge).ServeHTTP(w, httptest.NewRequest("GET", tt.target, nil))
+		if w.Code != http.StatusOK || w.Body.String() != "tick()" {
+			t.Errorf("%s: %d %q, want %d tick()", tt.name, w.Code, w.Body.String(), http.StatusOK)
+		}
+		if got := w.Header().Get("Cache-Control"); got != tt.cacheControl {
+			t.Errorf("%s: Cache-Control = %q, want %q", tt.name, got, tt.cacheControl)
+		}
+		if got := w.Header().Get("Last-Modified"); got != tt.lastModified {
+			t.Errorf("%s: Last-Modified = %q, want %q", tt.name, got, tt.lastModified)
+		}
+		if etag := w.Header().Get("ETag"); len(etag) != 34 || !strings.HasPrefix(etag, `"`) || !strings.HasSuffix(etag, `"`) {
+			t.Errorf("%s: ETag = %q, want a quoted 32 digit hash", tt.name, etag)
+		}
+		if got := w.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/javascript") {
+			t.Errorf("%s: Content-Type = %q, want text/javascript", tt.name, got)
+		}
+	}
+}
+
+func TestETag(t *testing.T) {
+	fsys := fstest.MapFS{"style.css": {Data: []byte("body {}"), ModTime: time.Now()}}
+	h := Handler(fsys, time.Hour)
+	etag := func() string {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/style.css", nil))
+		return w.Header().Get("ETag")
+	}
+	first := etag()
+	if again := etag(); again != first {
+		t.Errorf("ETag changed from %s to %s for the same file", first, again)
+	}
+	fsys["style.css"] = &fstest.MapFile{Data: []byte("body {color: red}"), ModTime: time.Now().Add(time.Second)}
+	if changed := etag(); changed == first {
+		t.Errorf("ETag %s kept after the file changed", first)
+	}
+}
+
+func TestNotModified(t *testing.T) {
+	modTime := time.Date(2015, time.March, 1, 12, 0, 0, 0, time.UTC)
+	h := Handler(fstest.MapFS{"style.css": {Data: []byte("body {}"), ModTime: modTime}}, time.Hour)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/style.css", nil))
+	etag := w.Header().Get("ETag")
+
+	tests := []struct {
+		name   string
+		header string
+		value  string
+		status int
+	}{
+		{"matching ETag", "If-None-Match", etag, http.StatusNotModified},
+		{"weak matching ETag", "If-None-Match", "W/" + etag, http.StatusNotModified},
+		{"stale ETag", "If-None-Match", `"stale"`, http.StatusOK},
+		{"not modified since", "If-Modified-Since", modTime.Format(http.TimeFormat), http.StatusNotModified},
+		{"modified since", "If-Modified-Since", modTime.Add(-time.Hour).Format(http.TimeFormat), http.StatusOK},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/style.css", nil)
+		r.Header.Set(tt.header, tt.value)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, tt.status)
+		}
+		if tt.status == http.StatusNotModified && (w.Body.Len() != 0 || w.Header().Get("ETag") != etag || w.Header().Get("Cache-Control") == "") {
+			t.Errorf("%s: 304 with %d byte body, ETag %q, Cache-Control %q", tt.name, w.Body.Len(), w.Header().Get("ETag"), w.Header().Get("Cache-Control"))
+		}
+	}
+}
+
+func TestNotServed(t *testing.T) {
+	h := Handler(fstest.MapFS{"img/logo.png": {Data: []byte("png")}}, time.Hour)
+	tests := []struct {
+		method string
+		target string
+		status int
+	}{
+		{"GET", "/", http.StatusNotFound},
+		{"GET", "/img", http.StatusNotFound},
+		{"GET", "/img/", http.StatusNotFound},
+		{"GET", "/img/logo.png/", http.StatusNotFound},
+		{"GET", "/missing.css", http.StatusNotFound},
+		{"POST", "/img/logo.png", http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(tt.method, tt.target, nil))
+		if w.Code != tt.status {
+			t.Errorf("%s %s: status = %d, want %d", tt.method, tt.target, w.Code, tt.status)
+		}
+		if w.Header().Get("Cache-Control") != "" {
+			t.Errorf("%s %s: refusal cacheable as %q", tt.method, tt.target, w.Header().Get("Cache-Control"))
+		}
+	}
+}
+
+func TestRange(t *testing.T) {
+	content := strings.Repeat("0123456789", 10)
+	h := Handler(fstest.MapFS{"font.woff2": {Data: []byte(content), ModTime: time.Now()}}, time.Hour)
+	tests := []struct {
+		rng    string
+		status int
+		body   string
+		span   string
+	}{
+		{"", http.StatusOK, content, ""},
+		{"bytes=10-19", http.StatusPartialContent, content[10:20], "bytes 10-19/100"},
+		{"bytes=95-", http.StatusPartialContent, content[95:], "bytes 95-99/100"},
+		{"bytes=-3", http.StatusPartialContent, content[97:], "bytes 97-99/100"},
+		{"bytes=200-300", http.StatusRequestedRangeNotSatisfiable, "", "bytes */100"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/font.woff2", nil)
+		if tt.rng != "" {
+			r.Header.Set("Range", tt.rng)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tt.status {
+			t.Errorf("%q: status = %d, want %d", tt.rng, w.Code, tt.status)
+			continue
+		}
+		if tt.status != http.StatusRequestedRangeNotSatisfiable && w.Body.String() != tt.body {
+			t.Errorf("%q: body = %q, want %q", tt.rng, w.Body.String(), tt.body)
+		}
+		if got := w.Header().Get("Content-Range"); got != tt.span {
+			t.Errorf("%q: Content-Range = %q, want %q", tt.rng, got, tt.span)
+		}
+	}
+}
+
+// A range conditional on a stale ETag gets the whole current file.
+func TestIfRange(t *testing.T) {
+	h := Handler(Builtin, time.Hour)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/style.css", nil))
+	etag, size := w.Header().Get("ETag"), w.Body.Len()
+	if etag == "" || size < 2 {
+		t.Fatalf("style.css served with ETag %q and %d bytes", etag, size)
+	}
+
+	tests := []struct {
+		ifRange string
+		status  int
+	}{
+		{etag, http.StatusPartialContent},
+		{`"stale"`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/style.css", nil)
+		r.Header.Set("Range", "bytes=0-0")
+		r.Header.Set("If-Range", tt.ifRange)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tt.status {
+			t.Errorf("If-Range %s: status = %d, want %d", tt.ifRange, w.Code, tt.status)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+//  Copyright (C) Pat Kaehuaea - All Rights Reserved
+//  Unauthorized copying of this file, via any medium is strictly prohibited
+//  Proprietary and confidential
+//  Written by Pat Kaehuaea, March 2015
+//
+// Package bounds how long a handler may take to answer. The request
+// context is cancelled once the limit passes, so downstream work such as
+// calls to authserver gives up, and the client is answered by a fallback
+// handler instead. Responses are held back until the handler returns so
+// the fallback never follows a partial body. A handler that flushes or
+// hijacks is streaming, and from then on is no longer limited, as streams
+// and websockets are expected to outlive any request timeout.
+package timeout
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Cause of the request context's cancellation once the limit passes, and
+// returned by writes from a handler that has been cut off.
+var ErrTimeout = errors.New("timeout: Request took too long.")
+
+// Returns middleware giving each request limit to answer. Requests over
+// the limit are answered by expired, which is called with the original,
+// uncancelled, request. A limit of zero or less returns handlers
+// unchanged.
+func Handler(limit time.Duration, expired http.Handler) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		if limit <= 0 {
+			return h
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithCancelCause(r.Context())
+			defer cancel(nil)
+
+			tw := &writer{w: w, header: make(http.Header), streaming: make(chan struct{})}
+			done := make(chan struct{})
+			panicked := make(chan interface{}, 1)
+			go func() {
+				defer func() {
+					if p := recover(); p != nil {
+						panicked <- p
+					}
+				}()
+				h.ServeHTTP(tw, r.WithContext(ctx))
+				close(done)
+			}()
+
+			timer := time.NewTimer(limit)
+			defer timer.Stop()
+			select {
+			case p := <-panicked:
+				panic(p)
+			case <-done:
+				tw.finish()
+				return
+			case <-tw.streaming:
+			case <-timer.C:
+				select {
+				case <-done:
+					tw.finish()
+					return
+				default:
+				}
+				tw.Lock()
+				if !tw.passThrough {
+					tw.expired = true
+					tw.Unlock()
+					cancel(ErrTimeout)
+					expired.ServeHTTP(w, r)
+					return
+				}
+				tw.Unlock()
+			}
+
+			// Streaming, so the handler is left to end on its own.
+			select {
+			case p := <-panicked:
+				panic(p)
+			case <-done:
+			}
+		})
+	}
+}
+
+// Response writer recording headers, status and body until the handler
+// returns, flushes or hijacks, whichever comes first. Until then another
+// goroutine may give up on the handler, so state is guarded by the mutex.
+type writer struct {
+	sync.Mutex
+	w           http.ResponseWriter
+	header      http.Header
+	status      int
+	buf         bytes.Buffer
+	expired     bool
+	passThrough bool
+	streaming   chan struct{}
+}
+
+func (tw *writer) Header() http.Header {
+	return tw.header
+}
+
+func (tw *writer) WriteHeader(status int) {
+	tw.Lock()
+	defer tw.Unlock()
+	if tw.passThrough {
+		tw.w.WriteHeader(status)
+		return
+	}
+	if tw.status == 0 && !tw.expired {
+		tw.status = status
+	}
+}
+
+func (tw *writer) Write(b []byte) (int, error) {
+	tw.Lock()
+	defer tw.Unlock()
+	if tw.expired {
+		return 0, ErrTimeout
+	}
+	if tw.passThrough {
+		return tw.w.Write(b)
+	}
+	if tw.status == 0 {
+		tw.status = http.StatusOK
+	}
+	return tw.buf.Write(b)
+}
+
+// Sends what the handler recorded. Called with the lock held.
+func (tw *writer) commit() {
+	dst := tw.w.Header()
+	for k, v := range tw.header {
+		dst[k] = v
+	}
+	// Later header changes go straight to the client's headers.
+	tw.header = dst
+	if tw.status != 0 {
+		tw.w.WriteHeader(tw.status)
+	}
+	if tw.buf.Len() > 0 {
+		tw.w.Write(tw.buf.Bytes())
+		tw.buf.Reset()
+	}
+	tw.passThrough = true
+}
+
+// Sends the response once the handler has returned within the limit.
+func (tw *writer) finish() {
+	tw.Lock()
+	defer tw.Unlock()
+	if !tw.passThrough {
+		tw.commit()
+	}
+}
+
+// Marks the response as streaming, sending what was recorded, and lifts
+// the limit. Called with the lock held. Returns false if the handler was
+// already cut off.
+func (tw *writer) stream() bool {
+	if tw.expired {
+		return false
+	}
+	if !tw.passThrough {
+		tw.commit()
+		close(tw.streaming)
+	}
+	return true
+}
+
+func (tw *writer) Flush() {
+	tw.Lock()
+	defer tw.Unlock()
+	if !tw.stream() {
+		return
+	}
+	if f, ok := tw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijacks the underlying connection, which lifts the limit. Fails once
+// the handler has been cut off.
+func (tw *writer) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	tw.Lock()
+	defer tw.Unlock()
+	hj, ok := tw.w.(http.Hijacker)
+	if !ok || tw.expired {
+		return nil, nil, errors.New("timeout: Connection cannot be hijacked.")
+	}
+	if !tw.passThrough {
+		tw.header = tw.w.Header()
+		tw.passThrough = true
+		close(tw.streaming)
+	}
+	return hj.Hijack()
+}
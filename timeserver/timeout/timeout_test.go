@@ -0,0 +1,204 @@
+//  Copyright (C) Pat Kaehuaea - All Rights Reserved
+//  Unauthorized copying of this file, via any medium is strictly prohibited
+//  Proprietary and confidential
+//  Written by Pat Kaehuaea, March 2015
+
+package timeout
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Limit given to handlers under test, and a wait comfortably past it.
+const (
+	LIMIT = 20 * time.Millisecond
+	PAST  = 5 * LIMIT
+)
+
+// Fallback answering expired requests with 504.
+var gatewayTimeout = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	http.Error(w, "too slow", http.StatusGatewayTimeout)
+})
+
+func TestWithinLimit(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   int
+	}{
+		{"status and body", http.StatusCreated, "made", http.StatusCreated},
+		{"implicit status", 0, "hello", http.StatusOK},
+		{"status only", http.StatusNoContent, "", http.StatusNoContent},
+		{"nothing written", 0, "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		h := Handler(LIMIT, gatewayTimeout)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Test", "kept")
+			if tt.status != 0 {
+				w.WriteHeader(tt.status)
+				w.WriteHeader(http.StatusTeapot)
+			}
+			io.WriteString(w, tt.body)
+		}))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+		if w.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, tt.want)
+		}
+		if w.Body.String() != tt.body {
+			t.Errorf("%s: body = %q, want %q", tt.name, w.Body.String(), tt.body)
+		}
+		if w.Header().Get("X-Test") != "kept" {
+			t.Errorf("%s: handler's header not sent", tt.name)
+		}
+		if w.Flushed {
+			t.Errorf("%s: buffered response was flushed", tt.name)
+		}
+	}
+}
+
+func TestExpired(t *testing.T) {
+	cause := make(chan error, 1)
+	written := make(chan error, 1)
+	h := Handler(LIMIT, gatewayTimeout)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Test", "leaked")
+		io.WriteString(w, "partial")
+		<-r.Context().Done()
+		cause <- context.Cause(r.Context())
+		_, err := io.WriteString(w, "late")
+		written <- err
+	}))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	if w.Code != http.StatusGatewayTimeout {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusGatewayTimeout)
+	}
+	if body := w.Body.String(); body != "too slow\n" {
+		t.Errorf("body = %q, want only the fallback's", body)
+	}
+	if w.Header().Get("X-Test") != "" {
+		t.Error("expired handler's header sent")
+	}
+	if err := <-cause; err != ErrTimeout {
+		t.Errorf("request context cancelled by %v, want ErrTimeout", err)
+	}
+	if err := <-written; err != ErrTimeout {
+		t.Errorf("late write error %v, want ErrTimeout", err)
+	}
+}
+
+func TestNoLimit(t *testing.T) {
+	for _, limit := range []time.Duration{0, -time.Second} {
+		w := httptest.NewRecorder()
+		Handler(limit, gatewayTimeout)(http.HandlerFunc(func(got http.ResponseWriter, r *http.Request) {
+			if got != http.ResponseWriter(w) {
+				t.Errorf("limit %v: handler wrapped", limit)
+			}
+		})).ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	}
+}
+
+func TestFlushStreams(t *testing.T) {
+	h := Handler(LIMIT, gatewayTimeout)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "first ")
+		w.(http.Flusher).Flush()
+		time.Sleep(PAST)
+		if err := r.Context().Err(); err != nil {
+			t.Errorf("streaming request cancelled - %v", err)
+		}
+		io.WriteString(w, "second")
+	}))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	if w.Code != http.StatusOK || w.Body.String() != "first second" {
+		t.Errorf("streamed response = %d %q, want %d %q", w.Code, w.Body.String(), http.StatusOK, "first second")
+	}
+	if !w.Flushed {
+		t.Error("Flush() not passed on")
+	}
+}
+
+func TestHijackStreams(t *testing.T) {
+	h := Handler(LIMIT, gatewayTimeout)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		time.Sleep(PAST)
+		rw.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 8\r\nConnection: close\r\n\r\nhijacked")
+		rw.Flush()
+	}))
+	server := httptest.NewServer(h)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "hijacked" {
+		t.Errorf("hijacked response = %d %q, want %d %q", resp.StatusCode, body, http.StatusOK, "hijacked")
+	}
+}
+
+func TestHijackAfterExpiry(t *testing.T) {
+	hijacked := make(chan error, 1)
+	h := Handler(LIMIT, gatewayTimeout)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		_, _, err := w.(http.Hijacker).Hijack()
+		hijacked <- err
+	}))
+	server := httptest.NewServer(h)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusGatewayTimeout {
+		t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusGatewayTimeout)
+	}
+	if err := <-hijacked; err == nil {
+		t.Error("Hijack() succeeded after the handler was cut off")
+	}
+}
+
+func TestPanic(t *testing.T) {
+	h := Handler(LIMIT, gatewayTimeout)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("handler failed")
+	}))
+	defer func() {
+		if p := recover(); p != "handler failed" {
+			t.Errorf("recovered %v, want the handler's panic", p)
+		}
+	}()
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	t.Error("panic not propagated")
+}
+
+func TestPanicWhileStreaming(t *testing.T) {
+	h := Handler(LIMIT, gatewayTimeout)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}))
+	defer func() {
+		if p := recover(); p != http.ErrAbortHandler {
+			t.Errorf("recovered %v, want http.ErrAbortHandler", p)
+		}
+	}()
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	t.Error("panic not propagated")
+}
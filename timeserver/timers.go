@@ -0,0 +1,282 @@
+//  Copyright (C) Pat Kaehuaea - All Rights Reserved
+//  Unauthorized copying of this file, via any medium is strictly prohibited
+//  Proprietary and confidential
+//  Written by Pat Kaehuaea, March 2015
+//
+// Personal stopwatch and countdown of the logged in user. Both are kept
+// by authserver on the user's record so they survive restarts and follow
+// the session between browsers, and both run on the clock the time pages
+// report. /countdown/ws pushes the time left to the countdown page.
+
+package main
+
+import (
+	"fmt"
+	log "github.com/cihub/seelog"
+	"github.com/gorilla/websocket"
+	"github.com/patkaehuaea/command/authserver/people"
+	"github.com/patkaehuaea/command/config"
+	"github.com/patkaehuaea/command/timeserver/cookie"
+	"net/http"
+	"time"
+)
+
+const COUNTDOWN_PARAM = "d"
+
+// Lap as shown on the stopwatch page. Split is the time since the
+// previous lap and Total the stopwatch's reading when it was taken.
+type lap struct {
+	Number int
+	Split  string
+	Total  string
+}
+
+// Data of the stopwatch template. Millis is the reading in milliseconds
+// for the script keeping a running stopwatch ticking.
+type stopwatchPage struct {
+	Running bool
+	Reading string
+	Millis  int64
+	Laps    []lap
+}
+
+// Data of the countdown template. Set is false when no countdown was
+// started, and Remaining reads zero once it has ended.
+type countdownPage struct {
+	Set       bool
+	Ended     bool
+	Remaining string
+	Duration  string
+	Message   string
+}
+
+// Formats d as a stopwatch shows it, such as 1:02:03.45, leaving out the
+// hours under an hour. Hundredths are left out unless fractions is true.
+func formatTimer(d time.Duration, fractions bool) string {
+	if d < 0 {
+		d = 0
+	}
+	h, m, s := int(d/time.Hour), int(d/time.Minute)%60, int(d/time.Second)%60
+	text := fmt.Sprintf("%02d:%02d", m, s)
+	if h > 0 {
+		text = fmt.Sprintf("%d:%s", h, text)
+	}
+	if fractions {
+		text += fmt.Sprintf(".%02d", int(d/(10*time.Millisecond))%100)
+	}
+	return text
+}
+
+// Returns the countdown's time left at t rounded up to the second, so the
+// page reads 10:00 as a ten minute countdown starts and 00:00 only once it
+// has ended.
+func countdownLeft(c people.Countdown, t time.Time) time.Duration {
+	left := c.Remaining(t)
+	if rounded := left.Truncate(time.Second); rounded != left {
+		left = rounded + time.Second
+	}
+	return left
+}
+
+// Returns the uuid of the logged in user, sending anyone else to login
+// first, after which they return to path. ok is false when redirected.
+func sessionOrLogin(w http.ResponseWriter, r *http.Request, path string) (uuid string, ok bool) {
+	uuid, err := cookie.UUID(r)
+	if err != nil {
+		http.Redirect(w, r, "/login?"+RETURN_PARAM+"="+path, http.StatusFound)
+		return "", false
+	}
+	return uuid, true
+}
+
+// Shows the logged in user's stopwatch as it reads now, with its laps.
+func handleDisplayStopwatch(w http.ResponseWriter, r *http.Request) {
+	uuid, ok := sessionOrLogin(w, r, "/stopwatch")
+	if !ok {
+		return
+	}
+	sw, err := authClient.Stopwatch(r.Context(), uuid)
+	if err != nil {
+		log.Error(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		renderTemplate(w, r, "500", nil)
+		return
+	}
+
+	reading := sw.Reading(now())
+	data := stopwatchPage{Running: sw.Running(), Reading: formatTimer(reading, true), Millis: reading.Milliseconds()}
+	var previous time.Duration
+	for i, total := range sw.Laps {
+		data.Laps = append(data.Laps, lap{i + 1, formatTimer(total-previous, true), formatTimer(total, true)})
+		previous = total
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	renderTemplate(w, r, "stopwatch", data)
+}
+
+// Starts, stops, laps or resets the logged in user's stopwatch with the
+// action form value and returns them to the stopwatch.
+func handleProcessStopwatch(w http.ResponseWriter, r *http.Request) {
+	uuid, ok := sessionOrLogin(w, r, "/stopwatch")
+	if !ok {
+		return
+	}
+	action := r.FormValue("action")
+	if !people.IsValidStopwatchAction(action) {
+		w.WriteHeader(http.StatusBadRequest)
+		renderTemplate(w, r, "400", "unknown stopwatch action")
+		return
+	}
+	if _, err := authClient.UpdateStopwatch(r.Context(), uuid, action, now()); err != nil {
+		log.Error(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		renderTemplate(w, r, "500", nil)
+		return
+	}
+	http.Redirect(w, r, "/stopwatch", http.StatusSeeOther)
+}
+
+// Shows the time left on the logged in user's countdown. With ?d, such
+// as ?d=10m, starts a countdown of that length first, replacing any
+// running one, then redirects so refreshing does not restart it.
+func handleCountdown(w http.ResponseWriter, r *http.Request) {
+	uuid, ok := sessionOrLogin(w, r, "/countdown")
+	if !ok {
+		return
+	}
+
+	if param := r.FormValue(COUNTDOWN_PARAM); param != "" {
+		d, err := time.ParseDuration(param)
+		if err != nil || !people.IsValidCountdown(d) {
+			w.WriteHeader(http.StatusBadRequest)
+			message := fmt.Sprintf("Countdowns are a duration such as 90s, 10m or 1h30m, up to %s.", people.MAX_COUNTDOWN)
+			renderTemplate(w, r, "countdown", countdownPage{Message: message})
+			return
+		}
+		c := people.Countdown{Ends: now().Add(d), Duration: d}
+		if err = authClient.SetCountdown(r.Context(), uuid, c); err != nil {
+			log.Error(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			renderTemplate(w, r, "500", nil)
+			return
+		}
+		http.Redirect(w, r, "/countdown", http.StatusSeeOther)
+		return
+	}
+
+	c, err := authClient.Countdown(r.Context(), uuid)
+	if err != nil {
+		log.Error(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		renderTemplate(w, r, "500", nil)
+		return
+	}
+	data := countdownPage{Set: c.Set()}
+	if data.Set {
+		left := countdownLeft(c, now())
+		data.Ended = left == 0
+		data.Remaining = formatTimer(left, false)
+		data.Duration = c.Duration.String()
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	renderTemplate(w, r, "countdown", data)
+}
+
+// Clears the logged in user's countdown and returns them to the page.
+func handleCancelCountdown(w http.ResponseWriter, r *http.Request) {
+	uuid, ok := sessionOrLogin(w, r, "/countdown")
+	if !ok {
+		return
+	}
+	if err := authClient.SetCountdown(r.Context(), uuid, people.Countdown{}); err != nil {
+		log.Error(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		renderTemplate(w, r, "500", nil)
+		return
+	}
+	http.Redirect(w, r, "/countdown", http.StatusSeeOther)
+}
+
+// Pushes the time left on the logged in user's countdown once per
+// --stream-interval, closing normally once it reaches zero. Follows
+// handleTimeWebSocket, including the goodbye on shutdown.
+func handleCountdownWebSocket(w http.ResponseWriter, r *http.Request) {
+	uuid, err := cookie.UUID(r)
+	if err != nil {
+		w.WriteHeader(http.StatusForbidden)
+		renderTemplate(w, r, "403", "log in to follow a countdown")
+		return
+	}
+	c, err := authClient.Countdown(r.Context(), uuid)
+	if err != nil {
+		log.Error(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		renderTemplate(w, r, "500", nil)
+		return
+	}
+
+	quit, leave, ok := streams.Join()
+	if !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		renderTemplate(w, r, "503", nil)
+		return
+	}
+	defer leave()
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already replied to the client with an error.
+		log.Warn(err)
+		return
+	}
+	defer conn.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		conn.SetReadDeadline(time.Now().Add(WS_PONG_WAIT))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(WS_PONG_WAIT))
+		})
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				log.Debug("timeserver: Countdown websocket closed - " + err.Error())
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(*config.StreamIntvl)
+	defer ticker.Stop()
+	ping := time.NewTicker(WS_PING_PERIOD)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-quit:
+			msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+			conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(WS_WRITE_WAIT))
+			return
+		case <-ticker.C:
+			left := countdownLeft(c, now())
+			conn.SetWriteDeadline(time.Now().Add(WS_WRITE_WAIT))
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(formatTimer(left, false))); err != nil {
+				log.Debug(err)
+				return
+			}
+			if left == 0 {
+				msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "countdown ended")
+				conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(WS_WRITE_WAIT))
+				return
+			}
+		case <-ping.C:
+			conn.SetWriteDeadline(time.Now().Add(WS_WRITE_WAIT))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				log.Debug(err)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,253 @@
+//  Copyright (C) Pat Kaehuaea - All Rights Reserved
+//  Unauthorized copying of this file, via any medium is strictly prohibited
+//  Proprietary and confidential
+//  Written by Pat Kaehuaea, March 2015
+
+package main
+
+import (
+	"github.com/gorilla/websocket"
+	"github.com/patkaehuaea/command/authserver/people"
+	"github.com/patkaehuaea/command/config"
+	"github.com/patkaehuaea/command/timeserver/cookie"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatTimer(t *testing.T) {
+	tests := []struct {
+		d         time.Duration
+		fractions bool
+		want      string
+	}{
+		{0, false, "00:00"},
+		{0, true, "00:00.00"},
+		{-time.Second, true, "00:00.00"},
+		{1234 * time.Millisecond, true, "00:01.23"},
+		{1234 * time.Millisecond, false, "00:01"},
+		{59*time.Minute + 59*time.Second, false, "59:59"},
+		{time.Hour + 2*time.Minute + 3*time.Second + 450*time.Millisecond, true, "1:02:03.45"},
+		{25 * time.Hour, false, "25:00:00"},
+	}
+	for _, tt := range tests {
+		if got := formatTimer(tt.d, tt.fractions); got != tt.want {
+			t.Errorf("formatTimer(%v, %v) = %q, want %q", tt.d, tt.fractions, got, tt.want)
+		}
+	}
+}
+
+func TestCountdownLeft(t *testing.T) {
+	c := people.Countdown{Ends: FIXED_NOW.Add(10 * time.Minute), Duration: 10 * time.Minute}
+	tests := []struct {
+		at   time.Time
+		want time.Duration
+	}{
+		{FIXED_NOW, 10 * time.Minute},
+		{FIXED_NOW.Add(time.Millisecond), 10 * time.Minute},
+		{FIXED_NOW.Add(time.Second), 9*time.Minute + 59*time.Second},
+		{c.Ends.Add(-time.Millisecond), time.Second},
+		{c.Ends, 0},
+		{c.Ends.Add(time.Hour), 0},
+	}
+	for _, tt := range tests {
+		if got := countdownLeft(c, tt.at); got != tt.want {
+			t.Errorf("countdownLeft(%v) = %v, want %v", tt.at, got, tt.want)
+		}
+	}
+}
+
+func TestTimersNeedSession(t *testing.T) {
+	tests := []struct {
+		method   string
+		target   string
+		fn       func(w http.ResponseWriter, r *http.Request)
+		location string
+	}{
+		{"GET", "/stopwatch", handleDisplayStopwatch, "/login?" + RETURN_PARAM + "=/stopwatch"},
+		{"POST", "/stopwatch", handleProcessStopwatch, "/login?" + RETURN_PARAM + "=/stopwatch"},
+		{"GET", "/countdown?d=10m", handleCountdown, "/login?" + RETURN_PARAM + "=/countdown"},
+		{"POST", "/countdown", handleCancelCountdown, "/login?" + RETURN_PARAM + "=/countdown"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		tt.fn(w, httptest.NewRequest(tt.method, tt.target, nil))
+		if w.Code != http.StatusFound || w.Header().Get("Location") != tt.location {
+			t.Errorf("%s %s: %d to %q, want %d to %q", tt.method, tt.target, w.Code, w.Header().Get("Location"), http.StatusFound, tt.location)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	handleCountdownWebSocket(w, httptest.NewRequest("GET", "/countdown/ws", nil))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("GET /countdown/ws: status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
+
+func TestDisplayStopwatch(t *testing.T) {
+	withFixedNow(t)
+	users := withAuthStub(t, people.NO_CAPACITY_LIMIT)
+	users.Add(TEST_UUID, "Ada")
+	users.UpdateStopwatch(TEST_UUID, "start", FIXED_NOW.Add(-90*time.Second))
+	users.UpdateStopwatch(TEST_UUID, "lap", FIXED_NOW.Add(-60*time.Second))
+	users.UpdateStopwatch(TEST_UUID, "lap", FIXED_NOW.Add(-15*time.Second))
+
+	w := httptest.NewRecorder()
+	handleDisplayStopwatch(w, sessionRequest("GET", "/stopwatch", TEST_UUID))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	body := w.Body.String()
+	for _, want := range []string{"01:30.00", "<td>1</td><td>00:30.00</td><td>00:30.00</td>", "<td>2</td><td>00:45.00</td><td>01:15.00</td>"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("stopwatch page lacks %q", want)
+		}
+	}
+	if cc := w.Header().Get("Cache-Control"); cc != "no-store" {
+		t.Errorf("Cache-Control = %q, want no-store", cc)
+	}
+}
+
+func TestProcessStopwatch(t *testing.T) {
+	withFixedNow(t)
+	tests := []struct {
+		action  string
+		known   bool
+		status  int
+		running bool
+	}{
+		{"start", true, http.StatusSeeOther, true},
+		{"stop", true, http.StatusSeeOther, false},
+		{"pause", true, http.StatusBadRequest, false},
+		{"", true, http.StatusBadRequest, false},
+		{"start", false, http.StatusInternalServerError, false},
+	}
+	for _, tt := range tests {
+		users := withAuthStub(t, people.NO_CAPACITY_LIMIT)
+		if tt.known {
+			users.Add(TEST_UUID, "Ada")
+		}
+		w := httptest.NewRecorder()
+		handleProcessStopwatch(w, sessionForm("/stopwatch", TEST_UUID, url.Values{"action": {tt.action}}))
+		if w.Code != tt.status {
+			t.Errorf("%q: status = %d, want %d", tt.action, w.Code, tt.status)
+		}
+		if tt.status == http.StatusSeeOther && w.Header().Get("Location") != "/stopwatch" {
+			t.Errorf("%q: redirected to %q, want /stopwatch", tt.action, w.Header().Get("Location"))
+		}
+		if sw := users.Stopwatch(TEST_UUID); sw.Running() != tt.running {
+			t.Errorf("%q: stopwatch running %v, want %v", tt.action, sw.Running(), tt.running)
+		}
+	}
+}
+
+func TestStartCountdown(t *testing.T) {
+	withFixedNow(t)
+	tests := []struct {
+		d      string
+		known  bool
+		status int
+		want   time.Duration
+	}{
+		{"10m", true, http.StatusSeeOther, 10 * time.Minute},
+		{"1h30m", true, http.StatusSeeOther, 90 * time.Minute},
+		{people.MAX_COUNTDOWN.String(), true, http.StatusSeeOther, people.MAX_COUNTDOWN},
+		{"25h", true, http.StatusBadRequest, 0},
+		{"0s", true, http.StatusBadRequest, 0},
+		{"-5m", true, http.StatusBadRequest, 0},
+		{"soon", true, http.StatusBadRequest, 0},
+		{"10m", false, http.StatusInternalServerError, 0},
+	}
+	for _, tt := range tests {
+		users := withAuthStub(t, people.NO_CAPACITY_LIMIT)
+		if tt.known {
+			users.Add(TEST_UUID, "Ada")
+		}
+		w := httptest.NewRecorder()
+		handleCountdown(w, sessionRequest("GET", "/countdown?"+COUNTDOWN_PARAM+"="+url.QueryEscape(tt.d), TEST_UUID))
+		if w.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.d, w.Code, tt.status)
+		}
+		c := users.Countdown(TEST_UUID)
+		if c.Duration != tt.want || (tt.want != 0 && !c.Ends.Equal(FIXED_NOW.Add(tt.want))) {
+			t.Errorf("%s: countdown = %+v, want %v from now", tt.d, c, tt.want)
+		}
+	}
+}
+
+func TestDisplayCountdown(t *testing.T) {
+	withFixedNow(t)
+	tests := []struct {
+		name      string
+		countdown people.Countdown
+		want      []string
+	}{
+		{"none", people.Countdown{}, nil},
+		{"running", people.Countdown{Ends: FIXED_NOW.Add(10 * time.Minute), Duration: 10 * time.Minute}, []string{"10:00", "(10m0s)", "/countdown/ws"}},
+		{"ended", people.Countdown{Ends: FIXED_NOW.Add(-time.Second), Duration: time.Minute}, []string{"00:00", "The countdown has ended."}},
+	}
+	for _, tt := range tests {
+		users := withAuthStub(t, people.NO_CAPACITY_LIMIT)
+		users.Add(TEST_UUID, "Ada")
+		users.SetCountdown(TEST_UUID, tt.countdown)
+
+		w := httptest.NewRecorder()
+		handleCountdown(w, sessionRequest("GET", "/countdown", TEST_UUID))
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, http.StatusOK)
+		}
+		body := w.Body.String()
+		for _, want := range tt.want {
+			if !strings.Contains(body, want) {
+				t.Errorf("%s: countdown page lacks %q", tt.name, want)
+			}
+		}
+		if !tt.countdown.Set() && strings.Contains(body, "span class=\"countdown\"") {
+			t.Errorf("%s: time left shown without a countdown", tt.name)
+		}
+	}
+}
+
+func TestCancelCountdown(t *testing.T) {
+	users := withAuthStub(t, people.NO_CAPACITY_LIMIT)
+	users.Add(TEST_UUID, "Ada")
+	users.SetCountdown(TEST_UUID, people.Countdown{Ends: FIXED_NOW, Duration: time.Minute})
+
+	w := httptest.NewRecorder()
+	handleCancelCountdown(w, sessionForm("/countdown", TEST_UUID, nil))
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/countdown" {
+		t.Errorf("%d to %q, want %d to /countdown", w.Code, w.Header().Get("Location"), http.StatusSeeOther)
+	}
+	if users.Countdown(TEST_UUID).Set() {
+		t.Error("countdown not cleared")
+	}
+}
+
+func TestCountdownWebSocket(t *testing.T) {
+	withFixedNow(t)
+	override(t, config.StreamIntvl, 10*time.Millisecond)
+	users := withAuthStub(t, people.NO_CAPACITY_LIMIT)
+	users.Add(TEST_UUID, "Ada")
+	users.SetCountdown(TEST_UUID, people.Countdown{Ends: FIXED_NOW.Add(-time.Second), Duration: time.Minute})
+
+	server := httptest.NewServer(http.HandlerFunc(handleCountdownWebSocket))
+	defer server.Close()
+	header := http.Header{"Cookie": {cookie.NewCookie(TEST_UUID, cookie.Age()).String()}}
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, msg, err := conn.ReadMessage(); err != nil || string(msg) != "00:00" {
+		t.Fatalf("first message %q - %v, want 00:00", msg, err)
+	}
+	_, _, err = conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		t.Errorf("ended countdown closed with %v, want a normal closure", err)
+	}
+}
@@ -15,21 +15,66 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"embed"
+	"encoding/json"
 	"errors"
 	"fmt"
 	log "github.com/cihub/seelog"
 	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
 	"github.com/patkaehuaea/command/authserver/client"
 	"github.com/patkaehuaea/command/authserver/people"
 	"github.com/patkaehuaea/command/config"
+	"github.com/patkaehuaea/command/debugserver"
+	"github.com/patkaehuaea/command/timeserver/clock"
+	"github.com/patkaehuaea/command/timeserver/compress"
 	"github.com/patkaehuaea/command/timeserver/cookie"
+	"github.com/patkaehuaea/command/timeserver/csp"
+	"github.com/patkaehuaea/command/timeserver/csrf"
+	"github.com/patkaehuaea/command/timeserver/events"
+	"github.com/patkaehuaea/command/timeserver/geo"
+	"github.com/patkaehuaea/command/timeserver/hub"
+	"github.com/patkaehuaea/command/timeserver/i18n"
+	"github.com/patkaehuaea/command/timeserver/maxprocs"
+	"github.com/patkaehuaea/command/timeserver/metrics"
+	"github.com/patkaehuaea/command/timeserver/middleware"
+	"github.com/patkaehuaea/command/timeserver/negotiate"
+	"github.com/patkaehuaea/command/timeserver/ntp"
+	"github.com/patkaehuaea/command/timeserver/provider"
+	"github.com/patkaehuaea/command/timeserver/proxy"
+	"github.com/patkaehuaea/command/timeserver/ratelimit"
+	"github.com/patkaehuaea/command/timeserver/requestid"
+	"github.com/patkaehuaea/command/timeserver/static"
 	"github.com/patkaehuaea/command/timeserver/stats"
+	"github.com/patkaehuaea/command/timeserver/timeout"
+	"github.com/patkaehuaea/command/timeserver/words"
+	"github.com/skip2/go-qrcode"
 	"html/template"
+	"io"
+	"io/ioutil"
+	"math"
 	"math/rand"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
 	"time"
+	"unicode/utf8"
 )
 
 const (
@@ -38,32 +83,143 @@ const (
 	TEMPL_FILE_EXTENSION = ".tmpl"
 	LOCAL_TIME_LAYOUT    = "3:04:05 PM"
 	UTC_TIME_LAYOUT      = "15:04:05 UTC"
+	WS_WRITE_WAIT        = 10 * time.Second
+	WS_PONG_WAIT         = 60 * time.Second
+	WS_PING_PERIOD       = (WS_PONG_WAIT * 9) / 10
+	MAX_QUERY_BYTES      = 512
+	MAX_TZ_LENGTH        = 64
+	TZ_REGEX             = "^[A-Za-z0-9_+/-]+$"
+	RETURN_PARAM         = "return"
+	LOGOUT_SAMPLE_DELAY  = 10
+	COOKIE_CHECK_PARAM   = "cookie-check"
+	AUTO_TZ_PARAM        = "auto"
+	PRIVILEGED_PORT_MAX  = 1023
+	HTTPS_PORT           = "443"
+	BUILTIN_TMPL_DIR     = "templates"
+	BUSY_RETRY_AFTER     = "1"
+	ADMIN_REALM          = "timeserver admin"
+	READYZ_FAILED        = "not ready"
 )
 
+// Pages the --post-login-path flag may send logged in users to.
+var postLoginPaths = map[string]bool{
+	"/time":         true,
+	"/time/iso":     true,
+	"/time/rfc1123": true,
+}
+
+// Modes accepted by the --log-names flag.
+var logNameModes = map[string]bool{
+	"off":      true,
+	"plain":    true,
+	"redacted": true,
+}
+
+// Fractional second layouts accepted by the --time-precision flag.
+var timePrecisions = map[string]string{
+	"seconds": "",
+	"millis":  ".000",
+	"nanos":   ".000000000",
+}
+
+// Matches the seconds element of a layout and any fraction following it.
+var secondsLayout = regexp.MustCompile(`:05(\.[09]+)?`)
+
+// Versions accepted by the --tls-min-version flag.
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// Curated list of forward secret AEAD suites for TLS 1.2 connections. TLS 1.3
+// suites are not configurable in crypto/tls and are always enabled.
+var tlsCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+}
+
 var (
 	authClient *client.AuthClient
+	blocked    []string
 	inFlight   *stats.ConcurrentRequests
-	templates  *template.Template
+	latency    *metrics.Histogram
+	requests   = metrics.NewCounter("timeserver_requests_total", "Requests served by route and status code.", "route", "code")
+	logins     = metrics.NewCounter("timeserver_logins_total", "Successful logins.")
+	logouts    = metrics.NewCounter("timeserver_logouts_total", "Logouts of a logged in user.")
+	timeouts   = metrics.NewCounter("timeserver_request_timeouts_total", "Requests cut off by --request-timeout.")
+	// Routed requests being handled, streams included, for /debug/vars.
+	serving atomic.Int64
+	// Semaphore bounding concurrent renders. Nil when unlimited.
+	renderSlots chan struct{}
+	ntpClient   *ntp.Client
+	// Per session limiter for the time pages. Nil when unlimited.
+	timeLimiter *ratelimit.Limiter
+	// Per address limiter for login attempts. Nil when unlimited.
+	loginLimiter *ratelimit.Limiter
+	// Holds the current *template.Template. Replaced wholesale on SIGHUP.
+	templates atomic.Value
+	tlsConfig *tls.Config
+	validTZ   = regexp.MustCompile(TZ_REGEX)
+	upgrader  = websocket.Upgrader{ReadBufferSize: 1024, WriteBufferSize: 1024}
+	// Clients of /time/stream, the websockets and /events, told to leave
+	// on shutdown.
+	streams = hub.New()
+	// Layouts used by the time endpoints, adjusted by --time-precision.
+	localLayout = LOCAL_TIME_LAYOUT
+	utcLayout   = UTC_TIME_LAYOUT
+	jsonLayout  = time.RFC3339Nano
+	// Source of the current time for all time endpoints. Replaceable
+	// so the clock can be stubbed or sourced from elsewhere.
+	now = time.Now
+	// Infers client time zones for ?auto=1. Replaceable with a GeoIP
+	// backed resolver.
+	tzResolver geo.TimezoneResolver = geo.UTC{}
+	// Connections accepted and not yet closed or hijacked.
+	openConns int64
+	// Process start, for the uptime shown on /admin.
+	started = time.Now()
 )
 
+// Sleeps for a normally distributed duration with mean average and
+// standard deviation deviation. The sample is scaled as a float, since
+// converting NormFloat64() to a Duration first truncates it to a whole
+// number of deviations, almost always zero. Samples below zero don't sleep.
+// Returns ctx's error if ctx is done before the sleep ends.
 // Credit: http://goo.gl/MsxPHk
-func delay(average time.Duration, deviation time.Duration) {
+func delay(ctx context.Context, average time.Duration, deviation time.Duration) error {
 	log.Trace("timeserver: delay average - " + average.String() + " ; " + "delay deviation = " + deviation.String())
-	load := time.Duration(rand.NormFloat64())*deviation + average
+	load := time.Duration(rand.NormFloat64()*float64(deviation)) + average
+	if load < 0 {
+		load = 0
+	}
 	log.Debug("timeserver: Sleeping for " + load.String() + ".")
-	time.Sleep(load)
+	timer := time.NewTimer(load)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 func getUUIDThenName(r *http.Request) (name string, err error) {
 	log.Info("timeserver: Called getUUIDThenName function.")
 
 	var uuid string
+	// Missing or malformed cookies are expected for anonymous
+	// visitors and already logged by the cookie package.
 	if uuid, err = cookie.UUID(r); err != nil {
-		log.Warn(err)
 		return
 	}
 
-	if name, err = authClient.Get(uuid); err != nil {
+	if name, err = authClient.Get(r.Context(), uuid); err != nil {
 		log.Warn(err)
 		return
 	}
@@ -79,179 +235,1993 @@ func getUUIDThenName(r *http.Request) (name string, err error) {
 	return
 }
 
-func handleDefault(w http.ResponseWriter, r *http.Request) {
-	log.Info("timeserver: Default handler called.")
+// Build provenance reported by /debug/buildinfo.
+type buildInfo struct {
+	Version   string            `json:"version"`
+	GoVersion string            `json:"go_version"`
+	Path      string            `json:"path"`
+	Main      string            `json:"main_version"`
+	Revision  string            `json:"vcs_revision,omitempty"`
+	Time      string            `json:"vcs_time,omitempty"`
+	Modified  bool              `json:"vcs_modified"`
+	Deps      map[string]string `json:"deps"`
+	Settings  map[string]string `json:"settings"`
+}
+
+// Reports the module versions and build settings embedded by the Go
+// toolchain. Version is VERSION_NUMBER, as printed by -V.
+func handleBuildInfo(w http.ResponseWriter, r *http.Request) {
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		log.Warn("timeserver: Binary built without build info.")
+		w.WriteHeader(http.StatusNotFound)
+		renderTemplate(w, r, "404", nil)
+		return
+	}
+	info := buildInfo{
+		Version:   VERSION_NUMBER,
+		GoVersion: bi.GoVersion,
+		Path:      bi.Path,
+		Main:      bi.Main.Version,
+		Deps:      map[string]string{},
+		Settings:  map[string]string{},
+	}
+	for _, dep := range bi.Deps {
+		info.Deps[dep.Path] = dep.Version
+	}
+	for _, setting := range bi.Settings {
+		info.Settings[setting.Key] = setting.Value
+		switch setting.Key {
+		case "vcs.revision":
+			info.Revision = setting.Value
+		case "vcs.time":
+			info.Time = setting.Value
+		case "vcs.modified":
+			info.Modified = setting.Value == "true"
+		}
+	}
+	renderJSON(w, http.StatusOK, info)
+}
+
+// Renews the session cookie of a logged in visitor so it expires
+// --session-ttl after their latest visit rather than after login.
+func refreshSession(w http.ResponseWriter, r *http.Request) {
+	if uuid, err := cookie.UUID(r); err == nil {
+		cookie.Set(w, r, cookie.NewCookie(uuid, cookie.Age()))
+	}
+}
+
+// Lists names of all parsed templates. Answers whether a given template
+// file was picked up by the glob in init().
+func handleDebugTemplates(w http.ResponseWriter, r *http.Request) {
+	names := []string{}
+	for _, t := range currentTemplates().Templates() {
+		names = append(names, t.Name())
+	}
+	sort.Strings(names)
+	renderJSON(w, http.StatusOK, names)
+}
+
+// Route and the methods it accepts as reported by /routes. An empty
+// methods list means any method is accepted.
+type routeInfo struct {
+	Path    string   `json:"path"`
+	Methods []string `json:"methods"`
+}
 
+// Returns handler listing every route registered on router. The router
+// is walked on each request so the list is always current.
+func handleRoutes(router *mux.Router) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		routes := []routeInfo{}
+		err := router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+			path, err := route.GetPathTemplate()
+			if err != nil {
+				return nil
+			}
+			methods, err := route.GetMethods()
+			if err != nil {
+				methods = []string{}
+			}
+			routes = append(routes, routeInfo{Path: path, Methods: methods})
+			return nil
+		})
+		if err != nil {
+			log.Error(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			renderTemplate(w, r, "500", nil)
+			return
+		}
+		renderJSON(w, http.StatusOK, routes)
+	}
+}
+
+// Publishes the name rules used by login so front-ends can validate
+// before submitting. Rules only change with a new build.
+func handleValidationRules(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	renderJSON(w, http.StatusOK, people.NameRules())
+}
+
+func handleDefault(w http.ResponseWriter, r *http.Request) {
 	name, err := getUUIDThenName(r)
 
 	if err != nil {
-		http.SetCookie(w, cookie.NewCookie(cookie.DELETE_VALUE, cookie.DELETE_AGE))
-		http.Redirect(w, r, "/login", http.StatusFound)
+		cookie.Set(w, r, cookie.NewCookie(cookie.DELETE_VALUE, cookie.DELETE_AGE))
+		// Arriving straight from a successful login without a cookie
+		// means the client dropped it. Redirecting to login would loop.
+		if *config.CookieCheck && r.URL.Query().Get(COOKIE_CHECK_PARAM) != "" {
+			log.Debug("timeserver: Client did not return session cookie after login.")
+			renderTemplate(w, r, "cookies-disabled", nil)
+			return
+		}
+		var target string
+		if uri := r.URL.RequestURI(); uri != "/" {
+			target = uri
+		}
+		// Saves the redirect hop. The form still posts to /login.
+		if *config.InlineLogin {
+			renderTemplate(w, r, "login", loginPage("What is your name, Earthling?", target))
+			return
+		}
+		login := "/login"
+		if target != "" {
+			login += "?" + RETURN_PARAM + "=" + url.QueryEscape(target)
+		}
+		http.Redirect(w, r, login, http.StatusFound)
+		return
+	}
+	refreshSession(w, r)
+
+	if *config.PostLoginPath != config.POST_LOGIN_PATH {
+		http.Redirect(w, r, *config.PostLoginPath, http.StatusFound)
 		return
 	}
 
 	log.Debug("timeserver: " + name + " viewing site.")
-	renderTemplate(w, "greetings", name)
+	renderTemplate(w, r, "greetings", name)
+}
+
+// Data for the login template. The return target is carried through the
+// form, and the links to login providers, so the user lands back where
+// they started after logging in.
+func loginPage(message string, target string) map[string]interface{} {
+	return map[string]interface{}{"message": message, "return": target, "providers": provider.All()}
+}
+
+// Returns target if it is a path on this site, otherwise "/". Only
+// relative references with an absolute path are accepted, so tampered
+// or stale return params can never redirect off site.
+func safeRedirect(target string) string {
+	u, err := url.Parse(target)
+	if err != nil || target == "" || u.IsAbs() || u.Host != "" || !strings.HasPrefix(u.Path, "/") ||
+		strings.HasPrefix(target, "//") || strings.HasPrefix(target, "/\\") {
+		log.Debug("timeserver: Rejected post-login redirect target: " + target)
+		return "/"
+	}
+	return target
+}
+
+// Marks target as the first request after login so handleDefault can
+// tell a client that dropped the session cookie from one never logged in.
+func withCookieCheck(target string) string {
+	u, err := url.Parse(target)
+	if err != nil {
+		return target
+	}
+	q := u.Query()
+	q.Set(COOKIE_CHECK_PARAM, "1")
+	u.RawQuery = q.Encode()
+	return u.String()
 }
 
 func handleDisplayLogin(w http.ResponseWriter, r *http.Request) {
-	log.Info("timeserver: Display login handler called.")
-	renderTemplate(w, "login", "What is your name, Earthling?")
+	renderTemplate(w, r, "login", loginPage("What is your name, Earthling?", r.FormValue(RETURN_PARAM)))
 }
 
-func handleProcessLogin(w http.ResponseWriter, r *http.Request) {
-	log.Info("timeserver: Process login handler called.")
+// Returns name as it should appear in the log under mode. Redacted names
+// are reduced to their length in characters and a truncated SHA-256, enough
+// to tell whether two failed logins submitted the same name.
+func loggableName(name, mode string) string {
+	if mode == "redacted" {
+		sum := sha256.Sum256([]byte(name))
+		return fmt.Sprintf("length=%d sha256=%x", utf8.RuneCountInString(name), sum[:8])
+	}
+	return strconv.Quote(name)
+}
 
+func handleProcessLogin(w http.ResponseWriter, r *http.Request) {
 	name := r.FormValue("name")
+	if *config.LogNames != config.LOG_NAMES {
+		log.Debug("timeserver: Login submitted name " + loggableName(name, *config.LogNames))
+	}
 
 	if people.IsValidName(name) {
 		log.Trace("timeserver: Name matched regex.")
 		uuid := people.UUID()
 
-		if err := authClient.Set(uuid, name); err != nil {
-			http.SetCookie(w, cookie.NewCookie(cookie.DELETE_VALUE, cookie.DELETE_AGE))
+		if err := authClient.Set(r.Context(), uuid, name); err == client.ErrCapacity {
+			cookie.Set(w, r, cookie.NewCookie(cookie.DELETE_VALUE, cookie.DELETE_AGE))
+			w.WriteHeader(http.StatusServiceUnavailable)
+			renderTemplate(w, r, "login", loginPage("Server at capacity, try later.", r.FormValue(RETURN_PARAM)))
+			log.Warn(err)
+			return
+		} else if err != nil {
+			cookie.Set(w, r, cookie.NewCookie(cookie.DELETE_VALUE, cookie.DELETE_AGE))
 			w.WriteHeader(http.StatusInternalServerError)
-			renderTemplate(w, "500", nil)
+			renderTemplate(w, r, "500", nil)
 			log.Error(err)
 			return
 		}
 
-		http.SetCookie(w, cookie.NewCookie(uuid, cookie.MAX_AGE))
-		http.Redirect(w, r, "/", http.StatusFound)
+		startSession(w, r, uuid, name, r.FormValue(RETURN_PARAM))
 		log.Info("timeserver: " + name + " registered on site.")
 		return
 	}
 
 	w.WriteHeader(http.StatusBadRequest)
-	renderTemplate(w, "login", "C'mon, I need a name.")
+	renderTemplate(w, r, "login", loginPage("C'mon, I need a name.", r.FormValue(RETURN_PARAM)))
 	log.Warn("timeserver: Invalid username or registration failed.")
 }
 
+// Issues the session cookie for the user with uuid, just registered with
+// authserver as name, and sends them on to target.
+func startSession(w http.ResponseWriter, r *http.Request, uuid string, name string, target string) {
+	logins.Inc()
+	publishActivity(events.LOGIN, name)
+	cookie.Set(w, r, cookie.NewCookie(uuid, cookie.Age()))
+	target = safeRedirect(target)
+	if *config.CookieCheck {
+		target = withCookieCheck(target)
+	}
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
 func handleLogout(w http.ResponseWriter, r *http.Request) {
-	log.Info("timeserver: Logout handler called.")
+	// Drop the session from the authserver so the uuid no longer
+	// resolves. The cookie is cleared even if that fails.
+	if uuid, err := cookie.UUID(r); err == nil {
+		logouts.Inc()
+		// Only /events needs the name, so skip asking when no one listens.
+		var name string
+		if activity.Len() > 0 {
+			name, _ = authClient.Get(r.Context(), uuid)
+		}
+		if err = authClient.Delete(r.Context(), uuid); err != nil {
+			log.Warn(err)
+		}
+		publishActivity(events.LOGOUT, name)
+	}
+	cookie.Set(w, r, cookie.NewCookie(cookie.DELETE_VALUE, cookie.DELETE_AGE))
 
-	http.SetCookie(w, cookie.NewCookie(cookie.DELETE_VALUE, cookie.DELETE_AGE))
-	renderTemplate(w, "logged-out", nil)
+	// API clients have no use for the logged out page.
+	if !negotiate.AcceptsHTML(r) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	renderTemplate(w, r, "logged-out", *config.LogoutDelay)
 }
 
-func handleNotFound(w http.ResponseWriter, r *http.Request) {
-	log.Info("timeserver: Not found handler called.")
+// Sets display theme of the logged in user from the theme form value
+// and returns them to the page they came from.
+func handleProfileTheme(w http.ResponseWriter, r *http.Request) {
+	uuid, err := cookie.UUID(r)
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
 
-	w.WriteHeader(http.StatusNotFound)
-	renderTemplate(w, "404", nil)
+	theme := r.FormValue("theme")
+	if !people.IsValidTheme(theme) {
+		log.Debug("timeserver: Rejected theme " + theme)
+		w.WriteHeader(http.StatusBadRequest)
+		renderTemplate(w, r, "400", "unknown theme")
+		return
+	}
+
+	if err = authClient.SetTheme(r.Context(), uuid, theme); err != nil {
+		log.Error(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		renderTemplate(w, r, "500", nil)
+		return
+	}
+	http.Redirect(w, r, safeRedirect(r.FormValue(RETURN_PARAM)), http.StatusFound)
 }
 
-func handleTime(w http.ResponseWriter, r *http.Request) {
-	log.Info("timeserver: Time handler called.")
+// Shows the settings form of the logged in user, currently their
+// preferred time zone. Anonymous visitors are sent to login first.
+func handleDisplaySettings(w http.ResponseWriter, r *http.Request) {
+	uuid, err := cookie.UUID(r)
+	if err != nil {
+		http.Redirect(w, r, "/login?"+RETURN_PARAM+"=/settings", http.StatusFound)
+		return
+	}
+	tz, err := authClient.Timezone(r.Context(), uuid)
+	if err != nil {
+		log.Error(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		renderTemplate(w, r, "500", nil)
+		return
+	}
+	renderTemplate(w, r, "settings", settingsPage("", tz))
+}
+
+// Saves the timezone form value as the logged in user's preferred time
+// zone, or clears it when empty, and returns them to the settings form.
+func handleProcessSettings(w http.ResponseWriter, r *http.Request) {
+	uuid, err := cookie.UUID(r)
+	if err != nil {
+		http.Redirect(w, r, "/login?"+RETURN_PARAM+"=/settings", http.StatusFound)
+		return
+	}
 
-	// Simulate load with delay function.
-	delay(*config.AvgRespMS, *config.DeviationMS)
+	tz := strings.TrimSpace(r.FormValue("timezone"))
+	if tz != "" && !people.IsValidTimezone(tz) {
+		log.Debug("timeserver: Rejected time zone preference " + strconv.Quote(tz))
+		w.WriteHeader(http.StatusBadRequest)
+		renderTemplate(w, r, "settings", settingsPage("Unknown time zone, try a name like Europe/Paris.", tz))
+		return
+	}
 
-	name, err := getUUIDThenName(r)
+	if err = authClient.SetTimezone(r.Context(), uuid, tz); err != nil {
+		log.Error(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		renderTemplate(w, r, "500", nil)
+		return
+	}
+	http.Redirect(w, r, "/settings", http.StatusFound)
+}
+
+// Data for the settings template.
+func settingsPage(message string, tz string) map[string]string {
+	return map[string]string{"message": message, "timezone": tz}
+}
+
+// Reports the version of the running server, the same one printed by -V.
+func handleVersion(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	renderJSON(w, http.StatusOK, map[string]string{"version": VERSION_NUMBER})
+}
+
+// Liveness check for load balancers. Answers HEAD with the same status
+// and headers as GET but no body, as many load balancers probe with HEAD.
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		io.WriteString(w, "ok\n")
+	}
+}
+
+// Readiness check for orchestrators such as Kubernetes. The server is
+// ready when its templates are parsed and authserver, which persists the
+// users, reports itself ready. Failures answer 503 naming each failed
+// check in a JSON body. Reasons name paths and addresses, so they are
+// only given to requests presenting --admin-token, as for /admin.
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	failed := make(map[string]string)
+	if templates.Load() == nil {
+		failed["templates"] = "templates not parsed"
+	} else if *config.DevTemplates {
+		// Pages fall back to the last good set, which is not the one
+		// on disk being developed.
+		if _, err := parseTemplates(); err != nil {
+			failed["templates"] = err.Error()
+		}
+	}
+	if err := authClient.Ready(r.Context()); err != nil {
+		failed["auth"] = err.Error()
+	}
+
+	if len(failed) != 0 {
+		log.Warnf("timeserver: Not ready - %v", failed)
+		if !isAdmin(r) {
+			for check := range failed {
+				failed[check] = READYZ_FAILED
+			}
+		}
+		renderJSON(w, http.StatusServiceUnavailable, map[string]interface{}{"status": "unavailable", "errors": failed})
+		return
+	}
+	renderJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// Wraps the /admin handlers. Requests must carry --admin-token, either as
+// "Authorization: Bearer <token>" or as the password of basic auth, which
+// browsers prompt for. The page does not exist without a token.
+func requireAdmin(fn func(w http.ResponseWriter, r *http.Request)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if *config.AdminToken == config.ADMIN_TOKEN {
+			handleNotFound(w, r)
+			return
+		}
+		if !isAdmin(r) {
+			log.Warn("timeserver: Rejected admin request from " + remoteHost(r))
+			w.Header().Set("WWW-Authenticate", `Basic realm="`+ADMIN_REALM+`", charset="UTF-8"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		fn(w, r)
+	}
+}
+
+// Returns true if r presents --admin-token as a bearer token or basic
+// auth password. Always false when the flag is unset.
+func isAdmin(r *http.Request) bool {
+	if *config.AdminToken == config.ADMIN_TOKEN {
+		return false
+	}
+	given, ok := bearerToken(r)
+	if _, password, basic := r.BasicAuth(); basic {
+		given, ok = password, true
+	}
+	return ok && subtle.ConstantTimeCompare([]byte(given), []byte(*config.AdminToken)) == 1
+}
+
+// Returns the token of an "Authorization: Bearer <token>" header. ok is
+// false when the header is missing or names another scheme, which is
+// matched regardless of case.
+func bearerToken(r *http.Request) (token string, ok bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	return token, true
+}
+
+// Registered user as shown on /admin. Age is the time since login and
+// Idle the time since the user last loaded a page.
+type adminUser struct {
+	ID     string
+	Name   string
+	Age    time.Duration
+	Idle   time.Duration
+	Visits int
+}
+
+// Requests served for a route pattern.
+type routeCount struct {
+	Route string
+	Count uint64
+}
+
+// Data of the admin template. UsersError is set instead of Users when
+// authserver could not be asked.
+type adminPage struct {
+	Notice     string
+	Users      []adminUser
+	UsersError string
+	Requests   []routeCount
+	Started    time.Time
+	Uptime     time.Duration
+	Memory     runtime.MemStats
+	Goroutines int
+	Streams    int
+}
+
+// Notices shown on /admin after an action redirects back to it.
+var adminNotices = map[string]string{
+	"evicted": "Session evicted.",
+	"cleared": "All users cleared.",
+}
+
+// Shows the state of the server and its registered users to operators:
+// sessions and their ages, requests per route, uptime and memory.
+func handleAdmin(w http.ResponseWriter, r *http.Request) {
+	data := adminPage{
+		Notice:     adminNotices[r.FormValue("done")],
+		Started:    started,
+		Uptime:     time.Since(started).Round(time.Second),
+		Goroutines: runtime.NumGoroutine(),
+		Streams:    streams.Len(),
+	}
+	runtime.ReadMemStats(&data.Memory)
 
+	list, err := authClient.People(r.Context(), *config.AdminToken)
 	if err != nil {
-		http.SetCookie(w, cookie.NewCookie(cookie.DELETE_VALUE, cookie.DELETE_AGE))
+		log.Error(err)
+		data.UsersError = err.Error()
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+	for _, p := range list {
+		data.Users = append(data.Users, adminUser{
+			ID:     p.ID,
+			Name:   p.Name,
+			Age:    time.Since(p.CreatedAt).Round(time.Second),
+			Idle:   time.Since(p.LastSeen).Round(time.Second),
+			Visits: p.Visits,
+		})
 	}
 
-	// If name is blank, template will not render
-	// personalized greeting.
-	params := map[string]interface{}{
-		"localTime": time.Now().Format(LOCAL_TIME_LAYOUT),
-		"UTCTime":   time.Now().Format(UTC_TIME_LAYOUT),
-		"name":      name,
+	for route, n := range requests.Totals("route") {
+		data.Requests = append(data.Requests, routeCount{route, n})
 	}
-	renderTemplate(w, "time", params)
+	sort.Slice(data.Requests, func(i, j int) bool { return data.Requests[i].Route < data.Requests[j].Route })
+
+	w.Header().Set("Cache-Control", "no-store")
+	renderTemplate(w, r, "admin", data)
 }
 
-// credit: http://tinyurl.com/kwc4hls
-func logFileRequest(h http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		log.Info("timeserver: File server called.")
-		h.ServeHTTP(w, r)
-	})
+// Logs out the session with the id form value and returns to /admin.
+func handleAdminEvict(w http.ResponseWriter, r *http.Request) {
+	id := r.FormValue("id")
+	if !people.IsValidUUID(id) {
+		w.WriteHeader(http.StatusBadRequest)
+		renderTemplate(w, r, "400", "invalid session id")
+		return
+	}
+	if err := authClient.Delete(r.Context(), id); err != nil {
+		log.Error(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		renderTemplate(w, r, "500", nil)
+		return
+	}
+	log.Info("timeserver: Admin evicted session " + id)
+	http.Redirect(w, r, "/admin?done=evicted", http.StatusSeeOther)
 }
 
-// credit: https://golang.org/doc/articles/wiki/#tmp_10
-func renderTemplate(w http.ResponseWriter, templ string, d interface{}) {
-	err := templates.ExecuteTemplate(w, templ+TEMPL_FILE_EXTENSION, d)
+// Removes every registered user, logging everyone out, once the confirm
+// box is ticked, and returns to /admin.
+func handleAdminClear(w http.ResponseWriter, r *http.Request) {
+	if r.FormValue("confirm") != "yes" {
+		w.WriteHeader(http.StatusBadRequest)
+		renderTemplate(w, r, "400", "tick the box to confirm clearing all users")
+		return
+	}
+	removed, err := authClient.Clear(r.Context(), *config.AdminToken)
 	if err != nil {
-		log.Error("timeserver: Error looking for template: " + templ)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		log.Error(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		renderTemplate(w, r, "500", nil)
+		return
 	}
+	log.Warnf("timeserver: Admin cleared %d users.", removed)
+	http.Redirect(w, r, "/admin?done=cleared", http.StatusSeeOther)
 }
 
-func throttle(fn func(w http.ResponseWriter, r *http.Request)) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
+// Exposes metrics in the Prometheus text format. The in-flight gauges
+// count time requests held under --max-inflight; both are zero when
+// there is no limit. Registered users are counted by authserver on
+// every scrape.
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	latency.Write(w)
 
-		if err := inFlight.Add(); err != nil {
-			log.Error(err)
-			w.WriteHeader(http.StatusInternalServerError)
-			renderTemplate(w, "500", nil)
-			return
-		}
+	requests.Write(w)
+	logins.Write(w)
+	logouts.Write(w)
+	timeouts.Write(w)
 
-		fn(w, r)
-		// Only subtract if stat was incrememted otherwise
-		// may attempt to subtract below stats.MIN_VALUE.
-		if err := inFlight.Subtract(); err != nil {
-			log.Error(err)
-		}
+	var current int
+	if inFlight != nil {
+		current = inFlight.Current()
+	}
+	metrics.WriteGauge(w, "timeserver_inflight_requests", "Time requests currently being served.", float64(current))
+	metrics.WriteGauge(w, "timeserver_inflight_limit", "Maximum concurrent time requests, from --max-inflight.", float64(*config.MaxInFlight))
+	metrics.WriteGauge(w, "timeserver_clock_offset_seconds", "Offset of the reported time from the local clock.", clockOffset().Seconds())
+	metrics.WriteGauge(w, "timeserver_stream_clients", "Clients connected to /time/stream and /time/ws.", float64(streams.Len()))
+
+	// Left out rather than reported as zero when authserver can't be
+	// reached, so a scrape never shows a false drop in users.
+	if count, err := authClient.Users(r.Context()); err == nil {
+		metrics.WriteGauge(w, "timeserver_registered_users", "Users held by authserver.", float64(count))
+	} else {
+		log.Warn("timeserver: Unable to count users for metrics - " + err.Error())
 	}
 }
 
-func init() {
+// Answers form submissions rejected by csrf.Handler, typically a form
+// left open past the token cookie's expiry or a cross-site post.
+func handleCSRFFailure(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusForbidden)
+	renderTemplate(w, r, "403", "the form has expired, please reload the page and try again")
+}
 
-	// Restrict parsing to *.templ to prevent fail on non-template files in a given directory
-	// like .DS_STORE.
-	var err error
-	if templates, err = template.ParseGlob(filepath.Join(*config.TmplDir, "*"+TEMPL_FILE_EXTENSION)); err != nil {
-		log.Critical(err)
-		os.Exit(1)
+// Answers requests cut off after --request-timeout. The handler has been
+// cancelled and anything it wrote is discarded.
+func handleTimeout(w http.ResponseWriter, r *http.Request) {
+	log.Warn("timeserver: Request for " + r.URL.Path + " exceeded " + config.ReqTimeout.String() + ".")
+	timeouts.Inc()
+	w.WriteHeader(http.StatusGatewayTimeout)
+	renderTemplate(w, r, "504", nil)
+}
+
+func handleNotFound(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusNotFound)
+	renderTemplate(w, r, "404", nil)
+}
+
+func handleTime(w http.ResponseWriter, r *http.Request) {
+	serveTime(w, r, localLayout, utcLayout)
+}
+
+// Machine readable time for downstream timeservers relaying this server's
+// clock with --upstream.
+func handleTimeJSON(w http.ResponseWriter, r *http.Request) {
+	t := now()
+	doc := clock.Document{Time: t.Format(jsonLayout)}
+	if extended(r) {
+		year, week := t.ISOWeek()
+		renderJSON(w, http.StatusOK, extendedDocument{Document: doc, ISOYear: year, ISOWeek: week, YearDay: t.YearDay()})
+		return
 	}
+	renderJSON(w, http.StatusOK, doc)
+}
 
-	log.ReplaceLogger(config.Logger)
-	authClient = client.NewAuthClient(*config.AuthHost, *config.AuthPort, *config.AuthTimeoutMS)
+// Time document with the calendar fields requested by ?extended=1.
+// Relaying timeservers only read the embedded time and ignore the rest.
+type extendedDocument struct {
+	clock.Document
+	ISOYear int `json:"iso_year"`
+	ISOWeek int `json:"iso_week"`
+	YearDay int `json:"year_day"`
 }
 
-func main() {
+// Returns true if the request asks for ISO week and day of year
+// alongside the time.
+func extended(r *http.Request) bool {
+	return r.FormValue("extended") == "1"
+}
 
-	/*
-		Paramters surfaced via config pacakge used in this program:
-		*config.AuthHost
-		*config.AuthPort
-		*config.AuthTimeoutMS
-		*config.AvgRespMS
-		*config.DeviationMS
-		*config.LogConf
-		config.Logger
-		*config.MaxInFlight
-		*config.TimePort
-		*config.TmplDir
-		*config.Verbose
-	*/
+// Reports the offset between the server's clock and NTP time, and the
+// drift of the time the endpoints report from NTP time once the time
+// source and --time-offset are applied. An unreachable NTP server results
+// in 503 with the error in the body.
+func handleTimeNTP(w http.ResponseWriter, r *http.Request) {
+	resp, err := ntpClient.Query()
+	if err != nil {
+		log.Warn(err)
+		renderJSON(w, http.StatusServiceUnavailable, map[string]string{"server": ntpClient.Server(), "error": err.Error()})
+		return
+	}
 
-	if *config.Verbose {
-		fmt.Printf("Version number: %s \n", VERSION_NUMBER)
-		os.Exit(0)
+	drift := clockOffset() - resp.Offset
+	renderJSON(w, http.StatusOK, map[string]interface{}{
+		"server":         ntpClient.Server(),
+		"ntp_time":       resp.Time.UTC().Format(time.RFC3339Nano),
+		"offset":         resp.Offset.String(),
+		"offset_seconds": resp.Offset.Seconds(),
+		"rtt_seconds":    resp.RTT.Seconds(),
+		"drift":          drift.String(),
+		"drift_seconds":  drift.Seconds(),
+	})
+}
+
+// Returns how far the time reported by the endpoints is ahead of the
+// local clock. Rounded to microseconds as the two readings are not
+// taken at the same instant.
+func clockOffset() time.Duration {
+	return now().Sub(time.Now()).Round(time.Microsecond)
+}
+
+// Returns the time of day layout of lc adjusted by --time-precision.
+func localeLayout(lc *i18n.Locale) string {
+	return withPrecision(lc.TimeLayout)
+}
+
+// Returns layout with the fraction of its seconds element set by
+// --time-precision. Layout is returned unchanged when the flag is unset.
+func withPrecision(layout string) string {
+	if *config.TimePrecision == config.TIME_PRECISION {
+		return layout
 	}
+	return secondsLayout.ReplaceAllString(layout, ":05"+timePrecisions[*config.TimePrecision])
+}
 
-	r := mux.NewRouter()
-	r.HandleFunc("/", handleDefault)
-	r.PathPrefix("/css/").Handler(logFileRequest(http.StripPrefix("/css/", http.FileServer(http.Dir("css/")))))
-	r.HandleFunc("/index.html", handleDefault)
-	r.HandleFunc("/login", handleDisplayLogin).Methods("GET")
-	r.HandleFunc("/login", handleProcessLogin).Methods("POST")
-	r.HandleFunc("/logout", handleLogout)
-	if *config.MaxInFlight != 0 {
-		log.Infof("%s - %d", "timeserver: Max concurrent time connections", *config.MaxInFlight)
-		inFlight = stats.NewCR(*config.MaxInFlight)
-		r.HandleFunc("/time", throttle(handleTime))
+// Returns handler for fixed format time routes that render both
+// local and UTC time using layout.
+func handleTimeLayout(layout string) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		serveTime(w, r, layout, layout)
 	}
-	r.HandleFunc("/time", handleTime)
-	r.NotFoundHandler = http.HandlerFunc(handleNotFound)
-	http.Handle("/", r)
-	if err := (http.ListenAndServe(*config.TimePort, nil)); err != nil {
-		log.Critical(err)
+}
+
+// Rejects query strings the time routes should never have to parse:
+// anything longer than MAX_QUERY_BYTES, malformed encodings, and tz
+// values that are too long or use characters absent from zone names.
+func validateTimeQuery(r *http.Request) error {
+	if len(r.URL.RawQuery) > MAX_QUERY_BYTES {
+		return errors.New("query string too long")
+	}
+	values, err := url.ParseQuery(r.URL.RawQuery)
+	if err != nil {
+		return errors.New("malformed query string")
+	}
+	if tz, ok := values["tz"]; ok {
+		if len(tz) != 1 || len(tz[0]) > MAX_TZ_LENGTH || !validTZ.MatchString(tz[0]) {
+			return errors.New("invalid tz parameter")
+		}
+	}
+	return nil
+}
+
+// JSON representation of the time routes, requested with an Accept
+// header of application/json or ?format=json. Name is omitted for
+// anonymous visitors, zone unless ?tz is given, and the calendar fields
+// unless ?extended=1.
+type timeResponse struct {
+	Time    string `json:"time"`
+	UTC     string `json:"utc"`
+	Zone    string `json:"zone,omitempty"`
+	Name    string `json:"name,omitempty"`
+	ISOYear int    `json:"iso_year,omitempty"`
+	ISOWeek int    `json:"iso_week,omitempty"`
+	YearDay int    `json:"year_day,omitempty"`
+}
+
+// Returns the location named by the tz query parameter and its name.
+// Without tz, auto=1 asks tzResolver for the client address's zone and
+// falls back to the local zone if it has none. Otherwise the logged in
+// user's preferred zone is used, then the server's local time zone with
+// an empty name. Errors if tz is not a known location.
+func location(r *http.Request) (loc *time.Location, zone string, err error) {
+	query := r.URL.Query()
+	zone = query.Get("tz")
+	if zone == "" && query.Get(AUTO_TZ_PARAM) == "1" {
+		return autoLocation(r)
+	}
+	if zone == "" {
+		loc, zone = preferredLocation(r)
+		return
+	}
+	if loc, err = time.LoadLocation(zone); err != nil {
+		return
+	}
+	zone = loc.String()
+	return
+}
+
+// Returns the logged in user's preferred time zone and its name, or the
+// server's local time zone and an empty name if there is no session, no
+// preference, or the lookup fails.
+func preferredLocation(r *http.Request) (*time.Location, string) {
+	uuid, err := cookie.UUID(r)
+	if err != nil {
+		return time.Local, ""
+	}
+	tz, err := authClient.Timezone(r.Context(), uuid)
+	if err != nil || tz == "" {
+		return time.Local, ""
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		log.Warn("timeserver: Ignoring unloadable time zone preference " + tz + " - " + err.Error())
+		return time.Local, ""
+	}
+	return loc, loc.String()
+}
+
+// Returns the time zone tzResolver infers from the client address, or
+// the server's local time zone if resolution fails.
+func autoLocation(r *http.Request) (*time.Location, string, error) {
+	ip := net.ParseIP(remoteHost(r))
+	if ip == nil {
+		log.Debug("timeserver: No client address to resolve time zone for.")
+		return time.Local, "", nil
+	}
+	loc, err := tzResolver.Timezone(ip)
+	if err != nil || loc == nil {
+		log.Debug("timeserver: Unable to resolve time zone for " + ip.String())
+		return time.Local, "", nil
+	}
+	return loc, loc.String(), nil
+}
+
+// Rejects stream and websocket requests with a bad query or time zone
+// before any streaming starts. Returns the location to report time in,
+// or nil after answering 400.
+func streamLocation(w http.ResponseWriter, r *http.Request) *time.Location {
+	err := validateTimeQuery(r)
+	var loc *time.Location
+	if err == nil {
+		loc, _, err = location(r)
+	}
+	if err != nil {
+		log.Debug("timeserver: Rejected time query - " + err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		renderTemplate(w, r, "400", err.Error())
+		return nil
+	}
+	return loc
+}
+
+// Shared implementation of the time routes. Local time is formatted
+// with localLayout in the ?tz location, or the server's, and UTC time
+// with utcLayout.
+func serveTime(w http.ResponseWriter, r *http.Request, localLayout string, utcLayout string) {
+	// Validate before any other work, including the simulated delay.
+	if err := validateTimeQuery(r); err != nil {
+		log.Debug("timeserver: Rejected time query - " + err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		renderTemplate(w, r, "400", err.Error())
+		return
+	}
+
+	loc, zone, err := location(r)
+	if err != nil {
+		log.Debug("timeserver: Rejected time zone - " + err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		renderTemplate(w, r, "400", "unknown time zone")
+		return
+	}
+
+	// Simulate load with delay function. A cancelled request has nobody
+	// left to answer.
+	if err := delay(r.Context(), *config.AvgRespMS, *config.DeviationMS); err != nil {
+		log.Debug("timeserver: Time request abandoned - " + err.Error())
+		return
+	}
+
+	// Shared kiosk deployments skip the lookup entirely so the name of
+	// whoever last logged in on the machine is never shown.
+	var name string
+	if !*config.TimeNoName {
+		var err error
+		if name, err = getUUIDThenName(r); err != nil {
+			cookie.Set(w, r, cookie.NewCookie(cookie.DELETE_VALUE, cookie.DELETE_AGE))
+		} else {
+			refreshSession(w, r)
+		}
+	}
+
+	t := now().In(loc)
+
+	if r.FormValue("format") == "words" {
+		phrase := words.Time(t)
+		if negotiate.WantsJSON(r) {
+			renderJSON(w, http.StatusOK, map[string]string{"time": phrase})
+			return
+		}
+		if wantsText(r) {
+			renderText(w, http.StatusOK, phrase)
+			return
+		}
+		renderTemplate(w, r, "time", map[string]interface{}{"words": phrase, "name": name})
+		return
+	}
+
+	if r.FormValue("format") == "json" || negotiate.WantsJSON(r) {
+		resp := timeResponse{Time: t.Format(localLayout), UTC: t.UTC().Format(utcLayout), Zone: zone, Name: name}
+		if extended(r) {
+			year, week := t.ISOWeek()
+			resp.ISOYear, resp.ISOWeek, resp.YearDay = year, week, t.YearDay()
+		}
+		renderJSON(w, http.StatusOK, resp)
+		return
+	}
+
+	if wantsText(r) {
+		line := t.Format(localLayout) + " (" + t.UTC().Format(utcLayout) + ")"
+		if zone != "" {
+			line += " in " + zone
+		}
+		renderText(w, http.StatusOK, line)
+		return
+	}
+
+	// If name is blank, template will not render
+	// personalized greeting.
+	params := map[string]interface{}{
+		"localTime": t.Format(localLayout),
+		"UTCTime":   t.UTC().Format(utcLayout),
+		"zone":      zone,
+		"name":      name,
+		// /time/ws pushes times in /time's layouts, so only that page
+		// keeps itself up to date.
+		"live": r.URL.Path == "/time",
+	}
+	// /time follows the visitor's language for the clock and date; the
+	// fixed format routes keep their layouts.
+	if r.URL.Path == "/time" {
+		lc := i18n.For(r)
+		params["localTime"] = t.Format(localeLayout(lc))
+		params["date"] = lc.Date(t)
+	}
+	if extended(r) {
+		year, week := t.ISOWeek()
+		params["isoYear"] = year
+		params["isoWeek"] = week
+		params["yearDay"] = t.YearDay()
+	}
+	renderTemplate(w, r, "time", params)
+}
+
+// Returns a PNG QR code for kiosk displays. By default it encodes the URL
+// of this server's /time page so a phone can open it; with ?content=time
+// it encodes the current time instead.
+func handleTimeQR(w http.ResponseWriter, r *http.Request) {
+	var content string
+	if r.FormValue("content") == "time" {
+		t := now()
+		content = t.Format(localLayout) + " (" + t.UTC().Format(utcLayout) + ")"
+	} else {
+		content = (&url.URL{Scheme: proxy.Scheme(r), Host: r.Host, Path: "/time"}).String()
+	}
+
+	png, err := qrcode.Encode(content, qrcode.Medium, *config.QRSize)
+	if err != nil {
+		log.Error(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		renderTemplate(w, r, "500", nil)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(png)
+}
+
+// Pushes the formatted time to the client as server-sent events once per
+// --stream-interval. Loop exits when the request context is cancelled, which
+// happens when the client disconnects, or when the hub is closed on
+// shutdown. Not throttled as a single stream would otherwise hold an
+// in-flight slot for its entire lifetime.
+func handleTimeStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		log.Error("timeserver: Response writer does not support flushing.")
+		w.WriteHeader(http.StatusInternalServerError)
+		renderTemplate(w, r, "500", nil)
+		return
+	}
+
+	loc := streamLocation(w, r)
+	if loc == nil {
+		return
+	}
+
+	quit, leave, ok := streams.Join()
+	if !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		renderTemplate(w, r, "503", nil)
+		return
+	}
+	defer leave()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	ticker := time.NewTicker(*config.StreamIntvl)
+	defer ticker.Stop()
+
+	for {
+		t := now().In(loc)
+		fmt.Fprintf(w, "data: %s (%s)\n\n", t.Format(localLayout), t.UTC().Format(utcLayout))
+		flusher.Flush()
+
+		select {
+		case <-r.Context().Done():
+			log.Debug("timeserver: Time stream client disconnected.")
+			return
+		case <-quit:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Upgrades connection to a websocket and pushes the time, formatted for the
+// visitor's language, once per --stream-interval. Reader go routine exists
+// only to process control frames (pong, close) and signals the writer when
+// the client goes away. On shutdown the client is sent a going away close
+// frame so browsers can tell it from a dropped connection. Keepalive
+// pattern credit: gorilla/websocket chat example.
+func handleTimeWebSocket(w http.ResponseWriter, r *http.Request) {
+	loc := streamLocation(w, r)
+	if loc == nil {
+		return
+	}
+
+	quit, leave, ok := streams.Join()
+	if !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		renderTemplate(w, r, "503", nil)
+		return
+	}
+	defer leave()
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already replied to the client with an error.
+		log.Warn(err)
+		return
+	}
+	defer conn.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		conn.SetReadDeadline(time.Now().Add(WS_PONG_WAIT))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(WS_PONG_WAIT))
+		})
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				log.Debug("timeserver: Time websocket closed - " + err.Error())
+				return
+			}
+		}
+	}()
+
+	// Matches the /time page, which this socket keeps up to date.
+	layout := localeLayout(i18n.For(r))
+	ticker := time.NewTicker(*config.StreamIntvl)
+	defer ticker.Stop()
+	ping := time.NewTicker(WS_PING_PERIOD)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-quit:
+			msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+			conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(WS_WRITE_WAIT))
+			return
+		case <-ticker.C:
+			t := now().In(loc)
+			msg := t.Format(layout) + " (" + t.UTC().Format(utcLayout) + ")"
+			conn.SetWriteDeadline(time.Now().Add(WS_WRITE_WAIT))
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+				log.Debug(err)
+				return
+			}
+		case <-ping.C:
+			conn.SetWriteDeadline(time.Now().Add(WS_WRITE_WAIT))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				log.Debug(err)
+				return
+			}
+		}
+	}
+}
+
+// Router middleware recording request latency and counting requests by
+// route and status. Runs after route matching so the route's path
+// template, rather than the raw URL, is used as the label keeping
+// cardinality bounded to registered routes.
+func instrument(h http.Handler) http.Handler {
+	observed := middleware.Observe(h, func(r *http.Request, status int, bytes int, duration time.Duration) {
+		route := "unknown"
+		if current := mux.CurrentRoute(r); current != nil {
+			if tmpl, err := current.GetPathTemplate(); err == nil {
+				route = tmpl
+			}
+		}
+		latency.Observe(route, duration.Seconds())
+		requests.Inc(route, strconv.Itoa(status))
+	})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serving.Add(1)
+		defer serving.Add(-1)
+		observed.ServeHTTP(w, r)
+	})
+}
+
+// Returns true if path is on the blocklist. Entries ending in "/" match
+// the path itself and everything below it, other entries match exactly.
+func isBlocked(path string) bool {
+	for _, entry := range blocked {
+		if path == entry || (strings.HasSuffix(entry, "/") && strings.HasPrefix(path, entry)) ||
+			path+"/" == entry {
+			return true
+		}
+	}
+	return false
+}
+
+// Short circuits requests for paths probed by scanners with a bare 404,
+// skipping routing, template rendering and info logging. When a tarpit
+// is configured the response is held back that long, or until the
+// client goes away.
+func blockProbes(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isBlocked(r.URL.Path) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		log.Debug("timeserver: Blocked probe for " + r.URL.Path + " from " + r.RemoteAddr)
+		if *config.Tarpit > 0 {
+			select {
+			case <-time.After(*config.Tarpit):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+	})
+}
+
+// Writes line to w as plain text with status code.
+func renderText(w http.ResponseWriter, status int, line string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	io.WriteString(w, line+"\n")
+}
+
+// Returns true if ?format=text or the Accept header asks for plain text.
+func wantsText(r *http.Request) bool {
+	return r.FormValue("format") == "text" || negotiate.WantsText(r)
+}
+
+// Writes d to w as a JSON document with status code.
+func renderJSON(w http.ResponseWriter, status int, d interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(d); err != nil {
+		log.Error(err)
+	}
+}
+
+// Templates built into the binary, used unless --templates names a
+// directory on disk.
+//
+//go:embed templates/*.tmpl
+var builtinTemplates embed.FS
+
+// credit: https://golang.org/doc/articles/wiki/#tmp_10
+// Parses every template in the template directory, or the built in
+// templates when --templates is unset, into a new set. Matching no files
+// is reported as such, naming the directory searched and the glob used,
+// rather than with ParseGlob's terse pattern error.
+func parseTemplates() (*template.Template, error) {
+	// Restrict parsing to *.templ to prevent fail on non-template files in a given directory
+	// like .DS_STORE.
+	glob := "*" + TEMPL_FILE_EXTENSION
+	if *config.TmplDir == config.TMPL_DIR {
+		return template.New("").Delims(*config.LeftDelim, *config.RightDelim).ParseFS(builtinTemplates, BUILTIN_TMPL_DIR+"/"+glob)
+	}
+	matches, err := filepath.Glob(filepath.Join(*config.TmplDir, glob))
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) == 0 {
+		dir, err := filepath.Abs(*config.TmplDir)
+		if err != nil {
+			dir = *config.TmplDir
+		}
+		return nil, errors.New("timeserver: No templates matching " + glob + " found in " + dir + ". Check --templates.")
+	}
+	return template.New("").Delims(*config.LeftDelim, *config.RightDelim).ParseFiles(matches...)
+}
+
+// Sample of the admin page listed in templateSamples.
+var adminSample = adminPage{
+	Notice:   "Session evicted.",
+	Users:    []adminUser{{ID: "00000000-0000-4000-8000-000000000000", Name: "Earthling", Age: time.Hour, Idle: time.Minute, Visits: 3}},
+	Requests: []routeCount{{"/time", 42}},
+}
+
+// Representative data for templates rendered with page specific data.
+// Templates not listed are rendered with nil data.
+var templateSamples = map[string]interface{}{
+	"400":        "sample error",
+	"403":        "sample error",
+	"429":        1,
+	"admin":      adminSample,
+	"countdown":  countdownPage{Set: true, Remaining: "09:59", Duration: "10m0s", Message: "sample message"},
+	"greetings":  "Earthling",
+	"logged-out": LOGOUT_SAMPLE_DELAY,
+	"login":      loginPage("What is your name, Earthling?", "/"),
+	"settings":   settingsPage("Unknown time zone.", "America/Los_Angeles"),
+	"stopwatch":  stopwatchPage{Running: true, Reading: "01:02.34", Millis: 62340, Laps: []lap{{1, "01:02.34", "01:02.34"}}},
+	"time": map[string]interface{}{
+		"localTime": LOCAL_TIME_LAYOUT,
+		"UTCTime":   UTC_TIME_LAYOUT,
+		"name":      "Earthling",
+		"isoYear":   2015,
+		"isoWeek":   10,
+		"yearDay":   60,
+		"live":      true,
+		"date":      "Sunday, March 1, 2015",
+	},
+}
+
+// Executes every template in set once against templateSamples, discarding
+// the output, so bad field references fail before traffic is served
+// rather than on the first request.
+func warmTemplates(set *template.Template) error {
+	for _, t := range set.Templates() {
+		// Only files are rendered. The empty root of the set and
+		// templates defined within files are covered by the files.
+		if !strings.HasSuffix(t.Name(), TEMPL_FILE_EXTENSION) {
+			continue
+		}
+		name := strings.TrimSuffix(t.Name(), TEMPL_FILE_EXTENSION)
+		d := page{Theme: *config.DefaultTheme, Locale: i18n.Default(), Data: templateSamples[name]}
+		if err := t.Execute(ioutil.Discard, d); err != nil {
+			return errors.New("timeserver: Template " + t.Name() + " failed to render - " + err.Error())
+		}
+	}
+	return nil
+}
+
+// Returns the template set to render with. Under --dev-templates the
+// directory is reparsed on every call so edits show up immediately; a
+// set that fails to parse is logged and the last good set is used.
+func currentTemplates() *template.Template {
+	if *config.DevTemplates {
+		parsed, err := parseTemplates()
+		if err == nil {
+			templates.Store(parsed)
+			return parsed
+		}
+		log.Error(err)
+	}
+	return templates.Load().(*template.Template)
+}
+
+// Reparses templates on every SIGHUP. The new set is swapped in
+// atomically, so requests in flight finish with the set they started
+// with and new requests see the new set. Only the current set is
+// referenced, so each replaced set is released to the garbage collector
+// once its last request completes. A set that fails to parse is
+// discarded and the current set stays in place. Intended to be run
+// as go routine.
+func reloadTemplates(hup <-chan os.Signal) {
+	for range hup {
+		parsed, err := parseTemplates()
+		if err == nil && *config.WarmTemplates {
+			err = warmTemplates(parsed)
+		}
+		if err != nil {
+			log.Error("timeserver: Keeping current templates, reload failed - " + err.Error())
+			continue
+		}
+		templates.Store(parsed)
+		log.Info("timeserver: Templates reloaded.")
+	}
+}
+
+// Returns err from binding addr, replaced with a hint when permission was
+// denied on a privileged port. Other errors are returned unchanged.
+func listenError(addr string, err error) error {
+	if !errors.Is(err, syscall.EACCES) {
+		return err
+	}
+	_, port, splitErr := net.SplitHostPort(addr)
+	number, convErr := strconv.Atoi(port)
+	if splitErr != nil || convErr != nil || number > PRIVILEGED_PORT_MAX {
+		return err
+	}
+	return errors.New("timeserver: Permission denied binding privileged port " + port +
+		", run as root, grant CAP_NET_BIND_SERVICE, or choose a port above " +
+		strconv.Itoa(PRIVILEGED_PORT_MAX) + " - " + err.Error())
+}
+
+// Counts connections from accept until closed or hijacked. Installed as
+// the server's ConnState hook.
+func trackConn(c net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateNew:
+		atomic.AddInt64(&openConns, 1)
+	case http.StateClosed, http.StateHijacked:
+		atomic.AddInt64(&openConns, -1)
+	}
+}
+
+// Waits for SIGINT or SIGTERM then shuts server down within timeout.
+// Closes done once server has stopped.
+func shutdownOnSignal(server *http.Server, timeout time.Duration, done chan<- struct{}) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	s := <-sig
+	log.Info("timeserver: Received " + s.String() + ", shutting down.")
+	shutdown(server, timeout)
+	close(done)
+}
+
+// Stops server accepting connections and lets in-flight requests finish.
+// Stream clients are told to leave as Shutdown starts and given until
+// timeout to do so; connections still open after timeout are closed.
+// Returns the number of connections closed forcibly.
+func shutdown(server *http.Server, timeout time.Duration) (forced int64) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		// Shutdown has closed idle connections, so those still open are
+		// busy, typically slow time requests. Hijacked websockets aren't
+		// counted; the stream hub has already told them to close.
+		forced = atomic.LoadInt64(&openConns)
+		log.Warn("timeserver: Shutdown timed out, closing remaining connections - " + err.Error())
+		server.Close()
+		log.Warnf("timeserver: Forcibly closed %d connections.", forced)
+	}
+	if err := streams.Wait(ctx); err != nil {
+		log.Warnf("timeserver: %d stream clients did not leave before the shutdown timeout.", streams.Len())
+	}
+	log.Info("timeserver: Shutdown complete.")
+	return
+}
+
+// Data common to every page. Templates reach page specific data
+// through .Data and translate text with .Locale.T. Inline scripts and
+// styles must carry .Nonce in their nonce attribute to satisfy the
+// Content-Security-Policy.
+type page struct {
+	Theme  string
+	Nonce  string
+	CSRF   string
+	Locale *i18n.Locale
+	Data   interface{}
+}
+
+// Returns display theme of the logged in user, or --default-theme for
+// anonymous visitors, users who have not chosen one, and auth failures.
+func pageTheme(r *http.Request) string {
+	uuid, err := cookie.UUID(r)
+	if err != nil {
+		return *config.DefaultTheme
+	}
+	theme, err := authClient.Theme(r.Context(), uuid)
+	if err != nil {
+		log.Warn(err)
+		return *config.DefaultTheme
+	}
+	if !people.IsValidTheme(theme) {
+		return *config.DefaultTheme
+	}
+	return theme
+}
+
+// Takes one of the --max-renders slots, waiting up to --render-wait.
+// Returns false if none became free. Always succeeds without a limit.
+func acquireRender(r *http.Request) bool {
+	if renderSlots == nil {
+		return true
+	}
+	select {
+	case renderSlots <- struct{}{}:
+		return true
+	default:
+	}
+	timer := time.NewTimer(*config.RenderWait)
+	defer timer.Stop()
+	select {
+	case renderSlots <- struct{}{}:
+		return true
+	case <-timer.C:
+	case <-r.Context().Done():
+	}
+	return false
+}
+
+func releaseRender() {
+	if renderSlots != nil {
+		<-renderSlots
+	}
+}
+
+// Renders templ with the common page data. Under --max-renders a
+// render that cannot get a slot in time answers 503 unrendered, as
+// rendering the error page would itself need a slot.
+func renderTemplate(w http.ResponseWriter, r *http.Request, templ string, d interface{}) {
+	// Looked up before taking a slot so the auth round trip is
+	// not counted against the render limit.
+	data := page{Theme: pageTheme(r), Nonce: csp.Nonce(r), CSRF: csrf.Token(r), Locale: i18n.For(r), Data: d}
+
+	if !acquireRender(r) {
+		log.Warn("timeserver: No render slot free for template: " + templ)
+		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	defer releaseRender()
+
+	// Rendered into a buffer so a missing or failing template answers a
+	// clean 500 rather than a half written page. Only this request fails.
+	var buf bytes.Buffer
+	if err := currentTemplates().ExecuteTemplate(&buf, templ+TEMPL_FILE_EXTENSION, data); err != nil {
+		log.Error("timeserver: Error rendering template " + templ + " - " + err.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Write(buf.Bytes())
+}
+
+// Returns true if --tls-cert and --tls-key are set and the server
+// listens for HTTPS.
+func servesTLS() bool {
+	return *config.TLSCert != config.TLS_CERT
+}
+
+// Redirects plain HTTP requests to the same host, path and query over
+// HTTPS on --port. Port 443 is left out of the Location. Served by the
+// --http-redirect-port listener.
+func redirectToHTTPS(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.Trim(host, "[]")
+	port := *config.TimePort
+	if _, p, err := net.SplitHostPort(port); err == nil {
+		port = p
+	}
+	if port != "" && port != HTTPS_PORT {
+		host = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+}
+
+// Errors unless --tls-cert and --tls-key are given together, and unless
+// --http-redirect-port comes with both.
+func checkTLSFlags() error {
+	if (*config.TLSCert == config.TLS_CERT) != (*config.TLSKey == config.TLS_KEY) {
+		return errors.New("timeserver: --tls-cert and --tls-key must be given together.")
+	}
+	if *config.HTTPRedirect != config.HTTP_REDIRECT && !servesTLS() {
+		return errors.New("timeserver: --http-redirect-port requires --tls-cert and --tls-key.")
+	}
+	return nil
+}
+
+// Returns TLS configuration restricted to version and above and the
+// curated cipher suites. Errors if version is not a key in tlsVersions.
+func newTLSConfig(version string) (*tls.Config, error) {
+	min, ok := tlsVersions[version]
+	if !ok {
+		return nil, errors.New("timeserver: Unsupported TLS version - " + version)
+	}
+	return &tls.Config{MinVersion: min, CipherSuites: tlsCipherSuites}, nil
+}
+
+// Returns key identifying the client for rate limiting: the session
+// uuid when logged in, otherwise the remote address without port.
+func clientKey(r *http.Request) string {
+	if uuid, err := cookie.UUID(r); err == nil {
+		return "session:" + uuid
+	}
+	return "addr:" + remoteHost(r)
+}
+
+// Returns the host part of the request's remote address.
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Wraps time page handlers with the --time-rate limit. Clients over the
+// limit get 429 with Retry-After in whole seconds. Returns fn unchanged
+// when there is no limit.
+func limitTime(fn func(w http.ResponseWriter, r *http.Request)) http.HandlerFunc {
+	if timeLimiter == nil {
+		return fn
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if ok, retry := timeLimiter.Allow(clientKey(r)); !ok {
+			log.Debug("timeserver: Rate limited time request from " + clientKey(r))
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retry.Seconds()))))
+			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+			return
+		}
+		fn(w, r)
+	}
+}
+
+// Wraps the login handler with the --login-rate limit, keyed by client
+// address since those submitting logins have no session yet. Clients over
+// the limit get 429 with Retry-After in whole seconds and the 429 page.
+// Returns fn unchanged when there is no limit.
+func limitLogin(fn func(w http.ResponseWriter, r *http.Request)) http.HandlerFunc {
+	if loginLimiter == nil {
+		return fn
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if ok, retry := loginLimiter.Allow(remoteHost(r)); !ok {
+			log.Warn("timeserver: Rate limited login from " + remoteHost(r))
+			seconds := int(math.Ceil(retry.Seconds()))
+			w.Header().Set("Retry-After", strconv.Itoa(seconds))
+			w.WriteHeader(http.StatusTooManyRequests)
+			renderTemplate(w, r, "429", seconds)
+			return
+		}
+		fn(w, r)
+	}
+}
+
+// Limits fn to *config.MaxInFlight concurrent requests. Requests over
+// the limit are answered 503 at once, with Retry-After, rather than
+// queued. Returns fn unchanged if throttling is not configured.
+func throttle(fn func(w http.ResponseWriter, r *http.Request)) http.HandlerFunc {
+	if inFlight == nil {
+		return fn
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		if err := inFlight.Add(); err != nil {
+			log.Warn(err)
+			w.Header().Set("Retry-After", BUSY_RETRY_AFTER)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			renderTemplate(w, r, "503", nil)
+			return
+		}
+
+		fn(w, r)
+		// Only subtract if stat was incrememted otherwise
+		// may attempt to subtract below stats.MIN_VALUE.
+		if err := inFlight.Subtract(); err != nil {
+			log.Error(err)
+		}
+	}
+}
+
+func init() {
+
+	// Custom delimiters let templates embed content, like client side
+	// template frameworks, that itself uses {{ }}.
+	if *config.LeftDelim == "" || *config.RightDelim == "" || *config.LeftDelim == *config.RightDelim {
+		log.Critical("timeserver: Template delimiters must be non-empty and different.")
+		os.Exit(1)
+	}
+
+	parsed, err := parseTemplates()
+	if err != nil {
+		log.Critical(err)
+		os.Exit(1)
+	}
+	templates.Store(parsed)
+
+	log.ReplaceLogger(config.Logger)
+
+	if *config.DevTemplates {
+		log.Warn("timeserver: Development templates enabled, templates are reparsed on every request.")
+		if *config.TmplDir == config.TMPL_DIR {
+			log.Warn("timeserver: Built in templates never change, set --templates to reload from disk.")
+		}
+	}
+
+	if *config.AutoMaxProcs {
+		if _, err := maxprocs.Set(); err != nil {
+			log.Warn("timeserver: Leaving GOMAXPROCS unchanged - " + err.Error())
+		}
+	}
+	log.Infof("timeserver: NumCPU %d, GOMAXPROCS %d.", runtime.NumCPU(), runtime.GOMAXPROCS(0))
+
+	if *config.LogoutDelay < 0 {
+		log.Critical("timeserver: Logout delay must not be negative.")
+		os.Exit(1)
+	}
+
+	if tlsConfig, err = newTLSConfig(*config.TLSMinVersion); err != nil {
+		log.Critical(err)
+		os.Exit(1)
+	}
+	if err := checkTLSFlags(); err != nil {
+		log.Critical(err)
+		os.Exit(1)
+	}
+	// Loaded here so a bad certificate or key fails at startup with the
+	// file named rather than from ListenAndServeTLS.
+	if servesTLS() {
+		cert, err := tls.LoadX509KeyPair(*config.TLSCert, *config.TLSKey)
+		if err != nil {
+			log.Critical("timeserver: Unable to load --tls-cert " + *config.TLSCert + " and --tls-key " + *config.TLSKey + " - " + err.Error())
+			os.Exit(1)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if _, ok := timePrecisions[*config.TimePrecision]; !ok && *config.TimePrecision != config.TIME_PRECISION {
+		log.Critical("timeserver: Time precision must be seconds, millis, or nanos.")
+		os.Exit(1)
+	}
+	if !people.IsValidTheme(*config.DefaultTheme) {
+		log.Critical("timeserver: Default theme must be system, light, or dark.")
+		os.Exit(1)
+	}
+
+	if !logNameModes[*config.LogNames] {
+		log.Critical("timeserver: Log names must be off, plain, or redacted.")
+		os.Exit(1)
+	}
+
+	if *config.PostLoginPath != config.POST_LOGIN_PATH && !postLoginPaths[*config.PostLoginPath] {
+		log.Critical("timeserver: Post login path must be /time, /time/iso, or /time/rfc1123.")
+		os.Exit(1)
+	}
+
+	localLayout = withPrecision(LOCAL_TIME_LAYOUT)
+	utcLayout = withPrecision(UTC_TIME_LAYOUT)
+	jsonLayout = withPrecision(time.RFC3339Nano)
+
+	var buckets []float64
+	if buckets, err = metrics.ParseBuckets(*config.LatencyBkts); err != nil {
+		log.Critical(err)
+		os.Exit(1)
+	}
+	latency = metrics.NewHistogram("timeserver_request_duration_seconds", "Request latency by route.", "route", buckets)
+
+	// --cookie-secret wins so keys can be rotated without touching
+	// the file.
+	if len(config.CookieSecrets) == 0 && *config.CookieKeyFile != config.COOKIE_KEY_FILE {
+		secret, err := cookie.LoadSecret(*config.CookieKeyFile, config.FileMode)
+		if err != nil {
+			log.Critical("timeserver: Unable to load --cookie-secret-file - " + err.Error())
+			os.Exit(1)
+		}
+		config.CookieSecrets = config.StringList{secret}
+	}
+	cookie.SetSecrets(config.CookieSecrets)
+	if len(config.CookieSecrets) == 0 {
+		log.Warn("timeserver: No --cookie-secret or --cookie-secret-file set, session cookies are unsigned.")
+	}
+
+	sameSite, ok := cookie.SAME_SITE_MODES[*config.CookieSite]
+	if !ok {
+		log.Critical("timeserver: Cookie SameSite must be lax, strict, or none.")
+		os.Exit(1)
+	}
+	// Cookies served over TLS are always Secure. --secure-cookies covers
+	// TLS terminated in front of the server.
+	if servesTLS() {
+		*config.CookieSecure = true
+	}
+	if sameSite == http.SameSiteNoneMode && !*config.CookieSecure {
+		log.Warn("timeserver: --cookie-samesite none requires Secure, enabling --secure-cookies.")
+		*config.CookieSecure = true
+	}
+	cookie.SetAttributes(*config.CookieSecure, sameSite)
+	if *config.SessionTTL < time.Second {
+		log.Critical("timeserver: Session TTL must be at least one second.")
+		os.Exit(1)
+	}
+	cookie.SetAge(*config.SessionTTL)
+	if *config.StreamIntvl <= 0 {
+		log.Critical("timeserver: Stream interval must be greater than zero.")
+		os.Exit(1)
+	}
+
+	for _, entry := range strings.Split(*config.BlockPaths, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			blocked = append(blocked, entry)
+		}
+	}
+	if err := proxy.SetTrusted(*config.TrustedProxy); err != nil {
+		log.Critical(err)
+		os.Exit(1)
+	}
+	if proxy.Enabled() {
+		log.Info("timeserver: Honoring forwarding headers from trusted proxies " + *config.TrustedProxy)
+	}
+
+	if *config.QRSize <= 0 {
+		log.Critical("timeserver: QR size must be positive.")
+		os.Exit(1)
+	}
+	if *config.CompressMin < 0 {
+		log.Critical("timeserver: Compression minimum size must not be negative.")
+		os.Exit(1)
+	}
+	if *config.ReqTimeout < 0 {
+		log.Critical("timeserver: Request timeout must not be negative.")
+		os.Exit(1)
+	}
+	if *config.StaticMaxAge < 0 {
+		log.Critical("timeserver: Static max age must not be negative.")
+		os.Exit(1)
+	}
+	if *config.StaticDir != config.STATIC_DIR {
+		if info, err := os.Stat(*config.StaticDir); err != nil || !info.IsDir() {
+			log.Critical("timeserver: Static directory " + *config.StaticDir + " not found. Check --static-dir.")
+			os.Exit(1)
+		}
+	}
+
+	if *config.TimeRate > 0 {
+		timeLimiter = ratelimit.NewLimiter(*config.TimeRate, *config.TimeBurst)
+	}
+	if *config.LoginRate > 0 {
+		loginLimiter = ratelimit.NewLimiter(*config.LoginRate, *config.LoginBurst)
+	}
+
+	if *config.MaxRenders > 0 {
+		renderSlots = make(chan struct{}, *config.MaxRenders)
+	}
+
+	ntpClient = ntp.NewClient(*config.NTPServer, *config.NTPTimeout, *config.NTPCacheTTL)
+	source := *config.TimeSource
+	if source == config.TIME_SOURCE && *config.Upstream != config.UPSTREAM {
+		source = "upstream"
+	}
+	// Cached falls back to the local clock, logging why, whenever the
+	// source can't be read, so requests never fail for lack of it.
+	switch source {
+	case config.TIME_SOURCE, "local":
+	case "ntp":
+		log.Info("timeserver: Correcting time by offset from NTP server " + *config.NTPServer)
+		now = clock.NewCached(ntpClient, *config.NTPCacheTTL).Now
+	case "upstream":
+		if *config.Upstream == config.UPSTREAM {
+			log.Critical("timeserver: Time source upstream requires --upstream.")
+			os.Exit(1)
+		}
+		log.Info("timeserver: Relaying time from upstream " + *config.Upstream)
+		now = clock.NewCached(clock.NewUpstream(*config.Upstream, *config.UpstreamTO), *config.UpstreamTTL).Now
+	default:
+		log.Critical("timeserver: Time source must be local, ntp, or upstream.")
+		os.Exit(1)
+	}
+
+	if *config.TrustedSource != config.TRUSTED_SOURCE {
+		if *config.TimeSource != config.TIME_SOURCE {
+			log.Critical("timeserver: Choose either --time-source or --trusted-source.")
+			os.Exit(1)
+		}
+		var src clock.Source
+		switch *config.TrustedSource {
+		case "ntp":
+			src = ntpClient
+		case "upstream":
+			if *config.Upstream == config.UPSTREAM {
+				log.Critical("timeserver: Trusted source upstream requires --upstream.")
+				os.Exit(1)
+			}
+			src = clock.NewUpstream(*config.Upstream, *config.UpstreamTO)
+		default:
+			log.Critical("timeserver: Trusted source must be ntp or upstream.")
+			os.Exit(1)
+		}
+		trusted, err := clock.NewTrusted(src)
+		if err != nil {
+			log.Warn("timeserver: Trusted clock starting from local time - " + err.Error())
+		}
+		go trusted.Refresh(*config.TrustedRefr)
+		now = trusted.Now
+	}
+	if *config.TimeOffset != config.TIME_OFFSET {
+		log.Warn("timeserver: Shifting reported time by " + config.TimeOffset.String())
+		now = clock.WithOffset(now, *config.TimeOffset)
+	}
+
+	authClient = client.NewAuthClient(*config.AuthHost, *config.AuthPort, *config.AuthTimeoutMS)
+	registerProviders()
+}
+
+// Returns the server listening on --host and --port for handler, with
+// keep-alives unless --disable-keepalives is set.
+func newServer(handler http.Handler) *http.Server {
+	server := &http.Server{
+		Addr:      config.ListenAddr(*config.TimeHost, *config.TimePort),
+		Handler:   handler,
+		TLSConfig: tlsConfig,
+		ConnState: trackConn,
+	}
+	// Some load balancers pin clients to a backend for as long as a
+	// connection is reused.
+	if *config.NoKeepAlives {
+		log.Info("timeserver: Keep-alives disabled.")
+		server.SetKeepAlivesEnabled(false)
+	}
+	return server
+}
+
+// Returns the router serving every route of the time server, without the
+// middleware chain main() wraps it in.
+func newRouter() *mux.Router {
+	r := mux.NewRouter()
+	r.HandleFunc("/", handleDefault)
+	// static.Handler serves through http.ServeContent, which answers Range
+	// requests with 206 Partial Content. A replacement must do the same.
+	// credit: http://tinyurl.com/kwc4hls
+	assets := static.Builtin
+	if *config.StaticDir != config.STATIC_DIR {
+		log.Info("timeserver: Serving static assets from " + *config.StaticDir)
+		assets = os.DirFS(*config.StaticDir)
+	}
+	r.PathPrefix("/static/").Handler(http.StripPrefix("/static/", static.Handler(assets, *config.StaticMaxAge)))
+	// Pages cached before the stylesheet moved to /static/.
+	r.Handle("/css/css490.css", http.RedirectHandler("/static/style.css", http.StatusMovedPermanently))
+	r.HandleFunc("/index.html", handleDefault)
+	r.HandleFunc("/login", handleDisplayLogin).Methods("GET")
+	r.HandleFunc("/login", limitLogin(handleProcessLogin)).Methods("POST")
+	r.HandleFunc("/login/{provider}", handleProviderLogin).Methods("GET")
+	r.HandleFunc("/login/{provider}/callback", limitLogin(handleProviderCallback)).Methods("GET")
+	r.HandleFunc("/logout", handleLogout).Methods("POST")
+	r.HandleFunc("/admin", requireAdmin(handleAdmin)).Methods("GET")
+	r.HandleFunc("/admin/evict", requireAdmin(handleAdminEvict)).Methods("POST")
+	r.HandleFunc("/admin/clear", requireAdmin(handleAdminClear)).Methods("POST")
+	r.HandleFunc("/healthz", handleHealthz).Methods("GET", "HEAD")
+	r.HandleFunc("/readyz", handleReadyz).Methods("GET", "HEAD")
+	r.HandleFunc("/metrics", handleMetrics).Methods("GET")
+	r.HandleFunc("/profile/theme", handleProfileTheme).Methods("POST")
+	r.HandleFunc("/routes", handleRoutes(r)).Methods("GET")
+	r.HandleFunc("/settings", handleDisplaySettings).Methods("GET")
+	r.HandleFunc("/settings", handleProcessSettings).Methods("POST")
+	r.HandleFunc("/stopwatch", handleDisplayStopwatch).Methods("GET")
+	r.HandleFunc("/stopwatch", handleProcessStopwatch).Methods("POST")
+	r.HandleFunc("/countdown", handleCountdown).Methods("GET")
+	r.HandleFunc("/countdown", handleCancelCountdown).Methods("POST")
+	r.HandleFunc("/countdown/ws", handleCountdownWebSocket).Methods("GET")
+	r.HandleFunc("/validation-rules", handleValidationRules).Methods("GET")
+	r.HandleFunc("/version", handleVersion).Methods("GET")
+	if *config.MaxInFlight != 0 {
+		log.Infof("%s - %d", "timeserver: Max concurrent time connections", *config.MaxInFlight)
+		inFlight = stats.NewCR(*config.MaxInFlight)
+	}
+	r.HandleFunc("/time", limitTime(throttle(handleTime)))
+	r.HandleFunc("/time.json", handleTimeJSON).Methods("GET")
+	r.HandleFunc("/time/iso", limitTime(throttle(handleTimeLayout(withPrecision(time.RFC3339))))).Methods("GET")
+	r.HandleFunc("/time/rfc1123", limitTime(throttle(handleTimeLayout(withPrecision(time.RFC1123))))).Methods("GET")
+	r.HandleFunc("/time/qr", handleTimeQR).Methods("GET")
+	r.HandleFunc("/time/ntp", handleTimeNTP).Methods("GET")
+	r.HandleFunc("/time/stream", handleTimeStream).Methods("GET")
+	r.HandleFunc("/time/ws", handleTimeWebSocket).Methods("GET")
+	r.HandleFunc("/events", handleEvents).Methods("GET")
+	if *config.DebugEndpts {
+		log.Warn("timeserver: Debug endpoints enabled.")
+		r.HandleFunc("/debug/buildinfo", handleBuildInfo).Methods("GET")
+		r.HandleFunc("/debug/templates", handleDebugTemplates).Methods("GET")
+	}
+	r.NotFoundHandler = http.HandlerFunc(handleNotFound)
+	r.Use(instrument)
+	return r
+}
+
+func main() {
+
+	/*
+		Paramters surfaced via config pacakge used in this program:
+		*config.AdminToken
+		*config.AuthHost
+		*config.AuthPort
+		*config.AuthTimeoutMS
+		*config.AutoMaxProcs
+		*config.AvgRespMS
+		*config.BlockPaths
+		*config.CompressMin
+		*config.CookieCheck
+		config.CookieSecrets
+		*config.CookieKeyFile
+		*config.CookieSecure
+		*config.CookieSite
+		*config.DebugAddr
+		*config.DebugEndpts
+		*config.DefaultTheme
+		*config.DevTemplates
+		*config.DeviationMS
+		*config.GitHubID
+		*config.GitHubSecret
+		*config.GoogleID
+		*config.GoogleSecret
+		*config.HTTPRedirect
+		*config.InlineLogin
+		*config.LoginBurst
+		*config.LoginRate
+		*config.LatencyBkts
+		*config.LeftDelim
+		*config.LogConf
+		*config.LogNames
+		*config.LogoutDelay
+		config.Logger
+		*config.MaxInFlight
+		*config.MaxRenders
+		*config.NoCompression
+		*config.NoKeepAlives
+		*config.NTPCacheTTL
+		*config.NTPServer
+		*config.NTPTimeout
+		*config.OIDCID
+		*config.OIDCIssuer
+		*config.OIDCSecret
+		*config.OIDCTitle
+		*config.PostLoginPath
+		*config.PublicURL
+		*config.QRSize
+		*config.RenderWait
+		*config.ReqTimeout
+		*config.RightDelim
+		*config.SessionTTL
+		*config.ShutdownTO
+		*config.Tarpit
+		*config.TimeNoName
+		*config.TimeHost
+		*config.TimePort
+		*config.TimePrecision
+		*config.TimeRate
+		*config.TimeOffset
+		*config.TimeSource
+		*config.TimeBurst
+		*config.TLSCert
+		*config.TLSKey
+		*config.TLSMinVersion
+		*config.StaticDir
+		*config.StaticMaxAge
+		*config.TmplDir
+		*config.TrustedProxy
+		*config.TrustedRefr
+		*config.TrustedSource
+		*config.Upstream
+		*config.UpstreamTO
+		*config.UpstreamTTL
+		*config.Verbose
+		*config.WarmTemplates
+	*/
+
+	if *config.Verbose {
+		fmt.Printf("Version number: %s \n", VERSION_NUMBER)
+		os.Exit(0)
+	}
+
+	// Skippable for fast startup while developing templates.
+	if *config.WarmTemplates {
+		if err := warmTemplates(currentTemplates()); err != nil {
+			log.Critical(err)
+			os.Exit(1)
+		}
+	}
+
+	r := newRouter()
+	csrf.Failure = http.HandlerFunc(handleCSRFFailure)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go reloadTemplates(hup)
+
+	// Outermost first, see the middleware package for the recommended order.
+	chain := middleware.Chain{
+		proxy.Handler,
+		requestid.Handler,
+		middleware.Logging,
+		csp.Handler,
+		blockProbes,
+		csrf.Handler,
+		i18n.Handler,
+		timeout.Handler(*config.ReqTimeout, http.HandlerFunc(handleTimeout)),
+	}
+	if *config.NoCompression {
+		log.Info("timeserver: Response compression disabled.")
+	} else {
+		chain = append(chain, compress.Handler(*config.CompressMin))
+	}
+	// Not http.DefaultServeMux, where net/http/pprof and expvar register
+	// themselves.
+	server := newServer(chain.Then(r))
+	server.RegisterOnShutdown(func() {
+		log.Infof("timeserver: Closed %d stream clients.", streams.Close())
+	})
+	if *config.DebugAddr != config.DEBUG_ADDR {
+		debugserver.Publish("requests_in_flight", func() interface{} { return serving.Load() })
+		debugserver.Publish("stream_clients", func() interface{} { return streams.Len() })
+		debugserver.Publish("event_subscribers", func() interface{} { return activity.Len() })
+		debugServer, err := debugserver.Serve(*config.DebugAddr)
+		if err != nil {
+			log.Critical(listenError(*config.DebugAddr, err))
+			os.Exit(1)
+		}
+		server.RegisterOnShutdown(func() { debugServer.Close() })
+	}
+	done := make(chan struct{})
+	go shutdownOnSignal(server, *config.ShutdownTO, done)
+	serve := server.ListenAndServe
+	if servesTLS() {
+		log.Info("timeserver: Serving HTTPS.")
+		// Certificate already loaded into tlsConfig.
+		serve = func() error { return server.ListenAndServeTLS("", "") }
+	}
+	if *config.HTTPRedirect != config.HTTP_REDIRECT {
+		redirect := &http.Server{
+			Addr:    config.ListenAddr(*config.TimeHost, *config.HTTPRedirect),
+			Handler: middleware.Logging(http.HandlerFunc(redirectToHTTPS)),
+		}
+		server.RegisterOnShutdown(func() { redirect.Close() })
+		go func() {
+			log.Info("timeserver: Redirecting HTTP on " + redirect.Addr + " to HTTPS.")
+			if err := redirect.ListenAndServe(); err != http.ErrServerClosed {
+				log.Critical(listenError(redirect.Addr, err))
+				os.Exit(1)
+			}
+		}()
+	}
+	if err := serve(); err != http.ErrServerClosed {
+		log.Critical(listenError(server.Addr, err))
 		os.Exit(1)
 	}
+	<-done
+	log.Flush()
 }
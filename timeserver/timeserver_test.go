@@ -0,0 +1,1828 @@
+//  Copyright (C) Pat Kaehuaea - All Rights Reserved
+//  Unauthorized copying of this file, via any medium is strictly prohibited
+//  Proprietary and confidential
+//  Written by Pat Kaehuaea, March 2015
+//
+// Tests for the time server's handlers. Handlers are called directly or
+// through httptest servers, so settings keep their flag defaults unless a
+// test changes them.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"github.com/patkaehuaea/command/authserver/client"
+	"github.com/patkaehuaea/command/authserver/people"
+	"github.com/patkaehuaea/command/config"
+	"github.com/patkaehuaea/command/timeserver/clock"
+	"github.com/patkaehuaea/command/timeserver/cookie"
+	"github.com/patkaehuaea/command/timeserver/csp"
+	"github.com/patkaehuaea/command/timeserver/csrf"
+	"github.com/patkaehuaea/command/timeserver/geo"
+	"github.com/patkaehuaea/command/timeserver/ntp"
+	"github.com/patkaehuaea/command/timeserver/ratelimit"
+	"html"
+	"image/png"
+	"io"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+const TEST_UUID = "0f8b6a3e-6f4e-4c1a-9d2b-3e5f7a9c1b2d"
+
+// Time routes answer without their simulated delay under test.
+func TestMain(m *testing.M) {
+	*config.AvgRespMS = 0
+	*config.DeviationMS = 0
+	os.Exit(m.Run())
+}
+
+// Sets *setting to value for the duration of t.
+func override[T any](t *testing.T, setting *T, value T) {
+	saved := *setting
+	*setting = value
+	t.Cleanup(func() { *setting = saved })
+}
+
+// Instant reported by now() while a test runs withFixedNow().
+var FIXED_NOW = time.Date(2015, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+// Replaces now() with FIXED_NOW for the duration of t.
+func withFixedNow(t *testing.T) {
+	override(t, &now, func() time.Time { return FIXED_NOW })
+}
+
+// Replaces authClient with one talking to a stand in for authserver for
+// the duration of t. The stand in answers the endpoints the time server
+// calls from the returned store, which holds at most max users.
+func withAuthStub(t *testing.T, max int) *people.UserStore {
+	users := people.NewUsers(max)
+	status := func(w http.ResponseWriter, ok bool) {
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/get", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, users.Visit(r.FormValue("cookie")))
+	})
+	mux.HandleFunc("/set", func(w http.ResponseWriter, r *http.Request) {
+		uuid := r.FormValue("cookie")
+		err := users.Add(uuid, r.FormValue("name"))
+		if identity := r.FormValue("identity"); err == nil && identity != "" {
+			users.SetIdentity(uuid, identity)
+		}
+		if err == people.ErrStoreFull {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		status(w, err == nil)
+	})
+	mux.HandleFunc("/delete", func(w http.ResponseWriter, r *http.Request) {
+		status(w, users.Remove(r.FormValue("cookie")) == nil)
+	})
+	mux.HandleFunc("/theme/get", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, users.Theme(r.FormValue("cookie")))
+	})
+	mux.HandleFunc("/theme/set", func(w http.ResponseWriter, r *http.Request) {
+		status(w, users.SetTheme(r.FormValue("cookie"), r.FormValue("theme")))
+	})
+	mux.HandleFunc("/timezone/get", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, users.Timezone(r.FormValue("cookie")))
+	})
+	mux.HandleFunc("/timezone/set", func(w http.ResponseWriter, r *http.Request) {
+		status(w, users.SetTimezone(r.FormValue("cookie"), r.FormValue("tz")))
+	})
+	mux.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(users.Stats())
+	})
+	mux.HandleFunc("/stopwatch/get", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(users.Stopwatch(r.FormValue("cookie")))
+	})
+	mux.HandleFunc("/stopwatch/set", func(w http.ResponseWriter, r *http.Request) {
+		at, _ := time.Parse(time.RFC3339Nano, r.FormValue("at"))
+		sw, ok := users.UpdateStopwatch(r.FormValue("cookie"), r.FormValue("action"), at)
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(sw)
+	})
+	mux.HandleFunc("/countdown/get", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(users.Countdown(r.FormValue("cookie")))
+	})
+	mux.HandleFunc("/countdown/set", func(w http.ResponseWriter, r *http.Request) {
+		var c people.Countdown
+		if ends := r.FormValue("ends"); ends != "" {
+			c.Ends, _ = time.Parse(time.RFC3339Nano, ends)
+			c.Duration, _ = time.ParseDuration(r.FormValue("duration"))
+		}
+		status(w, users.SetCountdown(r.FormValue("cookie"), c))
+	})
+	mux.HandleFunc("/export", func(w http.ResponseWriter, r *http.Request) {
+		data, _ := users.Export()
+		w.Write(data)
+	})
+	mux.HandleFunc("/admin/clear", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]int{"removed": users.Clear()})
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {})
+
+	server := httptest.NewServer(mux)
+	addr, _ := url.Parse(server.URL)
+	saved := authClient
+	authClient = client.NewAuthClient(addr.Hostname(), ":"+addr.Port(), time.Second)
+	t.Cleanup(func() {
+		authClient = saved
+		server.Close()
+	})
+	return users
+}
+
+// Returns a request for target carrying the session cookie for uuid.
+func sessionRequest(method string, target string, uuid string) *http.Request {
+	r := httptest.NewRequest(method, target, nil)
+	r.AddCookie(cookie.NewCookie(uuid, cookie.Age()))
+	return r
+}
+
+// Returns a login form submission for name, returning to target unless
+// it is empty.
+func loginRequest(name string, target string) *http.Request {
+	form := url.Values{"name": {name}}
+	if target != "" {
+		form.Set(RETURN_PARAM, target)
+	}
+	r := httptest.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return r
+}
+
+func TestTimeStream(t *testing.T) {
+	withFixedNow(t)
+	override(t, config.StreamIntvl, 10*time.Millisecond)
+
+	server := httptest.NewServer(http.HandlerFunc(handleTimeStream))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/time/stream?tz=Asia/Tokyo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type = %q, want text/event-stream", ct)
+	}
+
+	want := "data: 9:00:00 PM (12:00:00 UTC)"
+	scanner := bufio.NewScanner(resp.Body)
+	for events := 0; events < 2; {
+		if !scanner.Scan() {
+			t.Fatalf("stream ended after %d events - %v", events, scanner.Err())
+		}
+		if line := scanner.Text(); line != "" {
+			if line != want {
+				t.Errorf("event %d = %q, want %q", events, line, want)
+			}
+			events++
+		}
+	}
+}
+
+func TestTimeStreamBadZone(t *testing.T) {
+	w := httptest.NewRecorder()
+	handleTimeStream(w, httptest.NewRequest("GET", "/time/stream?tz=Nowhere/Special", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if strings.HasPrefix(w.Header().Get("Content-Type"), "text/event-stream") {
+		t.Error("rejected request answered as an event stream")
+	}
+}
+
+func TestLogout(t *testing.T) {
+	tests := []struct {
+		accept string
+		status int
+		body   bool
+	}{
+		{"text/html,application/xhtml+xml,*/*;q=0.8", http.StatusOK, true},
+		{"application/json", http.StatusNoContent, false},
+	}
+	for _, tt := range tests {
+		users := withAuthStub(t, people.NO_CAPACITY_LIMIT)
+		users.Add(TEST_UUID, "Ada")
+
+		r := sessionRequest("POST", "/logout", TEST_UUID)
+		r.Header.Set("Accept", tt.accept)
+		w := httptest.NewRecorder()
+		handleLogout(w, r)
+
+		if w.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.accept, w.Code, tt.status)
+		}
+		if (w.Body.Len() > 0) != tt.body {
+			t.Errorf("%s: body of %d bytes, want body %v", tt.accept, w.Body.Len(), tt.body)
+		}
+		if !strings.Contains(w.Header().Get("Set-Cookie"), cookie.COOKIE_NAME+"="+cookie.DELETE_VALUE) {
+			t.Errorf("%s: session cookie not cleared - %q", tt.accept, w.Header().Get("Set-Cookie"))
+		}
+		if users.Exists(TEST_UUID) {
+			t.Errorf("%s: user still in store after logout", tt.accept)
+		}
+	}
+}
+
+func TestTimeNoName(t *testing.T) {
+	tests := []struct {
+		noName bool
+		want   string
+	}{
+		{false, "Ada"},
+		{true, ""},
+	}
+	users := withAuthStub(t, people.NO_CAPACITY_LIMIT)
+	users.Add(TEST_UUID, "Ada")
+	for _, tt := range tests {
+		override(t, config.TimeNoName, tt.noName)
+		w := httptest.NewRecorder()
+		handleTime(w, sessionRequest("GET", "/time?format=json", TEST_UUID))
+
+		var resp timeResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Name != tt.want {
+			t.Errorf("--time-no-name %v: name = %q, want %q", tt.noName, resp.Name, tt.want)
+		}
+	}
+}
+
+func TestTimeInWords(t *testing.T) {
+	withFixedNow(t)
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"application/json", `{"time":"noon"}`},
+		{"text/plain", "noon"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/time?format=words&tz=UTC", nil)
+		r.Header.Set("Accept", tt.accept)
+		w := httptest.NewRecorder()
+		handleTime(w, r)
+		if got := strings.TrimSpace(w.Body.String()); got != tt.want {
+			t.Errorf("%s: body = %q, want %q", tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestLoginAtCapacity(t *testing.T) {
+	users := withAuthStub(t, 1)
+	tests := []struct {
+		name   string
+		status int
+	}{
+		{"Ada", http.StatusFound},
+		{"Grace", http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		handleProcessLogin(w, loginRequest(tt.name, ""))
+		if w.Code != tt.status {
+			t.Errorf("login %s: status = %d, want %d", tt.name, w.Code, tt.status)
+		}
+	}
+	if users.Len() != 1 {
+		t.Errorf("%d users registered, want 1", users.Len())
+	}
+}
+
+func TestTimeLayouts(t *testing.T) {
+	withFixedNow(t)
+	tests := []struct {
+		path   string
+		layout string
+		want   string
+	}{
+		{"/time/iso", time.RFC3339, "2015-03-01T21:00:00+09:00 (2015-03-01T12:00:00Z) in Asia/Tokyo"},
+		{"/time/rfc1123", time.RFC1123, "Sun, 01 Mar 2015 21:00:00 JST (Sun, 01 Mar 2015 12:00:00 UTC) in Asia/Tokyo"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		handleTimeLayout(tt.layout)(w, httptest.NewRequest("GET", tt.path+"?format=text&tz=Asia/Tokyo", nil))
+		if got := strings.TrimSpace(w.Body.String()); got != tt.want {
+			t.Errorf("%s: body = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestTimeQueryValidation(t *testing.T) {
+	tests := []struct {
+		name   string
+		query  string
+		status int
+	}{
+		{"no query", "", http.StatusOK},
+		{"valid zone", "tz=America/Los_Angeles", http.StatusOK},
+		{"offset zone", "tz=Etc/GMT%2B8", http.StatusOK},
+		{"overlong zone", "tz=" + strings.Repeat("A", MAX_TZ_LENGTH+1), http.StatusBadRequest},
+		{"overlong query", "x=" + strings.Repeat("a", MAX_QUERY_BYTES), http.StatusBadRequest},
+		{"bad escape", "tz=%zz", http.StatusBadRequest},
+		{"traversal", "tz=../../etc/passwd", http.StatusBadRequest},
+		{"spaces", "tz=America/Los%20Angeles", http.StatusBadRequest},
+		{"repeated", "tz=UTC&tz=UTC", http.StatusBadRequest},
+		{"unknown zone", "tz=Nowhere/Special", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		handleTime(w, httptest.NewRequest("GET", "/time?"+tt.query, nil))
+		if w.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, tt.status)
+		}
+	}
+}
+
+func TestRoutes(t *testing.T) {
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, httptest.NewRequest("GET", "/routes", nil))
+
+	var routes []routeInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &routes); err != nil {
+		t.Fatalf("status %d - %v", w.Code, err)
+	}
+	listed := make(map[string]bool)
+	for _, route := range routes {
+		for _, method := range route.Methods {
+			listed[method+" "+route.Path] = true
+		}
+	}
+	for _, want := range []string{"GET /login", "POST /login", "POST /logout", "GET /routes", "GET /time/iso", "GET /time/stream", "GET /healthz", "HEAD /healthz"} {
+		if !listed[want] {
+			t.Errorf("%s missing from /routes", want)
+		}
+	}
+	if listed["GET /debug/buildinfo"] {
+		t.Error("debug route listed without --debug-endpoints")
+	}
+}
+
+// pprof and expvar register on http.DefaultServeMux, which the public
+// router must not fall through to.
+func TestDebugNotPublic(t *testing.T) {
+	router := newRouter()
+	for _, target := range []string{"/debug/pprof/", "/debug/pprof/cmdline", "/debug/vars"} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("%s: status = %d, want %d", target, w.Code, http.StatusNotFound)
+		}
+	}
+}
+
+func TestLoginReturnTarget(t *testing.T) {
+	withAuthStub(t, people.NO_CAPACITY_LIMIT)
+	override(t, config.CookieCheck, false)
+	tests := []struct {
+		target string
+		want   string
+	}{
+		{"", "/"},
+		{"/time?tz=UTC", "/time?tz=UTC"},
+		{"/settings", "/settings"},
+		{"https://evil.example/", "/"},
+		{"//evil.example/", "/"},
+		{"/\\evil.example/", "/"},
+		{"javascript:alert(1)", "/"},
+		{"time", "/"},
+		{"%zz", "/"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		handleProcessLogin(w, loginRequest("Ada", tt.target))
+		if w.Code != http.StatusFound {
+			t.Errorf("return %q: status = %d, want %d", tt.target, w.Code, http.StatusFound)
+		}
+		if got := w.Header().Get("Location"); got != tt.want {
+			t.Errorf("return %q: redirected to %q, want %q", tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestCookieCheck(t *testing.T) {
+	withAuthStub(t, people.NO_CAPACITY_LIMIT)
+	override(t, config.CookieCheck, true)
+	tests := []struct {
+		name     string
+		keepsJar bool
+		contains string
+	}{
+		{"keeps cookies", true, "Ada"},
+		{"drops cookies", false, "enable cookies"},
+	}
+	for _, tt := range tests {
+		login := httptest.NewRecorder()
+		handleProcessLogin(login, loginRequest("Ada", ""))
+		target := login.Header().Get("Location")
+		if !strings.Contains(target, COOKIE_CHECK_PARAM+"=1") {
+			t.Fatalf("%s: login redirected to %q without the cookie check", tt.name, target)
+		}
+
+		r := httptest.NewRequest("GET", target, nil)
+		if tt.keepsJar {
+			for _, c := range login.Result().Cookies() {
+				r.AddCookie(c)
+			}
+		}
+		w := httptest.NewRecorder()
+		handleDefault(w, r)
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), tt.contains) {
+			t.Errorf("%s: status %d without %q in body", tt.name, w.Code, tt.contains)
+		}
+	}
+
+	// Without the marker a visitor with no cookie is simply sent to log in.
+	w := httptest.NewRecorder()
+	handleDefault(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/login" {
+		t.Errorf("anonymous visit: status %d to %q, want redirect to /login", w.Code, w.Header().Get("Location"))
+	}
+}
+
+func TestTemplateDelimiters(t *testing.T) {
+	tests := []struct {
+		left, right string
+		source      string
+		want        string
+	}{
+		{"{{", "}}", `{{define "hello"}}Hi {{.}}{{end}}`, "Hi Ada"},
+		{"[[", "]]", `[[define "hello"]]Hi [[.]] {{ngModel}}[[end]]`, "Hi Ada {{ngModel}}"},
+		{"<%", "%>", `<%define "hello"%>Hi <%.%> {{x}} [[y]]<%end%>`, "Hi Ada {{x}} [[y]]"},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, "hello"+TEMPL_FILE_EXTENSION), []byte(tt.source), 0600); err != nil {
+			t.Fatal(err)
+		}
+		override(t, config.TmplDir, dir)
+		override(t, config.LeftDelim, tt.left)
+		override(t, config.RightDelim, tt.right)
+
+		parsed, err := parseTemplates()
+		if err != nil {
+			t.Fatalf("%s %s: %v", tt.left, tt.right, err)
+		}
+		var out strings.Builder
+		if err := parsed.ExecuteTemplate(&out, "hello", "Ada"); err != nil {
+			t.Fatalf("%s %s: %v", tt.left, tt.right, err)
+		}
+		if out.String() != tt.want {
+			t.Errorf("%s %s: rendered %q, want %q", tt.left, tt.right, out.String(), tt.want)
+		}
+	}
+}
+
+func TestBlockProbes(t *testing.T) {
+	tests := []struct {
+		path    string
+		blocked bool
+	}{
+		{"/wp-login.php", true},
+		{"/.env", true},
+		{"/.git/config", true},
+		{"/.git", true},
+		{"/phpmyadmin/index.php", true},
+		{"/time", false},
+		{"/.envy", false},
+		{"/wp-login.php.bak", false},
+		{"/static/style.css", false},
+	}
+	h := blockProbes(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "routed")
+	}))
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		start := time.Now()
+		h.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+		took := time.Since(start)
+
+		if blocked := w.Code == http.StatusNotFound && w.Body.Len() == 0; blocked != tt.blocked {
+			t.Errorf("%s: status %d body %q, want blocked %v", tt.path, w.Code, w.Body.String(), tt.blocked)
+		}
+		if tt.blocked && took > 50*time.Millisecond {
+			t.Errorf("%s: blocked after %s without a tarpit", tt.path, took)
+		}
+	}
+}
+
+func TestTarpit(t *testing.T) {
+	const tarpit = 50 * time.Millisecond
+	override(t, config.Tarpit, tarpit)
+	h := blockProbes(http.NotFoundHandler())
+
+	start := time.Now()
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/.env", nil))
+	if took := time.Since(start); took < tarpit {
+		t.Errorf("blocked probe answered after %s, want at least %s", took, tarpit)
+	}
+
+	// Clients that give up are not held for the rest of the tarpit.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	start = time.Now()
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/.env", nil).WithContext(ctx))
+	if took := time.Since(start); took >= tarpit {
+		t.Errorf("abandoned probe held for %s", took)
+	}
+}
+
+func TestTimePrecision(t *testing.T) {
+	at := FIXED_NOW.Add(123456789 * time.Nanosecond)
+	override(t, &now, func() time.Time { return at })
+	for _, layout := range []*string{&localLayout, &utcLayout, &jsonLayout} {
+		override(t, layout, *layout)
+	}
+	tests := []struct {
+		precision string
+		text      string
+		json      string
+	}{
+		{config.TIME_PRECISION, "12:00:00 PM (12:00:00 UTC) in UTC", "2015-03-01T12:00:00.123456789Z"},
+		{"seconds", "12:00:00 PM (12:00:00 UTC) in UTC", "2015-03-01T12:00:00Z"},
+		{"millis", "12:00:00.123 PM (12:00:00.123 UTC) in UTC", "2015-03-01T12:00:00.123Z"},
+		{"nanos", "12:00:00.123456789 PM (12:00:00.123456789 UTC) in UTC", "2015-03-01T12:00:00.123456789Z"},
+	}
+	for _, tt := range tests {
+		override(t, config.TimePrecision, tt.precision)
+		localLayout = withPrecision(LOCAL_TIME_LAYOUT)
+		utcLayout = withPrecision(UTC_TIME_LAYOUT)
+		jsonLayout = withPrecision(time.RFC3339Nano)
+
+		w := httptest.NewRecorder()
+		handleTime(w, httptest.NewRequest("GET", "/time?format=text&tz=UTC", nil))
+		if got := strings.TrimSpace(w.Body.String()); got != tt.text {
+			t.Errorf("%q: /time = %q, want %q", tt.precision, got, tt.text)
+		}
+
+		w = httptest.NewRecorder()
+		handleTimeJSON(w, httptest.NewRequest("GET", "/time.json", nil))
+		var doc struct{ Time string }
+		json.Unmarshal(w.Body.Bytes(), &doc)
+		if doc.Time != tt.json {
+			t.Errorf("%q: /time.json time = %q, want %q", tt.precision, doc.Time, tt.json)
+		}
+	}
+}
+
+// Returns bytes of live heap after a full collection.
+func liveHeap() uint64 {
+	var stats runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&stats)
+	return stats.HeapAlloc
+}
+
+// Reloaded sets replace the current set rather than accumulate, so the
+// live heap after many reloads stays within a few sets of where it began.
+func TestReloadTemplatesReleasesOldSets(t *testing.T) {
+	const reloads = 300
+	override(t, config.WarmTemplates, false)
+	hup := make(chan os.Signal)
+	done := make(chan struct{})
+	go func() {
+		reloadTemplates(hup)
+		close(done)
+	}()
+	reload := func(n int) {
+		for i := 0; i < n; i++ {
+			hup <- syscall.SIGHUP
+		}
+	}
+
+	reload(10)
+	before := liveHeap()
+	first := currentTemplates()
+	reload(reloads)
+	close(hup)
+	<-done
+	after := liveHeap()
+
+	if currentTemplates() == first {
+		t.Fatal("templates not swapped by reload")
+	}
+	// One set is in the order of 100 KiB, so a leak would be tens of MiB.
+	if after > before+4<<20 {
+		t.Errorf("live heap grew from %d to %d bytes over %d reloads", before, after, reloads)
+	}
+}
+
+func TestPostLoginPath(t *testing.T) {
+	users := withAuthStub(t, people.NO_CAPACITY_LIMIT)
+	users.Add(TEST_UUID, "Ada")
+	tests := []struct {
+		path     string
+		status   int
+		location string
+	}{
+		{config.POST_LOGIN_PATH, http.StatusOK, ""},
+		{"/time", http.StatusFound, "/time"},
+		{"/time/iso", http.StatusFound, "/time/iso"},
+	}
+	for _, tt := range tests {
+		override(t, config.PostLoginPath, tt.path)
+		w := httptest.NewRecorder()
+		handleDefault(w, sessionRequest("GET", "/", TEST_UUID))
+		if w.Code != tt.status || w.Header().Get("Location") != tt.location {
+			t.Errorf("%q: status %d to %q, want %d to %q", tt.path, w.Code, w.Header().Get("Location"), tt.status, tt.location)
+		}
+		if tt.status == http.StatusOK && !strings.Contains(w.Body.String(), "Ada") {
+			t.Errorf("%q: greetings page without the name", tt.path)
+		}
+	}
+}
+
+func TestExtendedTime(t *testing.T) {
+	tests := []struct {
+		date                      string
+		isoYear, isoWeek, yearDay int
+	}{
+		{"2015-03-01", 2015, 9, 60},
+		{"2021-01-01", 2020, 53, 1},
+		{"2024-12-30", 2025, 1, 365},
+		{"2016-12-31", 2016, 52, 366},
+	}
+	for _, tt := range tests {
+		at, _ := time.Parse("2006-01-02", tt.date)
+		override(t, &now, func() time.Time { return at.Add(12 * time.Hour) })
+
+		w := httptest.NewRecorder()
+		handleTime(w, httptest.NewRequest("GET", "/time?format=json&tz=UTC&extended=1", nil))
+		var resp timeResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		if resp.ISOYear != tt.isoYear || resp.ISOWeek != tt.isoWeek || resp.YearDay != tt.yearDay {
+			t.Errorf("%s: week %d-W%02d day %d, want %d-W%02d day %d", tt.date,
+				resp.ISOYear, resp.ISOWeek, resp.YearDay, tt.isoYear, tt.isoWeek, tt.yearDay)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	handleTime(w, httptest.NewRequest("GET", "/time?format=json&tz=UTC", nil))
+	if strings.Contains(w.Body.String(), "iso_week") {
+		t.Errorf("calendar fields without ?extended=1 - %s", w.Body.String())
+	}
+}
+
+// Returns a form submission of values to target carrying the session
+// cookie for uuid.
+func sessionForm(target string, uuid string, values url.Values) *http.Request {
+	r := httptest.NewRequest("POST", target, strings.NewReader(values.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.AddCookie(cookie.NewCookie(uuid, cookie.Age()))
+	return r
+}
+
+func TestProfileTheme(t *testing.T) {
+	users := withAuthStub(t, people.NO_CAPACITY_LIMIT)
+	users.Add(TEST_UUID, "Ada")
+	tests := []struct {
+		theme  string
+		status int
+		stored string
+	}{
+		{"dark", http.StatusFound, "dark"},
+		{"neon", http.StatusBadRequest, "dark"},
+		{"light", http.StatusFound, "light"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		handleProfileTheme(w, sessionForm("/profile/theme", TEST_UUID, url.Values{"theme": {tt.theme}}))
+		if w.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.theme, w.Code, tt.status)
+		}
+		if got := users.Theme(TEST_UUID); got != tt.stored {
+			t.Errorf("%s: stored theme %q, want %q", tt.theme, got, tt.stored)
+		}
+
+		page := httptest.NewRecorder()
+		handleDefault(page, sessionRequest("GET", "/", TEST_UUID))
+		if !strings.Contains(page.Body.String(), `class="theme-`+tt.stored+`"`) {
+			t.Errorf("%s: page not rendered with theme %s", tt.theme, tt.stored)
+		}
+	}
+
+	// Visitors without a session see the default theme.
+	w := httptest.NewRecorder()
+	handleDisplayLogin(w, httptest.NewRequest("GET", "/login", nil))
+	if !strings.Contains(w.Body.String(), `class="theme-`+*config.DefaultTheme+`"`) {
+		t.Errorf("login page not rendered with default theme %s", *config.DefaultTheme)
+	}
+}
+
+func TestHealthz(t *testing.T) {
+	router := newRouter()
+	tests := []struct {
+		method string
+		status int
+		body   string
+	}{
+		{"GET", http.StatusOK, "ok\n"},
+		{"HEAD", http.StatusOK, ""},
+		{"POST", http.StatusMethodNotAllowed, ""},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(tt.method, "/healthz", nil))
+		if w.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.method, w.Code, tt.status)
+		}
+		if tt.status == http.StatusOK && w.Body.String() != tt.body {
+			t.Errorf("%s: body = %q, want %q", tt.method, w.Body.String(), tt.body)
+		}
+		if tt.status == http.StatusOK && w.Header().Get("Content-Type") != "text/plain; charset=utf-8" {
+			t.Errorf("%s: Content-Type = %q", tt.method, w.Header().Get("Content-Type"))
+		}
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	override(t, config.AdminToken, "s3cret")
+	empty := t.TempDir()
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	down, _ := url.Parse(closed.URL)
+	tests := []struct {
+		name          string
+		authDown      bool
+		tmplDir       string
+		authorization string
+		status        int
+		errors        map[string]string
+	}{
+		{"ready", false, "", "", http.StatusOK, nil},
+		{"ready, templates reparsed", false, config.TMPL_DIR, "", http.StatusOK, nil},
+		{"auth unreachable", true, "", "", http.StatusServiceUnavailable, map[string]string{"auth": READYZ_FAILED}},
+		{"templates unparsed", false, empty, "", http.StatusServiceUnavailable, map[string]string{"templates": READYZ_FAILED}},
+		{"both, wrong token", true, empty, "Bearer wrong", http.StatusServiceUnavailable, map[string]string{"auth": READYZ_FAILED, "templates": READYZ_FAILED}},
+		{"both, admin", true, empty, "Bearer s3cret", http.StatusServiceUnavailable, map[string]string{"auth": down.Host, "templates": empty}},
+	}
+	for _, tt := range tests {
+		withAuthStub(t, people.NO_CAPACITY_LIMIT)
+		if tt.authDown {
+			override(t, &authClient, client.NewAuthClient(down.Hostname(), ":"+down.Port(), time.Second))
+		}
+		// Templates are only reparsed, from tmplDir, under
+		// --dev-templates.
+		override(t, config.DevTemplates, tt.tmplDir != "")
+		override(t, config.TmplDir, tt.tmplDir)
+		r := httptest.NewRequest("GET", "/readyz", nil)
+		if tt.authorization != "" {
+			r.Header.Set("Authorization", tt.authorization)
+		}
+		w := httptest.NewRecorder()
+		handleReadyz(w, r)
+		if w.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, tt.status)
+		}
+		var body struct {
+			Status string
+			Errors map[string]string
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Errorf("%s: body %q - %v", tt.name, w.Body.String(), err)
+			continue
+		}
+		if want := map[bool]string{true: "ok", false: "unavailable"}[tt.status == http.StatusOK]; body.Status != want {
+			t.Errorf("%s: status %q, want %q", tt.name, body.Status, want)
+		}
+		if len(body.Errors) != len(tt.errors) {
+			t.Errorf("%s: errors = %v, want %v", tt.name, body.Errors, tt.errors)
+		}
+		for check, want := range tt.errors {
+			if got := body.Errors[check]; !strings.Contains(got, want) {
+				t.Errorf("%s: %s error %q, want it to contain %q", tt.name, check, got, want)
+			}
+		}
+		if tt.authorization != "Bearer s3cret" && (strings.Contains(w.Body.String(), down.Host) || strings.Contains(w.Body.String(), empty)) {
+			t.Errorf("%s: body %q gives failure reasons without the admin token", tt.name, w.Body.String())
+		}
+	}
+}
+
+func TestParseTemplatesNoMatches(t *testing.T) {
+	tests := []struct {
+		name  string
+		files []string
+		err   bool
+	}{
+		{"empty", nil, true},
+		{"no templates", []string{".DS_Store", "notes.txt"}, true},
+		{"one template", []string{"hello" + TEMPL_FILE_EXTENSION}, false},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		for _, name := range tt.files {
+			if err := os.WriteFile(filepath.Join(dir, name), []byte("hello"), 0600); err != nil {
+				t.Fatal(err)
+			}
+		}
+		override(t, config.TmplDir, dir)
+		_, err := parseTemplates()
+		if (err != nil) != tt.err {
+			t.Errorf("%s: parseTemplates() error %v, want error %v", tt.name, err, tt.err)
+			continue
+		}
+		if err != nil && (!strings.Contains(err.Error(), dir) || !strings.Contains(err.Error(), "*"+TEMPL_FILE_EXTENSION)) {
+			t.Errorf("%s: error %q does not name %s and the glob", tt.name, err, dir)
+		}
+	}
+}
+
+func TestRenderLimit(t *testing.T) {
+	const workers = 20
+	tests := []struct {
+		limit int
+	}{
+		{1},
+		{2},
+		{5},
+	}
+	for _, tt := range tests {
+		override(t, &renderSlots, make(chan struct{}, tt.limit))
+		override(t, config.RenderWait, 5*time.Second)
+		var (
+			mu       sync.Mutex
+			inFlight int
+			peak     int
+			refused  atomic.Int64
+			wg       sync.WaitGroup
+		)
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if !acquireRender(httptest.NewRequest("GET", "/time", nil)) {
+					refused.Add(1)
+					return
+				}
+				mu.Lock()
+				if inFlight++; inFlight > peak {
+					peak = inFlight
+				}
+				mu.Unlock()
+				time.Sleep(2 * time.Millisecond)
+				mu.Lock()
+				inFlight--
+				mu.Unlock()
+				releaseRender()
+			}()
+		}
+		wg.Wait()
+		if peak > tt.limit {
+			t.Errorf("--max-renders %d: %d renders at once", tt.limit, peak)
+		}
+		if n := refused.Load(); n != 0 {
+			t.Errorf("--max-renders %d: %d renders refused within --render-wait", tt.limit, n)
+		}
+	}
+}
+
+func TestRenderLimitRefuses(t *testing.T) {
+	override(t, &renderSlots, make(chan struct{}, 1))
+	override(t, config.RenderWait, 10*time.Millisecond)
+	renderSlots <- struct{}{}
+	w := httptest.NewRecorder()
+	renderTemplate(w, httptest.NewRequest("GET", "/logout", nil), "logged-out", nil)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d with every slot taken, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+	releaseRender()
+	w = httptest.NewRecorder()
+	renderTemplate(w, httptest.NewRequest("GET", "/logout", nil), "logged-out", nil)
+	if w.Code != http.StatusOK {
+		t.Errorf("status = %d with a slot free, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestTimeQR(t *testing.T) {
+	withFixedNow(t)
+	tests := []struct {
+		target string
+		size   int
+	}{
+		{"/time/qr", 256},
+		{"/time/qr?content=time", 256},
+		{"/time/qr", 64},
+	}
+	for _, tt := range tests {
+		override(t, config.QRSize, tt.size)
+		w := httptest.NewRecorder()
+		handleTimeQR(w, httptest.NewRequest("GET", tt.target, nil))
+		if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/png" {
+			t.Errorf("%s: %d %s, want %d image/png", tt.target, w.Code, w.Header().Get("Content-Type"), http.StatusOK)
+			continue
+		}
+		img, err := png.Decode(w.Body)
+		if err != nil {
+			t.Errorf("%s: invalid PNG - %v", tt.target, err)
+			continue
+		}
+		if b := img.Bounds(); b.Dx() != tt.size || b.Dy() != tt.size {
+			t.Errorf("%s: image %dx%d, want %dx%d", tt.target, b.Dx(), b.Dy(), tt.size, tt.size)
+		}
+	}
+}
+
+func TestInlineLogin(t *testing.T) {
+	withAuthStub(t, people.NO_CAPACITY_LIMIT)
+	tests := []struct {
+		inline   bool
+		target   string
+		status   int
+		location string
+		body     string
+	}{
+		{false, "/", http.StatusFound, "/login", ""},
+		{false, "/?tz=UTC", http.StatusFound, "/login?" + RETURN_PARAM + "=" + url.QueryEscape("/?tz=UTC"), ""},
+		{true, "/", http.StatusOK, "", `action="/login"`},
+		{true, "/?tz=UTC", http.StatusOK, "", `name="return" value="/?tz=UTC"`},
+	}
+	for _, tt := range tests {
+		override(t, config.InlineLogin, tt.inline)
+		w := httptest.NewRecorder()
+		handleDefault(w, httptest.NewRequest("GET", tt.target, nil))
+		if w.Code != tt.status || w.Header().Get("Location") != tt.location {
+			t.Errorf("--inline-login %v %s: %d %q, want %d %q", tt.inline, tt.target, w.Code, w.Header().Get("Location"), tt.status, tt.location)
+		}
+		if !strings.Contains(w.Body.String(), tt.body) {
+			t.Errorf("--inline-login %v %s: body lacks %s", tt.inline, tt.target, tt.body)
+		}
+	}
+}
+
+func TestTimePageNonce(t *testing.T) {
+	users := withAuthStub(t, people.NO_CAPACITY_LIMIT)
+	users.Add(TEST_UUID, "Ada")
+	w := httptest.NewRecorder()
+	csp.Handler(http.HandlerFunc(handleTime)).ServeHTTP(w, sessionRequest("GET", "/time", TEST_UUID))
+
+	header := w.Header().Get(csp.HEADER_NAME)
+	start := strings.Index(header, "'nonce-")
+	if start < 0 {
+		t.Fatalf("%s %q carries no nonce", csp.HEADER_NAME, header)
+	}
+	nonce := strings.SplitN(header[start+len("'nonce-"):], "'", 2)[0]
+	// Attribute values come back HTML escaped, + as &#43; for one.
+	match := regexp.MustCompile(`<script nonce="([^"]*)">`).FindStringSubmatch(w.Body.String())
+	if match == nil || html.UnescapeString(match[1]) != nonce {
+		t.Errorf("rendered script nonce %v, want %q", match, nonce)
+	}
+}
+
+func TestTimeJSON(t *testing.T) {
+	users := withAuthStub(t, people.NO_CAPACITY_LIMIT)
+	users.Add(TEST_UUID, "Ada")
+	server := httptest.NewServer(newRouter())
+	defer server.Close()
+	tests := []struct {
+		name    string
+		query   string
+		accept  string
+		session bool
+		json    bool
+		user    string
+	}{
+		{"accept header", "", "application/json", true, true, "Ada"},
+		{"query param", "?format=json", "", true, true, "Ada"},
+		{"query param beats browser", "?format=json", "text/html,*/*", true, true, "Ada"},
+		{"anonymous", "?format=json", "", false, true, ""},
+		{"browser", "", "text/html,application/xhtml+xml,*/*;q=0.8", true, false, ""},
+		{"no accept header", "", "", true, false, ""},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest("GET", server.URL+"/time"+tt.query, nil)
+		if tt.accept != "" {
+			req.Header.Set("Accept", tt.accept)
+		}
+		if tt.session {
+			req.AddCookie(cookie.NewCookie(TEST_UUID, cookie.Age()))
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		isJSON := strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json")
+		if resp.StatusCode != http.StatusOK || isJSON != tt.json {
+			t.Errorf("%s: %d %s, want %d JSON %v", tt.name, resp.StatusCode, resp.Header.Get("Content-Type"), http.StatusOK, tt.json)
+			continue
+		}
+		if !tt.json {
+			continue
+		}
+		var fields map[string]interface{}
+		if err := json.Unmarshal(body, &fields); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		name, hasName := fields["name"]
+		if at, _ := fields["time"].(string); at == "" || (tt.user == "") == hasName || (hasName && name != tt.user) {
+			t.Errorf("%s: response %v, want time and name %q", tt.name, fields, tt.user)
+		}
+	}
+}
+
+func TestTimeZones(t *testing.T) {
+	withFixedNow(t)
+	tests := []struct {
+		tz     string
+		status int
+		time   string
+		zone   string
+	}{
+		{"America/New_York", http.StatusOK, "7:00:00 AM", "America/New_York"},
+		{"Asia/Tokyo", http.StatusOK, "9:00:00 PM", "Asia/Tokyo"},
+		{"UTC", http.StatusOK, "12:00:00 PM", "UTC"},
+		{"Nowhere/Special", http.StatusBadRequest, "", ""},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		handleTime(w, httptest.NewRequest("GET", "/time?format=json&tz="+url.QueryEscape(tt.tz), nil))
+		if w.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.tz, w.Code, tt.status)
+			continue
+		}
+		if tt.status != http.StatusOK {
+			continue
+		}
+		var resp timeResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Time != tt.time || resp.Zone != tt.zone {
+			t.Errorf("%s: time %q in %q, want %q in %q", tt.tz, resp.Time, resp.Zone, tt.time, tt.zone)
+		}
+
+		w = httptest.NewRecorder()
+		handleTime(w, httptest.NewRequest("GET", "/time?tz="+url.QueryEscape(tt.tz), nil))
+		if !strings.Contains(w.Body.String(), "in "+tt.zone) {
+			t.Errorf("%s: page does not name the zone", tt.tz)
+		}
+	}
+}
+
+func TestTimeRateLimit(t *testing.T) {
+	override(t, &timeLimiter, ratelimit.NewLimiter(0.5, 2))
+	h := limitTime(func(w http.ResponseWriter, r *http.Request) {})
+	tests := []struct {
+		uuid       string
+		status     int
+		retryAfter string
+	}{
+		{TEST_UUID, http.StatusOK, ""},
+		{TEST_UUID, http.StatusOK, ""},
+		{TEST_UUID, http.StatusTooManyRequests, "2"},
+		{people.UUID(), http.StatusOK, ""},
+	}
+	for i, tt := range tests {
+		w := httptest.NewRecorder()
+		h(w, sessionRequest("GET", "/time", tt.uuid))
+		if w.Code != tt.status || w.Header().Get("Retry-After") != tt.retryAfter {
+			t.Errorf("request %d: %d Retry-After %q, want %d %q", i, w.Code, w.Header().Get("Retry-After"), tt.status, tt.retryAfter)
+		}
+	}
+}
+
+func TestLoginRateLimit(t *testing.T) {
+	withAuthStub(t, people.NO_CAPACITY_LIMIT)
+	override(t, &loginLimiter, ratelimit.NewLimiter(0.2, 3))
+	h := limitLogin(handleProcessLogin)
+	tests := []struct {
+		name       string
+		remoteAddr string
+		status     int
+		retryAfter string
+	}{
+		{"", "192.0.2.1:1234", http.StatusBadRequest, ""},
+		{"", "192.0.2.1:1235", http.StatusBadRequest, ""},
+		{"", "192.0.2.1:1236", http.StatusBadRequest, ""},
+		{"", "192.0.2.1:1237", http.StatusTooManyRequests, "5"},
+		{"Ada", "192.0.2.1:1238", http.StatusTooManyRequests, "5"},
+		{"Ada", "192.0.2.2:1234", http.StatusFound, ""},
+	}
+	for i, tt := range tests {
+		r := loginRequest(tt.name, "")
+		r.RemoteAddr = tt.remoteAddr
+		w := httptest.NewRecorder()
+		h(w, r)
+		if w.Code != tt.status || w.Header().Get("Retry-After") != tt.retryAfter {
+			t.Errorf("login %d from %s: %d Retry-After %q, want %d %q", i, tt.remoteAddr, w.Code, w.Header().Get("Retry-After"), tt.status, tt.retryAfter)
+		}
+		if tt.status == http.StatusTooManyRequests && !strings.Contains(w.Body.String(), "Please wait 5 seconds") {
+			t.Errorf("login %d from %s: body lacks the 429 page", i, tt.remoteAddr)
+		}
+	}
+}
+
+func TestMissingTemplate(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/missing", func(w http.ResponseWriter, r *http.Request) {
+		renderTemplate(w, r, "no-such-template", nil)
+	})
+	mux.Handle("/", newRouter())
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/missing", http.StatusInternalServerError},
+		{"/login", http.StatusOK},
+		{"/missing", http.StatusInternalServerError},
+		{"/login", http.StatusOK},
+	}
+	for _, tt := range tests {
+		resp, err := http.Get(server.URL + tt.path)
+		if err != nil {
+			t.Fatalf("%s: server gone - %v", tt.path, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.path, resp.StatusCode, tt.status)
+		}
+		if tt.status == http.StatusOK && !strings.Contains(string(body), "earthling_login") {
+			t.Errorf("%s: login form not rendered", tt.path)
+		}
+	}
+}
+
+// Names at the published limits must get the verdict the rules promise.
+func TestValidationRules(t *testing.T) {
+	w := httptest.NewRecorder()
+	handleValidationRules(w, httptest.NewRequest("GET", "/validation-rules", nil))
+	var rules people.Rules
+	if err := json.Unmarshal(w.Body.Bytes(), &rules); err != nil {
+		t.Fatalf("status %d - %v", w.Code, err)
+	}
+	if rules != people.NameRules() || rules.MaxLength != people.NAME_MAX_LENGTH {
+		t.Errorf("rules = %+v, want %+v", rules, people.NameRules())
+	}
+	pattern := regexp.MustCompile(rules.Pattern)
+	tests := []struct {
+		name  string
+		valid bool
+	}{
+		{strings.Repeat("a", rules.MaxLength), true},
+		{strings.Repeat("a", rules.MaxLength+1), false},
+		{strings.Repeat("é", rules.MaxLength), true},
+		{strings.Repeat("a", rules.MinLength), true},
+		{strings.Repeat("a", rules.MinLength-1), false},
+		{"Jean-Luc O'Brien", true},
+		{"Ada Byron King", false},
+	}
+	for _, tt := range tests {
+		if got := people.IsValidName(tt.name); got != tt.valid {
+			t.Errorf("IsValidName(%q) = %v, want %v under %+v", tt.name, got, tt.valid, rules)
+		}
+		if tt.valid && !pattern.MatchString(tt.name) {
+			t.Errorf("published pattern rejects valid %q", tt.name)
+		}
+	}
+}
+
+func TestLoggableName(t *testing.T) {
+	tests := []struct {
+		name string
+		mode string
+		want string
+	}{
+		{"Ada", "plain", `"Ada"`},
+		{"José", "plain", `"José"`},
+		{"Ada", "redacted", "length=3 sha256=99a563ab2f6e21e9"},
+		{"José", "redacted", "length=4 sha256=24c2ab65b7adab7e"},
+		{"Grace Hopper", "redacted", "length=12 sha256=b2278a963678b908"},
+	}
+	for _, tt := range tests {
+		got := loggableName(tt.name, tt.mode)
+		if got != tt.want {
+			t.Errorf("loggableName(%q, %s) = %q, want %q", tt.name, tt.mode, got, tt.want)
+		}
+		if tt.mode == "redacted" && strings.Contains(got, tt.name) {
+			t.Errorf("loggableName(%q, %s) = %q reveals the name", tt.name, tt.mode, got)
+		}
+	}
+}
+
+func TestListenError(t *testing.T) {
+	bindErr := func(errno syscall.Errno) error {
+		return &net.OpError{Op: "listen", Net: "tcp", Err: os.NewSyscallError("bind", errno)}
+	}
+	tests := []struct {
+		name string
+		addr string
+		err  error
+		hint bool
+	}{
+		{"privileged port denied", ":80", bindErr(syscall.EACCES), true},
+		{"privileged port on host denied", "127.0.0.1:443", bindErr(syscall.EACCES), true},
+		{"unprivileged port denied", ":8080", bindErr(syscall.EACCES), false},
+		{"privileged port in use", ":80", bindErr(syscall.EADDRINUSE), false},
+		{"unparseable address", "80", bindErr(syscall.EACCES), false},
+	}
+	for _, tt := range tests {
+		got := listenError(tt.addr, tt.err)
+		hinted := strings.Contains(got.Error(), "CAP_NET_BIND_SERVICE")
+		if hinted != tt.hint || !strings.Contains(got.Error(), tt.err.Error()) {
+			t.Errorf("%s: listenError() = %q, want hint %v", tt.name, got, tt.hint)
+		}
+		if !tt.hint && got != tt.err {
+			t.Errorf("%s: error %v replaced", tt.name, tt.err)
+		}
+	}
+}
+
+// Returns the session cookie set by a response, or nil.
+func sessionCookie(w *httptest.ResponseRecorder) *http.Cookie {
+	for _, c := range (&http.Response{Header: w.Header()}).Cookies() {
+		if c.Name == cookie.COOKIE_NAME {
+			return c
+		}
+	}
+	return nil
+}
+
+func TestCookieAttributes(t *testing.T) {
+	defer cookie.SetAttributes(false, http.SameSiteLaxMode)
+	withAuthStub(t, people.NO_CAPACITY_LIMIT)
+	tests := []struct {
+		secure   bool
+		sameSite http.SameSite
+	}{
+		{false, http.SameSiteLaxMode},
+		{true, http.SameSiteLaxMode},
+		{true, http.SameSiteStrictMode},
+		{true, http.SameSiteNoneMode},
+	}
+	for _, tt := range tests {
+		cookie.SetAttributes(tt.secure, tt.sameSite)
+
+		login := httptest.NewRecorder()
+		handleProcessLogin(login, loginRequest("Ada", ""))
+		created := sessionCookie(login)
+		if created == nil {
+			t.Fatalf("secure %v samesite %d: login set no session cookie", tt.secure, tt.sameSite)
+		}
+		uuid, _ := cookie.UUID(&http.Request{Header: http.Header{"Cookie": {created.String()}}})
+
+		logout := httptest.NewRecorder()
+		handleLogout(logout, sessionRequest("POST", "/logout", uuid))
+		deleted := sessionCookie(logout)
+		if deleted == nil || deleted.MaxAge >= 0 {
+			t.Fatalf("secure %v samesite %d: logout did not delete the session cookie", tt.secure, tt.sameSite)
+		}
+
+		for flow, c := range map[string]*http.Cookie{"login": created, "logout": deleted} {
+			if !c.HttpOnly || c.Secure != tt.secure || c.SameSite != tt.sameSite || c.Path != cookie.COOKIE_PATH {
+				t.Errorf("secure %v samesite %d: %s cookie %q", tt.secure, tt.sameSite, flow, c)
+			}
+		}
+	}
+}
+
+// Resolver placing addresses in the zones it maps them to.
+type stubResolver map[string]string
+
+func (s stubResolver) Timezone(ip net.IP) (*time.Location, error) {
+	zone, ok := s[ip.String()]
+	if !ok {
+		return nil, geo.ErrUnknownLocation
+	}
+	return time.LoadLocation(zone)
+}
+
+func TestTimeAutoZone(t *testing.T) {
+	withFixedNow(t)
+	tests := []struct {
+		name     string
+		resolver geo.TimezoneResolver
+		remote   string
+		query    string
+		zone     string
+	}{
+		{"default resolver", geo.UTC{}, "198.51.100.7:5000", "auto=1", "UTC"},
+		{"resolved", stubResolver{"198.51.100.7": "Asia/Tokyo"}, "198.51.100.7:5000", "auto=1", "Asia/Tokyo"},
+		{"resolved ipv6", stubResolver{"2001:db8::1": "Europe/Paris"}, "[2001:db8::1]:5000", "auto=1", "Europe/Paris"},
+		{"unresolved", stubResolver{}, "198.51.100.7:5000", "auto=1", ""},
+		{"no address", stubResolver{"198.51.100.7": "Asia/Tokyo"}, "pipe", "auto=1", ""},
+		{"not asked", stubResolver{"198.51.100.7": "Asia/Tokyo"}, "198.51.100.7:5000", "", ""},
+		{"tz wins", stubResolver{"198.51.100.7": "Asia/Tokyo"}, "198.51.100.7:5000", "auto=1&tz=UTC", "UTC"},
+	}
+	for _, tt := range tests {
+		override(t, &tzResolver, tt.resolver)
+		r := httptest.NewRequest("GET", "/time?format=json&"+tt.query, nil)
+		r.RemoteAddr = tt.remote
+		w := httptest.NewRecorder()
+		handleTime(w, r)
+		var resp timeResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("%s: status %d - %v", tt.name, w.Code, err)
+		}
+		if resp.Zone != tt.zone {
+			t.Errorf("%s: zone = %q, want %q", tt.name, resp.Zone, tt.zone)
+		}
+	}
+}
+
+func TestVersion(t *testing.T) {
+	router := newRouter()
+	tests := []struct {
+		method string
+		status int
+	}{
+		{"GET", http.StatusOK},
+		{"POST", http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(tt.method, "/version", nil))
+		if w.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.method, w.Code, tt.status)
+			continue
+		}
+		if tt.status != http.StatusOK {
+			continue
+		}
+		var got map[string]string
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		if want := map[string]string{"version": VERSION_NUMBER}; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: body %v, want %v", tt.method, got, want)
+		}
+	}
+}
+
+func TestBuildInfo(t *testing.T) {
+	tests := []struct {
+		debug  bool
+		status int
+	}{
+		{false, http.StatusNotFound},
+		{true, http.StatusOK},
+	}
+	for _, tt := range tests {
+		override(t, config.DebugEndpts, tt.debug)
+		w := httptest.NewRecorder()
+		newRouter().ServeHTTP(w, httptest.NewRequest("GET", "/debug/buildinfo", nil))
+		if w.Code != tt.status {
+			t.Errorf("--debug-endpoints %v: status = %d, want %d", tt.debug, w.Code, tt.status)
+			continue
+		}
+		if tt.status != http.StatusOK {
+			continue
+		}
+		var info buildInfo
+		if err := json.Unmarshal(w.Body.Bytes(), &info); err != nil {
+			t.Fatalf("invalid JSON - %v", err)
+		}
+		if info.Version != VERSION_NUMBER || !strings.HasPrefix(info.GoVersion, "go") {
+			t.Errorf("build info %+v, want version %s and a Go version", info, VERSION_NUMBER)
+		}
+	}
+}
+
+func TestKeepAlives(t *testing.T) {
+	tests := []struct {
+		disabled bool
+		close    bool
+	}{
+		{false, false},
+		{true, true},
+	}
+	for _, tt := range tests {
+		override(t, config.NoKeepAlives, tt.disabled)
+		server := httptest.NewUnstartedServer(nil)
+		server.Config = newServer(http.HandlerFunc(handleHealthz))
+		server.Start()
+
+		// Read raw as net/http drops the Connection header from responses.
+		conn, err := net.Dial("tcp", server.Listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(conn, "GET /healthz HTTP/1.1\r\nHost: localhost\r\n\r\n")
+		response := textproto.NewReader(bufio.NewReader(conn))
+		status, err := response.ReadLine()
+		if err != nil {
+			t.Fatal(err)
+		}
+		header, err := response.ReadMIMEHeader()
+		conn.Close()
+		server.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := header.Get("Connection"); (got == "close") != tt.close {
+			t.Errorf("--disable-keepalives %v: %s with Connection %q, want close %v", tt.disabled, status, got, tt.close)
+		}
+	}
+}
+
+func TestCheckTLSFlags(t *testing.T) {
+	tests := []struct {
+		name     string
+		cert     string
+		key      string
+		redirect string
+		err      bool
+	}{
+		{"plain HTTP", config.TLS_CERT, config.TLS_KEY, config.HTTP_REDIRECT, false},
+		{"HTTPS", "server.crt", "server.key", config.HTTP_REDIRECT, false},
+		{"HTTPS with redirect", "server.crt", "server.key", ":8081", false},
+		{"cert only", "server.crt", config.TLS_KEY, config.HTTP_REDIRECT, true},
+		{"key only", config.TLS_CERT, "server.key", config.HTTP_REDIRECT, true},
+		{"redirect without TLS", config.TLS_CERT, config.TLS_KEY, ":8081", true},
+	}
+	for _, tt := range tests {
+		override(t, config.TLSCert, tt.cert)
+		override(t, config.TLSKey, tt.key)
+		override(t, config.HTTPRedirect, tt.redirect)
+		if err := checkTLSFlags(); (err != nil) != tt.err {
+			t.Errorf("%s: checkTLSFlags() = %v, want error %v", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestTLSMinVersion(t *testing.T) {
+	tests := []struct {
+		min    string
+		client uint16
+		ok     bool
+	}{
+		{"1.2", tls.VersionTLS12, true},
+		{"1.2", tls.VersionTLS11, false},
+		{"1.3", tls.VersionTLS13, true},
+		{"1.3", tls.VersionTLS12, false},
+	}
+	for _, tt := range tests {
+		conf, err := newTLSConfig(tt.min)
+		if err != nil {
+			t.Fatal(err)
+		}
+		override(t, &tlsConfig, conf)
+		server := httptest.NewUnstartedServer(nil)
+		server.Config = newServer(http.HandlerFunc(handleHealthz))
+		server.TLS = server.Config.TLSConfig
+		server.StartTLS()
+
+		client := server.Client()
+		transport := client.Transport.(*http.Transport)
+		transport.TLSClientConfig.MinVersion = tt.client
+		transport.TLSClientConfig.MaxVersion = tt.client
+		resp, err := client.Get(server.URL + "/healthz")
+		if err == nil {
+			resp.Body.Close()
+		}
+		server.Close()
+		if (err == nil) != tt.ok {
+			t.Errorf("--tls-min-version %s: client at %x got error %v, want success %v", tt.min, tt.client, err, tt.ok)
+		}
+	}
+	if _, err := newTLSConfig("2.0"); err == nil {
+		t.Error("newTLSConfig(2.0) succeeded")
+	}
+}
+
+func TestRedirectToHTTPS(t *testing.T) {
+	tests := []struct {
+		port   string
+		target string
+		want   string
+	}{
+		{":443", "http://example.com/time?tz=UTC", "https://example.com/time?tz=UTC"},
+		{":8443", "http://example.com:8080/login", "https://example.com:8443/login"},
+		{"8443", "http://example.com/", "https://example.com:8443/"},
+		{":443", "http://[::1]:8080/", "https://[::1]/"},
+		{":8443", "http://[::1]:8080/", "https://[::1]:8443/"},
+	}
+	for _, tt := range tests {
+		override(t, config.TimePort, tt.port)
+		w := httptest.NewRecorder()
+		redirectToHTTPS(w, httptest.NewRequest("GET", tt.target, nil))
+		if got := w.Header().Get("Location"); w.Code != http.StatusMovedPermanently || got != tt.want {
+			t.Errorf("--port %s %s: %d %q, want %d %q", tt.port, tt.target, w.Code, got, http.StatusMovedPermanently, tt.want)
+		}
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	const timeout = 50 * time.Millisecond
+	tests := []struct {
+		name   string
+		hang   bool
+		forced int64
+	}{
+		{"finishing request", false, 0},
+		{"hanging request", true, 1},
+	}
+	for _, tt := range tests {
+		started, release := make(chan struct{}), make(chan struct{})
+		server := httptest.NewUnstartedServer(nil)
+		server.Config = newServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			if tt.hang {
+				<-release
+			}
+		}))
+		server.Start()
+
+		failed := make(chan error, 1)
+		go func() {
+			resp, err := server.Client().Get(server.URL)
+			if err == nil {
+				resp.Body.Close()
+			}
+			failed <- err
+		}()
+		<-started
+
+		begin := time.Now()
+		forced := shutdown(server.Config, timeout)
+		took := time.Since(begin)
+		close(release)
+		server.Close()
+
+		if forced != tt.forced {
+			t.Errorf("%s: forcibly closed %d connections, want %d", tt.name, forced, tt.forced)
+		}
+		if took > timeout+time.Second {
+			t.Errorf("%s: shutdown took %s with a %s timeout", tt.name, took, timeout)
+		}
+		if err := <-failed; (err != nil) != tt.hang {
+			t.Errorf("%s: client error %v, want error %v", tt.name, err, tt.hang)
+		}
+	}
+}
+
+func TestDelay(t *testing.T) {
+	const slack = 200 * time.Millisecond
+	tests := []struct {
+		name      string
+		average   time.Duration
+		deviation time.Duration
+		cancelled bool
+		min       time.Duration
+		max       time.Duration
+	}{
+		{"none", 0, 0, false, 0, slack},
+		{"fixed", 30 * time.Millisecond, 0, false, 30 * time.Millisecond, 30*time.Millisecond + slack},
+		// Half the samples fall below zero and must not sleep.
+		{"deviation only", 0, 5 * time.Millisecond, false, 0, 5*5*time.Millisecond + slack},
+		{"cancelled", time.Hour, 0, true, 0, slack},
+	}
+	for _, tt := range tests {
+		ctx, cancel := context.WithCancel(context.Background())
+		if tt.cancelled {
+			cancel()
+		}
+		start := time.Now()
+		err := delay(ctx, tt.average, tt.deviation)
+		took := time.Since(start)
+		cancel()
+		if (err != nil) != tt.cancelled {
+			t.Errorf("%s: delay() error %v, want error %v", tt.name, err, tt.cancelled)
+		}
+		if took < tt.min || took > tt.max {
+			t.Errorf("%s: slept %s, want between %s and %s", tt.name, took, tt.min, tt.max)
+		}
+	}
+}
+
+func TestTimeSimulatedLoad(t *testing.T) {
+	const average = 30 * time.Millisecond
+	override(t, config.AvgRespMS, average)
+	start := time.Now()
+	w := httptest.NewRecorder()
+	handleTime(w, httptest.NewRequest("GET", "/time", nil))
+	if took := time.Since(start); w.Code != http.StatusOK || took < average {
+		t.Errorf("status %d after %s, want %d after at least %s", w.Code, took, http.StatusOK, average)
+	}
+}
+
+// API clients log out by echoing the token from the csrf cookie issued
+// on any GET.
+func TestLogoutCSRF(t *testing.T) {
+	users := withAuthStub(t, people.NO_CAPACITY_LIMIT)
+	server := httptest.NewServer(csrf.Handler(newRouter()))
+	defer server.Close()
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{Jar: jar, CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	base, _ := url.Parse(server.URL)
+	token := func() string {
+		for _, c := range jar.Cookies(base) {
+			if c.Name == csrf.COOKIE_NAME {
+				return c.Value
+			}
+		}
+		return ""
+	}
+	post := func(path string, form url.Values, token string) int {
+		r, _ := http.NewRequest("POST", server.URL+path, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.Header.Set("Accept", "application/json")
+		if token != "" {
+			r.Header.Set(csrf.HEADER_NAME, token)
+		}
+		resp, err := client.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if resp, err := client.Get(server.URL + "/healthz"); err != nil || token() == "" {
+		t.Fatalf("GET /healthz issued no csrf token - %v", err)
+	} else {
+		resp.Body.Close()
+	}
+	if status := post("/login", url.Values{"name": {"Ada"}}, token()); status != http.StatusFound || users.Len() != 1 {
+		t.Fatalf("login = %d with %d users, want %d and 1", status, users.Len(), http.StatusFound)
+	}
+
+	tests := []struct {
+		name   string
+		token  string
+		status int
+		users  int
+	}{
+		{"no token", "", http.StatusForbidden, 1},
+		{"wrong token", "guess", http.StatusForbidden, 1},
+		{"cookie token", token(), http.StatusNoContent, 0},
+	}
+	for _, tt := range tests {
+		if status := post("/logout", nil, tt.token); status != tt.status || users.Len() != tt.users {
+			t.Errorf("%s: logout = %d with %d users, want %d and %d", tt.name, status, users.Len(), tt.status, tt.users)
+		}
+	}
+}
+
+// Starts a stand in NTP server whose clock is ahead of the local one by
+// ahead and returns its address.
+func ntpStub(t *testing.T, ahead time.Duration) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		packet := make([]byte, ntp.PACKET_SIZE)
+		for {
+			_, addr, err := conn.ReadFrom(packet)
+			if err != nil {
+				return
+			}
+			at := time.Now().Add(ahead)
+			packet[0], packet[1] = 0x1C, 2
+			for _, field := range [][]byte{packet[32:40], packet[40:48]} {
+				binary.BigEndian.PutUint32(field[0:4], uint32(at.Unix()+ntp.EPOCH_OFFSET))
+				binary.BigEndian.PutUint32(field[4:8], uint32((int64(at.Nanosecond())<<32)/1e9))
+			}
+			conn.WriteTo(packet, addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestTimeNTPDrift(t *testing.T) {
+	const slack = 0.1
+	tests := []struct {
+		name   string
+		ahead  time.Duration
+		offset time.Duration
+		drift  float64
+	}{
+		{"local clock in step", 0, 0, 0},
+		{"local clock behind", time.Hour, 0, -3600},
+		{"corrected by --time-offset", time.Hour, time.Hour, 0},
+		{"shifted past NTP", 0, 90 * time.Second, 90},
+	}
+	for _, tt := range tests {
+		override(t, &ntpClient, ntp.NewClient(ntpStub(t, tt.ahead), time.Second, 0))
+		override(t, &now, clock.WithOffset(time.Now, tt.offset))
+		w := httptest.NewRecorder()
+		handleTimeNTP(w, httptest.NewRequest("GET", "/time/ntp", nil))
+		var got struct {
+			Offset float64 `json:"offset_seconds"`
+			Drift  float64 `json:"drift_seconds"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("%s: status %d - %v", tt.name, w.Code, err)
+		}
+		if d := got.Drift - tt.drift; d < -slack || d > slack {
+			t.Errorf("%s: drift %gs, want %gs", tt.name, got.Drift, tt.drift)
+		}
+		if d := got.Offset - tt.ahead.Seconds(); d < -slack || d > slack {
+			t.Errorf("%s: offset %gs, want %gs", tt.name, got.Offset, tt.ahead.Seconds())
+		}
+	}
+}
+
+func TestTimeNTPUnreachable(t *testing.T) {
+	// Nothing listens on the discard port, so the query is refused.
+	override(t, &ntpClient, ntp.NewClient("127.0.0.1:9", 100*time.Millisecond, 0))
+	w := httptest.NewRecorder()
+	handleTimeNTP(w, httptest.NewRequest("GET", "/time/ntp", nil))
+	if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), `"error"`) {
+		t.Errorf("status %d %s, want %d with the error", w.Code, w.Body.String(), http.StatusServiceUnavailable)
+	}
+}
+
+func TestAdmin(t *testing.T) {
+	const token = "s3cret"
+	routes := []struct {
+		method string
+		path   string
+		fn     func(w http.ResponseWriter, r *http.Request)
+		form   url.Values
+		status int
+		gone   bool
+	}{
+		{"GET", "/admin", handleAdmin, nil, http.StatusOK, false},
+		{"POST", "/admin/evict", handleAdminEvict, url.Values{"id": {TEST_UUID}}, http.StatusSeeOther, true},
+		{"POST", "/admin/evict", handleAdminEvict, url.Values{"id": {"not-a-uuid"}}, http.StatusBadRequest, false},
+		{"POST", "/admin/clear", handleAdminClear, url.Values{"confirm": {"yes"}}, http.StatusSeeOther, true},
+		{"POST", "/admin/clear", handleAdminClear, nil, http.StatusBadRequest, false},
+	}
+	tests := []struct {
+		name       string
+		configured string
+		auth       func(r *http.Request)
+		authorized bool
+		status     int
+	}{
+		{"no token configured", config.ADMIN_TOKEN, func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }, false, http.StatusNotFound},
+		{"no credentials", token, func(r *http.Request) {}, false, http.StatusUnauthorized},
+		{"wrong bearer token", token, func(r *http.Request) { r.Header.Set("Authorization", "Bearer guess") }, false, http.StatusUnauthorized},
+		{"no scheme", token, func(r *http.Request) { r.Header.Set("Authorization", token) }, false, http.StatusUnauthorized},
+		{"other scheme", token, func(r *http.Request) { r.Header.Set("Authorization", "Token "+token) }, false, http.StatusUnauthorized},
+		{"wrong password", token, func(r *http.Request) { r.SetBasicAuth("admin", "guess") }, false, http.StatusUnauthorized},
+		{"bearer token", token, func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }, true, 0},
+		{"password", token, func(r *http.Request) { r.SetBasicAuth("admin", token) }, true, 0},
+	}
+	for _, tt := range tests {
+		override(t, config.AdminToken, tt.configured)
+		for _, route := range routes {
+			users := withAuthStub(t, people.NO_CAPACITY_LIMIT)
+			users.Add(TEST_UUID, "Ada")
+
+			r := httptest.NewRequest(route.method, route.path, strings.NewReader(route.form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			tt.auth(r)
+			w := httptest.NewRecorder()
+			requireAdmin(route.fn)(w, r)
+
+			want := tt.status
+			if tt.authorized {
+				want = route.status
+			}
+			if w.Code != want {
+				t.Errorf("%s: %s %s %v = %d, want %d", tt.name, route.method, route.path, route.form, w.Code, want)
+			}
+			if challenge := w.Header().Get("WWW-Authenticate"); (want == http.StatusUnauthorized) != strings.HasPrefix(challenge, "Basic ") {
+				t.Errorf("%s: %s WWW-Authenticate = %q", tt.name, route.path, challenge)
+			}
+			if gone := !users.Exists(TEST_UUID); gone != (tt.authorized && route.gone) {
+				t.Errorf("%s: %s %s %v removed user %v", tt.name, route.method, route.path, route.form, gone)
+			}
+			if tt.authorized && route.path == "/admin" && !strings.Contains(w.Body.String(), TEST_UUID) {
+				t.Errorf("%s: /admin does not list the registered session", tt.name)
+			}
+		}
+	}
+}
@@ -0,0 +1,77 @@
+//  Copyright (C) Pat Kaehuaea - All Rights Reserved
+//  Unauthorized copying of this file, via any medium is strictly prohibited
+//  Proprietary and confidential
+//  Written by Pat Kaehuaea, March 2015
+//
+// Package converts a clock time into an English phrase such as "quarter
+// past three in the afternoon". Minutes are spelled exactly rather than
+// rounded, and twelve o'clock is reported as midnight or noon.
+package words
+
+import (
+	"time"
+)
+
+var numbers = []string{
+	"zero", "one", "two", "three", "four", "five", "six", "seven", "eight",
+	"nine", "ten", "eleven", "twelve", "thirteen", "fourteen", "fifteen",
+	"sixteen", "seventeen", "eighteen", "nineteen", "twenty",
+}
+
+// Spells n for 0 <= n < 30, the largest count of minutes past or to.
+func number(n int) string {
+	if n <= 20 {
+		return numbers[n]
+	}
+	return "twenty-" + numbers[n-20]
+}
+
+func minutes(n int) string {
+	if n == 1 {
+		return "one minute"
+	}
+	return number(n) + " minutes"
+}
+
+// Names hour of day h (0-23) and the part of the day it falls in.
+// Midnight and noon stand on their own with no part of the day.
+func hour(h int) (name string, part string) {
+	switch {
+	case h == 0:
+		return "midnight", ""
+	case h == 12:
+		return "noon", ""
+	case h < 12:
+		return number(h), " in the morning"
+	case h < 17:
+		return number(h - 12), " in the afternoon"
+	case h < 21:
+		return number(h - 12), " in the evening"
+	default:
+		return number(h - 12), " at night"
+	}
+}
+
+// Returns t as an English phrase, ignoring seconds.
+func Time(t time.Time) string {
+	h, m := t.Hour(), t.Minute()
+	name, part := hour(h)
+	nextName, nextPart := hour((h + 1) % 24)
+
+	switch {
+	case m == 0 && part == "":
+		return name
+	case m == 0:
+		return name + " o'clock" + part
+	case m == 15:
+		return "quarter past " + name + part
+	case m == 30:
+		return "half past " + name + part
+	case m == 45:
+		return "quarter to " + nextName + nextPart
+	case m < 30:
+		return minutes(m) + " past " + name + part
+	default:
+		return minutes(60-m) + " to " + nextName + nextPart
+	}
+}
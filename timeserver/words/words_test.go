@@ -0,0 +1,38 @@
+//  Copyright (C) Pat Kaehuaea - All Rights Reserved
+//  Unauthorized copying of this file, via any medium is strictly prohibited
+//  Proprietary and confidential
+//  Written by Pat Kaehuaea, March 2015
+
+package words
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTime(t *testing.T) {
+	tests := []struct {
+		hour, min int
+		want      string
+	}{
+		{0, 0, "midnight"},
+		{12, 0, "noon"},
+		{3, 0, "three o'clock in the morning"},
+		{15, 15, "quarter past three in the afternoon"},
+		{18, 30, "half past six in the evening"},
+		{22, 45, "quarter to eleven at night"},
+		{23, 45, "quarter to midnight"},
+		{11, 45, "quarter to noon"},
+		{0, 1, "one minute past midnight"},
+		{12, 29, "twenty-nine minutes past noon"},
+		{16, 59, "one minute to five in the evening"},
+		{9, 40, "twenty minutes to ten in the morning"},
+		{23, 59, "one minute to midnight"},
+	}
+	for _, tt := range tests {
+		at := time.Date(2015, time.March, 1, tt.hour, tt.min, 42, 0, time.UTC)
+		if got := Time(at); got != tt.want {
+			t.Errorf("Time(%s) = %q, want %q", at.Format("15:04"), got, tt.want)
+		}
+	}
+}